@@ -6,6 +6,8 @@ import (
 	"io"
 	"net/http"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -58,9 +60,18 @@ type Configuration struct {
 	Storage Storage `yaml:"storage"`
 
 	// Auth allows configuration of various authorization methods that may be
-	// used to gate requests.
+	// used to gate requests. Several methods may be configured, in which case
+	// they are consulted in turn.
 	Auth Auth `yaml:"auth,omitempty"`
 
+	// Anonymous configures the repositories readable without
+	// authentication.
+	Anonymous Anonymous `yaml:"anonymous,omitempty"`
+
+	// TokenServer configures the token server run by the token-server
+	// command, issuing the tokens of the token access controller.
+	TokenServer TokenServer `yaml:"tokenserver,omitempty"`
+
 	// Middleware lists all middlewares to be used by the registry.
 	Middleware map[string][]Middleware `yaml:"middleware,omitempty"`
 
@@ -185,6 +196,17 @@ type Configuration struct {
 	// Validation configures validation options for the registry.
 	Validation Validation `yaml:"validation,omitempty"`
 
+	// Retention configures the tag retention policies enforced by the
+	// registry.
+	Retention Retention `yaml:"retention,omitempty"`
+
+	// Quota configures the storage quotas enforced by the registry.
+	Quota Quota `yaml:"quota,omitempty"`
+
+	// ImmutableTags configures the tags which cannot be moved or deleted
+	// once pushed.
+	ImmutableTags ImmutableTags `yaml:"immutabletags,omitempty"`
+
 	// Policy configures registry policy options.
 	Policy struct {
 		// Repository configures policies for repositories
@@ -310,6 +332,15 @@ type Health struct {
 		// unhealthy state
 		Threshold int `yaml:"threshold,omitempty"`
 	} `yaml:"storagedriver,omitempty"`
+	// Upstream configures a health check on the remote registries of a
+	// pull through cache
+	Upstream struct {
+		// Enabled turns on the health check for the remote registries
+		Enabled bool `yaml:"enabled,omitempty"`
+		// Interval is the duration in between probes of the remote
+		// registries
+		Interval time.Duration `yaml:"interval,omitempty"`
+	} `yaml:"upstream,omitempty"`
 }
 
 type Platform struct {
@@ -483,49 +514,224 @@ func (storage Storage) MarshalYAML() (interface{}, error) {
 	return map[string]Parameters(storage), nil
 }
 
+// TokenServer configures a token server implementing the token
+// authentication specification.
+type TokenServer struct {
+	// Addr is the address the token server listens on, :5001 by default.
+	Addr string `yaml:"addr,omitempty"`
+
+	// TLS configures the certificate and key of the token server.
+	TLS struct {
+		// Certificate specifies the path to an x509 certificate file to
+		// be used for TLS.
+		Certificate string `yaml:"certificate,omitempty"`
+
+		// Key specifies the path to the x509 key file, which should
+		// contain the private portion for the file specified in
+		// Certificate.
+		Key string `yaml:"key,omitempty"`
+	} `yaml:"tls,omitempty"`
+
+	// Issuer is the issuer of the tokens, trusted by the token access
+	// controller.
+	Issuer string `yaml:"issuer,omitempty"`
+
+	// Service is the name of the registry, the audience of the tokens.
+	Service string `yaml:"service,omitempty"`
+
+	// Expiration is the lifetime of the tokens, 5 minutes by default.
+	Expiration time.Duration `yaml:"expiration,omitempty"`
+
+	// SigningKey is the path to the PEM encoded private key signing the
+	// tokens.
+	SigningKey string `yaml:"signingkey,omitempty"`
+
+	// Certificate is the path to the PEM encoded certificate chain of the
+	// signing key, sent with the tokens. If not set, the tokens identify
+	// the key by KeyID.
+	Certificate string `yaml:"certificate,omitempty"`
+
+	// KeyID is the identifier of the signing key, its RFC 7638 thumbprint
+	// by default.
+	KeyID string `yaml:"keyid,omitempty"`
+
+	// HTPasswd is the path to the htpasswd file authenticating the users.
+	HTPasswd string `yaml:"htpasswd,omitempty"`
+
+	// ACL is the path to the access control list granting actions to the
+	// users.
+	ACL string `yaml:"acl,omitempty"`
+}
+
 // Auth defines the configuration for registry authorization.
+//
+// Auth is either a map of auth types to their parameters, or a sequence of
+// single-entry maps which configures access controllers consulted in turn,
+// possibly several of the same type. The access controllers of a sequence
+// are keyed by their type and their position, such as "htpasswd#1".
 type Auth map[string]Parameters
 
-// Type returns the auth type, such as htpasswd or token
+// AuthOrderParameter is the parameter ordering the auth types of the map
+// form, when several are configured. Each type must then have a distinct
+// order, and types are consulted by ascending order.
+const AuthOrderParameter = "order"
+
+// authPositionSeparator separates the type of an access controller of the
+// sequence form from its position.
+const authPositionSeparator = "#"
+
+// AuthController is an access controller configured in the auth section.
+type AuthController struct {
+	// Type is the auth type, such as htpasswd or token.
+	Type string
+
+	// Parameters are the parameters of the access controller, without the
+	// order parameter.
+	Parameters Parameters
+}
+
+// Type returns the auth type, such as htpasswd or token, or the first of the
+// auth types when several are configured.
 func (auth Auth) Type() string {
-	// Return only key in this map
-	for k := range auth {
-		return k
+	if keys := auth.keys(); len(keys) > 0 {
+		authType, _, _ := strings.Cut(keys[0], authPositionSeparator)
+		return authType
 	}
 	return ""
 }
 
+// Controllers returns the access controllers, in the order in which they are
+// consulted. It fails when the order of the access controllers is ambiguous.
+func (auth Auth) Controllers() ([]AuthController, error) {
+	keys := auth.keys()
+	positions := 0
+	orders := make(map[int]string, len(keys))
+	for _, key := range keys {
+		if _, _, ok := strings.Cut(key, authPositionSeparator); ok {
+			positions++
+			continue
+		}
+		order, ok := auth[key][AuthOrderParameter]
+		if !ok {
+			if len(keys) > 1 {
+				return nil, fmt.Errorf("auth %s: %s is required when several auth types are configured", key, AuthOrderParameter)
+			}
+			continue
+		}
+		o, ok := order.(int)
+		if !ok {
+			return nil, fmt.Errorf("auth %s: %s must be an integer", key, AuthOrderParameter)
+		}
+		if other, ok := orders[o]; ok {
+			return nil, fmt.Errorf("auth %s: %s %d is also the order of %s", key, AuthOrderParameter, o, other)
+		}
+		orders[o] = key
+	}
+	if positions > 0 && positions != len(keys) {
+		return nil, errors.New("auth: a sequence of auth types cannot be combined with auth types keyed by name")
+	}
+
+	controllers := make([]AuthController, 0, len(keys))
+	for _, key := range keys {
+		authType, _, _ := strings.Cut(key, authPositionSeparator)
+		var params Parameters
+		if auth[key] != nil {
+			params = make(Parameters, len(auth[key]))
+			for k, v := range auth[key] {
+				if k != AuthOrderParameter {
+					params[k] = v
+				}
+			}
+		}
+		controllers = append(controllers, AuthController{Type: authType, Parameters: params})
+	}
+	return controllers, nil
+}
+
+// keys returns the keys of the auth types, by ascending position or order,
+// then by name.
+func (auth Auth) keys() []string {
+	keys := make([]string, 0, len(auth))
+	for k := range auth {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		oi, oj := auth.order(keys[i]), auth.order(keys[j])
+		if oi != oj {
+			return oi < oj
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// order returns the position of the auth type in the sequence form, or its
+// order parameter in the map form, 0 by default.
+func (auth Auth) order(key string) int {
+	if _, position, ok := strings.Cut(key, authPositionSeparator); ok {
+		order, _ := strconv.Atoi(position)
+		return order
+	}
+	order, _ := auth[key][AuthOrderParameter].(int)
+	return order
+}
+
 // Parameters returns the Parameters map for an Auth configuration
 func (auth Auth) Parameters() Parameters {
-	return auth[auth.Type()]
+	if keys := auth.keys(); len(keys) > 0 {
+		return auth[keys[0]]
+	}
+	return nil
 }
 
 // setParameter changes the parameter at the provided key to the new value
 func (auth Auth) setParameter(key string, value interface{}) {
-	auth[auth.Type()][key] = value
+	auth.Parameters()[key] = value
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface
-// Unmarshals a single item map into a Storage or a string into a Storage type with no parameters
+// Unmarshals a map of types, a sequence of single-type maps or a string
+// into an Auth type with no parameters
 func (auth *Auth) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var m map[string]Parameters
 	err := unmarshal(&m)
 	if err == nil {
-		if len(m) > 1 {
-			types := make([]string, 0, len(m))
-			for k := range m {
-				types = append(types, k)
+		for authType, params := range m {
+			if strings.Contains(authType, authPositionSeparator) {
+				return fmt.Errorf("auth %s: invalid auth type", authType)
+			}
+			if order, ok := params[AuthOrderParameter]; ok {
+				if _, ok := order.(int); !ok {
+					return fmt.Errorf("auth %s: %s must be an integer", authType, AuthOrderParameter)
+				}
 			}
-
-			// TODO(stevvooe): May want to change this slightly for
-			// authorization to allow multiple challenges.
-			return fmt.Errorf("must provide exactly one type. Provided: %v", types)
-
 		}
 		*auth = m
 		return nil
 	}
 
+	var s []map[string]Parameters
+	err = unmarshal(&s)
+	if err == nil {
+		a := make(Auth, len(s))
+		for i, entry := range s {
+			if len(entry) != 1 {
+				return fmt.Errorf("auth[%d]: must configure exactly one auth type", i)
+			}
+			for authType, params := range entry {
+				if strings.Contains(authType, authPositionSeparator) {
+					return fmt.Errorf("auth[%d]: invalid auth type %s", i, authType)
+				}
+				if _, ok := params[AuthOrderParameter]; ok {
+					return fmt.Errorf("auth[%d]: %s is not supported in a sequence", i, AuthOrderParameter)
+				}
+				a[authType+authPositionSeparator+strconv.Itoa(i)] = params
+			}
+		}
+		*auth = a
+		return nil
+	}
+
 	var authType string
 	err = unmarshal(&authType)
 	if err == nil {
@@ -538,12 +744,32 @@ func (auth *Auth) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 // MarshalYAML implements the yaml.Marshaler interface
 func (auth Auth) MarshalYAML() (interface{}, error) {
-	if auth.Parameters() == nil {
+	if len(auth) == 1 && auth.Parameters() == nil {
 		return auth.Type(), nil
 	}
+	keys := auth.keys()
+	if len(keys) > 0 && strings.Contains(keys[0], authPositionSeparator) {
+		s := make([]map[string]Parameters, 0, len(keys))
+		for _, key := range keys {
+			authType, _, _ := strings.Cut(key, authPositionSeparator)
+			s = append(s, map[string]Parameters{authType: auth[key]})
+		}
+		return s, nil
+	}
 	return map[string]Parameters(auth), nil
 }
 
+// Anonymous configures the access granted to requests without credentials,
+// which is checked before the access controllers are consulted.
+type Anonymous struct {
+	// Repositories are shell patterns (https://pkg.go.dev/path#Match) of
+	// the names of the repositories which can be pulled anonymously.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Catalog allows listing the repositories anonymously.
+	Catalog bool `yaml:"catalog,omitempty"`
+}
+
 // Notifications configures multiple http endpoints.
 type Notifications struct {
 	// EventConfig is the configuration for the event format that is sent to each Endpoint.
@@ -552,6 +778,36 @@ type Notifications struct {
 	// respond to webhook notifications. In the future, we may allow other
 	// kinds of endpoints, such as external queues.
 	Endpoints []Endpoint `yaml:"endpoints,omitempty"`
+	// Queue configures the queues of the events pending for the endpoints.
+	Queue NotificationQueue `yaml:"queue,omitempty"`
+}
+
+// NotificationQueue configures where the events pending for the endpoints are
+// queued, and what happens to the events which cannot be delivered.
+type NotificationQueue struct {
+	// Backend is the queue backend: inmemory (the default), file or redis.
+	// The file and redis backends persist the pending events, which are
+	// delivered once the registry restarts.
+	Backend string `yaml:"backend,omitempty"`
+
+	// Directory is the directory of the file backend, in which each
+	// endpoint has a subdirectory.
+	Directory string `yaml:"directory,omitempty"`
+
+	// MaxSize is the number of events pending for an endpoint in a
+	// persistent queue, beyond which events are dropped.
+	MaxSize int `yaml:"maxsize,omitempty"`
+
+	// MaxAttempts is the number of deliveries attempted before an event of
+	// a persistent queue is moved to the dead-letter storage of its
+	// endpoint. Events are retried indefinitely if not set.
+	MaxAttempts int `yaml:"maxattempts,omitempty"`
+
+	// Instance identifies the queue of the registry instance in the redis
+	// backend. It must be unique among the instances sharing the redis, and
+	// stable across restarts for the pending events to be delivered once the
+	// instance restarts. Defaults to the hostname.
+	Instance string `yaml:"instance,omitempty"`
 }
 
 // Endpoint describes the configuration of an http webhook notification
@@ -566,6 +822,8 @@ type Endpoint struct {
 	Backoff           time.Duration `yaml:"backoff"`           // backoff duration
 	IgnoredMediaTypes []string      `yaml:"ignoredmediatypes"` // target media types to ignore
 	Ignore            Ignore        `yaml:"ignore"`            // ignore event types
+	Format            string        `yaml:"format"`            // format of the notifications: envelope or cloudevents
+	Secret            string        `yaml:"secret"`            // secret signing the notifications with an HMAC-SHA256 X-Registry-Signature header
 }
 
 // Events configures notification events.
@@ -604,6 +862,176 @@ type Proxy struct {
 	// if not set, defaults to 7 * 24 hours
 	// If set to zero, will never expire cache
 	TTL *time.Duration `yaml:"ttl,omitempty"`
+
+	// Hybrid serves local content first and accepts pushes, content missing
+	// locally is pulled through from the remote registry.
+	Hybrid bool `yaml:"hybrid,omitempty"`
+
+	// Health configures the tracking of the availability of the remote
+	// registries.
+	Health ProxyHealth `yaml:"health,omitempty"`
+
+	// Scheduler configures where the expiry times of the cached content are
+	// kept.
+	Scheduler ProxyScheduler `yaml:"scheduler,omitempty"`
+
+	// Upstreams are remote registries each pulled through for the
+	// repositories whose name starts with its prefix. Repositories matching
+	// no upstream are hosted by the registry. Upstreams cannot be used along
+	// with RemoteURL.
+	Upstreams []ProxyUpstream `yaml:"upstreams,omitempty"`
+}
+
+// ProxyHealth configures the tracking of the availability of the remote
+// registries of a pull through cache, from the outcome of the requests sent
+// to them.
+type ProxyHealth struct {
+	// Threshold is the number of consecutive failed requests after which a
+	// remote registry is considered unavailable, defaults to 1.
+	Threshold int `yaml:"threshold,omitempty"`
+
+	// Backoff is the time during which no request is sent to a remote
+	// registry considered unavailable, so that requests fail fast. If not
+	// set, requests are always sent.
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+
+	// ServeStale keeps the expired content pulled through from a remote
+	// registry while it is unavailable, so that it is still served.
+	ServeStale bool `yaml:"servestale,omitempty"`
+}
+
+// ProxyScheduler configures the scheduler expiring the content cached by a
+// pull through cache.
+type ProxyScheduler struct {
+	// Backend is either storage, the default, which keeps the expiry times in
+	// memory and saves them to the storage, or redis, which shares them
+	// between the registry instances using the same redis. With redis, a
+	// single elected instance expires the cached content.
+	Backend string `yaml:"backend,omitempty"`
+
+	// Interval is the time between checks for expired content with the redis
+	// backend, defaults to 5 seconds.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// KeyPrefix is prepended to the redis keys of the redis backend, so that
+	// registries sharing a redis without sharing their cache do not share
+	// their expiry times.
+	KeyPrefix string `yaml:"keyprefix,omitempty"`
+}
+
+// ProxyUpstream is a remote registry pulled through for the repositories
+// whose name starts with Prefix.
+type ProxyUpstream struct {
+	// Prefix is the first components of the names of the repositories pulled
+	// through from the remote registry, such as dockerhub. The prefix is
+	// removed from the name of a repository to get its name on the remote
+	// registry.
+	Prefix string `yaml:"prefix"`
+
+	// RemoteURL is the URL of the remote registry
+	RemoteURL string `yaml:"remoteurl"`
+
+	// Username of the remote registry user
+	Username string `yaml:"username"`
+
+	// Password of the remote registry user
+	Password string `yaml:"password"`
+
+	// TTL is the expiry time of the content pulled through from the remote
+	// registry, if not set, defaults to 7 * 24 hours. If set to zero, the
+	// content never expires.
+	TTL *time.Duration `yaml:"ttl,omitempty"`
+
+	// Hybrid serves local content first and accepts pushes, content missing
+	// locally is pulled through from the remote registry.
+	Hybrid bool `yaml:"hybrid,omitempty"`
+}
+
+// Retention configures the background job deleting the tags selected by
+// retention policies.
+type Retention struct {
+	// Interval is the time between runs of the retention job, if not set,
+	// defaults to 24 hours.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// DryRun only reports the tags which would be deleted.
+	DryRun bool `yaml:"dryrun,omitempty"`
+
+	// DeleteManifests also deletes the manifests which are no longer
+	// referenced by any tag once the selected tags are deleted.
+	DeleteManifests bool `yaml:"deletemanifests,omitempty"`
+
+	// Policies are matched in order against repository names, the first
+	// policy matching a repository applies to it.
+	Policies []RetentionPolicy `yaml:"policies,omitempty"`
+}
+
+// RetentionPolicy selects the tags to delete in a set of repositories. A tag
+// is deleted when every rule of the policy allows it.
+type RetentionPolicy struct {
+	// Repositories are shell patterns (https://pkg.go.dev/path#Match) of
+	// the repository names the policy applies to.
+	Repositories []string `yaml:"repositories"`
+
+	// Tags is a regular expression restricting the policy to the matching
+	// tags. All tags are subject to the policy if not set.
+	Tags string `yaml:"tags,omitempty"`
+
+	// KeepLast keeps the given number of most recently pushed tags.
+	KeepLast int `yaml:"keeplast,omitempty"`
+
+	// MaxAge keeps the tags pushed more recently than the given duration.
+	MaxAge time.Duration `yaml:"maxage,omitempty"`
+
+	// Protect lists tags which are never deleted, such as latest.
+	Protect []string `yaml:"protect,omitempty"`
+}
+
+// ImmutableTags configures the policies protecting tags from being moved to
+// another manifest or deleted.
+type ImmutableTags struct {
+	// Policies select the immutable tags, a tag is immutable when any
+	// policy matches it.
+	Policies []ImmutableTagPolicy `yaml:"policies,omitempty"`
+
+	// OverrideUsers are the users allowed to move and delete immutable
+	// tags, for deliberate fixes.
+	OverrideUsers []string `yaml:"overrideusers,omitempty"`
+}
+
+// ImmutableTagPolicy selects immutable tags in a set of repositories.
+type ImmutableTagPolicy struct {
+	// Repositories are shell patterns (https://pkg.go.dev/path#Match) of
+	// the repository names the policy applies to.
+	Repositories []string `yaml:"repositories"`
+
+	// Tags is a regular expression restricting the policy to the tags it
+	// matches entirely. All tags are immutable if not set.
+	Tags string `yaml:"tags,omitempty"`
+}
+
+// Quota configures byte quotas on the storage used by the repositories whose
+// name starts with a prefix.
+type Quota struct {
+	// Backend is where usages are accounted, either inmemory (the default)
+	// or redis. The redis backend requires the redis section and must be
+	// used when several registry instances share the storage.
+	Backend string `yaml:"backend,omitempty"`
+
+	// TTL is the time after which accounted usages are computed again from
+	// the storage. Defaults to 1h.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+
+	// Limits are the quotas, each applying to the repositories whose name
+	// starts with its path prefix.
+	Limits []QuotaLimit `yaml:"limits,omitempty"`
+}
+
+// QuotaLimit restricts the bytes used by the repositories whose name starts
+// with Prefix. Content is accounted once per repository it is linked to.
+type QuotaLimit struct {
+	Prefix string `yaml:"prefix"`
+	Limit  int64  `yaml:"limit"`
 }
 
 type Validation struct {
@@ -670,6 +1098,17 @@ func (platforms *Platforms) UnmarshalYAML(unmarshal func(interface{}) error) err
 // Configuration.Abc may be replaced by the value of REGISTRY_ABC,
 // Configuration.Abc.Xyz may be replaced by the value of REGISTRY_ABC_XYZ, and so forth
 func Parse(rd io.Reader) (*Configuration, error) {
+	return parse(rd, true)
+}
+
+// ParseWithoutStorage parses a configuration like Parse, but does not
+// require a storage section, for the commands which do not use the storage,
+// such as the token server.
+func ParseWithoutStorage(rd io.Reader) (*Configuration, error) {
+	return parse(rd, false)
+}
+
+func parse(rd io.Reader, requireStorage bool) (*Configuration, error) {
 	in, err := io.ReadAll(rd)
 	if err != nil {
 		return nil, err
@@ -696,7 +1135,7 @@ func Parse(rd io.Reader) (*Configuration, error) {
 						v0_1.Catalog.MaxEntries = 1000
 					}
 
-					if v0_1.Storage.Type() == "" {
+					if requireStorage && v0_1.Storage.Type() == "" {
 						return nil, errors.New("no storage configuration provided")
 					}
 					return (*Configuration)(v0_1), nil
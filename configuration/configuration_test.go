@@ -551,6 +551,411 @@ func checkStructs(tt *testing.T, t reflect.Type, structsChecked map[string]struc
 
 // TestValidateConfigStruct makes sure that the config struct has no members
 // with yaml tags that would be ambiguous to the environment variable parser.
+// TestParseRetention validates that the retention policies can be parsed
+func (suite *ConfigSuite) TestParseRetention() {
+	yml := configYamlV0_1 + `
+retention:
+  interval: 12h
+  dryrun: true
+  policies:
+    - repositories: [library/*, foo]
+      tags: ^v\d+
+      keeplast: 10
+      maxage: 2160h
+      protect: [latest]
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+
+	suite.expectedConfig.Retention = Retention{
+		Interval: 12 * time.Hour,
+		DryRun:   true,
+		Policies: []RetentionPolicy{{
+			Repositories: []string{"library/*", "foo"},
+			Tags:         `^v\d+`,
+			KeepLast:     10,
+			MaxAge:       2160 * time.Hour,
+			Protect:      []string{"latest"},
+		}},
+	}
+	suite.Require().Equal(suite.expectedConfig, config)
+}
+
+// TestParseProxyUpstreams validates that the proxy upstreams can be parsed
+func (suite *ConfigSuite) TestParseProxyUpstreams() {
+	yml := configYamlV0_1 + `
+proxy:
+  upstreams:
+    - prefix: dockerhub
+      remoteurl: https://registry-1.docker.io
+      username: user
+      password: secret
+      ttl: 24h
+    - prefix: ghcr
+      remoteurl: https://ghcr.io
+      ttl: 0s
+      hybrid: true
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+
+	dayTTL := 24 * time.Hour
+	noTTL := time.Duration(0)
+	suite.expectedConfig.Proxy = Proxy{
+		Upstreams: []ProxyUpstream{
+			{
+				Prefix:    "dockerhub",
+				RemoteURL: "https://registry-1.docker.io",
+				Username:  "user",
+				Password:  "secret",
+				TTL:       &dayTTL,
+			},
+			{
+				Prefix:    "ghcr",
+				RemoteURL: "https://ghcr.io",
+				TTL:       &noTTL,
+				Hybrid:    true,
+			},
+		},
+	}
+	suite.Require().Equal(suite.expectedConfig, config)
+}
+
+// TestParseEndpointFormat validates that the format and the signing secret of
+// notification endpoints can be parsed
+func (suite *ConfigSuite) TestParseEndpointFormat() {
+	yml := configYamlV0_1 + `
+notifications:
+  endpoints:
+    - name: endpoint-1
+      url:  http://example.com
+      format: cloudevents
+      secret: s3cr3t
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+
+	suite.expectedConfig.Notifications = Notifications{
+		Endpoints: []Endpoint{
+			{
+				Name:   "endpoint-1",
+				URL:    "http://example.com",
+				Format: "cloudevents",
+				Secret: "s3cr3t",
+			},
+		},
+	}
+	suite.Require().Equal(suite.expectedConfig, config)
+}
+
+// TestParseTokenServer validates that the configuration of the token server
+// can be parsed
+func (suite *ConfigSuite) TestParseTokenServer() {
+	yml := configYamlV0_1 + `
+tokenserver:
+  addr: :5001
+  tls:
+    certificate: /etc/registry/tls.crt
+    key: /etc/registry/tls.key
+  issuer: registry-token-issuer
+  service: my-registry
+  expiration: 10m
+  signingkey: /etc/registry/token.key
+  certificate: /etc/registry/token.crt
+  htpasswd: /etc/registry/htpasswd
+  acl: /etc/registry/acl.yml
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+
+	suite.expectedConfig.TokenServer = TokenServer{
+		Addr:        ":5001",
+		Issuer:      "registry-token-issuer",
+		Service:     "my-registry",
+		Expiration:  10 * time.Minute,
+		SigningKey:  "/etc/registry/token.key",
+		Certificate: "/etc/registry/token.crt",
+		HTPasswd:    "/etc/registry/htpasswd",
+		ACL:         "/etc/registry/acl.yml",
+	}
+	suite.expectedConfig.TokenServer.TLS.Certificate = "/etc/registry/tls.crt"
+	suite.expectedConfig.TokenServer.TLS.Key = "/etc/registry/tls.key"
+	suite.Require().Equal(suite.expectedConfig, config)
+}
+
+// TestParseWithoutStorage validates that the configuration of the commands
+// which do not use the storage, such as the token server, can be parsed
+// without a storage section
+func (suite *ConfigSuite) TestParseWithoutStorage() {
+	yml := `
+version: 0.1
+tokenserver:
+  issuer: registry-token-issuer
+  service: my-registry
+`
+	_, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().Error(err)
+
+	config, err := ParseWithoutStorage(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	suite.Require().Empty(config.Storage.Type())
+	suite.Require().Equal("registry-token-issuer", config.TokenServer.Issuer)
+	suite.Require().Equal("my-registry", config.TokenServer.Service)
+}
+
+// TestParseAuthChain validates that several auth types can be configured,
+// and are ordered by their order parameter
+func (suite *ConfigSuite) TestParseAuthChain() {
+	yml := strings.Replace(configYamlV0_1, `auth:
+  silly:
+    realm: silly
+    service: silly
+`, `auth:
+  token:
+    realm: https://auth.example.com/token
+    order: 2
+  htpasswd:
+    realm: basic-realm
+    path: /etc/registry/htpasswd
+    order: 0
+  silly:
+    realm: silly
+    order: 1
+`, 1)
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	controllers, err := config.Auth.Controllers()
+	suite.Require().NoError(err)
+	suite.Require().Equal([]AuthController{
+		{Type: "htpasswd", Parameters: Parameters{"realm": "basic-realm", "path": "/etc/registry/htpasswd"}},
+		{Type: "silly", Parameters: Parameters{"realm": "silly"}},
+		{Type: "token", Parameters: Parameters{"realm": "https://auth.example.com/token"}},
+	}, controllers)
+	suite.Require().Equal("htpasswd", config.Auth.Type())
+
+	suite.T().Setenv("REGISTRY_AUTH_HTPASSWD_ORDER", "3")
+	config, err = Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	controllers, err = config.Auth.Controllers()
+	suite.Require().NoError(err)
+	suite.Require().Equal("silly", controllers[0].Type)
+	suite.Require().Equal("htpasswd", controllers[2].Type)
+
+	_, err = Parse(bytes.NewReader([]byte(strings.Replace(yml, "order: 1", "order: first", 1))))
+	suite.Require().Error(err)
+
+	suite.T().Setenv("REGISTRY_AUTH_HTPASSWD_ORDER", "2")
+	config, err = Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	_, err = config.Auth.Controllers()
+	suite.Require().ErrorContains(err, "also the order")
+
+	config, err = Parse(bytes.NewReader([]byte(strings.Replace(yml, "    order: 1\n", "", 1))))
+	suite.Require().NoError(err)
+	_, err = config.Auth.Controllers()
+	suite.Require().ErrorContains(err, "order is required")
+}
+
+// TestParseAuthSequence validates that a sequence of auth types can be
+// configured, with several access controllers of the same type
+func (suite *ConfigSuite) TestParseAuthSequence() {
+	yml := strings.Replace(configYamlV0_1, `auth:
+  silly:
+    realm: silly
+    service: silly
+`, `auth:
+  - htpasswd:
+      realm: robots
+      path: /etc/registry/robots
+  - token:
+      realm: https://auth.example.com/token
+  - htpasswd:
+      realm: users
+      path: /etc/registry/users
+`, 1)
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	controllers, err := config.Auth.Controllers()
+	suite.Require().NoError(err)
+	suite.Require().Equal([]AuthController{
+		{Type: "htpasswd", Parameters: Parameters{"realm": "robots", "path": "/etc/registry/robots"}},
+		{Type: "token", Parameters: Parameters{"realm": "https://auth.example.com/token"}},
+		{Type: "htpasswd", Parameters: Parameters{"realm": "users", "path": "/etc/registry/users"}},
+	}, controllers)
+	suite.Require().Equal("htpasswd", config.Auth.Type())
+	suite.Require().Equal(Parameters{"realm": "robots", "path": "/etc/registry/robots"}, config.Auth.Parameters())
+
+	out, err := yaml.Marshal(config)
+	suite.Require().NoError(err)
+	config, err = Parse(bytes.NewReader(out))
+	suite.Require().NoError(err)
+	marshaled, err := config.Auth.Controllers()
+	suite.Require().NoError(err)
+	suite.Require().Equal(controllers, marshaled)
+
+	_, err = Parse(bytes.NewReader([]byte(strings.Replace(yml, "      realm: users\n", "      realm: users\n      order: 1\n", 1))))
+	suite.Require().ErrorContains(err, "not supported in a sequence")
+
+	_, err = Parse(bytes.NewReader([]byte(strings.Replace(yml, "  - token:\n", "  - silly:\n      realm: silly\n    token:\n", 1))))
+	suite.Require().ErrorContains(err, "exactly one auth type")
+}
+
+// TestParseAnonymous validates that the repositories readable anonymously
+// can be parsed
+func (suite *ConfigSuite) TestParseAnonymous() {
+	yml := configYamlV0_1 + `
+anonymous:
+  repositories: ["library/*", "public/*"]
+  catalog: true
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+
+	suite.expectedConfig.Anonymous = Anonymous{
+		Repositories: []string{"library/*", "public/*"},
+		Catalog:      true,
+	}
+	suite.Require().Equal(suite.expectedConfig, config)
+}
+
+// TestParseImmutableTags validates that the immutability policies can be
+// parsed
+func (suite *ConfigSuite) TestParseImmutableTags() {
+	yml := configYamlV0_1 + `
+immutabletags:
+  policies:
+    - repositories: ["release/*"]
+      tags: ^v[0-9]+
+  overrideusers: [admin]
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+
+	suite.expectedConfig.ImmutableTags = ImmutableTags{
+		Policies:      []ImmutableTagPolicy{{Repositories: []string{"release/*"}, Tags: "^v[0-9]+"}},
+		OverrideUsers: []string{"admin"},
+	}
+	suite.Require().Equal(suite.expectedConfig, config)
+}
+
+// TestParseNotificationQueue validates that the queue of the notification
+// endpoints can be parsed
+func (suite *ConfigSuite) TestParseNotificationQueue() {
+	yml := configYamlV0_1 + `
+notifications:
+  queue:
+    backend: file
+    directory: /var/lib/registry/notifications
+    maxsize: 500
+    maxattempts: 20
+    instance: registry-0
+  endpoints:
+    - name: endpoint-1
+      url:  http://example.com
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+
+	suite.expectedConfig.Notifications = Notifications{
+		Endpoints: []Endpoint{
+			{
+				Name: "endpoint-1",
+				URL:  "http://example.com",
+			},
+		},
+		Queue: NotificationQueue{
+			Backend:     "file",
+			Directory:   "/var/lib/registry/notifications",
+			MaxSize:     500,
+			MaxAttempts: 20,
+			Instance:    "registry-0",
+		},
+	}
+	suite.Require().Equal(suite.expectedConfig, config)
+}
+
+// TestParseProxyHealth validates that the tracking of the availability of
+// the remote registries can be parsed
+func (suite *ConfigSuite) TestParseProxyHealth() {
+	yml := configYamlV0_1 + `
+proxy:
+  remoteurl: https://registry-1.docker.io
+  health:
+    threshold: 3
+    backoff: 30s
+    servestale: true
+health:
+  upstream:
+    enabled: true
+    interval: 10s
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+
+	suite.expectedConfig.Proxy = Proxy{
+		RemoteURL: "https://registry-1.docker.io",
+		Health: ProxyHealth{
+			Threshold:  3,
+			Backoff:    30 * time.Second,
+			ServeStale: true,
+		},
+	}
+	suite.expectedConfig.Health.Upstream.Enabled = true
+	suite.expectedConfig.Health.Upstream.Interval = 10 * time.Second
+	suite.Require().Equal(suite.expectedConfig, config)
+}
+
+// TestParseProxyScheduler validates that the proxy scheduler backend can be
+// parsed
+func (suite *ConfigSuite) TestParseProxyScheduler() {
+	yml := configYamlV0_1 + `
+proxy:
+  remoteurl: https://registry-1.docker.io
+  scheduler:
+    backend: redis
+    interval: 10s
+    keyprefix: "cache1::"
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+
+	suite.expectedConfig.Proxy = Proxy{
+		RemoteURL: "https://registry-1.docker.io",
+		Scheduler: ProxyScheduler{
+			Backend:   "redis",
+			Interval:  10 * time.Second,
+			KeyPrefix: "cache1::",
+		},
+	}
+	suite.Require().Equal(suite.expectedConfig, config)
+}
+
+// TestParseQuota validates that the storage quotas can be parsed
+func (suite *ConfigSuite) TestParseQuota() {
+	yml := configYamlV0_1 + `
+quota:
+  backend: redis
+  ttl: 1h
+  limits:
+    - prefix: team-a/
+      limit: 1073741824
+    - prefix: team-b/app
+      limit: 10485760
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+
+	suite.expectedConfig.Quota = Quota{
+		Backend: "redis",
+		TTL:     time.Hour,
+		Limits: []QuotaLimit{
+			{Prefix: "team-a/", Limit: 1 << 30},
+			{Prefix: "team-b/app", Limit: 10 << 20},
+		},
+	}
+	suite.Require().Equal(suite.expectedConfig, config)
+}
+
 func (suite *ConfigSuite) TestValidateConfigStruct() {
 	structsChecked := make(map[string]struct{})
 	checkStructs(suite.T(), reflect.TypeOf(Configuration{}), structsChecked)
@@ -33,6 +33,16 @@ func (err ErrTagUnknown) Error() string {
 	return fmt.Sprintf("unknown tag=%s", err.Tag)
 }
 
+// ErrTagImmutable is returned when moving or deleting a tag which an
+// immutability policy protects.
+type ErrTagImmutable struct {
+	Tag string
+}
+
+func (err ErrTagImmutable) Error() string {
+	return fmt.Sprintf("immutable tag=%s", err.Tag)
+}
+
 // ErrRepositoryUnknown is returned if the named repository is not known by
 // the registry.
 type ErrRepositoryUnknown struct {
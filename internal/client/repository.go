@@ -198,7 +198,14 @@ type tags struct {
 
 // All returns all tags
 func (t *tags) All(ctx context.Context) ([]string, error) {
-	listURLStr, err := t.ub.BuildTagsURL(t.name)
+	return t.List(ctx, "", 0)
+}
+
+// List returns up to n tags following last, following the pagination links
+// of the registry until n tags are listed, or all of them if n is not
+// positive.
+func (t *tags) List(ctx context.Context, last string, n int) ([]string, error) {
+	listURLStr, err := t.ub.BuildTagsURL(t.name, buildCatalogValues(n, last))
 	if err != nil {
 		return nil, err
 	}
@@ -236,6 +243,9 @@ func (t *tags) All(ctx context.Context) ([]string, error) {
 			return allTags, err
 		}
 		allTags = append(allTags, tagsResponse.Tags...)
+		if n > 0 && len(allTags) >= n {
+			return allTags[:n], nil
+		}
 		if link := resp.Header.Get("Link"); link != "" {
 			firsLink, _, _ := strings.Cut(link, ";")
 			linkURL, err := url.Parse(strings.Trim(firsLink, "<>"))
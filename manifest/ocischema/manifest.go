@@ -55,6 +55,10 @@ type Manifest struct {
 	// MediaType is the media type of this schema.
 	MediaType string `json:"mediaType,omitempty"`
 
+	// ArtifactType specifies the IANA media type of artifact when the
+	// manifest is used for an artifact.
+	ArtifactType string `json:"artifactType,omitempty"`
+
 	// Config references the image configuration as a blob.
 	Config v1.Descriptor `json:"config"`
 
@@ -62,6 +66,11 @@ type Manifest struct {
 	// configuration.
 	Layers []v1.Descriptor `json:"layers"`
 
+	// Subject is an optional link from the image manifest to another
+	// manifest forming an association between the image manifest and the
+	// other manifest.
+	Subject *v1.Descriptor `json:"subject,omitempty"`
+
 	// Annotations contains arbitrary metadata for the image manifest.
 	Annotations map[string]string `json:"annotations,omitempty"`
 }
@@ -49,6 +49,15 @@ type ManifestEnumerator interface {
 	Enumerate(ctx context.Context, ingester func(digest.Digest) error) error
 }
 
+// ReferrersProvider provides access to the manifests which declare another
+// manifest as their subject.
+type ReferrersProvider interface {
+	// Referrers returns the descriptors of the manifests whose subject is the
+	// manifest identified by dgst. If artifactType is not empty, only
+	// descriptors with a matching artifact type are returned.
+	Referrers(ctx context.Context, dgst digest.Digest, artifactType string) ([]v1.Descriptor, error)
+}
+
 // Describable is an interface for descriptors.
 //
 // Implementations of Describable are generally objects which can be
@@ -16,4 +16,14 @@ var (
 
 	// ProxyNamespace is the prometheus namespace of proxy related metrics
 	ProxyNamespace = metrics.NewNamespace(NamespacePrefix, "proxy", nil)
+
+	// AuthNamespace is the prometheus namespace of authentication related metrics
+	AuthNamespace = metrics.NewNamespace(NamespacePrefix, "auth", nil)
+
+	// QuotaNamespace is the prometheus namespace of storage quota related metrics
+	QuotaNamespace = metrics.NewNamespace(NamespacePrefix, "quota", nil)
+
+	// DiskCacheNamespace is the prometheus namespace of the local disk cache
+	// storage middleware metrics
+	DiskCacheNamespace = metrics.NewNamespace(NamespacePrefix, "diskcache", nil)
 )
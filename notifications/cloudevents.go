@@ -0,0 +1,86 @@
+package notifications
+
+import (
+	"fmt"
+	"time"
+
+	events "github.com/docker/go-events"
+)
+
+const (
+	// CloudEventsMediaType is the mediatype of a single event sent in the
+	// structured content mode of CloudEvents.
+	CloudEventsMediaType = "application/cloudevents+json"
+	// CloudEventTypePrefix prefixes the action of an event to form the type
+	// of its CloudEvent, such as org.cncf.distribution.push.
+	CloudEventTypePrefix = "org.cncf.distribution."
+)
+
+// CloudEvent is a CloudEvents 1.0 event, holding a registry event as its
+// data.
+type CloudEvent struct {
+	// SpecVersion is the version of the CloudEvents specification, 1.0.
+	SpecVersion string `json:"specversion"`
+
+	// ID is the identifier of the registry event.
+	ID string `json:"id"`
+
+	// Source identifies the registry node that generated the event, as a
+	// network-path reference to its address.
+	Source string `json:"source"`
+
+	// Type is the action of the event, prefixed by CloudEventTypePrefix.
+	Type string `json:"type"`
+
+	// Subject is the reference of the target of the event, if any.
+	Subject string `json:"subject,omitempty"`
+
+	// Time is the time at which the event occurred, in RFC 3339 format.
+	Time string `json:"time,omitempty"`
+
+	// DataContentType is the mediatype of Data, application/json.
+	DataContentType string `json:"datacontenttype"`
+
+	// Data is the registry event.
+	Data Event `json:"data"`
+}
+
+// NewCloudEvent returns the CloudEvent of a registry event.
+func NewCloudEvent(event Event) CloudEvent {
+	ce := CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              event.ID,
+		Source:          "//" + event.Source.Addr,
+		Type:            CloudEventTypePrefix + event.Action,
+		DataContentType: "application/json",
+		Data:            event,
+	}
+
+	if event.Target.Repository != "" {
+		switch {
+		case event.Target.Tag != "":
+			ce.Subject = event.Target.Repository + ":" + event.Target.Tag
+		case event.Target.Digest != "":
+			ce.Subject = event.Target.Repository + "@" + event.Target.Digest.String()
+		default:
+			ce.Subject = event.Target.Repository
+		}
+	}
+
+	if !event.Timestamp.IsZero() {
+		ce.Time = event.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	return ce
+}
+
+// cloudEvent returns the CloudEvent of an event written to a sink.
+func cloudEvent(event events.Event) (CloudEvent, error) {
+	switch event := event.(type) {
+	case Event:
+		return NewCloudEvent(event), nil
+	case *Event:
+		return NewCloudEvent(*event), nil
+	default:
+		return CloudEvent{}, fmt.Errorf("unexpected event type %T", event)
+	}
+}
@@ -0,0 +1,260 @@
+package notifications
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+// QueueStore persists the events pending for an endpoint, so that they are
+// delivered once the registry restarts, and keeps the events which could not
+// be delivered. A store is used by a single endpoint.
+type QueueStore interface {
+	// Load returns the events pending in the store, oldest first.
+	Load() ([]QueuedEvent, error)
+
+	// Push persists a pending event, returning its identifier in the store.
+	Push(event Event) (string, error)
+
+	// Ack removes a delivered event from the store.
+	Ack(id string) error
+
+	// DeadLetter moves an event which could not be delivered to the
+	// dead-letter storage of the endpoint, along with the cause of the
+	// failure.
+	DeadLetter(id string, event Event, cause error) error
+
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// QueuedEvent is an event pending in a QueueStore.
+type QueuedEvent struct {
+	ID    string
+	Event Event
+}
+
+// DeadLetter is an event which could not be delivered to an endpoint.
+type DeadLetter struct {
+	// Event is the event which could not be delivered.
+	Event Event `json:"event"`
+
+	// Error is the error returned by the last delivery attempt.
+	Error string `json:"error"`
+
+	// Timestamp is the time at which the event was given up.
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// durableQueueListener is called when various events happen on a durable
+// queue, in addition to those of an eventQueue.
+type durableQueueListener interface {
+	eventQueueListener
+	dropped(event events.Event)
+	deadLettered(event events.Event)
+}
+
+// durableQueue accepts messages into a queue persisted by a QueueStore, for
+// asynchronous delivery to a sink. Unlike an eventQueue, it retries the
+// deliveries itself, according to a retry strategy, so that events failing
+// maxAttempts deliveries are moved to the dead-letter storage. The queue is
+// bounded by maxSize, events written to a full queue are dropped.
+type durableQueue struct {
+	sink        events.Sink
+	store       QueueStore
+	strategy    events.RetryStrategy
+	maxSize     int
+	maxAttempts int
+	listeners   []durableQueueListener
+
+	events  *list.List
+	cond    *sync.Cond
+	mu      sync.Mutex
+	closed  bool
+	done    chan struct{}
+	runDone chan struct{}
+}
+
+// newDurableQueue returns a queue to the provided sink, replaying the events
+// pending in the store, or an error if they could not be loaded. maxSize and
+// maxAttempts are unbounded if not positive.
+func newDurableQueue(sink events.Sink, store QueueStore, strategy events.RetryStrategy, maxSize, maxAttempts int, listeners ...durableQueueListener) (*durableQueue, error) {
+	dq := durableQueue{
+		sink:        sink,
+		store:       store,
+		strategy:    strategy,
+		maxSize:     maxSize,
+		maxAttempts: maxAttempts,
+		listeners:   listeners,
+		events:      list.New(),
+		done:        make(chan struct{}),
+		runDone:     make(chan struct{}),
+	}
+	dq.cond = sync.NewCond(&dq.mu)
+
+	pending, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("durablequeue: error loading pending events: %w", err)
+	}
+	for _, qe := range pending {
+		for _, listener := range dq.listeners {
+			listener.ingress(qe.Event)
+		}
+		dq.events.PushBack(qe)
+	}
+
+	go dq.run()
+	return &dq, nil
+}
+
+// Write persists the event into the queue, failing if the queue has been
+// closed or the event could not be persisted.
+func (dq *durableQueue) Write(event events.Event) error {
+	var e Event
+	switch event := event.(type) {
+	case Event:
+		e = event
+	case *Event:
+		e = *event
+	default:
+		return fmt.Errorf("durablequeue: unexpected event type %T", event)
+	}
+
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	if dq.closed {
+		return ErrSinkClosed
+	}
+
+	if dq.maxSize > 0 && dq.events.Len() >= dq.maxSize {
+		logrus.Warnf("durablequeue: queue of %v is full, dropping event %s", dq.sink, e.ID)
+		for _, listener := range dq.listeners {
+			listener.dropped(event)
+		}
+		return nil
+	}
+
+	id, err := dq.store.Push(e)
+	if err != nil {
+		return fmt.Errorf("durablequeue: error persisting event: %w", err)
+	}
+
+	for _, listener := range dq.listeners {
+		listener.ingress(event)
+	}
+	dq.events.PushBack(QueuedEvent{ID: id, Event: e})
+	dq.cond.Signal() // signal waiters
+
+	return nil
+}
+
+// Close shuts down the queue. The pending events are left in the store, to
+// be delivered once the queue is created again.
+func (dq *durableQueue) Close() error {
+	dq.mu.Lock()
+	if dq.closed {
+		dq.mu.Unlock()
+		return fmt.Errorf("durablequeue: already closed")
+	}
+	dq.closed = true
+	close(dq.done)
+	dq.cond.Broadcast()
+	dq.mu.Unlock()
+
+	<-dq.runDone
+
+	if err := dq.sink.Close(); err != nil {
+		return err
+	}
+	return dq.store.Close()
+}
+
+// run is the main goroutine to deliver events to the target sink.
+func (dq *durableQueue) run() {
+	defer close(dq.runDone)
+
+	for {
+		qe, ok := dq.next()
+		if !ok {
+			return // the queue is closed
+		}
+
+		dq.deliver(qe)
+
+		for _, listener := range dq.listeners {
+			listener.egress(qe.Event)
+		}
+	}
+}
+
+// next returns the oldest pending event, blocking until an event is pending.
+// It returns false once the queue is closed.
+func (dq *durableQueue) next() (QueuedEvent, bool) {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	for dq.events.Len() < 1 && !dq.closed {
+		dq.cond.Wait()
+	}
+	if dq.closed {
+		return QueuedEvent{}, false
+	}
+
+	front := dq.events.Front()
+	dq.events.Remove(front)
+	return front.Value.(QueuedEvent), true
+}
+
+// deliver writes an event to the sink until it succeeds, the queue is closed
+// or maxAttempts is reached, in which case the event is moved to the
+// dead-letter storage.
+func (dq *durableQueue) deliver(qe QueuedEvent) {
+	logger := logrus.WithField("event", qe.Event.ID)
+
+	for attempts := 1; ; attempts++ {
+		if backoff := dq.strategy.Proceed(qe.Event); backoff > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-dq.done:
+				return
+			}
+		}
+
+		err := dq.sink.Write(qe.Event)
+		if err == nil {
+			dq.strategy.Success(qe.Event)
+			if err := dq.store.Ack(qe.ID); err != nil {
+				logger.WithError(err).Errorf("durablequeue: error removing delivered event")
+			}
+			return
+		}
+		if err == ErrSinkClosed {
+			return
+		}
+
+		dq.strategy.Failure(qe.Event, err)
+
+		if dq.maxAttempts > 0 && attempts >= dq.maxAttempts {
+			logger.WithError(err).Errorf("durablequeue: giving up event after %d attempts", attempts)
+			if dlErr := dq.store.DeadLetter(qe.ID, qe.Event, err); dlErr != nil {
+				logger.WithError(dlErr).Errorf("durablequeue: error moving event to the dead-letter storage")
+			}
+			for _, listener := range dq.listeners {
+				listener.deadLettered(qe.Event)
+			}
+			return
+		}
+
+		logger.WithError(err).Errorf("durablequeue: error writing event, retrying")
+		select {
+		case <-dq.done:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	events "github.com/docker/go-events"
+)
+
+// TestDurableQueueReplay ensures that the events pending when the queue is
+// closed are delivered by the next queue of the same store.
+func TestDurableQueueReplay(t *testing.T) {
+	const nevents = 10
+	dir := t.TempDir()
+
+	store, err := NewFileQueueStore(dir)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	failing := testSinkFn(func(event events.Event) error {
+		return errors.New("endpoint unavailable")
+	})
+	dq, err := newDurableQueue(failing, store, events.NewBreaker(1, time.Hour), 0, 0)
+	if err != nil {
+		t.Fatalf("error creating queue: %v", err)
+	}
+	for i := 0; i < nevents; i++ {
+		if err := dq.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+			t.Fatalf("error writing event: %v", err)
+		}
+	}
+	checkClose(t, dq)
+
+	store, err = NewFileQueueStore(dir)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	delivered := make(chan events.Event, nevents)
+	metrics := newSafeMetrics("")
+	dq, err = newDurableQueue(testSinkFn(func(event events.Event) error {
+		delivered <- event
+		return nil
+	}), store, events.NewBreaker(1, time.Hour), 0, 0, metrics.durableQueueListener())
+	if err != nil {
+		t.Fatalf("error creating queue: %v", err)
+	}
+
+	for i := 0; i < nevents; i++ {
+		select {
+		case event := <-delivered:
+			if event.(Event).Target.Repository != "library/test" {
+				t.Fatalf("unexpected event delivered: %#v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d pending events were delivered", i, nevents)
+		}
+	}
+	checkClose(t, dq)
+
+	store, err = NewFileQueueStore(dir)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	defer store.Close()
+	pending, err := store.Load()
+	if err != nil {
+		t.Fatalf("error loading events: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("delivered events are still pending: %d", len(pending))
+	}
+}
+
+// TestDurableQueueDeadLetter ensures that the events failing too many
+// deliveries are moved to the dead-letter storage.
+func TestDurableQueueDeadLetter(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileQueueStore(dir)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+
+	attempts := make(chan struct{}, 10)
+	metrics := newSafeMetrics("")
+	dq, err := newDurableQueue(testSinkFn(func(event events.Event) error {
+		attempts <- struct{}{}
+		return errors.New("endpoint unavailable")
+	}), store, events.NewBreaker(10, time.Millisecond), 0, 3, metrics.durableQueueListener())
+	if err != nil {
+		t.Fatalf("error creating queue: %v", err)
+	}
+
+	event := createTestEvent("push", "library/test", "blob")
+	if err := dq.Write(event); err != nil {
+		t.Fatalf("error writing event: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		metrics.Lock()
+		deadLetters := metrics.DeadLetters
+		metrics.Unlock()
+		if deadLetters == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("event was not moved to the dead-letter storage")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkClose(t, dq)
+
+	if len(attempts) != 3 {
+		t.Fatalf("unexpected number of attempts: %d != 3", len(attempts))
+	}
+
+	p, err := os.ReadFile(filepath.Join(dir, fileDeadLetterLog))
+	if err != nil {
+		t.Fatalf("error reading dead letters: %v", err)
+	}
+	var deadLetter DeadLetter
+	if err := json.Unmarshal(p, &deadLetter); err != nil {
+		t.Fatalf("error decoding dead letter: %v", err)
+	}
+	if deadLetter.Event.ID != event.ID || deadLetter.Error != "endpoint unavailable" {
+		t.Fatalf("unexpected dead letter: %#v", deadLetter)
+	}
+
+	store, err = NewFileQueueStore(dir)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	defer store.Close()
+	if pending, _ := store.Load(); len(pending) != 0 {
+		t.Fatalf("dead letter is still pending")
+	}
+}
+
+// TestDurableQueueMaxSize ensures that events written to a full queue are
+// dropped.
+func TestDurableQueueMaxSize(t *testing.T) {
+	store, err := NewFileQueueStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+
+	inflight := make(chan struct{})
+	release := make(chan struct{})
+	metrics := newSafeMetrics("")
+	dq, err := newDurableQueue(testSinkFn(func(event events.Event) error {
+		inflight <- struct{}{}
+		<-release
+		return nil
+	}), store, events.NewBreaker(1, time.Hour), 2, 0, metrics.durableQueueListener())
+	if err != nil {
+		t.Fatalf("error creating queue: %v", err)
+	}
+
+	// the first event is being delivered, the next two fill the queue
+	for i := 0; i < 4; i++ {
+		if err := dq.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+			t.Fatalf("error writing event: %v", err)
+		}
+		if i == 0 {
+			<-inflight
+		}
+	}
+
+	metrics.Lock()
+	if metrics.Dropped != 1 || metrics.Pending != 3 {
+		t.Fatalf("unexpected metrics: dropped=%d pending=%d", metrics.Dropped, metrics.Pending)
+	}
+	metrics.Unlock()
+
+	go func() {
+		for range inflight {
+		}
+	}()
+	close(release)
+	checkClose(t, dq)
+	close(inflight)
+}
+
+// failingLoadStore is a store whose pending events cannot be loaded.
+type failingLoadStore struct {
+	QueueStore
+}
+
+func (failingLoadStore) Load() ([]QueuedEvent, error) {
+	return nil, errors.New("corrupted store")
+}
+
+// TestDurableQueueLoadError ensures that an endpoint whose pending events
+// cannot be loaded fails to start, rather than dropping them.
+func TestDurableQueueLoadError(t *testing.T) {
+	if _, err := NewEndpoint("load", "http://localhost", EndpointConfig{Queue: failingLoadStore{}}); err == nil {
+		t.Fatal("expected an error loading the pending events")
+	}
+}
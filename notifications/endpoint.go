@@ -1,6 +1,7 @@
 package notifications
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
@@ -8,6 +9,15 @@ import (
 	events "github.com/docker/go-events"
 )
 
+// Formats of the notifications sent to endpoints.
+const (
+	// FormatEnvelope sends events in an Envelope.
+	FormatEnvelope = "envelope"
+	// FormatCloudEvents sends each event as a CloudEvent, in the structured
+	// content mode of CloudEvents.
+	FormatCloudEvents = "cloudevents"
+)
+
 // EndpointConfig covers the optional configuration parameters for an active
 // endpoint.
 type EndpointConfig struct {
@@ -18,6 +28,20 @@ type EndpointConfig struct {
 	IgnoredMediaTypes []string
 	Transport         *http.Transport `json:"-"`
 	Ignore            configuration.Ignore
+	Format            string
+	Secret            string `json:"-"`
+
+	// Queue persists the events pending for the endpoint if set, instead of
+	// queueing them in memory.
+	Queue QueueStore `json:"-"`
+	// MaxPending bounds the number of events pending in a persistent
+	// queue, events are dropped once it is reached. Unbounded if not
+	// positive.
+	MaxPending int
+	// MaxAttempts is the number of deliveries attempted before an event
+	// of a persistent queue is moved to its dead-letter storage. Events are
+	// retried indefinitely if not positive.
+	MaxAttempts int
 }
 
 // defaults set any zero-valued fields to a reasonable default.
@@ -37,6 +61,24 @@ func (ec *EndpointConfig) defaults() {
 	if ec.Transport == nil {
 		ec.Transport = http.DefaultTransport.(*http.Transport)
 	}
+
+	if ec.Format == "" {
+		ec.Format = FormatEnvelope
+	}
+}
+
+// Validate returns an error if the format or the signing configuration of
+// the endpoint is invalid.
+func (ec *EndpointConfig) Validate() error {
+	switch ec.Format {
+	case "", FormatEnvelope, FormatCloudEvents:
+	default:
+		return fmt.Errorf("unknown notification format %q", ec.Format)
+	}
+	if ec.Secret != "" && ec.Headers.Get(SignatureHeader) != "" {
+		return fmt.Errorf("the %s header is set by the secret and cannot be configured", SignatureHeader)
+	}
+	return nil
 }
 
 // Endpoint is a reliable, queued, thread-safe sink that notify external http
@@ -52,8 +94,13 @@ type Endpoint struct {
 	metrics *safeMetrics
 }
 
-// NewEndpoint returns a running endpoint, ready to receive events.
-func NewEndpoint(name, url string, config EndpointConfig) *Endpoint {
+// NewEndpoint returns a running endpoint, ready to receive events, or an
+// error if the configuration is invalid.
+func NewEndpoint(name, url string, config EndpointConfig) (*Endpoint, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("endpoint %s: %v", name, err)
+	}
+
 	var endpoint Endpoint
 	endpoint.name = name
 	endpoint.url = url
@@ -61,17 +108,30 @@ func NewEndpoint(name, url string, config EndpointConfig) *Endpoint {
 	endpoint.defaults()
 	endpoint.metrics = newSafeMetrics(name)
 
-	// Configures the inmemory queue, retry, http pipeline.
+	// Configures the queue, retry, http pipeline.
 	endpoint.Sink = newHTTPSink(
 		endpoint.url, endpoint.Timeout, endpoint.Headers,
-		endpoint.Transport, endpoint.metrics.httpStatusListener())
-	endpoint.Sink = events.NewRetryingSink(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff))
-	endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
+		endpoint.Transport, endpoint.Format, endpoint.Secret,
+		endpoint.metrics.httpStatusListener())
+	if endpoint.Queue != nil {
+		// The persistent queue retries the deliveries itself, to move the
+		// events failing too many deliveries to the dead-letter storage.
+		queue, err := newDurableQueue(endpoint.Sink, endpoint.Queue,
+			events.NewBreaker(endpoint.Threshold, endpoint.Backoff),
+			endpoint.MaxPending, endpoint.MaxAttempts, endpoint.metrics.durableQueueListener())
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %s: %v", name, err)
+		}
+		endpoint.Sink = queue
+	} else {
+		endpoint.Sink = events.NewRetryingSink(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff))
+		endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
+	}
 	mediaTypes := append(config.Ignore.MediaTypes, config.IgnoredMediaTypes...)
 	endpoint.Sink = newIgnoredSink(endpoint.Sink, mediaTypes, config.Ignore.Actions)
 
 	register(&endpoint)
-	return &endpoint
+	return &endpoint, nil
 }
 
 // Name returns the name of the endpoint, generally used for debugging.
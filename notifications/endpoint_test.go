@@ -0,0 +1,33 @@
+package notifications
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEndpointConfigValidate(t *testing.T) {
+	for name, config := range map[string]EndpointConfig{
+		"unknown format": {Format: "xml"},
+		"batch format":   {Format: "cloudevents-batch"},
+		"signature header": {
+			Secret:  "secret",
+			Headers: http.Header{"X-Registry-Signature": []string{"t=0,v1=00"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := config.Validate(); err == nil {
+				t.Fatal("expected an error")
+			}
+			if _, err := NewEndpoint("invalid", "http://localhost", config); err == nil {
+				t.Fatal("expected NewEndpoint to fail")
+			}
+		})
+	}
+
+	for _, format := range []string{"", FormatEnvelope, FormatCloudEvents} {
+		config := EndpointConfig{Format: format, Secret: "secret"}
+		if err := config.Validate(); err != nil {
+			t.Fatalf("unexpected error for format %q: %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package notifications
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	fileQueueLog      = "queue.log"
+	fileDeadLetterLog = "deadletter.log"
+
+	// fileQueueCompactThreshold is the number of acknowledged events after
+	// which the log is compacted.
+	fileQueueCompactThreshold = 1000
+)
+
+// fileQueueRecord is a line of the write-ahead log of a fileQueueStore. It
+// records a pending event if Event is set, and the acknowledgement of the
+// event with the sequence number otherwise.
+type fileQueueRecord struct {
+	Seq   uint64 `json:"seq"`
+	Event *Event `json:"event,omitempty"`
+}
+
+// fileQueueStore is a QueueStore keeping the pending events in a write-ahead
+// log in a local directory, and the dead letters in a second log in the same
+// directory.
+type fileQueueStore struct {
+	mu          sync.Mutex
+	dir         string
+	log         *os.File
+	deadLetters *os.File
+	seq         uint64
+	pending     map[uint64]Event
+	acked       int
+}
+
+// NewFileQueueStore returns a QueueStore persisting the events in dir, which
+// is created if it does not exist. The events already pending in dir are
+// loaded.
+func NewFileQueueStore(dir string) (QueueStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	fs := &fileQueueStore{
+		dir:     dir,
+		pending: make(map[uint64]Event),
+	}
+	if err := fs.replay(); err != nil {
+		return nil, err
+	}
+	if err := fs.compact(); err != nil {
+		return nil, err
+	}
+
+	deadLetters, err := os.OpenFile(filepath.Join(dir, fileDeadLetterLog), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		fs.log.Close()
+		return nil, err
+	}
+	fs.deadLetters = deadLetters
+	return fs, nil
+}
+
+// replay reads the pending events from the log. A record truncated by a crash
+// ends the log.
+func (fs *fileQueueStore) replay() error {
+	f, err := os.Open(filepath.Join(fs.dir, fileQueueLog))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				logrus.Warnf("filequeue: ignoring truncated record at the end of %s", f.Name())
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var record fileQueueRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			logrus.Warnf("filequeue: ignoring the end of %s after invalid record: %v", f.Name(), err)
+			return nil
+		}
+		if record.Event != nil {
+			fs.pending[record.Seq] = *record.Event
+		} else {
+			delete(fs.pending, record.Seq)
+		}
+		if record.Seq > fs.seq {
+			fs.seq = record.Seq
+		}
+	}
+}
+
+// compact rewrites the log with only the pending events, and opens it for
+// appending.
+func (fs *fileQueueStore) compact() error {
+	tmp, err := os.CreateTemp(fs.dir, fileQueueLog+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, seq := range fs.sequences() {
+		event := fs.pending[seq]
+		if err := writeRecord(w, fileQueueRecord{Seq: seq, Event: &event}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(fs.dir, fileQueueLog)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if fs.log != nil {
+		fs.log.Close()
+	}
+	fs.log, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	fs.acked = 0
+	return nil
+}
+
+// sequences returns the sequence numbers of the pending events, in order.
+func (fs *fileQueueStore) sequences() []uint64 {
+	seqs := make([]uint64, 0, len(fs.pending))
+	for seq := range fs.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
+}
+
+// Load returns the events pending in the store, oldest first.
+func (fs *fileQueueStore) Load() ([]QueuedEvent, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	seqs := fs.sequences()
+	pending := make([]QueuedEvent, 0, len(seqs))
+	for _, seq := range seqs {
+		pending = append(pending, QueuedEvent{
+			ID:    strconv.FormatUint(seq, 10),
+			Event: fs.pending[seq],
+		})
+	}
+	return pending, nil
+}
+
+// Push appends a pending event to the log, and syncs it to disk.
+func (fs *fileQueueStore) Push(event Event) (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	seq := fs.seq + 1
+	if err := writeRecord(fs.log, fileQueueRecord{Seq: seq, Event: &event}); err != nil {
+		return "", err
+	}
+	if err := fs.log.Sync(); err != nil {
+		return "", err
+	}
+
+	fs.seq = seq
+	fs.pending[seq] = event
+	return strconv.FormatUint(seq, 10), nil
+}
+
+// Ack appends the acknowledgement of an event to the log, compacting it once
+// enough events were acknowledged.
+func (fs *fileQueueStore) Ack(id string) error {
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("filequeue: invalid event id %q", id)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.pending[seq]; !ok {
+		return nil
+	}
+	if err := writeRecord(fs.log, fileQueueRecord{Seq: seq}); err != nil {
+		return err
+	}
+	delete(fs.pending, seq)
+	fs.acked++
+
+	if len(fs.pending) == 0 || fs.acked >= fileQueueCompactThreshold {
+		return fs.compact()
+	}
+	return nil
+}
+
+// DeadLetter appends the event to the dead-letter log, and acknowledges it.
+func (fs *fileQueueStore) DeadLetter(id string, event Event, cause error) error {
+	fs.mu.Lock()
+	err := writeRecord(fs.deadLetters, DeadLetter{
+		Event:     event,
+		Error:     cause.Error(),
+		Timestamp: time.Now().UTC(),
+	})
+	if err == nil {
+		err = fs.deadLetters.Sync()
+	}
+	fs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return fs.Ack(id)
+}
+
+// Close closes the logs.
+func (fs *fileQueueStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	err := fs.log.Close()
+	if dlErr := fs.deadLetters.Close(); err == nil {
+		err = dlErr
+	}
+	return err
+}
+
+// writeRecord writes v as a line of JSON.
+func writeRecord(w io.Writer, v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(p, '\n'))
+	return err
+}
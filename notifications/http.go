@@ -15,7 +15,9 @@ import (
 // very lightweight in that it only makes an attempt at an http request.
 // Reliability should be provided by the caller.
 type httpSink struct {
-	url string
+	url    string
+	format string
+	secret []byte
 
 	mu        sync.Mutex
 	closed    bool
@@ -27,13 +29,20 @@ type httpSink struct {
 }
 
 // newHTTPSink returns an unreliable, single-flight http sink. Wrap in other
-// sinks for increased reliability.
-func newHTTPSink(u string, timeout time.Duration, headers http.Header, transport *http.Transport, listeners ...httpStatusListener) *httpSink {
+// sinks for increased reliability. Events are encoded in the given format,
+// and requests are signed if secret is not empty.
+func newHTTPSink(u string, timeout time.Duration, headers http.Header, transport *http.Transport, format, secret string, listeners ...httpStatusListener) *httpSink {
 	if transport == nil {
 		transport = http.DefaultTransport.(*http.Transport)
 	}
+	var key []byte
+	if secret != "" {
+		key = []byte(secret)
+	}
 	return &httpSink{
 		url:       u,
+		format:    format,
+		secret:    key,
 		listeners: listeners,
 		client: &http.Client{
 			Transport: &headerRoundTripper{
@@ -64,24 +73,31 @@ func (hs *httpSink) Write(event events.Event) error {
 		return ErrSinkClosed
 	}
 
-	envelope := Envelope{
-		Events: []events.Event{event},
-	}
-
 	// TODO(stevvooe): It is not ideal to keep re-encoding the request body on
 	// retry but we are going to do it to keep the code simple. It is likely
 	// we could change the event struct to manage its own buffer.
 
-	p, err := json.MarshalIndent(envelope, "", "   ")
+	p, mediaType, err := hs.encode(event)
+	if err != nil {
+		for _, listener := range hs.listeners {
+			listener.err(err, event)
+		}
+		return fmt.Errorf("%v: error marshaling event: %v", hs, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hs.url, bytes.NewReader(p))
 	if err != nil {
 		for _, listener := range hs.listeners {
 			listener.err(err, event)
 		}
-		return fmt.Errorf("%v: error marshaling event envelope: %v", hs, err)
+		return fmt.Errorf("%v: error creating request: %v", hs, err)
+	}
+	req.Header.Set("Content-Type", mediaType)
+	if hs.secret != nil {
+		req.Header.Set(SignatureHeader, Sign(hs.secret, time.Now(), p))
 	}
 
-	body := bytes.NewReader(p)
-	resp, err := hs.client.Post(hs.url, EventsMediaType, body)
+	resp, err := hs.client.Do(req)
 	if err != nil {
 		for _, listener := range hs.listeners {
 			listener.err(err, event)
@@ -112,6 +128,28 @@ func (hs *httpSink) Write(event events.Event) error {
 	}
 }
 
+// encode returns the body of the request notifying the endpoint of event,
+// and its mediatype.
+func (hs *httpSink) encode(event events.Event) ([]byte, string, error) {
+	switch hs.format {
+	case "", FormatEnvelope:
+		envelope := Envelope{
+			Events: []events.Event{event},
+		}
+		p, err := json.MarshalIndent(envelope, "", "   ")
+		return p, EventsMediaType, err
+	case FormatCloudEvents:
+		ce, err := cloudEvent(event)
+		if err != nil {
+			return nil, "", err
+		}
+		p, err := json.MarshalIndent(ce, "", "   ")
+		return p, CloudEventsMediaType, err
+	default:
+		return nil, "", fmt.Errorf("unknown notification format %q", hs.format)
+	}
+}
+
 // Close the endpoint
 func (hs *httpSink) Close() error {
 	hs.mu.Lock()
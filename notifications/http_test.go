@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"mime"
 	"net"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	events "github.com/docker/go-events"
@@ -65,7 +67,7 @@ func TestHTTPSink(t *testing.T) {
 	server := httptest.NewTLSServer(serverHandler)
 
 	metrics := newSafeMetrics("")
-	sink := newHTTPSink(server.URL, 0, nil, nil,
+	sink := newHTTPSink(server.URL, 0, nil, nil, "", "",
 		&endpointMetricsHTTPStatusListener{safeMetrics: metrics})
 
 	// first make sure that the default transport gives x509 untrusted cert error
@@ -82,7 +84,7 @@ func TestHTTPSink(t *testing.T) {
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
-	sink = newHTTPSink(server.URL, 0, nil, tr,
+	sink = newHTTPSink(server.URL, 0, nil, tr, "", "",
 		&endpointMetricsHTTPStatusListener{safeMetrics: metrics})
 	err = sink.Write(event)
 	if err != nil {
@@ -92,7 +94,7 @@ func TestHTTPSink(t *testing.T) {
 	// reset server to standard http server and sink to a basic sink
 	metrics = newSafeMetrics("")
 	server = httptest.NewServer(serverHandler)
-	sink = newHTTPSink(server.URL, 0, nil, nil,
+	sink = newHTTPSink(server.URL, 0, nil, nil, "", "",
 		&endpointMetricsHTTPStatusListener{safeMetrics: metrics})
 	var expectedMetrics EndpointMetrics
 	expectedMetrics.Statuses = make(map[string]int)
@@ -207,3 +209,57 @@ func createTestEvent(action, repo, typ string) Event {
 
 	return *event
 }
+
+// TestHTTPSinkCloudEvents ensures that events are sent as signed CloudEvents
+// in the structured content mode.
+func TestHTTPSinkCloudEvents(t *testing.T) {
+	secret := "s3cr3t"
+	received := make(chan CloudEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := VerifySignature([]byte(secret), r.Header.Get(SignatureHeader), body, time.Minute); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Header.Get("Content-Type") != CloudEventsMediaType {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		var ce CloudEvent
+		if err := json.Unmarshal(body, &ce); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- ce
+	}))
+	defer server.Close()
+
+	event := createTestEvent("push", "library/test", schema2.MediaTypeManifest)
+	event.Target.Tag = "latest"
+
+	sink := newHTTPSink(server.URL, 0, nil, nil, FormatCloudEvents, secret)
+	if err := sink.Write(event); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+
+	ce := <-received
+	if ce.SpecVersion != "1.0" || ce.ID != event.ID || ce.Type != "org.cncf.distribution.push" ||
+		ce.Source != "//"+event.Source.Addr || ce.Subject != "library/test:latest" || ce.DataContentType != "application/json" {
+		t.Fatalf("unexpected cloud event: %#v", ce)
+	}
+	if ce.Data.Target.Digest != event.Target.Digest || ce.Data.Action != event.Action {
+		t.Fatalf("unexpected cloud event data: %#v", ce.Data)
+	}
+
+	// unsigned requests are rejected by the endpoint
+	sink = newHTTPSink(server.URL, 0, nil, nil, FormatCloudEvents, "")
+	if err := sink.Write(event); err == nil {
+		t.Fatal("unsigned event was accepted")
+	}
+}
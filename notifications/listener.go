@@ -127,6 +127,13 @@ func (msl *manifestServiceListener) Put(ctx context.Context, sm distribution.Man
 	return dgst, err
 }
 
+func (msl *manifestServiceListener) Referrers(ctx context.Context, dgst digest.Digest, artifactType string) ([]v1.Descriptor, error) {
+	if provider, ok := msl.ManifestService.(distribution.ReferrersProvider); ok {
+		return provider.Referrers(ctx, dgst, artifactType)
+	}
+	return nil, distribution.ErrUnsupported
+}
+
 type blobServiceListener struct {
 	distribution.BlobStore
 	parent *repositoryListener
@@ -75,12 +75,14 @@ func init() {
 // number of events. The goal of this to export it via expvar but we may find
 // some other future solution to be better.
 type EndpointMetrics struct {
-	Pending   int            // events pending in queue
-	Events    int            // total events incoming
-	Successes int            // total events written successfully
-	Failures  int            // total events failed
-	Errors    int            // total events errored
-	Statuses  map[string]int // status code histogram, per call event
+	Pending     int            // events pending in queue
+	Events      int            // total events incoming
+	Successes   int            // total events written successfully
+	Failures    int            // total events failed
+	Errors      int            // total events errored
+	Dropped     int            // total events dropped by a full queue
+	DeadLetters int            // total events moved to the dead-letter storage
+	Statuses    map[string]int // status code histogram, per call event
 }
 
 // safeMetrics guards the metrics implementation with a lock and provides a
@@ -114,6 +116,14 @@ func (sm *safeMetrics) eventQueueListener() eventQueueListener {
 	}
 }
 
+// durableQueueListener returns a listener that maintains the counters of a
+// durable queue.
+func (sm *safeMetrics) durableQueueListener() durableQueueListener {
+	return &endpointMetricsEventQueueListener{
+		safeMetrics: sm,
+	}
+}
+
 // endpointMetricsHTTPStatusListener increments counters related to http sinks
 // for the relevant events.
 type endpointMetricsHTTPStatusListener struct {
@@ -174,6 +184,24 @@ func (eqc *endpointMetricsEventQueueListener) egress(event events.Event) {
 	pendingGauge.WithValues(eqc.EndpointName).Dec(1)
 }
 
+func (eqc *endpointMetricsEventQueueListener) dropped(event events.Event) {
+	eqc.Lock()
+	defer eqc.Unlock()
+	eqc.Events++
+	eqc.Dropped++
+
+	eventsCounter.WithValues("Events", eqc.EndpointName).Inc()
+	eventsCounter.WithValues("Dropped", eqc.EndpointName).Inc()
+}
+
+func (eqc *endpointMetricsEventQueueListener) deadLettered(event events.Event) {
+	eqc.Lock()
+	defer eqc.Unlock()
+	eqc.DeadLetters++
+
+	eventsCounter.WithValues("DeadLetters", eqc.EndpointName).Inc()
+}
+
 // register places the endpoint into expvar so that stats are tracked.
 func register(e *Endpoint) {
 	endpoints.mu.Lock()
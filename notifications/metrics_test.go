@@ -17,7 +17,9 @@ func TestMetricsExpvar(t *testing.T) {
 		t.Fatalf("expected nil, got %#v", v)
 	}
 
-	NewEndpoint("x", "y", EndpointConfig{})
+	if _, err := NewEndpoint("x", "y", EndpointConfig{}); err != nil {
+		t.Fatalf("unexpected error creating endpoint: %v", err)
+	}
 
 	if err := json.Unmarshal([]byte(endpointsVar.String()), &v); err != nil {
 		t.Fatalf("unexpected error unmarshaling endpoints: %v", err)
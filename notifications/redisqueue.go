@@ -0,0 +1,97 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisQueueStore is a QueueStore keeping the pending events in a redis
+// stream, and the dead letters in a second, capped stream.
+type redisQueueStore struct {
+	pool           redis.UniversalClient
+	key            string
+	deadLetterKey  string
+	maxDeadLetters int64
+}
+
+// NewRedisQueueStore returns a QueueStore persisting the pending events in
+// the redis stream at key, and the dead letters in the stream at
+// deadLetterKey, which holds about maxDeadLetters entries if positive. The
+// client is not closed with the store.
+func NewRedisQueueStore(pool redis.UniversalClient, key, deadLetterKey string, maxDeadLetters int64) QueueStore {
+	return &redisQueueStore{
+		pool:           pool,
+		key:            key,
+		deadLetterKey:  deadLetterKey,
+		maxDeadLetters: maxDeadLetters,
+	}
+}
+
+// Load returns the entries of the stream, oldest first.
+func (rs *redisQueueStore) Load() ([]QueuedEvent, error) {
+	messages, err := rs.pool.XRange(context.Background(), rs.key, "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]QueuedEvent, 0, len(messages))
+	for _, message := range messages {
+		var event Event
+		p, _ := message.Values["event"].(string)
+		if err := json.Unmarshal([]byte(p), &event); err != nil {
+			return pending, fmt.Errorf("redisqueue: invalid event %s in %s: %w", message.ID, rs.key, err)
+		}
+		pending = append(pending, QueuedEvent{ID: message.ID, Event: event})
+	}
+	return pending, nil
+}
+
+// Push adds the event to the stream.
+func (rs *redisQueueStore) Push(event Event) (string, error) {
+	p, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	return rs.pool.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: rs.key,
+		Values: map[string]interface{}{"event": string(p)},
+	}).Result()
+}
+
+// Ack removes the event from the stream.
+func (rs *redisQueueStore) Ack(id string) error {
+	return rs.pool.XDel(context.Background(), rs.key, id).Err()
+}
+
+// DeadLetter moves the event from the stream to the dead-letter stream.
+func (rs *redisQueueStore) DeadLetter(id string, event Event, cause error) error {
+	p, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = rs.pool.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.XAdd(context.Background(), &redis.XAddArgs{
+			Stream: rs.deadLetterKey,
+			MaxLen: rs.maxDeadLetters,
+			Approx: rs.maxDeadLetters > 0,
+			Values: map[string]interface{}{
+				"event":     string(p),
+				"error":     cause.Error(),
+				"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+			},
+		})
+		pipe.XDel(context.Background(), rs.key, id)
+		return nil
+	})
+	return err
+}
+
+// Close does nothing, the client is shared with the rest of the registry.
+func (rs *redisQueueStore) Close() error {
+	return nil
+}
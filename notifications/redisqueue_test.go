@@ -0,0 +1,73 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisAddr string
+
+func init() {
+	flag.StringVar(&redisAddr, "test.notifications.redis.addr", "", "configure the address of a test instance of redis")
+}
+
+// TestRedisQueueStore exercises the queue store against a live redis
+// instance.
+func TestRedisQueueStore(t *testing.T) {
+	if redisAddr == "" {
+		// fallback to an environment variable
+		redisAddr = os.Getenv("TEST_NOTIFICATIONS_REDIS_ADDR")
+	}
+
+	if redisAddr == "" {
+		// skip if still not set
+		t.Skip("please set -test.notifications.redis.addr to test the notifications queue against redis")
+	}
+
+	pool := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+	defer pool.Close()
+
+	// Clear the database
+	ctx := context.Background()
+	if err := pool.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+
+	store := NewRedisQueueStore(pool, "queue", "deadletter", 10)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := store.Push(createTestEvent("push", "library/test", "blob"))
+		if err != nil {
+			t.Fatalf("error pushing event: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := store.Ack(ids[0]); err != nil {
+		t.Fatalf("error acknowledging event: %v", err)
+	}
+	pending, err := store.Load()
+	if err != nil {
+		t.Fatalf("error loading events: %v", err)
+	}
+	if len(pending) != 2 || pending[0].ID != ids[1] || pending[1].ID != ids[2] {
+		t.Fatalf("unexpected pending events: %v", pending)
+	}
+
+	if err := store.DeadLetter(ids[1], pending[0].Event, errors.New("endpoint unavailable")); err != nil {
+		t.Fatalf("error moving event to the dead-letter storage: %v", err)
+	}
+	if n, err := pool.XLen(ctx, "queue").Result(); err != nil || n != 1 {
+		t.Fatalf("unexpected queue length: %d %v", n, err)
+	}
+	if n, err := pool.XLen(ctx, "deadletter").Result(); err != nil || n != 1 {
+		t.Fatalf("unexpected dead-letter length: %d %v", n, err)
+	}
+}
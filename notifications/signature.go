@@ -0,0 +1,84 @@
+package notifications
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header of the notifications sent to the endpoints
+// configured with a secret. Its value has the form t=<timestamp>,v1=<hmac>,
+// where timestamp is the unix time at which the notification was sent, and
+// hmac the hex encoded HMAC-SHA256 of the timestamp, a dot and the request
+// body, keyed by the secret.
+const SignatureHeader = "X-Registry-Signature"
+
+// ErrInvalidSignature is returned when the signature of a notification does
+// not match its body.
+var ErrInvalidSignature = errors.New("notifications: invalid signature")
+
+// Sign returns the value of the signature header of a notification with the
+// given body, sent at t.
+func Sign(secret []byte, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(signature(secret, timestamp, body))
+}
+
+// VerifySignature checks the signature header of a notification with the
+// given body, sent by a registry configured with secret. If tolerance is
+// positive, signatures made longer than tolerance ago are rejected, so that
+// captured notifications cannot be replayed.
+func VerifySignature(secret []byte, header string, body []byte, tolerance time.Duration) error {
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sig, err := hex.DecodeString(value)
+			if err != nil {
+				continue
+			}
+			signatures = append(signatures, sig)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return fmt.Errorf("%w: malformed %s header", ErrInvalidSignature, SignatureHeader)
+	}
+
+	if tolerance > 0 {
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: malformed timestamp: %v", ErrInvalidSignature, err)
+		}
+		if time.Since(time.Unix(unix, 0)) > tolerance {
+			return fmt.Errorf("%w: signature is older than %s", ErrInvalidSignature, tolerance)
+		}
+	}
+
+	expected := signature(secret, timestamp, body)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func signature(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
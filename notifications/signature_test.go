@@ -0,0 +1,44 @@
+package notifications
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("s3cr3t")
+	body := []byte(`{"events":[]}`)
+	now := time.Now()
+	header := Sign(secret, now, body)
+
+	if err := VerifySignature(secret, header, body, time.Minute); err != nil {
+		t.Fatalf("unexpected error verifying signature: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		secret    []byte
+		header    string
+		body      []byte
+		tolerance time.Duration
+	}{
+		{name: "wrong secret", secret: []byte("other"), header: header, body: body},
+		{name: "tampered body", secret: secret, header: header, body: []byte(`{"events":[{}]}`)},
+		{name: "malformed header", secret: secret, header: "sha256=abcdef", body: body},
+		{name: "expired", secret: secret, header: Sign(secret, now.Add(-time.Hour), body), body: body, tolerance: time.Minute},
+	} {
+		err := VerifySignature(tc.secret, tc.header, tc.body, tc.tolerance)
+		if !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("%s: expected ErrInvalidSignature, got %v", tc.name, err)
+		}
+	}
+
+	// any of several signatures may match, such as while rotating secrets
+	other := Sign([]byte("other"), now, body)
+	multiple := other + "," + header[strings.Index(header, "v1="):]
+	if err := VerifySignature(secret, multiple, body, 0); err != nil {
+		t.Fatalf("unexpected error verifying signatures %q: %v", multiple, err)
+	}
+}
@@ -54,6 +54,18 @@ type RepositoryEnumerator interface {
 	Enumerate(ctx context.Context, ingester func(string) error) error
 }
 
+// RepositoryPrefixEnumerator describes operations to list and enumerate the
+// repositories whose name starts with a prefix, without walking the others.
+type RepositoryPrefixEnumerator interface {
+	// RepositoriesWithPrefix behaves as Namespace.Repositories, filling
+	// 'repos' only with repositories whose name starts with 'prefix'.
+	RepositoriesWithPrefix(ctx context.Context, repos []string, prefix, last string) (n int, err error)
+
+	// EnumeratePrefix applies ingester to each repository whose name starts
+	// with 'prefix'.
+	EnumeratePrefix(ctx context.Context, prefix string, ingester func(string) error) error
+}
+
 // RepositoryRemover removes given repository
 type RepositoryRemover interface {
 	Remove(ctx context.Context, name reference.Named) error
@@ -214,6 +214,28 @@ var (
 		HTTPStatusCode: http.StatusNotFound,
 	})
 
+	// ErrorCodeQuotaExceeded is returned when storing content would exceed
+	// a storage quota of the repository.
+	ErrorCodeQuotaExceeded = register(errGroup, ErrorDescriptor{
+		Value:   "QUOTA_EXCEEDED",
+		Message: "storage quota exceeded",
+		Description: `Returned when the content being pushed would make
+		the repository, or a namespace it belongs to, use more storage than
+		its configured quota allows.`,
+		HTTPStatusCode: http.StatusForbidden,
+	})
+
+	// ErrorCodeTagImmutable is returned when moving or deleting a tag
+	// protected by an immutability policy.
+	ErrorCodeTagImmutable = register(errGroup, ErrorDescriptor{
+		Value:   "TAG_IMMUTABLE",
+		Message: "tag is immutable",
+		Description: `Returned when a manifest put would move a tag
+		protected by an immutability policy to another manifest, or when
+		deleting such a tag or the manifest it references.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
 	// ErrorCodePaginationNumberInvalid is returned when the `n` parameter is
 	// not an integer, or `n` is negative.
 	ErrorCodePaginationNumberInvalid = register(errGroup, ErrorDescriptor{
@@ -28,6 +28,14 @@ var (
 		Description: `Name of the target repository.`,
 	}
 
+	tagParameterDescriptor = ParameterDescriptor{
+		Name:        "tag",
+		Type:        "string",
+		Format:      reference.TagRegexp.String(),
+		Required:    true,
+		Description: `Tag of the target manifest.`,
+	}
+
 	referenceParameterDescriptor = ParameterDescriptor{
 		Name:        "reference",
 		Type:        "string",
@@ -199,6 +207,48 @@ var (
 		},
 	}
 
+	quotaExceededResponseDescriptor = ResponseDescriptor{
+		Name:        "Quota Exceeded",
+		StatusCode:  http.StatusForbidden,
+		Description: "Storing the content would exceed a storage quota applying to the repository.",
+		Headers: []ParameterDescriptor{
+			{
+				Name:        "Content-Length",
+				Type:        "integer",
+				Description: "Length of the JSON response body.",
+				Format:      "<length>",
+			},
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			errcode.ErrorCodeQuotaExceeded,
+		},
+	}
+
+	tagImmutableResponseDescriptor = ResponseDescriptor{
+		Name:        "Tag Immutable",
+		StatusCode:  http.StatusConflict,
+		Description: "The tag is protected by an immutability policy, and cannot be moved to another manifest or deleted.",
+		Headers: []ParameterDescriptor{
+			{
+				Name:        "Content-Length",
+				Type:        "integer",
+				Description: "Length of the JSON response body.",
+				Format:      "<length>",
+			},
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			errcode.ErrorCodeTagImmutable,
+		},
+	}
+
 	tooManyRequestsDescriptor = ResponseDescriptor{
 		Name:        "Too Many Requests",
 		StatusCode:  http.StatusTooManyRequests,
@@ -628,6 +678,8 @@ var routeDescriptors = []RouteDescriptor{
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
+							quotaExceededResponseDescriptor,
+							tagImmutableResponseDescriptor,
 							tooManyRequestsDescriptor,
 							{
 								Name:        "Missing Layer(s)",
@@ -699,6 +751,7 @@ var routeDescriptors = []RouteDescriptor{
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
+							tagImmutableResponseDescriptor,
 							tooManyRequestsDescriptor,
 							{
 								Name:        "Unknown Manifest",
@@ -1472,6 +1525,7 @@ var routeDescriptors = []RouteDescriptor{
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
+							quotaExceededResponseDescriptor,
 							tooManyRequestsDescriptor,
 						},
 					},
@@ -1536,6 +1590,314 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+	{
+		Name:        RouteNameReferrers,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/referrers/{digest:" + digest.DigestRegexp.String() + "}",
+		Entity:      "Referrers",
+		Description: "Retrieve the manifests referring to a manifest through their `subject` field.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch an image index listing the manifests whose subject is the manifest identified by `name` and `digest`. The subject manifest does not need to exist.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Referrers",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							digestPathParameter,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "artifactType",
+								Type:        "string",
+								Description: "Only return referrers with the given artifact type.",
+								Format:      "<media type>",
+								Required:    false,
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "An image index of the referrers of the manifest.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									{
+										Name:        "OCI-Filters-Applied",
+										Type:        "string",
+										Description: "Lists the filters applied to the response, set to `artifactType` when the referrers were filtered.",
+										Format:      "artifactType",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/vnd.oci.image.index.v1+json",
+									Format: `{
+    "schemaVersion": 2,
+    "mediaType": "application/vnd.oci.image.index.v1+json",
+    "manifests": [
+        {
+            "mediaType": <media type>,
+            "size": <size>,
+            "digest": <digest>,
+            "artifactType": <artifact type>,
+            "annotations": <annotations>
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The digest was invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameUsage,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_usage",
+		Entity:      "Usage",
+		Description: "Retrieve the storage used by a repository and the quotas applying to it.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the bytes used by the repository identified by `name`, and by the repositories sharing each of its quotas. Content is accounted once per repository it is linked to.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Usage",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The storage used by the repository.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "repository": <name>,
+    "bytes": <bytes used by the repository>,
+    "quotas": [
+        {
+            "prefix": <prefix of the repository names sharing the quota>,
+            "limit": <limit in bytes>,
+            "bytes": <bytes used by the repositories sharing the quota>
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Not allowed",
+								Description: "Storage quotas are not configured.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameTagHistory,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_tags/{tag:" + reference.TagRegexp.String() + "}/history",
+		Entity:      "Tag History",
+		Description: "Retrieve the manifests a tag previously referenced, and roll the tag back to one of them.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the manifests referenced by the tag, from the most to the least recently tagged. The time is the last time the tag was pointed at the manifest, or the modification time of the tag link in the storage for manifests tagged by earlier registry versions, whose resolution depends on the storage driver. Manifests removed by garbage collection are no longer part of the history.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Tag History",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The history of the tag.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "tag": <tag>,
+    "history": [
+        {
+            "digest": <digest of the manifest>,
+            "tagged": <RFC 3339 time>,
+            "current": <true for the manifest currently tagged>
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Unknown Tag",
+								Description: "The tag is unknown to the registry.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodPost,
+				Description: "Roll the tag back to a manifest it previously referenced.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Tag Rollback",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "digest",
+								Type:        "query",
+								Format:      "<digest>",
+								Required:    true,
+								Description: "Digest of a manifest in the history of the tag.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The tag references the manifest.",
+								StatusCode:  http.StatusCreated,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Description: "The canonical location url of the manifest.",
+										Format:      "<url>",
+									},
+									contentLengthZeroHeader,
+									digestHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Digest",
+								Description: "The `digest` parameter is missing or invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Unknown Manifest",
+								Description: "The tag is unknown, or the manifest is not part of its history or no longer exists.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Not allowed",
+								Description: "Tag rollback is not allowed because the registry is configured as a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tagImmutableResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
 	{
 		Name:        RouteNameCatalog,
 		Path:        "/v2/_catalog",
@@ -1588,6 +1950,61 @@ var routeDescriptors = []RouteDescriptor{
 		...
 	],
 	"next": "<url>?last=<name>&n=<last value of n>"
+}`,
+								},
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									linkHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							invalidPaginationResponseDescriptor,
+						},
+					},
+					{
+						Name:        "Catalog Fetch Filtered",
+						Description: "Return the repositories whose name starts with a prefix, optionally with extended information on each. The details are only returned for the repositories the request was granted pull access to, at most 50 at once.",
+						QueryParameters: append([]ParameterDescriptor{
+							{
+								Name:        "prefix",
+								Type:        "string",
+								Description: "Only return the repositories whose name starts with prefix.",
+								Format:      "<prefix>",
+								Required:    false,
+							},
+							{
+								Name:        "details",
+								Type:        "boolean",
+								Description: "Return the number of tags, the last push time and the size of each repository.",
+								Format:      "true",
+								Required:    false,
+							},
+						}, paginationParameters...),
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"repositories": [
+		<name>,
+		...
+	],
+	"details": [
+		{
+			"name": <name>,
+			"tags": <number of tags>,
+			"lastPush": "<time>",
+			"size": <bytes>
+		},
+		...
+	]
 }`,
 								},
 								Headers: []ParameterDescriptor{
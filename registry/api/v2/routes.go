@@ -16,6 +16,9 @@ const (
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
 	RouteNameCatalog         = "catalog"
+	RouteNameReferrers       = "referrers"
+	RouteNameUsage           = "usage"
+	RouteNameTagHistory      = "tag-history"
 )
 
 var (
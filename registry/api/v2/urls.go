@@ -174,6 +174,46 @@ func (ub *URLBuilder) BuildBlobURL(ref reference.Canonical) (string, error) {
 	return layerURL.String(), nil
 }
 
+// BuildReferrersURL constructs a url to list the manifests referring to the
+// manifest identified by name and digest.
+func (ub *URLBuilder) BuildReferrersURL(ref reference.Canonical, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameReferrers)
+
+	referrersURL, err := route.URL("name", ref.Name(), "digest", ref.Digest().String())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(referrersURL, values...).String(), nil
+}
+
+// BuildUsageURL constructs a url to retrieve the storage used by the
+// repository identified by name.
+func (ub *URLBuilder) BuildUsageURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameUsage)
+
+	usageURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return usageURL.String(), nil
+}
+
+// BuildTagHistoryURL constructs a url to retrieve the manifests previously
+// referenced by the tag of the reference, or to roll the tag back to one of
+// them.
+func (ub *URLBuilder) BuildTagHistoryURL(ref reference.NamedTagged, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameTagHistory)
+
+	historyURL, err := route.URL("name", ref.Name(), "tag", ref.Tag())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(historyURL, values...).String(), nil
+}
+
 // BuildBlobUploadURL constructs a url to begin a blob upload in the
 // repository identified by name.
 func (ub *URLBuilder) BuildBlobUploadURL(name reference.Named, values ...url.Values) (string, error) {
@@ -0,0 +1,204 @@
+// Package acl provides access control lists granting actions on registry
+// resources to users and groups of users.
+//
+// An access control list is a YAML document of the following form:
+//
+//	groups:
+//	  team-a: [alice, bob]
+//	rules:
+//	  - groups: [team-a]
+//	    names: ["team-a/*"]
+//	    actions: [pull, push, delete]
+//	  - users: ["*"]
+//	    names: ["library/*"]
+//	    actions: [pull]
+//	  - users: [admin]
+//	    type: registry
+//	    names: [catalog]
+//	    actions: ["*"]
+//
+// Users and resource names are matched by the patterns of path.Match, and
+// resources are repositories unless the rule sets another type. An action is
+// allowed if a rule grants it, every other action is denied.
+package acl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	"gopkg.in/yaml.v2"
+)
+
+// AnyAction is the action granting every action on the resources of a rule.
+const AnyAction = "*"
+
+// defaultType is the type of the resources of a rule setting none.
+const defaultType = "repository"
+
+// Policy is an access control list.
+type Policy struct {
+	// Groups maps the names of the groups to the names of their members.
+	Groups map[string][]string `yaml:"groups,omitempty"`
+
+	// Rules grant actions on resources to users and groups.
+	Rules []Rule `yaml:"rules"`
+
+	// memberships maps the names of the users to the names of their
+	// groups.
+	memberships map[string][]string
+}
+
+// Rule grants actions on resources to users and groups.
+type Rule struct {
+	// Users are patterns of the names of the users the rule applies to.
+	Users []string `yaml:"users,omitempty"`
+
+	// Groups are the names of the groups the rule applies to.
+	Groups []string `yaml:"groups,omitempty"`
+
+	// Type is the type of the resources, repository by default.
+	Type string `yaml:"type,omitempty"`
+
+	// Names are patterns of the names of the resources.
+	Names []string `yaml:"names"`
+
+	// Actions are the actions granted, such as pull, push, delete or *.
+	Actions []string `yaml:"actions"`
+}
+
+// Parse reads and validates an access control list.
+func Parse(rd io.Reader) (*Policy, error) {
+	in, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := yaml.UnmarshalStrict(in, &p); err != nil {
+		return nil, fmt.Errorf("acl: %v", err)
+	}
+
+	p.memberships = make(map[string][]string)
+	for group, members := range p.Groups {
+		for _, member := range members {
+			p.memberships[member] = append(p.memberships[member], group)
+		}
+	}
+
+	for i, rule := range p.Rules {
+		if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+			return nil, fmt.Errorf("acl: rule %d applies to no users or groups", i)
+		}
+		if len(rule.Names) == 0 || len(rule.Actions) == 0 {
+			return nil, fmt.Errorf("acl: rule %d requires names and actions", i)
+		}
+		for _, group := range rule.Groups {
+			if _, ok := p.Groups[group]; !ok {
+				return nil, fmt.Errorf("acl: rule %d refers to unknown group %q", i, group)
+			}
+		}
+		for _, pattern := range append(append([]string(nil), rule.Users...), rule.Names...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("acl: rule %d has invalid pattern %q: %v", i, pattern, err)
+			}
+		}
+		if rule.Type == "" {
+			p.Rules[i].Type = defaultType
+		}
+	}
+
+	return &p, nil
+}
+
+// Allowed reports whether the policy grants the access to the user.
+func (p *Policy) Allowed(user string, access auth.Access) bool {
+	for _, action := range p.Actions(user, access.Resource) {
+		if action == AnyAction || action == access.Action {
+			return true
+		}
+	}
+	return false
+}
+
+// Actions returns the actions the policy grants the user on the resource,
+// which may include AnyAction.
+func (p *Policy) Actions(user string, resource auth.Resource) []string {
+	var actions []string
+	for _, rule := range p.Rules {
+		if rule.Type == resource.Type && p.appliesTo(rule, user) && matchAny(rule.Names, resource.Name) {
+			actions = append(actions, rule.Actions...)
+		}
+	}
+	return actions
+}
+
+// appliesTo reports whether the rule applies to the user.
+func (p *Policy) appliesTo(rule Rule, user string) bool {
+	if matchAny(rule.Users, user) {
+		return true
+	}
+	for _, group := range p.memberships[user] {
+		for _, g := range rule.Groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchAny reports whether name matches any of the patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// File is a Policy read from a file, which is read again whenever it is
+// modified.
+type File struct {
+	path    string
+	modtime time.Time
+	mu      sync.Mutex
+	policy  *Policy
+}
+
+// NewFile returns a File reading the access control list at path.
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Policy returns the latest access control list of the file.
+func (f *File) Policy() (*Policy, error) {
+	fstat, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	lastModified := fstat.ModTime()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.policy == nil || !f.modtime.Equal(lastModified) {
+		fp, err := os.Open(f.path)
+		if err != nil {
+			return nil, err
+		}
+		defer fp.Close()
+
+		p, err := Parse(fp)
+		if err != nil {
+			return nil, err
+		}
+		f.modtime = lastModified
+		f.policy = p
+	}
+	return f.policy, nil
+}
@@ -0,0 +1,115 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+const testPolicy = `
+groups:
+  team-a: [alice, bob]
+rules:
+  - groups: [team-a]
+    names: ["team-a/*"]
+    actions: [pull, push]
+  - users: ["*"]
+    names: ["library/*"]
+    actions: [pull]
+  - users: [admin]
+    type: registry
+    names: [catalog]
+    actions: ["*"]
+`
+
+func repository(name, action string) auth.Access {
+	return auth.Access{
+		Resource: auth.Resource{Type: "repository", Name: name},
+		Action:   action,
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p, err := Parse(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatalf("error parsing policy: %v", err)
+	}
+
+	catalog := auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}
+	for _, tc := range []struct {
+		user    string
+		access  auth.Access
+		allowed bool
+	}{
+		{"alice", repository("team-a/app", "push"), true},
+		{"bob", repository("team-a/app", "pull"), true},
+		{"bob", repository("team-a/app", "delete"), false},
+		{"bob", repository("team-a/app/nested", "pull"), false},
+		{"carol", repository("team-a/app", "pull"), false},
+		{"carol", repository("library/ubuntu", "pull"), true},
+		{"carol", repository("library/ubuntu", "push"), false},
+		{"admin", catalog, true},
+		{"alice", catalog, false},
+	} {
+		if allowed := p.Allowed(tc.user, tc.access); allowed != tc.allowed {
+			t.Errorf("%s %s %s: expected allowed=%t", tc.user, tc.access.Action, tc.access.Name, tc.allowed)
+		}
+	}
+}
+
+func TestParseInvalidPolicy(t *testing.T) {
+	for _, policy := range []string{
+		"rules:\n  - names: [foo]\n    actions: [pull]\n",
+		"rules:\n  - users: [foo]\n    actions: [pull]\n",
+		"rules:\n  - groups: [unknown]\n    names: [foo]\n    actions: [pull]\n",
+		"rules:\n  - users: [foo]\n    names: [\"[\"]\n    actions: [pull]\n",
+		"rules:\n  - user: [foo]\n    names: [foo]\n    actions: [pull]\n",
+	} {
+		if _, err := Parse(strings.NewReader(policy)); err == nil {
+			t.Errorf("expected error parsing %q", policy)
+		}
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.yml")
+	if err := os.WriteFile(path, []byte(testPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFile(path)
+	p, err := f.Policy()
+	if err != nil {
+		t.Fatalf("error reading policy: %v", err)
+	}
+	if p.Allowed("carol", repository("team-a/app", "pull")) {
+		t.Fatal("unexpected access granted")
+	}
+
+	updated := testPolicy + `
+  - users: [carol]
+    names: ["team-a/*"]
+    actions: [pull]
+`
+	if err := os.WriteFile(path, []byte(updated), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// ensure the modification time changes on filesystems with a coarse
+	// resolution
+	modtime := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, modtime, modtime); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = f.Policy()
+	if err != nil {
+		t.Fatalf("error reading policy: %v", err)
+	}
+	if !p.Allowed("carol", repository("team-a/app", "pull")) {
+		t.Fatal("modified policy was not reloaded")
+	}
+}
@@ -43,6 +43,10 @@ var (
 
 	// ErrAuthenticationFailure returned when authentication fails.
 	ErrAuthenticationFailure = errors.New("authentication failure")
+
+	// ErrInsufficientScope is returned when an authenticated user is not
+	// granted the requested access.
+	ErrInsufficientScope = errors.New("insufficient scope")
 )
 
 // InitFunc is the type of an AccessController factory function and is used
@@ -79,6 +83,11 @@ type Access struct {
 type Grant struct {
 	User      UserInfo   // The authenticated user for the request.
 	Resources []Resource // The list of resources which have been authorized for the request.
+
+	// Allowed reports whether the user is granted other accesses than the
+	// ones requested, for the access controllers which can tell without
+	// another request. It is nil for the others.
+	Allowed func(Access) bool
 }
 
 // Challenge is a special error type which is used for HTTP 401 Unauthorized
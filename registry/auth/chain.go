@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// chain is an AccessController consulting several access controllers in
+// turn.
+type chain []AccessController
+
+// Chain returns an AccessController consulting the access controllers in
+// turn, granting the request the first grant returned. If every access
+// controller denies the request with a challenge, the challenges are combined
+// into a single challenge, advertising all the supported authentication
+// schemes. Any other error denies the request.
+func Chain(accessControllers ...AccessController) AccessController {
+	if len(accessControllers) == 1 {
+		return accessControllers[0]
+	}
+	return chain(accessControllers)
+}
+
+// Authorized implements the AccessController interface.
+func (c chain) Authorized(r *http.Request, access ...Access) (*Grant, error) {
+	var challenges chainChallenge
+	for _, ac := range c {
+		grant, err := ac.Authorized(r, access...)
+		if err == nil {
+			return grant, nil
+		}
+
+		var challenge Challenge
+		if !errors.As(err, &challenge) {
+			return nil, err
+		}
+		challenges = append(challenges, challenge)
+	}
+	return nil, challenges
+}
+
+// chainChallenge combines the challenges of several access controllers.
+type chainChallenge []Challenge
+
+var _ Challenge = chainChallenge{}
+
+// SetHeaders sets the headers of every challenge on the response.
+func (cc chainChallenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
+	for _, challenge := range cc {
+		challenge.SetHeaders(r, w)
+	}
+}
+
+func (cc chainChallenge) Error() string {
+	errs := make([]string, 0, len(cc))
+	for _, challenge := range cc {
+		errs = append(errs, challenge.Error())
+	}
+	return strings.Join(errs, "; ")
+}
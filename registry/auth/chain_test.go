@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// basicController grants requests with basic credentials, and challenges
+// other requests for them.
+type basicController struct{}
+
+func (basicController) Authorized(r *http.Request, access ...Access) (*Grant, error) {
+	if username, _, ok := r.BasicAuth(); ok {
+		return &Grant{User: UserInfo{Name: username}}, nil
+	}
+	return nil, testChallenge(`Basic realm="basic"`)
+}
+
+// bearerController challenges every request for a token.
+type bearerController struct{}
+
+func (bearerController) Authorized(r *http.Request, access ...Access) (*Grant, error) {
+	return nil, testChallenge(`Bearer realm="https://auth.example.com/token"`)
+}
+
+// failingController fails to authorize every request.
+type failingController struct{}
+
+func (failingController) Authorized(r *http.Request, access ...Access) (*Grant, error) {
+	return nil, errors.New("backend unavailable")
+}
+
+type testChallenge string
+
+func (ch testChallenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", string(ch))
+}
+
+func (ch testChallenge) Error() string {
+	return fmt.Sprintf("challenge %s", string(ch))
+}
+
+func TestChain(t *testing.T) {
+	ac := Chain(bearerController{}, basicController{})
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	req.SetBasicAuth("robot", "secret")
+	grant, err := ac.Authorized(req)
+	if err != nil {
+		t.Fatalf("unexpected error authorizing request: %v", err)
+	}
+	if grant.User.Name != "robot" {
+		t.Fatalf("unexpected grant: %#v", grant)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	_, err = ac.Authorized(req)
+	challenge, ok := err.(Challenge)
+	if !ok {
+		t.Fatalf("expected a challenge, got %v", err)
+	}
+	w := httptest.NewRecorder()
+	challenge.SetHeaders(req, w)
+	expected := []string{`Bearer realm="https://auth.example.com/token"`, `Basic realm="basic"`}
+	if headers := w.Header().Values("WWW-Authenticate"); !reflect.DeepEqual(headers, expected) {
+		t.Fatalf("unexpected challenges: %q", headers)
+	}
+}
+
+func TestChainError(t *testing.T) {
+	ac := Chain(failingController{}, basicController{})
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	req.SetBasicAuth("robot", "secret")
+	if _, err := ac.Authorized(req); err == nil {
+		t.Fatal("expected the error of an access controller to deny the request")
+	} else if _, ok := err.(Challenge); ok {
+		t.Fatalf("unexpected challenge: %v", err)
+	}
+}
@@ -3,6 +3,9 @@
 // location.
 //
 // This authentication method MUST be used under TLS, as simple token-replay attack is possible.
+//
+// Authenticated users are granted every access, unless an access control list
+// is configured, in which case they are only granted the access it allows.
 package htpasswd
 
 import (
@@ -13,13 +16,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"sync"
-	"time"
+	"sort"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/acl"
 	"github.com/sirupsen/logrus"
 )
 
@@ -30,11 +34,9 @@ func init() {
 }
 
 type accessController struct {
-	realm    string
-	path     string
-	modtime  time.Time
-	mu       sync.Mutex
-	htpasswd *htpasswd
+	realm string
+	file  *File
+	acl   *acl.File
 }
 
 var _ auth.AccessController = &accessController{}
@@ -53,7 +55,19 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 	if err := createHtpasswdFile(path); err != nil {
 		return nil, err
 	}
-	return &accessController{realm: realm.(string), path: path}, nil
+	ac := &accessController{realm: realm.(string), file: NewFile(path)}
+
+	if aclOpt, present := options["acl"]; present {
+		aclPath, ok := aclOpt.(string)
+		if !ok || aclPath == "" {
+			return nil, fmt.Errorf(`"acl" must be a path for htpasswd access controller`)
+		}
+		ac.acl = acl.NewFile(aclPath)
+		if _, err := ac.acl.Policy(); err != nil {
+			return nil, fmt.Errorf("unable to read htpasswd acl: %v", err)
+		}
+	}
+	return ac, nil
 }
 
 func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.Access) (*auth.Grant, error) {
@@ -66,33 +80,11 @@ func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.
 	}
 
 	// Dynamically parsing the latest account list
-	fstat, err := os.Stat(ac.path)
+	localHTPasswd, err := ac.file.load()
 	if err != nil {
 		return nil, err
 	}
 
-	lastModified := fstat.ModTime()
-	ac.mu.Lock()
-	if ac.htpasswd == nil || !ac.modtime.Equal(lastModified) {
-		ac.modtime = lastModified
-
-		f, err := os.Open(ac.path)
-		if err != nil {
-			ac.mu.Unlock()
-			return nil, err
-		}
-		defer f.Close()
-
-		h, err := newHTPasswd(f)
-		if err != nil {
-			ac.mu.Unlock()
-			return nil, err
-		}
-		ac.htpasswd = h
-	}
-	localHTPasswd := ac.htpasswd
-	ac.mu.Unlock()
-
 	if err := localHTPasswd.authenticateUser(username, password); err != nil {
 		dcontext.GetLogger(req.Context()).Errorf("error authenticating user %q: %v", username, err)
 		return nil, &challenge{
@@ -101,26 +93,82 @@ func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.
 		}
 	}
 
-	return &auth.Grant{User: auth.UserInfo{Name: username}}, nil
+	grant := &auth.Grant{User: auth.UserInfo{Name: username}}
+	if ac.acl == nil {
+		grant.Allowed = func(auth.Access) bool { return true }
+		return grant, nil
+	}
+
+	// Dynamically parsing the latest access control list
+	policy, err := ac.acl.Policy()
+	if err != nil {
+		return nil, err
+	}
+
+	var denied []auth.Access
+	for _, access := range accessRecords {
+		if !policy.Allowed(username, access) {
+			denied = append(denied, access)
+		}
+	}
+	if len(denied) > 0 {
+		dcontext.GetLogger(req.Context()).Infof("user %q denied %s", username, scope(denied))
+		return nil, &challenge{
+			realm:  ac.realm,
+			err:    auth.ErrInsufficientScope,
+			denied: denied,
+		}
+	}
+
+	grant.Allowed = func(access auth.Access) bool {
+		return policy.Allowed(username, access)
+	}
+	return grant, nil
 }
 
 // challenge implements the auth.Challenge interface.
 type challenge struct {
-	realm string
-	err   error
+	realm  string
+	err    error
+	denied []auth.Access
 }
 
 var _ auth.Challenge = challenge{}
 
-// SetHeaders sets the basic challenge header on the response.
+// SetHeaders sets the basic challenge header on the response, along with the
+// denied scope when the user was authenticated.
 func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
-	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", ch.realm))
+	header := fmt.Sprintf("Basic realm=%q", ch.realm)
+	if ch.err == auth.ErrInsufficientScope {
+		header = fmt.Sprintf("%s,error=%q,scope=%q", header, "insufficient_scope", scope(ch.denied))
+	}
+	w.Header().Add("WWW-Authenticate", header)
 }
 
 func (ch challenge) Error() string {
 	return fmt.Sprintf("basic authentication challenge for realm %q: %s", ch.realm, ch.err)
 }
 
+// scope formats access records as the scope of a token request, such as
+// "repository:foo/bar:pull,push".
+func scope(accessRecords []auth.Access) string {
+	actions := make(map[auth.Resource][]string)
+	var resources []auth.Resource
+	for _, access := range accessRecords {
+		if _, ok := actions[access.Resource]; !ok {
+			resources = append(resources, access.Resource)
+		}
+		actions[access.Resource] = append(actions[access.Resource], access.Action)
+	}
+
+	scopes := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		sort.Strings(actions[resource])
+		scopes = append(scopes, fmt.Sprintf("%s:%s:%s", resource.Type, resource.Name, strings.Join(actions[resource], ",")))
+	}
+	return strings.Join(scopes, " ")
+}
+
 // createHtpasswdFile creates and populates htpasswd file with a new user in case the file is missing
 func createHtpasswdFile(path string) error {
 	if f, err := os.Open(path); err == nil {
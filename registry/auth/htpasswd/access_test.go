@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3/registry/auth"
 )
@@ -154,3 +156,76 @@ func TestCreateHtpasswdFile(t *testing.T) {
 		t.Fatalf("failed to find default user in file %s", string(content))
 	}
 }
+
+func TestACLAccessController(t *testing.T) {
+	dir := t.TempDir()
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	aclPath := filepath.Join(dir, "acl.yml")
+	if err := os.WriteFile(htpasswdPath, []byte("frodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeACL := func(policy string, modtime time.Time) {
+		if err := os.WriteFile(aclPath, []byte(policy), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(aclPath, modtime, modtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeACL(`
+rules:
+  - users: [frodo]
+    names: ["shire/*"]
+    actions: [pull]
+`, time.Now().Add(-time.Minute))
+
+	accessController, err := newAccessController(map[string]interface{}{
+		"realm": "The-Shire",
+		"path":  htpasswdPath,
+		"acl":   aclPath,
+	})
+	if err != nil {
+		t.Fatalf("error creating access controller: %v", err)
+	}
+
+	authorize := func(actions ...string) error {
+		req := httptest.NewRequest(http.MethodGet, "/v2/shire/ring/manifests/latest", nil)
+		req.SetBasicAuth("frodo", "baggins")
+		var accessRecords []auth.Access
+		for _, action := range actions {
+			accessRecords = append(accessRecords, auth.Access{
+				Resource: auth.Resource{Type: "repository", Name: "shire/ring"},
+				Action:   action,
+			})
+		}
+		_, err := accessController.Authorized(req, accessRecords...)
+		return err
+	}
+
+	if err := authorize("pull"); err != nil {
+		t.Fatalf("unexpected error authorizing pull: %v", err)
+	}
+
+	err = authorize("pull", "push", "delete")
+	ch, ok := err.(auth.Challenge)
+	if !ok {
+		t.Fatalf("expected a challenge authorizing push, got %v", err)
+	}
+	w := httptest.NewRecorder()
+	ch.SetHeaders(httptest.NewRequest(http.MethodGet, "/", nil), w)
+	expected := `Basic realm="The-Shire",error="insufficient_scope",scope="repository:shire/ring:delete,push"`
+	if header := w.Header().Get("WWW-Authenticate"); header != expected {
+		t.Fatalf("unexpected challenge: %q != %q", header, expected)
+	}
+
+	writeACL(`
+rules:
+  - users: [frodo]
+    names: ["shire/*"]
+    actions: ["*"]
+`, time.Now())
+
+	if err := authorize("pull", "push", "delete"); err != nil {
+		t.Fatalf("modified acl was not reloaded: %v", err)
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/distribution/distribution/v3/registry/auth"
 
@@ -48,6 +51,59 @@ func (htpasswd *htpasswd) authenticateUser(username string, password string) err
 	return nil
 }
 
+// File is a CredentialAuthenticator checking credentials against the entries
+// of an htpasswd file, which is parsed again whenever it is modified.
+type File struct {
+	path     string
+	modtime  time.Time
+	mu       sync.Mutex
+	htpasswd *htpasswd
+}
+
+var _ auth.CredentialAuthenticator = &File{}
+
+// NewFile returns a File reading the htpasswd file at path.
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// AuthenticateUser checks a given user:password credential against the
+// latest entries of the file. If the check passes, nil is returned.
+func (f *File) AuthenticateUser(username string, password string) error {
+	h, err := f.load()
+	if err != nil {
+		return err
+	}
+	return h.authenticateUser(username, password)
+}
+
+// load returns the entries of the file, parsing it again if it was modified.
+func (f *File) load() (*htpasswd, error) {
+	fstat, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	lastModified := fstat.ModTime()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.htpasswd == nil || !f.modtime.Equal(lastModified) {
+		fp, err := os.Open(f.path)
+		if err != nil {
+			return nil, err
+		}
+		defer fp.Close()
+
+		h, err := newHTPasswd(fp)
+		if err != nil {
+			return nil, err
+		}
+		f.modtime = lastModified
+		f.htpasswd = h
+	}
+	return f.htpasswd, nil
+}
+
 // parseHTPasswd parses the contents of htpasswd. This will read all the
 // entries in the file, whether or not they are needed. An error is returned
 // if a syntax errors are encountered or if the reader fails.
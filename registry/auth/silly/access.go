@@ -67,7 +67,10 @@ func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.
 		return nil, &challenge
 	}
 
-	return &auth.Grant{User: auth.UserInfo{Name: "silly"}}, nil
+	return &auth.Grant{
+		User:    auth.UserInfo{Name: "silly"},
+		Allowed: func(auth.Access) bool { return true },
+	}, nil
 }
 
 type challenge struct {
@@ -86,7 +89,7 @@ func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
 		header = fmt.Sprintf("%s,scope=%q", header, ch.scope)
 	}
 
-	w.Header().Set("WWW-Authenticate", header)
+	w.Header().Add("WWW-Authenticate", header)
 }
 
 func (ch challenge) Error() string {
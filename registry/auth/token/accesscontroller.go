@@ -1,7 +1,6 @@
 package token
 
 import (
-	"crypto"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -12,6 +11,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/distribution/distribution/v3/registry/auth"
 	"github.com/go-jose/go-jose/v4"
@@ -78,7 +78,7 @@ func (s accessSet) scopeParam() string {
 
 // Errors used and exported by this package.
 var (
-	ErrInsufficientScope = errors.New("insufficient scope")
+	ErrInsufficientScope = auth.ErrInsufficientScope
 	ErrTokenRequired     = errors.New("authorization token required")
 )
 
@@ -156,9 +156,8 @@ type accessController struct {
 	autoRedirectPath  string
 	issuer            string
 	service           string
-	rootCerts         *x509.CertPool
-	trustedKeys       map[string]crypto.PublicKey
 	signingAlgorithms []jose.SignatureAlgorithm
+	keys              *keySources
 }
 
 const (
@@ -175,6 +174,9 @@ type tokenAccessOptions struct {
 	service           string
 	rootCertBundle    string
 	jwks              string
+	jwksURL           string
+	oidcIssuer        string
+	jwksRefresh       time.Duration
 	signingAlgorithms []string
 }
 
@@ -226,6 +228,33 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 		}
 	}
 
+	for key, val := range map[string]*string{"jwksurl": &opts.jwksURL, "oidcissuer": &opts.oidcIssuer} {
+		if v, ok := options[key]; ok {
+			if *val, ok = v.(string); !ok {
+				return opts, fmt.Errorf("token auth requires a valid option string: %q", key)
+			}
+		}
+	}
+
+	opts.jwksRefresh = defaultJWKSRefresh
+	if r, ok := options["jwksrefresh"]; ok {
+		switch r := r.(type) {
+		case time.Duration:
+			opts.jwksRefresh = r
+		case string:
+			refresh, err := time.ParseDuration(r)
+			if err != nil {
+				return opts, fmt.Errorf("token auth requires a valid option duration: jwksrefresh: %v", err)
+			}
+			opts.jwksRefresh = refresh
+		default:
+			return opts, errors.New("token auth requires a valid option duration: jwksrefresh")
+		}
+		if opts.jwksRefresh <= 0 {
+			return opts, errors.New("token auth requires a positive jwksrefresh")
+		}
+	}
+
 	signingAlgos, ok := options["signingalgorithms"]
 	if ok {
 		signingAlgorithmsVals, ok := signingAlgos.([]string)
@@ -274,8 +303,6 @@ func getRootCerts(path string) ([]*x509.Certificate, error) {
 }
 
 func getJwks(path string) (*jose.JSONWebKeySet, error) {
-	// TODO(milosgajdos): we should consider providing a JWKS
-	// URL from which the JWKS could be fetched
 	jp, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open jwks file %q: %s", path, err)
@@ -314,47 +341,25 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		return nil, err
 	}
 
-	var (
-		rootCerts []*x509.Certificate
-		jwks      *jose.JSONWebKeySet
-		signAlgos []jose.SignatureAlgorithm
-	)
-
-	if config.rootCertBundle != "" {
-		rootCerts, err = rootCertFetcher(config.rootCertBundle)
-		if err != nil {
-			return nil, err
-		}
+	keys := &keySources{
+		rootCertBundle: config.rootCertBundle,
+		jwks:           config.jwks,
+		jwksURL:        config.jwksURL,
+		oidcIssuer:     config.oidcIssuer,
+		refresh:        config.jwksRefresh,
+		fileCheck:      keyFilesCheckInterval,
+		client:         &http.Client{Timeout: 10 * time.Second},
 	}
-
-	if config.jwks != "" {
-		jwks, err = jwkFetcher(config.jwks)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	if (len(rootCerts) == 0 && jwks == nil) || // no certs bundle and no jwks
-		(len(rootCerts) == 0 && jwks != nil && len(jwks.Keys) == 0) { // no certs bundle and empty jwks
-		return nil, errors.New("token auth requires at least one token signing key")
-	}
-
-	trustedKeys := make(map[string]crypto.PublicKey)
-	rootPool := x509.NewCertPool()
-	for _, rootCert := range rootCerts {
-		rootPool.AddCert(rootCert)
-		if key := GetRFC7638Thumbprint(rootCert.PublicKey); key != "" {
-			trustedKeys[key] = rootCert.PublicKey
-		}
+	if err := keys.load(); err != nil {
+		return nil, err
 	}
 
-	if jwks != nil {
-		for _, key := range jwks.Keys {
-			trustedKeys[key.KeyID] = key.Public()
-		}
+	if len(keys.keys().trustedKeys) == 0 && len(keys.rootCerts) == 0 {
+		// no certs bundle and no jwks
+		return nil, errNoSigningKey
 	}
 
-	signAlgos, err = getSigningAlgorithms(config.signingAlgorithms)
+	signAlgos, err := getSigningAlgorithms(config.signingAlgorithms)
 	if err != nil {
 		return nil, err
 	}
@@ -364,16 +369,39 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		signAlgos = defaultSigningAlgorithms
 	}
 
-	return &accessController{
+	ac := &accessController{
 		realm:             config.realm,
 		autoRedirect:      config.autoRedirect,
 		autoRedirectPath:  config.autoRedirectPath,
 		issuer:            config.issuer,
 		service:           config.service,
-		rootCerts:         rootPool,
-		trustedKeys:       trustedKeys,
 		signingAlgorithms: signAlgos,
-	}, nil
+		keys:              keys,
+	}
+	keys.start()
+	return ac, nil
+}
+
+// Close stops refreshing the trusted keys in the background.
+func (ac *accessController) Close() error {
+	ac.keys.stop()
+	return nil
+}
+
+// verifyOptions returns the options verifying a token, after fetching the
+// trusted keys again if the token is signed by an unknown key.
+func (ac *accessController) verifyOptions(token *Token) VerifyOptions {
+	keys := ac.keys.keys()
+	if unknownKeyID(token, keys.trustedKeys) && ac.keys.fetchUnknown() {
+		keys = ac.keys.keys()
+	}
+
+	return VerifyOptions{
+		TrustedIssuers:    []string{ac.issuer},
+		AcceptedAudiences: []string{ac.service},
+		Roots:             keys.rootCerts,
+		TrustedKeys:       keys.trustedKeys,
+	}
 }
 
 // Authorized handles checking whether the given request is authorized
@@ -399,14 +427,7 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 		return nil, challenge
 	}
 
-	verifyOpts := VerifyOptions{
-		TrustedIssuers:    []string{ac.issuer},
-		AcceptedAudiences: []string{ac.service},
-		Roots:             ac.rootCerts,
-		TrustedKeys:       ac.trustedKeys,
-	}
-
-	claims, err := token.Verify(verifyOpts)
+	claims, err := token.Verify(ac.verifyOptions(token))
 	if err != nil {
 		challenge.err = err
 		return nil, challenge
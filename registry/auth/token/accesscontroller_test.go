@@ -2,15 +2,26 @@ package token
 
 import (
 	"testing"
+	"time"
 
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
 
 	"net/http"
 	"net/http/httptest"
 
+	"github.com/distribution/distribution/v3/registry/auth"
 	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
 )
 
 func TestBuildAutoRedirectURL(t *testing.T) {
@@ -143,7 +154,7 @@ func TestRootCertIncludedInTrustedKeys(t *testing.T) {
 	// newAccessController return type is an interface built from
 	// accessController struct. The type check can be safely ignored.
 	ac2, _ := ac.(*accessController)
-	if got := len(ac2.trustedKeys); got != 1 {
+	if got := len(ac2.keys.keys().trustedKeys); got != 1 {
 		t.Fatalf("Unexpected number of trusted keys, expected 1 got: %d", got)
 	}
 }
@@ -173,7 +184,329 @@ func TestJWKSIncludedInTrustedKeys(t *testing.T) {
 	// newAccessController return type is an interface built from
 	// accessController struct. The type check can be safely ignored.
 	ac2, _ := ac.(*accessController)
-	if got := len(ac2.trustedKeys); got != 1 {
+	if got := len(ac2.keys.keys().trustedKeys); got != 1 {
 		t.Fatalf("Unexpected number of trusted keys, expected 1 got: %d", got)
 	}
 }
+
+// makeKeyIDToken returns a token granting pull access to foo/bar, signed by
+// key and identified by keyID.
+func makeKeyIDToken(t *testing.T, key *ecdsa.PrivateKey, keyID, issuer, service string) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", keyID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	raw, err := jwt.Signed(signer).Claims(&ClaimSet{
+		Issuer:     issuer,
+		Subject:    "foo",
+		Audience:   []string{service},
+		Expiration: now.Add(time.Minute).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		JWTID:      keyID,
+		Access:     []*ResourceActions{{Type: "repository", Name: "foo/bar", Actions: []string{"pull"}}},
+	}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func makeJWKS(keys map[string]*ecdsa.PrivateKey) jose.JSONWebKeySet {
+	var jwks jose.JSONWebKeySet
+	for keyID, key := range keys {
+		jwks.Keys = append(jwks.Keys, jose.JSONWebKey{Key: key.Public(), KeyID: keyID, Algorithm: string(jose.ES256), Use: "sig"})
+	}
+	return jwks
+}
+
+func authorizeKeyIDToken(ac auth.AccessController, raw string) error {
+	req := httptest.NewRequest(http.MethodGet, "/v2/foo/bar/manifests/latest", nil)
+	req.Header.Set("Authorization", "Bearer "+raw)
+	_, err := ac.Authorized(req, auth.Access{
+		Resource: auth.Resource{Type: "repository", Name: "foo/bar"},
+		Action:   "pull",
+	})
+	return err
+}
+
+func TestOIDCIssuerKeyRotation(t *testing.T) {
+	issuer := "test-issuer.example.com"
+	service := "test-service.example.com"
+
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, keyID := range []string{"key-1", "key-2"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[keyID] = key
+	}
+
+	var (
+		mu        sync.Mutex
+		published = map[string]*ecdsa.PrivateKey{"key-1": keys["key-1"]}
+		fetches   int
+	)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case oidcDiscoveryPath:
+			// nolint:errcheck
+			json.NewEncoder(w).Encode(map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/keys"})
+		case "/keys":
+			fetches++
+			// nolint:errcheck
+			json.NewEncoder(w).Encode(makeJWKS(published))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":       "https://auth.example.com/token/",
+		"issuer":      issuer,
+		"service":     service,
+		"oidcissuer":  server.URL,
+		"jwksrefresh": "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ac.(io.Closer).Close()
+
+	if err := authorizeKeyIDToken(ac, makeKeyIDToken(t, keys["key-1"], "key-1", issuer, service)); err != nil {
+		t.Fatalf("unexpected error authorizing token signed by a published key: %v", err)
+	}
+	if err := authorizeKeyIDToken(ac, makeKeyIDToken(t, keys["key-2"], "key-2", issuer, service)); err == nil {
+		t.Fatal("token signed by an unpublished key was authorized")
+	}
+
+	mu.Lock()
+	published["key-2"] = keys["key-2"]
+	mu.Unlock()
+
+	// tokens signed by unknown keys fetch the keys again at most every
+	// minJWKSRefresh
+	if err := authorizeKeyIDToken(ac, makeKeyIDToken(t, keys["key-2"], "key-2", issuer, service)); err == nil {
+		t.Fatal("keys were fetched again before minJWKSRefresh")
+	}
+	ac2, _ := ac.(*accessController)
+	ac2.keys.mu.Lock()
+	ac2.keys.lastFetch = time.Now().Add(-minJWKSRefresh)
+	ac2.keys.mu.Unlock()
+
+	if err := authorizeKeyIDToken(ac, makeKeyIDToken(t, keys["key-2"], "key-2", issuer, service)); err != nil {
+		t.Fatalf("unexpected error authorizing token signed by a rotated key: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 2 {
+		t.Fatalf("unexpected number of jwks fetches: %d", fetches)
+	}
+}
+
+func TestJWKSFileReload(t *testing.T) {
+	issuer := "test-issuer.example.com"
+	service := "test-service.example.com"
+
+	key1, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS := func(jwks jose.JSONWebKeySet, modtime time.Time) {
+		p, err := json.Marshal(jwks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, p, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modtime, modtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeJWKS(makeJWKS(map[string]*ecdsa.PrivateKey{"key-1": key1}), time.Now().Add(-time.Minute))
+
+	defer func(interval time.Duration) { keyFilesCheckInterval = interval }(keyFilesCheckInterval)
+	keyFilesCheckInterval = 10 * time.Millisecond
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":   "https://auth.example.com/token/",
+		"issuer":  issuer,
+		"service": service,
+		"jwks":    path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ac.(io.Closer).Close()
+
+	if err := authorizeKeyIDToken(ac, makeKeyIDToken(t, key2, "key-2", issuer, service)); err == nil {
+		t.Fatal("token signed by an untrusted key was authorized")
+	}
+
+	writeJWKS(makeJWKS(map[string]*ecdsa.PrivateKey{"key-2": key2}), time.Now())
+
+	// the file is reloaded in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := authorizeKeyIDToken(ac, makeKeyIDToken(t, key2, "key-2", issuer, service))
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected error authorizing token signed by a reloaded key: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := authorizeKeyIDToken(ac, makeKeyIDToken(t, key1, "key-1", issuer, service)); err == nil {
+		t.Fatal("token signed by a removed key was authorized")
+	}
+}
+
+func TestCloseStopsKeyReload(t *testing.T) {
+	issuer := "test-issuer.example.com"
+	service := "test-service.example.com"
+
+	key1, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS := func(jwks jose.JSONWebKeySet, modtime time.Time) {
+		p, err := json.Marshal(jwks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, p, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modtime, modtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeJWKS(makeJWKS(map[string]*ecdsa.PrivateKey{"key-1": key1}), time.Now().Add(-time.Minute))
+
+	defer func(interval time.Duration) { keyFilesCheckInterval = interval }(keyFilesCheckInterval)
+	keyFilesCheckInterval = 10 * time.Millisecond
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":   "https://auth.example.com/token/",
+		"issuer":  issuer,
+		"service": service,
+		"jwks":    path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.(io.Closer).Close(); err != nil {
+		t.Fatalf("unexpected error closing access controller: %v", err)
+	}
+
+	// the file is no longer reloaded once closed
+	writeJWKS(makeJWKS(map[string]*ecdsa.PrivateKey{"key-2": key2}), time.Now())
+	time.Sleep(10 * keyFilesCheckInterval)
+	if err := authorizeKeyIDToken(ac, makeKeyIDToken(t, key2, "key-2", issuer, service)); err == nil {
+		t.Fatal("file was reloaded after closing the access controller")
+	}
+}
+
+func TestUnknownKeyFetchDoesNotBlock(t *testing.T) {
+	issuer := "test-issuer.example.com"
+	service := "test-service.example.com"
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu      sync.Mutex
+		fetches int
+	)
+	fetching := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		block := fetches > 1
+		mu.Unlock()
+		if block {
+			// hold the fetches triggered by unknown keys
+			fetching <- struct{}{}
+			<-release
+		}
+		// nolint:errcheck
+		json.NewEncoder(w).Encode(makeJWKS(map[string]*ecdsa.PrivateKey{"key-1": key}))
+	}))
+	defer server.Close()
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":       "https://auth.example.com/token/",
+		"issuer":      issuer,
+		"service":     service,
+		"jwksurl":     server.URL,
+		"jwksrefresh": "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ac.(io.Closer).Close()
+	ac2, _ := ac.(*accessController)
+	ac2.keys.mu.Lock()
+	ac2.keys.lastFetch = time.Now().Add(-minJWKSRefresh)
+	ac2.keys.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keyID := fmt.Sprintf("unknown-%d", i)
+			if err := authorizeKeyIDToken(ac, makeKeyIDToken(t, key, keyID, issuer, service)); err == nil {
+				t.Errorf("token signed by unknown key %s was authorized", keyID)
+			}
+		}(i)
+	}
+	<-fetching
+
+	// tokens signed by known keys are verified while the keys are fetched
+	done := make(chan error)
+	go func() {
+		done <- authorizeKeyIDToken(ac, makeKeyIDToken(t, key, "key-1", issuer, service))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error authorizing token signed by a known key: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("verifying a token signed by a known key waited for the keys to be fetched")
+	}
+
+	close(release)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 2 {
+		t.Fatalf("unexpected number of jwks fetches: %d", fetches)
+	}
+}
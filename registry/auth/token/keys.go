@@ -0,0 +1,417 @@
+package token
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// defaultJWKSRefresh is the interval at which the keys of a JWKS URL
+	// are fetched again if not configured.
+	defaultJWKSRefresh = time.Hour
+	// minJWKSRefresh is the minimum interval between two fetches of a JWKS
+	// URL triggered by tokens signed by unknown keys.
+	minJWKSRefresh = 10 * time.Second
+	// oidcDiscoveryPath is the path of the OpenID Connect discovery
+	// document, relative to the issuer.
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+)
+
+// keyFilesCheckInterval is the interval at which the root certificate bundle
+// and the JWKS file are checked for modifications.
+var keyFilesCheckInterval = 10 * time.Second
+
+// keyRefreshFailures counts the failures to refresh the keys trusted to sign
+// tokens, by source of the keys.
+var keyRefreshFailures = prometheus.AuthNamespace.NewLabeledCounter("key_refresh_failures", "The number of failures to refresh the keys trusted to sign tokens", "source")
+
+func init() {
+	metrics.Register(prometheus.AuthNamespace)
+}
+
+// keySources loads the keys trusted to sign tokens from a root certificate
+// bundle and a JWKS file, which are read again when they are modified, and
+// from a JWKS URL, which is fetched again at an interval and when a token is
+// signed by an unknown key. The JWKS URL may be discovered from an OpenID
+// Connect issuer.
+//
+// The files are checked and the URL fetched in the background or, for tokens
+// signed by unknown keys, in a single fetch shared by the requests, so that
+// verifying the tokens signed by known keys never waits for them. The trusted
+// keys are swapped once rebuilt.
+type keySources struct {
+	rootCertBundle string
+	jwks           string
+	jwksURL        string
+	oidcIssuer     string
+	refresh        time.Duration
+	fileCheck      time.Duration
+	client         *http.Client
+
+	fetches singleflight.Group
+	current atomic.Pointer[trustedKeySet]
+
+	done     chan struct{} // closed to stop checking the files and fetching the URL
+	stopOnce sync.Once
+
+	mu               sync.Mutex // protects the loaded keys, not held while reading or fetching them
+	rootCertsModTime time.Time
+	jwksModTime      time.Time
+	rootCerts        []*x509.Certificate
+	fileKeys         *jose.JSONWebKeySet
+	urlKeys          *jose.JSONWebKeySet
+	discoveredURL    string
+	lastFetch        time.Time
+}
+
+// trustedKeySet is the pool of root certificates and the trusted keys, by
+// key ID, built from the key sources.
+type trustedKeySet struct {
+	rootCerts   *x509.CertPool
+	trustedKeys map[string]crypto.PublicKey
+}
+
+// load reads all the sources, failing if any cannot be read, and builds the
+// trusted keys.
+func (ks *keySources) load() error {
+	if _, err := ks.reloadFiles(true); err != nil {
+		return err
+	}
+	if ks.remote() {
+		ks.mu.Lock()
+		ks.lastFetch = time.Now()
+		ks.mu.Unlock()
+		if err := ks.fetch(); err != nil {
+			return err
+		}
+	}
+	ks.rebuild()
+	return nil
+}
+
+// start checks the files for modifications at the file check interval, and
+// fetches the JWKS URL at the refresh interval, in the background until
+// stopped.
+func (ks *keySources) start() {
+	ks.done = make(chan struct{})
+	if (ks.rootCertBundle != "" || ks.jwks != "") && ks.fileCheck > 0 {
+		go ks.watch()
+	}
+	if ks.remote() {
+		go ks.poll()
+	}
+}
+
+// stop stops checking the files and fetching the URL in the background.
+func (ks *keySources) stop() {
+	ks.stopOnce.Do(func() {
+		if ks.done != nil {
+			close(ks.done)
+		}
+	})
+}
+
+// keys returns the trusted keys.
+func (ks *keySources) keys() *trustedKeySet {
+	return ks.current.Load()
+}
+
+// remote reports whether keys are fetched from a URL.
+func (ks *keySources) remote() bool {
+	return ks.jwksURL != "" || ks.oidcIssuer != ""
+}
+
+// reloadFiles reads the files which were modified since they were last read,
+// or all of them if force is set. It reports whether any was read.
+func (ks *keySources) reloadFiles(force bool) (bool, error) {
+	ks.mu.Lock()
+	rootCertsModTime, jwksModTime := ks.rootCertsModTime, ks.jwksModTime
+	ks.mu.Unlock()
+
+	var changed bool
+	if ks.rootCertBundle != "" {
+		modtime, read, err := modified(ks.rootCertBundle, rootCertsModTime, force)
+		if err != nil {
+			return changed, err
+		}
+		if read {
+			rootCerts, err := rootCertFetcher(ks.rootCertBundle)
+			if err != nil {
+				return changed, err
+			}
+			ks.mu.Lock()
+			ks.rootCerts, ks.rootCertsModTime, changed = rootCerts, modtime, true
+			ks.mu.Unlock()
+		}
+	}
+
+	if ks.jwks != "" {
+		modtime, read, err := modified(ks.jwks, jwksModTime, force)
+		if err != nil {
+			return changed, err
+		}
+		if read {
+			jwks, err := jwkFetcher(ks.jwks)
+			if err != nil {
+				return changed, err
+			}
+			ks.mu.Lock()
+			ks.fileKeys, ks.jwksModTime, changed = jwks, modtime, true
+			ks.mu.Unlock()
+		}
+	}
+	return changed, nil
+}
+
+// modified returns the modification time of the file at path, and whether it
+// should be read because it differs from last or force is set. When forced,
+// files which cannot be stat'ed are read, so that reading them reports the
+// error.
+func modified(path string, last time.Time, force bool) (time.Time, bool, error) {
+	fstat, err := os.Stat(path)
+	if err != nil {
+		if force {
+			return time.Time{}, true, nil
+		}
+		return time.Time{}, false, err
+	}
+	return fstat.ModTime(), force || !fstat.ModTime().Equal(last), nil
+}
+
+// fetch fetches the keys of the JWKS URL, discovering it first if needed.
+func (ks *keySources) fetch() error {
+	ks.mu.Lock()
+	jwksURL := ks.jwksURL
+	if jwksURL == "" {
+		jwksURL = ks.discoveredURL
+	}
+	ks.mu.Unlock()
+
+	if jwksURL == "" {
+		discovered, err := discoverJwksURL(ks.client, ks.oidcIssuer)
+		if err != nil {
+			return err
+		}
+		jwksURL = discovered
+		ks.mu.Lock()
+		ks.discoveredURL = discovered
+		ks.mu.Unlock()
+	}
+
+	jwks, err := fetchJwks(ks.client, jwksURL)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err != nil {
+		// discover the URL again, in case the issuer moved it
+		ks.discoveredURL = ""
+		return err
+	}
+	ks.urlKeys = jwks
+	return nil
+}
+
+// fetchUnknown fetches the JWKS URL for a token signed by an unknown key,
+// unless it was fetched recently, and rebuilds the trusted keys. Concurrent
+// calls share the same fetch, and the fetches done in the background. It
+// reports whether the keys were fetched. Failures are logged and counted, the
+// keys fetched previously being kept.
+func (ks *keySources) fetchUnknown() bool {
+	if !ks.remote() {
+		return false
+	}
+
+	fetched, _, _ := ks.fetches.Do("fetch", func() (interface{}, error) {
+		ks.mu.Lock()
+		if time.Since(ks.lastFetch) < minJWKSRefresh {
+			ks.mu.Unlock()
+			return false, nil
+		}
+		ks.lastFetch = time.Now()
+		ks.mu.Unlock()
+
+		if err := ks.fetch(); err != nil {
+			logrus.Errorf("token auth: error fetching trusted keys: %v", err)
+			keyRefreshFailures.WithValues("url").Inc(1)
+			return false, nil
+		}
+		ks.rebuild()
+		return true, nil
+	})
+	return fetched.(bool)
+}
+
+// watch reloads the modified files at the file check interval, rebuilding
+// the trusted keys when they change. Failures are logged and counted, the
+// keys loaded previously being kept.
+func (ks *keySources) watch() {
+	ticker := time.NewTicker(ks.fileCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ks.done:
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := ks.reloadFiles(false)
+		if err != nil {
+			logrus.Errorf("token auth: error reloading trusted keys: %v", err)
+			keyRefreshFailures.WithValues("file").Inc(1)
+		}
+		if changed {
+			ks.rebuild()
+		}
+	}
+}
+
+// poll fetches the JWKS URL at the refresh interval, rebuilding the trusted
+// keys after each successful fetch.
+func (ks *keySources) poll() {
+	ticker := time.NewTicker(ks.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ks.done:
+			return
+		case <-ticker.C:
+		}
+
+		// nolint:errcheck
+		ks.fetches.Do("fetch", func() (interface{}, error) {
+			ks.mu.Lock()
+			ks.lastFetch = time.Now()
+			ks.mu.Unlock()
+
+			if err := ks.fetch(); err != nil {
+				logrus.Errorf("token auth: error fetching trusted keys: %v", err)
+				keyRefreshFailures.WithValues("url").Inc(1)
+				return false, nil
+			}
+			ks.rebuild()
+			return true, nil
+		})
+	}
+}
+
+// rebuild builds the trusted keys from the loaded keys, and swaps them in.
+func (ks *keySources) rebuild() {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	trustedKeys := make(map[string]crypto.PublicKey)
+	rootPool := x509.NewCertPool()
+	for _, rootCert := range ks.rootCerts {
+		rootPool.AddCert(rootCert)
+		if key := GetRFC7638Thumbprint(rootCert.PublicKey); key != "" {
+			trustedKeys[key] = rootCert.PublicKey
+		}
+	}
+
+	for _, jwks := range []*jose.JSONWebKeySet{ks.fileKeys, ks.urlKeys} {
+		if jwks == nil {
+			continue
+		}
+		for _, key := range jwks.Keys {
+			if key.Use == "enc" {
+				continue
+			}
+			trustedKeys[key.KeyID] = key.Public()
+		}
+	}
+	ks.current.Store(&trustedKeySet{rootCerts: rootPool, trustedKeys: trustedKeys})
+}
+
+// fetchJwks fetches the JSON Web Key Set at url.
+func fetchJwks(client *http.Client, url string) (*jose.JSONWebKeySet, error) {
+	var jwks jose.JSONWebKeySet
+	if err := getJSON(client, url, &jwks); err != nil {
+		return nil, fmt.Errorf("unable to fetch jwks: %v", err)
+	}
+	return &jwks, nil
+}
+
+// discoverJwksURL returns the JWKS URL of an OpenID Connect issuer.
+func discoverJwksURL(client *http.Client, issuer string) (string, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JwksURI string `json:"jwks_uri"`
+	}
+	issuer = strings.TrimSuffix(issuer, "/")
+	if err := getJSON(client, issuer+oidcDiscoveryPath, &discovery); err != nil {
+		return "", fmt.Errorf("unable to discover oidc issuer %q: %v", issuer, err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return "", fmt.Errorf("oidc discovery document of %q is for issuer %q", issuer, discovery.Issuer)
+	}
+	if discovery.JwksURI == "" {
+		return "", fmt.Errorf("oidc issuer %q has no jwks_uri", issuer)
+	}
+	return discovery.JwksURI, nil
+}
+
+// getJSON decodes the JSON document at url into v.
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid document at %s: %v", url, err)
+	}
+	return nil
+}
+
+// unknownKeyID reports whether the token is identified by a key ID which is
+// not trusted, rather than by a certificate chain.
+func unknownKeyID(token *Token, trustedKeys map[string]crypto.PublicKey) bool {
+	if len(token.JWT.Headers) == 0 {
+		return false
+	}
+	header := token.JWT.Headers[0]
+	if _, err := header.Certificates(x509.VerifyOptions{}); !errors.Is(err, jose.ErrMissingX5cHeader) {
+		return false
+	}
+
+	keyID := header.KeyID
+	if header.JSONWebKey != nil {
+		if len(header.JSONWebKey.Certificates) > 0 {
+			return false
+		}
+		keyID = header.JSONWebKey.KeyID
+	}
+	if keyID == "" {
+		return false
+	}
+	_, ok := trustedKeys[keyID]
+	return !ok
+}
+
+var errNoSigningKey = errors.New("token auth requires at least one token signing key")
@@ -0,0 +1,153 @@
+package tokenserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// signingKey is the private key signing the tokens, along with its
+// certificate chain, if any.
+type signingKey struct {
+	key       crypto.Signer
+	algorithm jose.SignatureAlgorithm
+	keyID     string
+	chain     []*x509.Certificate
+}
+
+// loadSigningKey reads the PEM encoded private key at keyPath, and the PEM
+// encoded certificate chain at certPath if not empty.
+func loadSigningKey(keyPath, certPath, keyID string) (*signingKey, error) {
+	p, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token signing key: %v", err)
+	}
+	block, _ := pem.Decode(p)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key in %s", keyPath)
+	}
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse token signing key: %v", err)
+	}
+
+	sk := &signingKey{key: key, keyID: keyID}
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		sk.algorithm = jose.RS256
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			sk.algorithm = jose.ES256
+		case elliptic.P384():
+			sk.algorithm = jose.ES384
+		case elliptic.P521():
+			sk.algorithm = jose.ES512
+		default:
+			return nil, fmt.Errorf("unsupported token signing key curve: %s", pub.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		sk.algorithm = jose.EdDSA
+	default:
+		return nil, fmt.Errorf("unsupported token signing key type: %T", pub)
+	}
+
+	if certPath != "" {
+		if sk.chain, err = loadCertificates(certPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if sk.keyID == "" {
+		jwk := sk.jwk()
+		thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		sk.keyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+	return sk, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+	p, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token signing certificate: %v", err)
+	}
+
+	var chain []*x509.Certificate
+	for block, rest := pem.Decode(p); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse token signing certificate: %v", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no PEM encoded certificate in " + path)
+	}
+	return chain, nil
+}
+
+// signer returns a signer of JSON Web Tokens. Tokens carry the certificate
+// chain of the key in their x5c header, verified against the rootcertbundle
+// of the token access controller, or the identifier of the key otherwise,
+// which must be in its jwks.
+func (sk *signingKey) signer() (jose.Signer, error) {
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if len(sk.chain) > 0 {
+		x5c := make([]string, 0, len(sk.chain))
+		for _, cert := range sk.chain {
+			x5c = append(x5c, base64.StdEncoding.EncodeToString(cert.Raw))
+		}
+		opts = opts.WithHeader("x5c", x5c)
+	} else {
+		opts = opts.WithHeader("kid", sk.keyID)
+	}
+
+	return jose.NewSigner(jose.SigningKey{Algorithm: sk.algorithm, Key: sk.key}, opts)
+}
+
+// jwk returns the public JSON Web Key of the signing key.
+func (sk *signingKey) jwk() jose.JSONWebKey {
+	return jose.JSONWebKey{
+		Key:       sk.key.Public(),
+		KeyID:     sk.keyID,
+		Algorithm: string(sk.algorithm),
+		Use:       "sig",
+	}
+}
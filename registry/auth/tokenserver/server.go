@@ -0,0 +1,247 @@
+// Package tokenserver implements a token server issuing the JSON Web Tokens
+// trusted by the token access controller, as described by the token
+// authentication specification. Users are authenticated against an htpasswd
+// file, and granted the actions of an access control list.
+package tokenserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/acl"
+	"github.com/distribution/distribution/v3/registry/auth/htpasswd"
+	"github.com/distribution/distribution/v3/registry/auth/token"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// defaultExpiration is the lifetime of the tokens if not configured.
+const defaultExpiration = 5 * time.Minute
+
+// Server is an http.Handler issuing tokens.
+type Server struct {
+	issuer      string
+	service     string
+	expiration  time.Duration
+	key         *signingKey
+	signer      jose.Signer
+	credentials auth.CredentialAuthenticator
+	acl         *acl.File
+}
+
+// New returns a token server configured by config.
+func New(config configuration.TokenServer) (*Server, error) {
+	if config.Issuer == "" || config.Service == "" {
+		return nil, errors.New("token server requires an issuer and a service")
+	}
+	if config.SigningKey == "" {
+		return nil, errors.New("token server requires a signing key")
+	}
+	if config.HTPasswd == "" || config.ACL == "" {
+		return nil, errors.New("token server requires an htpasswd file and an acl")
+	}
+
+	key, err := loadSigningKey(config.SigningKey, config.Certificate, config.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := key.signer()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create token signer: %v", err)
+	}
+
+	policy := acl.NewFile(config.ACL)
+	if _, err := policy.Policy(); err != nil {
+		return nil, fmt.Errorf("unable to read acl: %v", err)
+	}
+
+	expiration := config.Expiration
+	if expiration <= 0 {
+		expiration = defaultExpiration
+	}
+
+	return &Server{
+		issuer:      config.Issuer,
+		service:     config.Service,
+		expiration:  expiration,
+		key:         key,
+		signer:      signer,
+		credentials: htpasswd.NewFile(config.HTPasswd),
+		acl:         policy,
+	}, nil
+}
+
+// JWKS returns the JSON Web Key Set of the signing key, for the jwks option
+// of the token access controller.
+func (s *Server) JWKS() jose.JSONWebKeySet {
+	return jose.JSONWebKeySet{Keys: []jose.JSONWebKey{s.key.jwk()}}
+}
+
+// tokenResponse is the response of a token request.
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	IssuedAt    string `json:"issued_at"`
+}
+
+// ServeHTTP authenticates the user of a token request with basic
+// authentication, and responds with a token granting the requested actions
+// allowed by the access control list.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "unsupported", "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	if service := query.Get("service"); service != "" && service != s.service {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("unknown service %q", service))
+		return
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		s.challenge(w, auth.ErrInvalidCredential)
+		return
+	}
+	if account := query.Get("account"); account != "" && account != username {
+		writeError(w, http.StatusBadRequest, "invalid_request", "account does not match the credentials")
+		return
+	}
+	if err := s.credentials.AuthenticateUser(username, password); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error authenticating user %q: %v", username, err)
+		s.challenge(w, auth.ErrAuthenticationFailure)
+		return
+	}
+
+	policy, err := s.acl.Policy()
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error reading acl: %v", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "unable to read acl")
+		return
+	}
+
+	var access []*token.ResourceActions
+	for _, scope := range query["scope"] {
+		for _, requested := range strings.Fields(scope) {
+			ra, err := parseScope(requested)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+				return
+			}
+			if ra = grant(policy, username, ra); ra != nil {
+				access = append(access, ra)
+			}
+		}
+	}
+
+	now := time.Now()
+	claims := token.ClaimSet{
+		Issuer:     s.issuer,
+		Subject:    username,
+		Audience:   token.AudienceList{s.service},
+		Expiration: now.Add(s.expiration).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		JWTID:      newJWTID(),
+		Access:     access,
+	}
+	raw, err := jwt.Signed(s.signer).Claims(claims).Serialize()
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error signing token: %v", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "unable to sign token")
+		return
+	}
+
+	dcontext.GetLogger(ctx).Infof("issued token to %q for %d resources", username, len(access))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	// nolint:errcheck
+	json.NewEncoder(w).Encode(tokenResponse{
+		Token:       raw,
+		AccessToken: raw,
+		ExpiresIn:   int(s.expiration.Seconds()),
+		IssuedAt:    now.UTC().Format(time.RFC3339),
+	})
+}
+
+// challenge responds with a basic authentication challenge.
+func (s *Server) challenge(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", s.service))
+	writeError(w, http.StatusUnauthorized, "unauthorized", err.Error())
+}
+
+// parseScope parses a scope of the form type[(class)]:name:actions. The name
+// may itself contain colons, such as a repository prefixed by a host and
+// port.
+func parseScope(scope string) (*token.ResourceActions, error) {
+	typ, rest, ok := strings.Cut(scope, ":")
+	i := strings.LastIndex(rest, ":")
+	if !ok || i <= 0 || typ == "" {
+		return nil, fmt.Errorf("invalid scope %q", scope)
+	}
+
+	ra := &token.ResourceActions{
+		Type: typ,
+		Name: rest[:i],
+	}
+	if open := strings.Index(typ, "("); open > 0 && strings.HasSuffix(typ, ")") {
+		ra.Type, ra.Class = typ[:open], typ[open+1:len(typ)-1]
+	}
+	for _, action := range strings.Split(rest[i+1:], ",") {
+		if action != "" {
+			ra.Actions = append(ra.Actions, action)
+		}
+	}
+	return ra, nil
+}
+
+// grant returns the requested actions allowed to the user, or nil if none
+// is.
+func grant(policy *acl.Policy, user string, requested *token.ResourceActions) *token.ResourceActions {
+	allowed := policy.Actions(user, auth.Resource{Type: requested.Type, Name: requested.Name})
+
+	granted := &token.ResourceActions{Type: requested.Type, Class: requested.Class, Name: requested.Name}
+	for _, action := range requested.Actions {
+		for _, a := range allowed {
+			if a == acl.AnyAction || a == action {
+				granted.Actions = append(granted.Actions, action)
+				break
+			}
+		}
+	}
+	if len(granted.Actions) == 0 {
+		return nil
+	}
+	return granted
+}
+
+func newJWTID() string {
+	p := make([]byte, 16)
+	// nolint:errcheck
+	rand.Read(p)
+	return base64.RawURLEncoding.EncodeToString(p)
+}
+
+// writeError writes an OAuth 2.0 error response.
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// nolint:errcheck
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
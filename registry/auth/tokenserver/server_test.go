@@ -0,0 +1,222 @@
+package tokenserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/token"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testACL = `
+rules:
+  - users: [alice]
+    names: ["team-a/*"]
+    actions: [pull, push]
+`
+
+// writeTestFiles writes a signing key, its self-signed certificate, an
+// htpasswd file and an acl to dir.
+func writeTestFiles(t *testing.T, dir string) configuration.TokenServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "token-signer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := configuration.TokenServer{
+		Issuer:      "test-issuer",
+		Service:     "test-registry",
+		SigningKey:  filepath.Join(dir, "token.key"),
+		Certificate: filepath.Join(dir, "token.crt"),
+		HTPasswd:    filepath.Join(dir, "htpasswd"),
+		ACL:         filepath.Join(dir, "acl.yml"),
+	}
+	for path, content := range map[string][]byte{
+		config.SigningKey:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		config.Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+		config.HTPasswd:    []byte("alice:" + string(hash) + "\n"),
+		config.ACL:         []byte(testACL),
+	} {
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return config
+}
+
+// requestToken requests a token for the scopes with the given credentials.
+func requestToken(t *testing.T, s *Server, username, password string, scopes ...string) (*httptest.ResponseRecorder, string) {
+	req := httptest.NewRequest(http.MethodGet, "/token?service=test-registry", nil)
+	query := req.URL.Query()
+	for _, scope := range scopes {
+		query.Add("scope", scope)
+	}
+	req.URL.RawQuery = query.Encode()
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	var resp tokenResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("error decoding token response: %v", err)
+		}
+	}
+	return w, resp.Token
+}
+
+// checkAuthorized checks the access granted by the token access controller
+// to a token.
+func checkAuthorized(t *testing.T, ac auth.AccessController, rawToken string, action string, expected bool) {
+	req := httptest.NewRequest(http.MethodGet, "/v2/team-a/app/manifests/latest", nil)
+	req.Header.Set("Authorization", "Bearer "+rawToken)
+	_, err := ac.Authorized(req, auth.Access{
+		Resource: auth.Resource{Type: "repository", Name: "team-a/app"},
+		Action:   action,
+	})
+	if authorized := err == nil; authorized != expected {
+		t.Fatalf("%s: expected authorized=%t, got error %v", action, expected, err)
+	}
+}
+
+func TestTokenServer(t *testing.T) {
+	dir := t.TempDir()
+	config := writeTestFiles(t, dir)
+
+	for _, tc := range []struct {
+		name    string
+		trusted func(s *Server) map[string]interface{}
+	}{
+		{
+			name: "rootcertbundle",
+			trusted: func(s *Server) map[string]interface{} {
+				return map[string]interface{}{"rootcertbundle": config.Certificate}
+			},
+		},
+		{
+			name: "jwks",
+			trusted: func(s *Server) map[string]interface{} {
+				p, err := json.Marshal(s.JWKS())
+				if err != nil {
+					t.Fatal(err)
+				}
+				path := filepath.Join(dir, "jwks.json")
+				if err := os.WriteFile(path, p, 0o600); err != nil {
+					t.Fatal(err)
+				}
+				return map[string]interface{}{"jwks": path}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := config
+			if tc.name == "jwks" {
+				config.Certificate = ""
+			}
+			s, err := New(config)
+			if err != nil {
+				t.Fatalf("error creating token server: %v", err)
+			}
+
+			options := tc.trusted(s)
+			options["realm"] = "https://auth.example.com/token"
+			options["issuer"] = config.Issuer
+			options["service"] = config.Service
+			ac, err := auth.GetAccessController("token", options)
+			if err != nil {
+				t.Fatalf("error creating token access controller: %v", err)
+			}
+
+			w, rawToken := requestToken(t, s, "alice", "secret", "repository:team-a/app:pull,push,delete")
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status requesting token: %d %s", w.Code, w.Body)
+			}
+			checkAuthorized(t, ac, rawToken, "pull", true)
+			checkAuthorized(t, ac, rawToken, "push", true)
+			checkAuthorized(t, ac, rawToken, "delete", false)
+		})
+	}
+}
+
+func TestTokenServerAuthentication(t *testing.T) {
+	s, err := New(writeTestFiles(t, t.TempDir()))
+	if err != nil {
+		t.Fatalf("error creating token server: %v", err)
+	}
+
+	for _, credentials := range [][2]string{{"", ""}, {"alice", "wrong"}, {"mallory", "secret"}} {
+		w, _ := requestToken(t, s, credentials[0], credentials[1], "repository:team-a/app:pull")
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: unexpected status: %d", credentials[0], w.Code)
+		}
+		if w.Header().Get("WWW-Authenticate") != `Basic realm="test-registry"` {
+			t.Fatalf("%s: unexpected challenge: %q", credentials[0], w.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	if w, _ := requestToken(t, s, "alice", "secret", "repository"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status for an invalid scope: %d", w.Code)
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	for scope, expected := range map[string]*token.ResourceActions{
+		"repository:foo/bar:pull,push":       {Type: "repository", Name: "foo/bar", Actions: []string{"pull", "push"}},
+		"repository:localhost:5000/foo:pull": {Type: "repository", Name: "localhost:5000/foo", Actions: []string{"pull"}},
+		"repository(plugin):foo:pull":        {Type: "repository", Class: "plugin", Name: "foo", Actions: []string{"pull"}},
+		"registry:catalog:*":                 {Type: "registry", Name: "catalog", Actions: []string{"*"}},
+	} {
+		ra, err := parseScope(scope)
+		if err != nil {
+			t.Fatalf("error parsing scope %q: %v", scope, err)
+		}
+		if !reflect.DeepEqual(ra, expected) {
+			t.Fatalf("%s: unexpected resource actions: %#v", scope, ra)
+		}
+	}
+
+	for _, scope := range []string{"repository", "repository:foo", ":foo:pull"} {
+		if _, err := parseScope(scope); err == nil {
+			t.Fatalf("expected error parsing scope %q", scope)
+		}
+	}
+}
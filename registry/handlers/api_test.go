@@ -13,18 +13,23 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/quota"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
@@ -451,6 +456,223 @@ func TestCatalogAPI(t *testing.T) {
 	}
 }
 
+// TestCatalogAPIPrefix tests the prefix and details parameters of the
+// /v2/_catalog endpoint
+func TestCatalogAPIPrefix(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	for _, image := range []string{"team-a/app", "team-a/web", "team-a-x/app", "team-b/app"} {
+		createRepository(env, t, image, "sometag")
+	}
+
+	var ctlg struct {
+		Repositories []string            `json:"repositories"`
+		Details      []repositoryDetails `json:"details"`
+	}
+	getCatalog := func(values url.Values) *http.Response {
+		catalogURL, err := env.builder.BuildCatalogURL(values)
+		if err != nil {
+			t.Fatalf("unexpected error building catalog url: %v", err)
+		}
+		resp, err := http.Get(catalogURL)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		checkResponse(t, "issuing catalog api check", resp, http.StatusOK)
+		ctlg.Repositories, ctlg.Details = nil, nil
+		if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+		return resp
+	}
+
+	resp := getCatalog(url.Values{"prefix": []string{"team-a/"}, "n": []string{"1"}})
+	if !reflect.DeepEqual(ctlg.Repositories, []string{"team-a/app"}) {
+		t.Fatalf("unexpected repositories: %v", ctlg.Repositories)
+	}
+	if ctlg.Details != nil {
+		t.Fatalf("unexpected details: %v", ctlg.Details)
+	}
+	values := checkLink(t, resp.Header.Get("Link"), 1, "team-a/app")
+	if values.Get("prefix") != "team-a/" {
+		t.Fatalf("prefix not kept in the link: %v", values)
+	}
+
+	resp = getCatalog(values)
+	if !reflect.DeepEqual(ctlg.Repositories, []string{"team-a/web"}) {
+		t.Fatalf("unexpected repositories: %v", ctlg.Repositories)
+	}
+
+	if resp.Header.Get("Link") != "" {
+		t.Fatalf("unexpected link: %s", resp.Header.Get("Link"))
+	}
+
+	getCatalog(url.Values{"prefix": []string{"team-a"}})
+	if !reflect.DeepEqual(ctlg.Repositories, []string{"team-a/app", "team-a/web", "team-a-x/app"}) {
+		t.Fatalf("unexpected repositories: %v", ctlg.Repositories)
+	}
+
+	getCatalog(url.Values{"prefix": []string{"team-c/"}})
+	if len(ctlg.Repositories) != 0 {
+		t.Fatalf("unexpected repositories: %v", ctlg.Repositories)
+	}
+
+	getCatalog(url.Values{"prefix": []string{"team-b/"}, "details": []string{"true"}})
+	if !reflect.DeepEqual(ctlg.Repositories, []string{"team-b/app"}) {
+		t.Fatalf("unexpected repositories: %v", ctlg.Repositories)
+	}
+	if len(ctlg.Details) != 1 {
+		t.Fatalf("unexpected details: %v", ctlg.Details)
+	}
+	details := ctlg.Details[0]
+	if details.Name != "team-b/app" || details.Tags != 1 || details.Size <= 0 || details.LastPush == nil {
+		t.Fatalf("unexpected details: %+v", details)
+	}
+	if time.Since(*details.LastPush) > time.Minute {
+		t.Fatalf("unexpected last push time: %v", details.LastPush)
+	}
+}
+
+// TestCatalogAPIDetailsACL tests that the details of the /v2/_catalog
+// endpoint are only returned for the repositories an access control list
+// grants to the user
+func TestCatalogAPIDetailsACL(t *testing.T) {
+	dir := t.TempDir()
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	if err := os.WriteFile(htpasswdPath, []byte("frodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	aclPath := filepath.Join(dir, "acl.yml")
+	if err := os.WriteFile(aclPath, []byte(`
+rules:
+  - users: [frodo]
+    names: ["shire/*"]
+    actions: [pull]
+  - users: [frodo]
+    type: registry
+    names: [catalog]
+    actions: ["*"]
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"htpasswd": {
+				"realm": "The-Shire",
+				"path":  htpasswdPath,
+				"acl":   aclPath,
+			},
+		},
+		Catalog: configuration.Catalog{
+			MaxEntries: 100,
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	for _, name := range []string{"mordor/ring", "shire/ring"} {
+		named, _ := reference.WithName(name)
+		repo, err := env.app.registry.Repository(env.ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Tags(env.ctx).Tag(env.ctx, "latest", v1.Descriptor{Digest: digest.FromString(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	getCatalog := func(values url.Values) (*http.Response, catalogAPIResponse) {
+		catalogURL, err := env.builder.BuildCatalogURL(values)
+		if err != nil {
+			t.Fatalf("unexpected error building catalog url: %v", err)
+		}
+		req, err := http.NewRequest(http.MethodGet, catalogURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("frodo", "baggins")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var ctlg catalogAPIResponse
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+				t.Fatalf("error decoding catalog: %v", err)
+			}
+		}
+		return resp, ctlg
+	}
+
+	resp, ctlg := getCatalog(nil)
+	checkResponse(t, "listing the catalog", resp, http.StatusOK)
+	if !reflect.DeepEqual(ctlg.Repositories, []string{"mordor/ring", "shire/ring"}) {
+		t.Fatalf("unexpected repositories: %v", ctlg.Repositories)
+	}
+
+	resp, ctlg = getCatalog(url.Values{"details": []string{"true"}})
+	checkResponse(t, "listing the catalog details", resp, http.StatusOK)
+	if !reflect.DeepEqual(ctlg.Repositories, []string{"shire/ring"}) {
+		t.Fatalf("unexpected repositories: %v", ctlg.Repositories)
+	}
+	if len(ctlg.Details) != 1 || ctlg.Details[0].Name != "shire/ring" || ctlg.Details[0].Tags != 1 {
+		t.Fatalf("unexpected details: %+v", ctlg.Details)
+	}
+
+	resp, _ = getCatalog(url.Values{"details": []string{"true"}, "n": []string{strconv.Itoa(maxDetailedEntries + 1)}})
+	checkResponse(t, "listing too many catalog details", resp, http.StatusBadRequest)
+}
+
+func TestAuthorizedRepositories(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := authorizedRepositories(ctx); ok {
+		t.Fatal("repositories filtered without authorized resources")
+	}
+
+	ctx = withResources(ctx, []auth.Resource{
+		{Type: "registry", Name: "catalog"},
+		{Type: "repository", Name: "team-a/app"},
+		{Type: "repository", Name: "team-b/*"},
+	})
+	authorized, ok := authorizedRepositories(ctx)
+	if !ok {
+		t.Fatal("repositories not filtered by the authorized resources")
+	}
+	for repo, expected := range map[string]bool{
+		"team-a/app":     true,
+		"team-a/web":     false,
+		"team-b/app":     true,
+		"team-b/app/sub": false,
+		"catalog":        false,
+	} {
+		if authorized(repo) != expected {
+			t.Fatalf("%s: expected authorized=%t", repo, expected)
+		}
+	}
+
+	// the accesses reported by the access controller take precedence
+	ctx = withAllowed(ctx, func(access auth.Access) bool {
+		return access.Type == "repository" && access.Name == "team-a/web" && access.Action == "pull"
+	})
+	authorized, ok = authorizedRepositories(ctx)
+	if !ok || authorized("team-a/app") || !authorized("team-a/web") {
+		t.Fatal("repositories not filtered by the allowed accesses")
+	}
+}
+
 // TestTagsAPI tests the /v2/<name>/tags/list endpoint
 func TestTagsAPI(t *testing.T) {
 	env := newTestEnv(t, false)
@@ -564,6 +786,7 @@ func TestTagsAPI(t *testing.T) {
 			queryParams:        url.Values{"last": []string{"does-not-exist"}, "n": []string{"3"}},
 			expectedStatusCode: http.StatusOK,
 			expectedBody: tagsAPIResponse{Name: imageName.Name(), Tags: []string{
+				"jyi7b",
 				"kb0j5",
 				"sb71y",
 			}},
@@ -1305,7 +1528,7 @@ func TestManifestAPI(t *testing.T) {
 }
 
 func TestManifestAPI_DeleteTag(t *testing.T) {
-	env := newTestEnv(t, false)
+	env := newTestEnv(t, true)
 	defer env.Shutdown()
 
 	imageName, err := reference.WithName("foo/bar")
@@ -1350,7 +1573,7 @@ func TestManifestAPI_DeleteTag(t *testing.T) {
 }
 
 func TestManifestAPI_DeleteTag_Unknown(t *testing.T) {
-	env := newTestEnv(t, false)
+	env := newTestEnv(t, true)
 	defer env.Shutdown()
 
 	imageName, err := reference.WithName("foo/bar")
@@ -1372,6 +1595,37 @@ func TestManifestAPI_DeleteTag_Unknown(t *testing.T) {
 	checkBodyHasErrorCodes(t, msg, resp, errcode.ErrorCodeManifestUnknown)
 }
 
+func TestManifestAPI_DeleteTag_Disabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+
+	tag := "latest"
+	createRepository(env, t, imageName.Name(), tag)
+
+	ref, err := reference.WithTag(imageName, tag)
+	checkErr(t, err, "building tag reference")
+
+	u, err := env.builder.BuildManifestURL(ref)
+	checkErr(t, err, "building tag URL")
+
+	resp, err := httpDelete(u)
+	msg := "deleting tag with delete disabled"
+	checkErr(t, err, msg)
+	defer resp.Body.Close()
+
+	checkResponse(t, msg, resp, http.StatusMethodNotAllowed)
+	checkBodyHasErrorCodes(t, msg, resp, errcode.ErrorCodeUnsupported)
+
+	msg = "checking tag still exists"
+	resp, err = http.Head(u)
+	checkErr(t, err, msg)
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusOK)
+}
+
 func TestManifestAPI_DeleteTag_ReadOnly(t *testing.T) {
 	env := newTestEnv(t, false)
 	defer env.Shutdown()
@@ -2714,6 +2968,297 @@ func createRepository(env *testEnv, t *testing.T, imageName string, tag string)
 	return dgst
 }
 
+func TestReferrersAPI(t *testing.T) {
+	env := newTestEnv(t, true)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/referrers")
+	subject := createRepository(env, t, imageName.Name(), "latest")
+
+	// Push the empty config and a layer shared by the referrers
+	emptyConfig := v1.DescriptorEmptyJSON
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, emptyConfig.Digest, uploadURLBase, bytes.NewReader(emptyConfig.Data))
+
+	rs, layerDigest, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer: %v", err)
+	}
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, rs)
+
+	pushReferrer := func(artifactType string) digest.Digest {
+		referrer := &ocischema.Manifest{
+			Versioned:    specs.Versioned{SchemaVersion: 2},
+			MediaType:    v1.MediaTypeImageManifest,
+			ArtifactType: artifactType,
+			Config: v1.Descriptor{
+				MediaType: emptyConfig.MediaType,
+				Digest:    emptyConfig.Digest,
+				Size:      emptyConfig.Size,
+			},
+			Layers: []v1.Descriptor{
+				{MediaType: v1.MediaTypeImageLayer, Digest: layerDigest},
+			},
+			Subject: &v1.Descriptor{
+				MediaType: schema2.MediaTypeManifest,
+				Digest:    subject,
+			},
+		}
+		deserialized, err := ocischema.FromStruct(*referrer)
+		if err != nil {
+			t.Fatalf("could not create DeserializedManifest: %v", err)
+		}
+		_, payload, err := deserialized.Payload()
+		if err != nil {
+			t.Fatalf("could not get manifest payload: %v", err)
+		}
+		dgst := digest.FromBytes(payload)
+		digestRef, _ := reference.WithDigest(imageName, dgst)
+		manifestURL, err := env.builder.BuildManifestURL(digestRef)
+		checkErr(t, err, "building manifest url")
+
+		resp := putManifest(t, "putting referrer", manifestURL, v1.MediaTypeImageManifest, deserialized)
+		defer resp.Body.Close()
+		checkResponse(t, "putting referrer", resp, http.StatusCreated)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{dgst.String()},
+			"OCI-Subject":           []string{subject.String()},
+		})
+		return dgst
+	}
+
+	signature := pushReferrer("application/vnd.example.signature")
+	sbom := pushReferrer("application/vnd.example.sbom")
+
+	getReferrers := func(values ...url.Values) (v1.Index, http.Header) {
+		ref, _ := reference.WithDigest(imageName, subject)
+		referrersURL, err := env.builder.BuildReferrersURL(ref, values...)
+		checkErr(t, err, "building referrers url")
+
+		resp, err := http.Get(referrersURL)
+		if err != nil {
+			t.Fatalf("unexpected error fetching referrers: %v", err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, "fetching referrers", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Content-Type": []string{v1.MediaTypeImageIndex},
+		})
+
+		var index v1.Index
+		if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+			t.Fatalf("error decoding referrers response: %v", err)
+		}
+		return index, resp.Header
+	}
+
+	index, header := getReferrers()
+	if len(index.Manifests) != 2 {
+		t.Fatalf("expected 2 referrers, got %v", index.Manifests)
+	}
+	if header.Get("OCI-Filters-Applied") != "" {
+		t.Fatalf("unexpected OCI-Filters-Applied header: %q", header.Get("OCI-Filters-Applied"))
+	}
+	for _, desc := range index.Manifests {
+		if desc.Digest != signature && desc.Digest != sbom {
+			t.Fatalf("unexpected referrer %s", desc.Digest)
+		}
+	}
+
+	index, header = getReferrers(url.Values{"artifactType": []string{"application/vnd.example.sbom"}})
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != sbom {
+		t.Fatalf("expected only %s, got %v", sbom, index.Manifests)
+	}
+	if header.Get("OCI-Filters-Applied") != "artifactType" {
+		t.Fatalf("expected OCI-Filters-Applied header, got %q", header.Get("OCI-Filters-Applied"))
+	}
+}
+
+func TestQuotaAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete":   configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Quota: configuration.Quota{
+			Limits: []configuration.QuotaLimit{{Prefix: "foo/", Limit: 1000}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/quota")
+
+	getUsage := func() quota.Usage {
+		usageURL, err := env.builder.BuildUsageURL(imageName)
+		checkErr(t, err, "building usage url")
+
+		resp, err := http.Get(usageURL)
+		if err != nil {
+			t.Fatalf("unexpected error fetching usage: %v", err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, "fetching usage", resp, http.StatusOK)
+
+		var usage quota.Usage
+		if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+			t.Fatalf("error decoding usage response: %v", err)
+		}
+		return usage
+	}
+
+	if usage := getUsage(); usage.Bytes != 0 || len(usage.Quotas) != 1 || usage.Quotas[0].Limit != 1000 {
+		t.Fatalf("unexpected usage of empty repository: %+v", usage)
+	}
+
+	layer := bytes.Repeat([]byte("a"), 600)
+	layerDigest := digest.FromBytes(layer)
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, bytes.NewReader(layer))
+
+	if usage := getUsage(); usage.Bytes != 600 || usage.Quotas[0].Bytes != 600 {
+		t.Fatalf("unexpected usage after push: %+v", usage)
+	}
+
+	// pushing the same layer again is not accounted
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, bytes.NewReader(layer))
+
+	other := bytes.Repeat([]byte("b"), 600)
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	resp, err := doPushLayer(t, env.builder, imageName, digest.FromBytes(other), uploadURLBase, bytes.NewReader(other))
+	if err != nil {
+		t.Fatalf("unexpected error pushing layer: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "pushing layer over quota", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "pushing layer over quota", resp, errcode.ErrorCodeQuotaExceeded)
+
+	if usage := getUsage(); usage.Bytes != 600 {
+		t.Fatalf("unexpected usage after rejected push: %+v", usage)
+	}
+
+	ref, _ := reference.WithDigest(imageName, layerDigest)
+	layerURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building blob url")
+	resp, err = httpDelete(layerURL)
+	checkErr(t, err, "deleting layer")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting layer", resp, http.StatusAccepted)
+
+	if usage := getUsage(); usage.Bytes != 0 || usage.Quotas[0].Bytes != 0 {
+		t.Fatalf("unexpected usage after delete: %+v", usage)
+	}
+
+	// mounting a blob from another repository is checked against the quota
+	sourceName, _ := reference.WithName("bar/source")
+	uploadURLBase, _ = startPushLayer(t, env, sourceName)
+	pushLayer(t, env.builder, sourceName, digest.FromBytes(other), uploadURLBase, bytes.NewReader(other))
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, bytes.NewReader(layer))
+
+	mountURL, err := env.builder.BuildBlobUploadURL(imageName, url.Values{
+		"mount": []string{digest.FromBytes(other).String()},
+		"from":  []string{sourceName.Name()},
+	})
+	checkErr(t, err, "building mount url")
+	resp, err = http.Post(mountURL, "", nil)
+	checkErr(t, err, "mounting layer")
+	defer resp.Body.Close()
+	checkResponse(t, "mounting layer over quota", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "mounting layer over quota", resp, errcode.ErrorCodeQuotaExceeded)
+
+	if usage := getUsage(); usage.Bytes != 600 {
+		t.Fatalf("unexpected usage after rejected mount: %+v", usage)
+	}
+}
+
+func TestImmutableTagsAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete":   configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		ImmutableTags: configuration.ImmutableTags{
+			Policies: []configuration.ImmutableTagPolicy{{Repositories: []string{"release/*"}, Tags: `v[0-9]+`}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("release/app")
+	immutableDigest := createRepository(env, t, imageName.Name(), "v1")
+	otherDigest := createRepository(env, t, imageName.Name(), "latest")
+
+	manifestURL := func(ref reference.Named) string {
+		u, err := env.builder.BuildManifestURL(ref)
+		checkErr(t, err, "building manifest url")
+		return u
+	}
+	getManifest := func(dgst digest.Digest) []byte {
+		ref, _ := reference.WithDigest(imageName, dgst)
+		req, err := http.NewRequest(http.MethodGet, manifestURL(ref), nil)
+		checkErr(t, err, "building manifest request")
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching manifest", resp, http.StatusOK)
+		p, err := io.ReadAll(resp.Body)
+		checkErr(t, err, "reading manifest")
+		return p
+	}
+	putTag := func(tag string, payload []byte) *http.Response {
+		ref, _ := reference.WithTag(imageName, tag)
+		req, err := http.NewRequest(http.MethodPut, manifestURL(ref), bytes.NewReader(payload))
+		checkErr(t, err, "building manifest put")
+		req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "putting manifest")
+		return resp
+	}
+
+	// pushing the same manifest again is allowed
+	resp := putTag("v1", getManifest(immutableDigest))
+	defer resp.Body.Close()
+	checkResponse(t, "putting the same manifest to an immutable tag", resp, http.StatusCreated)
+
+	resp = putTag("v1", getManifest(otherDigest))
+	defer resp.Body.Close()
+	checkResponse(t, "moving an immutable tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "moving an immutable tag", resp, errcode.ErrorCodeTagImmutable)
+
+	tagRef, _ := reference.WithTag(imageName, "v1")
+	resp, err := httpDelete(manifestURL(tagRef))
+	checkErr(t, err, "deleting tag")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting an immutable tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "deleting an immutable tag", resp, errcode.ErrorCodeTagImmutable)
+
+	digestRef, _ := reference.WithDigest(imageName, immutableDigest)
+	resp, err = httpDelete(manifestURL(digestRef))
+	checkErr(t, err, "deleting manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting a manifest with an immutable tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "deleting a manifest with an immutable tag", resp, errcode.ErrorCodeTagImmutable)
+
+	// tags not matching the policy are mutable
+	resp = putTag("latest", getManifest(immutableDigest))
+	defer resp.Body.Close()
+	checkResponse(t, "moving a mutable tag", resp, http.StatusCreated)
+}
+
 // Test mutation operations on a registry configured as a cache.  Ensure that they return
 // appropriate errors.
 func TestRegistryAsCacheMutationAPIs(t *testing.T) {
@@ -2856,3 +3401,75 @@ func TestProxyManifestGetByTag(t *testing.T) {
 		"Docker-Content-Digest": []string{newDigest.String()},
 	})
 }
+
+func TestTagHistoryAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/history")
+	first := createRepository(env, t, imageName.Name(), "latest")
+	second := createRepository(env, t, imageName.Name(), "latest")
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	historyURL, err := env.builder.BuildTagHistoryURL(tagRef)
+	checkErr(t, err, "building tag history url")
+
+	getHistory := func() tagHistoryAPIResponse {
+		resp, err := http.Get(historyURL)
+		checkErr(t, err, "fetching tag history")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching tag history", resp, http.StatusOK)
+
+		var history tagHistoryAPIResponse
+		err = json.NewDecoder(resp.Body).Decode(&history)
+		checkErr(t, err, "decoding tag history")
+		return history
+	}
+
+	history := getHistory()
+	if history.Name != imageName.Name() || history.Tag != "latest" || len(history.History) != 2 {
+		t.Fatalf("unexpected tag history: %+v", history)
+	}
+	if history.History[0].Digest != second || !history.History[0].Current || history.History[1].Digest != first || history.History[1].Current {
+		t.Fatalf("unexpected tag history: %+v", history)
+	}
+
+	rollback := func(dgst string) *http.Response {
+		u, err := env.builder.BuildTagHistoryURL(tagRef, url.Values{"digest": []string{dgst}})
+		checkErr(t, err, "building tag rollback url")
+		resp, err := http.Post(u, "", nil)
+		checkErr(t, err, "rolling back tag")
+		return resp
+	}
+
+	resp := rollback("invalid")
+	defer resp.Body.Close()
+	checkResponse(t, "rolling back to an invalid digest", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "rolling back to an invalid digest", resp, errcode.ErrorCodeDigestInvalid)
+
+	resp = rollback(digest.FromString("unknown").String())
+	defer resp.Body.Close()
+	checkResponse(t, "rolling back to a manifest not in the history", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "rolling back to a manifest not in the history", resp, errcode.ErrorCodeManifestUnknown)
+
+	resp = rollback(first.String())
+	defer resp.Body.Close()
+	checkResponse(t, "rolling back tag", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{first.String()},
+	})
+
+	history = getHistory()
+	if len(history.History) != 2 || history.History[0].Digest != first || !history.History[0].Current {
+		t.Fatalf("unexpected tag history after rollback: %+v", history)
+	}
+
+	unknownRef, _ := reference.WithTag(imageName, "unknown")
+	unknownURL, err := env.builder.BuildTagHistoryURL(unknownRef)
+	checkErr(t, err, "building tag history url")
+	resp, err = http.Get(unknownURL)
+	checkErr(t, err, "fetching tag history")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching the history of an unknown tag", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching the history of an unknown tag", resp, errcode.ErrorCodeManifestUnknown)
+}
@@ -5,14 +5,18 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"expvar"
 	"fmt"
+	"io"
 	"math"
 	"math/big"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -32,6 +36,7 @@ import (
 	registrymiddleware "github.com/distribution/distribution/v3/registry/middleware/registry"
 	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
 	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/quota"
 	"github.com/distribution/distribution/v3/registry/storage"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
 	rediscache "github.com/distribution/distribution/v3/registry/storage/cache/redis"
@@ -43,6 +48,7 @@ import (
 	events "github.com/docker/go-events"
 	"github.com/docker/go-metrics"
 	"github.com/gorilla/mux"
+	"github.com/hashicorp/golang-lru/v2/expirable"
 	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
@@ -55,6 +61,14 @@ const randomSecretSize = 32
 // defaultCheckInterval is the default time in between health checks
 const defaultCheckInterval = 10 * time.Second
 
+// defaultNotificationQueueSize is the default number of events pending for an
+// endpoint in a persistent queue, and of its dead letters kept in redis.
+const defaultNotificationQueueSize = 10000
+
+// anonymousUser is the name of the user of the requests granted anonymous
+// access.
+const anonymousUser = "anonymous"
+
 // App is a global registry application object. Shared resources can be placed
 // on this object that will be accessible from all requests. Any writable
 // fields should be protected.
@@ -69,6 +83,9 @@ type App struct {
 	repoRemover      distribution.RepositoryRemover // repoRemover provides ability to delete repos
 	accessController auth.AccessController          // main access controller for application
 
+	// anonymous grants pull access without authentication, if configured.
+	anonymous configuration.Anonymous
+
 	// httpHost is a parsed representation of the http.host parameter from
 	// the configuration. Only the Scheme and Host fields are used.
 	httpHost url.URL
@@ -81,11 +98,30 @@ type App struct {
 
 	redis redis.UniversalClient
 
+	// quotas enforces the storage quotas, if configured.
+	quotas *quota.Quotas
+
+	// catalogDetails caches the details of the repositories returned by the
+	// catalog.
+	catalogDetails *expirable.LRU[string, repositoryDetails]
+
+	// immutableTags are the policies protecting tags from being moved or
+	// deleted, if configured.
+	immutableTags []storage.ImmutableTagPolicy
+
 	// isCache is true if this registry is configured as a pull through cache
 	isCache bool
 
 	// readOnly is true if the registry is in a read-only maintenance mode
 	readOnly bool
+
+	// deleteEnabled is true if the deletion of blobs, manifests and tags is
+	// enabled
+	deleteEnabled bool
+
+	// closers release the resources of the storage middlewares and the
+	// access controllers on shutdown.
+	closers []io.Closer
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -96,7 +132,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		Config:  config,
 		Context: ctx,
 		router:  v2.RouterWithPrefix(config.HTTP.Prefix),
-		isCache: config.Proxy.RemoteURL != "",
+		isCache: config.Proxy.RemoteURL != "" && !config.Proxy.Hybrid,
 	}
 
 	// Register the handler dispatchers.
@@ -109,6 +145,9 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	app.register(v2.RouteNameBlob, blobDispatcher)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
+	app.register(v2.RouteNameReferrers, referrersDispatcher)
+	app.register(v2.RouteNameUsage, usageDispatcher)
+	app.register(v2.RouteNameTagHistory, tagHistoryDispatcher)
 
 	// override the storage driver's UA string for registry outbound HTTP requests
 	storageParams := config.Storage.Parameters()
@@ -129,6 +168,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 
 	purgeConfig := uploadPurgeDefaultConfig()
+	var gcConfig map[interface{}]interface{}
 	if mc, ok := config.Storage["maintenance"]; ok {
 		if v, ok := mc["uploadpurging"]; ok {
 			purgeConfig, ok = v.(map[interface{}]interface{})
@@ -136,6 +176,12 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				panic("uploadpurging config key must contain additional keys")
 			}
 		}
+		if v, ok := mc["garbagecollect"]; ok {
+			gcConfig, ok = v.(map[interface{}]interface{})
+			if !ok {
+				panic("garbagecollect config key must contain additional keys")
+			}
+		}
 		if v, ok := mc["readonly"]; ok {
 			readOnly, ok := v.(map[interface{}]interface{})
 			if !ok {
@@ -152,7 +198,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 
 	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig)
 
-	app.driver, err = applyStorageMiddleware(app, app.driver, config.Middleware["storage"])
+	app.driver, app.closers, err = applyStorageMiddleware(app, app.driver, config.Middleware["storage"])
 	if err != nil {
 		panic(err)
 	}
@@ -162,8 +208,8 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	if !app.isCache {
 		app.configureSecret(config)
 	}
-	app.configureEvents(config)
 	app.configureRedis(config)
+	app.configureEvents(config)
 	app.configureLogHook(config)
 
 	options := registrymiddleware.GetRegistryOptions()
@@ -180,16 +226,34 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		options = append(options, storage.DisableDigestResumption)
 	}
 
+	// configure online garbage collection, the registry records the content
+	// it references while a collection is running
+	var gcInFlight *storage.InFlight
+	if gcConfig != nil && gcConfig["enabled"] == true && !app.isCache {
+		gcInFlight = storage.NewInFlight()
+		options = append(options, storage.GCInFlight(gcInFlight))
+	}
+
 	// configure deletion
 	if d, ok := config.Storage["delete"]; ok {
 		e, ok := d["enabled"]
 		if ok {
 			if deleteEnabled, ok := e.(bool); ok && deleteEnabled {
 				options = append(options, storage.EnableDelete)
+				app.deleteEnabled = true
 			}
 		}
 	}
 
+	// configure tag immutability
+	if len(config.ImmutableTags.Policies) > 0 && !app.isCache {
+		app.immutableTags, err = immutableTagPolicies(config.ImmutableTags)
+		if err != nil {
+			panic(err.Error())
+		}
+		options = append(options, storage.ImmutableTags(app.immutableTags...))
+	}
+
 	// configure tag lookup concurrency limit
 	if p := config.Storage.TagParameters(); p != nil {
 		l, ok := p["concurrencylimit"]
@@ -276,6 +340,25 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 
 	// configure storage caches
 	if cc, ok := config.Storage["cache"]; ok {
+		switch tagIndex := cc["tagindex"]; tagIndex {
+		case "redis":
+			if app.redis == nil {
+				panic("redis configuration required to use for tag index")
+			}
+			var ttl time.Duration
+			if configuredTTL, ok := cc["tagindexttl"]; ok {
+				ttl, err = time.ParseDuration(fmt.Sprint(configuredTTL))
+				if err != nil {
+					panic(fmt.Sprintf("invalid tagindexttl value %s: %s", configuredTTL, err))
+				}
+			}
+			options = append(options, storage.TagIndex(rediscache.NewRedisTagIndex(app.redis, ttl)))
+			dcontext.GetLogger(app).Infof("using redis tag index")
+		case nil, "":
+		default:
+			dcontext.GetLogger(app).Warnf("unknown tag index type %q, tag index disabled", tagIndex)
+		}
+
 		v, ok := cc["blobdescriptor"]
 		if !ok {
 			// Backwards compatible: "layerinfo" == "blobdescriptor"
@@ -330,30 +413,84 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
+	if len(config.Quota.Limits) > 0 && !app.isCache {
+		app.configureQuotas(config)
+	}
+	app.catalogDetails = expirable.NewLRU[string, repositoryDetails](catalogDetailsCacheSize, nil, catalogDetailsTTL)
+
+	if gcInFlight != nil {
+		startGarbageCollector(app, app.driver, dcontext.GetLogger(app), gcConfig, gcInFlight, app.resetUsages)
+	}
+
+	if len(config.Retention.Policies) > 0 && !app.isCache {
+		registry, err := storage.NewRegistry(app, app.driver, options...)
+		if err != nil {
+			panic("could not create registry for retention: " + err.Error())
+		}
+		startRetention(app, app.driver, registry, dcontext.GetLogger(app), config.Retention, app.retentionEventBridge(), app.invalidateUsage)
+	}
+
 	app.registry, err = applyRegistryMiddleware(app, app.registry, app.driver, config.Middleware["registry"])
 	if err != nil {
 		panic(err)
 	}
 
-	authType := config.Auth.Type()
+	for i, pattern := range config.Anonymous.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic(fmt.Sprintf("anonymous.repositories[%d]: %v", i, err))
+		}
+	}
+	app.anonymous = config.Anonymous
+
+	authControllers, err := config.Auth.Controllers()
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure authorization: %v", err))
+	}
+	var accessControllers []auth.AccessController
+	for _, authController := range authControllers {
+		authType := authController.Type
+		if authType == "" || strings.EqualFold(authType, "none") {
+			continue
+		}
 
-	if authType != "" && !strings.EqualFold(authType, "none") {
-		accessController, err := auth.GetAccessController(config.Auth.Type(), config.Auth.Parameters())
+		options := make(map[string]interface{}, len(authController.Parameters))
+		for k, v := range authController.Parameters {
+			options[k] = v
+		}
+		accessController, err := auth.GetAccessController(authType, options)
 		if err != nil {
 			panic(fmt.Sprintf("unable to configure authorization (%s): %v", authType, err))
 		}
-		app.accessController = accessController
+		accessControllers = append(accessControllers, accessController)
+		if c, ok := accessController.(io.Closer); ok {
+			app.closers = append(app.closers, c)
+		}
 		dcontext.GetLogger(app).Debugf("configured %q access controller", authType)
 	}
+	if len(accessControllers) > 0 {
+		app.accessController = auth.Chain(accessControllers...)
+	}
 
 	// configure as a pull through cache
+	var proxyOptions []proxy.Option
+	if app.redis != nil {
+		proxyOptions = append(proxyOptions, proxy.WithRedis(app.redis))
+	}
 	if config.Proxy.RemoteURL != "" {
-		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy)
+		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy, proxyOptions...)
 		if err != nil {
 			panic(err.Error())
 		}
-		app.isCache = true
+		app.isCache = !config.Proxy.Hybrid
 		dcontext.GetLogger(app).Info("Registry configured as a proxy cache to ", config.Proxy.RemoteURL)
+	} else if len(config.Proxy.Upstreams) > 0 {
+		// only the repositories of the upstreams are caches, the others
+		// accept pushes
+		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy, proxyOptions...)
+		if err != nil {
+			panic(err.Error())
+		}
+		dcontext.GetLogger(app).Infof("Registry configured with %d proxy upstreams", len(config.Proxy.Upstreams))
 	}
 	var ok bool
 	app.repoRemover, ok = app.registry.(distribution.RepositoryRemover)
@@ -402,6 +539,20 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 		go health.Poll(app, updater, storageDriverCheck, interval)
 	}
 
+	if app.Config.Health.Upstream.Enabled {
+		if checker, ok := app.registry.(proxy.UpstreamChecker); ok {
+			interval := app.Config.Health.Upstream.Interval
+			if interval == 0 {
+				interval = defaultCheckInterval
+			}
+
+			healthRegistry.Register("upstream", health.CheckFunc(checker.CheckUpstreams))
+			go checker.PollUpstreams(app, interval)
+		} else {
+			dcontext.GetLogger(app).Warnf("upstream health check is enabled, but the registry is not a pull through cache")
+		}
+	}
+
 	for _, fileChecker := range app.Config.Health.FileCheckers {
 		interval := fileChecker.Interval
 		if interval == 0 {
@@ -449,10 +600,16 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 
 // Shutdown close the underlying registry
 func (app *App) Shutdown() error {
+	var err error
 	if r, ok := app.registry.(proxy.Closer); ok {
-		return r.Close()
+		err = r.Close()
 	}
-	return nil
+	for _, c := range app.closers {
+		if cErr := c.Close(); cErr != nil {
+			err = errors.Join(err, cErr)
+		}
+	}
+	return err
 }
 
 // register a handler with the application, by route name. The handler will be
@@ -480,6 +637,47 @@ func (app *App) register(routeName string, dispatch dispatchFunc) {
 
 // configureEvents prepares the event sink for action.
 func (app *App) configureEvents(configuration *configuration.Configuration) {
+	// Populate registry event source
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = configuration.HTTP.Addr
+	} else {
+		// try to pick the port off the config
+		_, port, err := net.SplitHostPort(configuration.HTTP.Addr)
+		if err == nil {
+			hostname = net.JoinHostPort(hostname, port)
+		}
+	}
+
+	queue := configuration.Notifications.Queue
+	maxSize := queue.MaxSize
+	switch queue.Backend {
+	case "", "inmemory":
+		if queue.MaxAttempts > 0 || queue.MaxSize > 0 {
+			dcontext.GetLogger(app).Warnf("notifications queue maxsize and maxattempts require the file or redis backend, ignoring")
+		}
+	case "file":
+		if queue.Directory == "" {
+			panic("notifications queue directory is required by the file backend")
+		}
+	case "redis":
+		if app.redis == nil {
+			panic("redis configuration required to use the redis notifications queue")
+		}
+	default:
+		panic(fmt.Sprintf("unknown notifications queue backend %q", queue.Backend))
+	}
+	instance := queue.Instance
+	if instance == "" {
+		instance = hostname
+		if queue.Backend == "redis" {
+			dcontext.GetLogger(app).Warnf("notifications queue instance not set, the events pending in redis are only delivered if the registry restarts with the hostname %s", hostname)
+		}
+	}
+	if maxSize <= 0 {
+		maxSize = defaultNotificationQueueSize
+	}
+
 	// Configure all of the endpoint sinks.
 	// NOTE(milosgajdos): we are disabling the linter here as
 	// if an endpoint is disabled we continue with the evaluation
@@ -494,14 +692,22 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 		}
 
 		dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
-		endpoint := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
+		endpoint, err := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
 			Timeout:           endpoint.Timeout,
 			Threshold:         endpoint.Threshold,
 			Backoff:           endpoint.Backoff,
 			Headers:           endpoint.Headers,
 			IgnoredMediaTypes: endpoint.IgnoredMediaTypes,
 			Ignore:            endpoint.Ignore,
+			Format:            endpoint.Format,
+			Secret:            endpoint.Secret,
+			Queue:             app.notificationQueue(queue, endpoint.Name, instance),
+			MaxPending:        maxSize,
+			MaxAttempts:       queue.MaxAttempts,
 		})
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure notifications: %v", err))
+		}
 
 		sinks = append(sinks, endpoint)
 	}
@@ -512,24 +718,34 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	// simple.
 	app.events.sink = events.NewBroadcaster(sinks...)
 
-	// Populate registry event source
-	hostname, err := os.Hostname()
-	if err != nil {
-		hostname = configuration.HTTP.Addr
-	} else {
-		// try to pick the port off the config
-		_, port, err := net.SplitHostPort(configuration.HTTP.Addr)
-		if err == nil {
-			hostname = net.JoinHostPort(hostname, port)
-		}
-	}
-
 	app.events.source = notifications.SourceRecord{
 		Addr:       hostname,
 		InstanceID: dcontext.GetStringValue(app, "instance.id"),
 	}
 }
 
+// notificationQueue returns the store persisting the events pending for an
+// endpoint, or nil if they are queued in memory. The queue of the redis
+// backend is specific to the registry instance, while its dead letters are
+// shared by the instances.
+func (app *App) notificationQueue(config configuration.NotificationQueue, endpoint, instance string) notifications.QueueStore {
+	switch config.Backend {
+	case "file":
+		store, err := notifications.NewFileQueueStore(filepath.Join(config.Directory, url.PathEscape(endpoint)))
+		if err != nil {
+			panic(fmt.Sprintf("unable to open the notifications queue of endpoint %s: %v", endpoint, err))
+		}
+		return store
+	case "redis":
+		return notifications.NewRedisQueueStore(app.redis,
+			"notifications::queue::"+endpoint+"::"+instance,
+			"notifications::deadletter::"+endpoint,
+			int64(defaultNotificationQueueSize))
+	default:
+		return nil
+	}
+}
+
 func (app *App) configureRedis(cfg *configuration.Configuration) {
 	if len(cfg.Redis.Options.Addrs) == 0 {
 		dcontext.GetLogger(app).Infof("redis not configured")
@@ -587,6 +803,67 @@ func (app *App) configureRedis(cfg *configuration.Configuration) {
 	}))
 }
 
+// configureQuotas prepares the accounting of the storage used by repositories
+// and the enforcement of the configured quotas.
+func (app *App) configureQuotas(cfg *configuration.Configuration) {
+	ttl := cfg.Quota.TTL
+	if ttl <= 0 {
+		ttl = quota.DefaultTTL
+	}
+
+	var ledger quota.Ledger
+	switch cfg.Quota.Backend {
+	case "", "inmemory":
+		ledger = quota.NewMemoryLedger(ttl)
+	case "redis":
+		if app.redis == nil {
+			panic("redis configuration required to use for quota backend")
+		}
+		ledger = quota.NewRedisLedger(app.redis, ttl)
+	default:
+		panic(fmt.Sprintf("unknown quota backend: %q", cfg.Quota.Backend))
+	}
+
+	limits := make([]quota.Limit, 0, len(cfg.Quota.Limits))
+	for _, limit := range cfg.Quota.Limits {
+		if limit.Limit <= 0 {
+			panic(fmt.Sprintf("invalid quota limit for prefix %q: %d", limit.Prefix, limit.Limit))
+		}
+		limits = append(limits, quota.Limit{Prefix: limit.Prefix, Bytes: limit.Limit})
+	}
+
+	// usages are computed with a registry of their own, so that enumerating
+	// content is not mistaken for uses by the online garbage collection
+	registry, err := storage.NewRegistry(app, app.driver)
+	if err != nil {
+		panic("could not create registry for quotas: " + err.Error())
+	}
+	app.quotas = quota.New(registry, ledger, limits)
+	dcontext.GetLogger(app).Infof("configured %d storage quotas", len(limits))
+}
+
+// resetUsages discards the recorded storage usages once content was removed
+// by the garbage collection.
+func (app *App) resetUsages(ctx context.Context) {
+	if app.quotas == nil {
+		return
+	}
+	if err := app.quotas.Reset(ctx); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error resetting storage usages: %v", err)
+	}
+}
+
+// invalidateUsage discards the recorded storage usage of the named repository
+// once content was removed from it by the retention.
+func (app *App) invalidateUsage(ctx context.Context, repo string) {
+	if app.quotas == nil {
+		return
+	}
+	if err := app.quotas.Invalidate(ctx, repo); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error invalidating storage usage of %s: %v", repo, err)
+	}
+}
+
 func (app *App) createPool(cfg redis.UniversalOptions) redis.UniversalClient {
 	cfg.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
 		res := cn.Ping(ctx)
@@ -853,6 +1130,16 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 		accessRecords = appendCatalogAccessRecord(accessRecords, r)
 	}
 
+	if r.Header.Get("Authorization") == "" && app.anonymousAccess(accessRecords) {
+		ctx := withUser(context.Context, auth.UserInfo{Name: anonymousUser})
+		ctx = withAllowed(ctx, func(access auth.Access) bool {
+			return app.anonymousAccess([]auth.Access{access})
+		})
+		dcontext.GetLogger(ctx, userNameKey).Info("authorized anonymous request")
+		context.Context = ctx
+		return nil
+	}
+
 	grant, err := app.accessController.Authorized(r.WithContext(context.Context), accessRecords...)
 	if err != nil {
 		switch err := err.(type) {
@@ -880,6 +1167,15 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 
 	ctx := withUser(context.Context, grant.User)
 	ctx = withResources(ctx, grant.Resources)
+	if grant.Allowed != nil {
+		ctx = withAllowed(ctx, grant.Allowed)
+	}
+	for _, user := range app.Config.ImmutableTags.OverrideUsers {
+		if user == grant.User.Name {
+			ctx = storage.WithTagImmutabilityOverride(ctx)
+			break
+		}
+	}
 
 	dcontext.GetLogger(ctx, userNameKey).Info("authorized request")
 	// TODO(stevvooe): This pattern needs to be cleaned up a bit. One context
@@ -889,6 +1185,40 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	return nil
 }
 
+// anonymousAccess reports whether the access records are all granted to
+// requests without credentials. Requests without access records, such as the
+// base route, are not, so that clients are challenged for credentials.
+func (app *App) anonymousAccess(accessRecords []auth.Access) bool {
+	if len(accessRecords) == 0 {
+		return false
+	}
+	for _, access := range accessRecords {
+		switch {
+		case access.Type == "repository" && access.Action == "pull":
+			if !matchesAny(app.anonymous.Repositories, access.Name) {
+				return false
+			}
+		case access.Type == "registry" && access.Name == "catalog":
+			if !app.anonymous.Catalog {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAny reports whether name matches any of the shell patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // eventBridge returns a bridge for the current request, configured with the
 // correct actor and source.
 func (app *App) eventBridge(ctx *Context, r *http.Request) notifications.Listener {
@@ -900,6 +1230,16 @@ func (app *App) eventBridge(ctx *Context, r *http.Request) notifications.Listene
 	return notifications.NewBridge(ctx.urlBuilder, app.events.source, actor, request, app.events.sink, app.Config.Notifications.EventConfig.IncludeReferences)
 }
 
+// retentionEventBridge returns a bridge for the deletions performed by the
+// retention job, which are not tied to a request.
+func (app *App) retentionEventBridge() notifications.Listener {
+	actor := notifications.ActorRecord{
+		Name: "retention",
+	}
+
+	return notifications.NewBridge(v2.NewURLBuilder(&app.httpHost, false), app.events.source, actor, notifications.RequestRecord{}, app.events.sink, app.Config.Notifications.EventConfig.IncludeReferences)
+}
+
 // nameRequired returns true if the route requires a name.
 func (app *App) nameRequired(r *http.Request) bool {
 	route := mux.CurrentRoute(r)
@@ -999,16 +1339,207 @@ func applyRepoMiddleware(ctx context.Context, repository distribution.Repository
 	return repository, nil
 }
 
-// applyStorageMiddleware wraps a storage driver with the configured middlewares
-func applyStorageMiddleware(ctx context.Context, driver storagedriver.StorageDriver, middlewares []configuration.Middleware) (storagedriver.StorageDriver, error) {
+// applyStorageMiddleware wraps a storage driver with the configured
+// middlewares, and returns the middlewares to close on shutdown.
+func applyStorageMiddleware(ctx context.Context, driver storagedriver.StorageDriver, middlewares []configuration.Middleware) (storagedriver.StorageDriver, []io.Closer, error) {
+	var closers []io.Closer
 	for _, mw := range middlewares {
 		smw, err := storagemiddleware.Get(ctx, mw.Name, mw.Options, driver)
 		if err != nil {
-			return nil, fmt.Errorf("unable to configure storage middleware (%s): %v", mw.Name, err)
+			return nil, nil, fmt.Errorf("unable to configure storage middleware (%s): %v", mw.Name, err)
+		}
+		if c, ok := smw.(io.Closer); ok {
+			closers = append(closers, c)
 		}
 		driver = smw
 	}
-	return driver, nil
+	return driver, closers, nil
+}
+
+// badGarbageCollectConfig panics on an invalid garbage collection configuration.
+func badGarbageCollectConfig(reason string) {
+	panic(fmt.Sprintf("Unable to parse garbage collection configuration: %s", reason))
+}
+
+// startGarbageCollector schedules a goroutine which will periodically run
+// an online garbage collection of the registry storage, calling collected
+// after each collection which may have removed content.
+func startGarbageCollector(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}, inflight *storage.InFlight, collected func(context.Context)) {
+	var intervalDuration time.Duration
+	var err error
+	interval, ok := config["interval"]
+	if ok {
+		intervalStr, ok := interval.(string)
+		if !ok {
+			badGarbageCollectConfig("interval is not a string")
+		}
+		intervalDuration, err = time.ParseDuration(intervalStr)
+		if err != nil {
+			badGarbageCollectConfig(fmt.Sprintf("Cannot parse interval: %s", err.Error()))
+		}
+	} else {
+		badGarbageCollectConfig("interval missing")
+	}
+
+	gracePeriodDuration := storage.DefaultGCGracePeriod
+	if gracePeriod, ok := config["graceperiod"]; ok {
+		gracePeriodStr, ok := gracePeriod.(string)
+		if !ok {
+			badGarbageCollectConfig("graceperiod is not a string")
+		}
+		gracePeriodDuration, err = time.ParseDuration(gracePeriodStr)
+		if err != nil {
+			badGarbageCollectConfig(fmt.Sprintf("Cannot parse graceperiod: %s", err.Error()))
+		}
+	}
+
+	var deleteUntaggedBool, dryRunBool bool
+	if deleteUntagged, ok := config["deleteuntagged"]; ok {
+		deleteUntaggedBool, ok = deleteUntagged.(bool)
+		if !ok {
+			badGarbageCollectConfig("cannot parse deleteuntagged")
+		}
+	}
+	if dryRun, ok := config["dryrun"]; ok {
+		dryRunBool, ok = dryRun.(bool)
+		if !ok {
+			badGarbageCollectConfig("cannot parse dryrun")
+		}
+	}
+	var keepTagHistoryInt int
+	if keepTagHistory, ok := config["keeptaghistory"]; ok {
+		keepTagHistoryInt, ok = keepTagHistory.(int)
+		if !ok || keepTagHistoryInt < 0 {
+			badGarbageCollectConfig("cannot parse keeptaghistory")
+		}
+	}
+
+	// The collector reads through its own registry instance, so that its
+	// lookups are not mistaken for content in use.
+	registry, err := storage.NewRegistry(ctx, storageDriver)
+	if err != nil {
+		panic("could not create registry for garbage collection: " + err.Error())
+	}
+
+	go func() {
+		for {
+			log.Infof("Starting online garbage collection in %s", intervalDuration)
+			time.Sleep(intervalDuration)
+
+			err := storage.MarkAndSweep(ctx, storageDriver, registry, storage.GCOpts{
+				DryRun:         dryRunBool,
+				RemoveUntagged: deleteUntaggedBool,
+				KeepTagHistory: keepTagHistoryInt,
+				Online:         true,
+				GracePeriod:    gracePeriodDuration,
+				InFlight:       inflight,
+			})
+			if err != nil {
+				log.Errorf("online garbage collection failed: %v", err)
+			}
+			if !dryRunBool {
+				collected(ctx)
+			}
+		}
+	}()
+}
+
+// RetentionPolicies validates the configured retention policies and returns
+// them in the form enforced by storage.EnforceRetention.
+func RetentionPolicies(config configuration.Retention) ([]storage.RetentionPolicy, error) {
+	policies := make([]storage.RetentionPolicy, 0, len(config.Policies))
+	for i, p := range config.Policies {
+		policy := storage.RetentionPolicy{
+			Repositories: p.Repositories,
+			KeepLast:     p.KeepLast,
+			MaxAge:       p.MaxAge,
+			Protect:      p.Protect,
+		}
+		for _, pattern := range p.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("retention.policies[%d].repositories: %v", i, err)
+			}
+		}
+		if p.Tags != "" {
+			re, err := regexp.Compile(p.Tags)
+			if err != nil {
+				return nil, fmt.Errorf("retention.policies[%d].tags: %v", i, err)
+			}
+			policy.Tags = re
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// immutableTagPolicies validates the configured immutability policies and
+// returns them in the form enforced by the tag service.
+func immutableTagPolicies(config configuration.ImmutableTags) ([]storage.ImmutableTagPolicy, error) {
+	policies := make([]storage.ImmutableTagPolicy, 0, len(config.Policies))
+	for i, p := range config.Policies {
+		if len(p.Repositories) == 0 {
+			return nil, fmt.Errorf("immutabletags.policies[%d]: no repositories", i)
+		}
+		for _, pattern := range p.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("immutabletags.policies[%d].repositories: %v", i, err)
+			}
+		}
+		policy := storage.ImmutableTagPolicy{Repositories: p.Repositories}
+		if p.Tags != "" {
+			// the pattern must match the whole tag, so that v[0-9]+ does not
+			// also protect dev-v1-test.
+			re, err := regexp.Compile("^(?:" + p.Tags + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("immutabletags.policies[%d].tags: %v", i, err)
+			}
+			policy.Tags = re
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// startRetention schedules a goroutine which will periodically delete the
+// tags selected by the retention policies, calling expired for each
+// repository they were deleted from.
+func startRetention(ctx context.Context, storageDriver storagedriver.StorageDriver, registry distribution.Namespace, log dcontext.Logger, config configuration.Retention, listener storage.RetentionListener, expired func(ctx context.Context, repo string)) {
+	policies, err := RetentionPolicies(config)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	interval := config.Interval
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+
+	go func() {
+		for {
+			log.Infof("Starting retention in %s", interval)
+			time.Sleep(interval)
+
+			tags, err := storage.EnforceRetention(ctx, storageDriver, registry, storage.RetentionOpts{
+				Policies:        policies,
+				DryRun:          config.DryRun,
+				DeleteManifests: config.DeleteManifests,
+				Listener:        listener,
+			})
+			repos := make(map[string]struct{})
+			for _, tag := range tags {
+				log.Infof("retention: tag %s:%s (%s) pushed at %s is expired", tag.Repository, tag.Tag, tag.Digest, tag.Pushed.Format(time.RFC3339))
+				repos[tag.Repository] = struct{}{}
+			}
+			if !config.DryRun {
+				for repo := range repos {
+					expired(ctx, repo)
+				}
+			}
+			if err != nil {
+				log.Errorf("retention failed: %v", err)
+			}
+		}
+	}()
 }
 
 // uploadPurgeDefaultConfig provides a default configuration for upload
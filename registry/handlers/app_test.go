@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -13,6 +15,7 @@ import (
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
+	_ "github.com/distribution/distribution/v3/registry/auth/htpasswd"
 	_ "github.com/distribution/distribution/v3/registry/auth/silly"
 	"github.com/distribution/distribution/v3/registry/storage"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
@@ -211,6 +214,139 @@ func TestNewApp(t *testing.T) {
 	}
 }
 
+// TestNewAppAuthChain covers the creation of an application with several
+// access controllers, whose challenges are all advertised.
+func TestNewAppAuthChain(t *testing.T) {
+	ctx := dcontext.Background()
+	htpasswdPath := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(htpasswdPath, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+				"order":   1,
+			},
+			"htpasswd": {
+				"realm": "basic-realm",
+				"path":  htpasswdPath,
+				"order": 0,
+			},
+		},
+	}
+
+	server := httptest.NewServer(NewApp(ctx, &config))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v2/")
+	if err != nil {
+		t.Fatalf("unexpected error during GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code: %v != %v", resp.StatusCode, http.StatusUnauthorized)
+	}
+	expected := []string{`Basic realm="basic-realm"`, `Bearer realm="realm-test",service="service-test"`}
+	if challenges := resp.Header.Values("WWW-Authenticate"); !reflect.DeepEqual(challenges, expected) {
+		t.Fatalf("unexpected WWW-Authenticate headers: %q != %q", challenges, expected)
+	}
+}
+
+// TestAnonymousAccess covers the repositories which can be pulled without
+// credentials while an access controller is configured.
+func TestAnonymousAccess(t *testing.T) {
+	ctx := dcontext.Background()
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+		Anonymous: configuration.Anonymous{
+			Repositories: []string{"library/*"},
+		},
+	}
+
+	server := httptest.NewServer(NewApp(ctx, &config))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		method   string
+		path     string
+		expected int
+	}{
+		{http.MethodGet, "/v2/", http.StatusUnauthorized},
+		{http.MethodGet, "/v2/library/ubuntu/tags/list", http.StatusNotFound},
+		{http.MethodHead, "/v2/library/ubuntu/manifests/latest", http.StatusNotFound},
+		{http.MethodPost, "/v2/library/ubuntu/blobs/uploads/", http.StatusUnauthorized},
+		{http.MethodDelete, "/v2/library/ubuntu/manifests/latest", http.StatusUnauthorized},
+		{http.MethodGet, "/v2/library/nested/ubuntu/tags/list", http.StatusUnauthorized},
+		{http.MethodGet, "/v2/private/app/tags/list", http.StatusUnauthorized},
+		{http.MethodGet, "/v2/_catalog", http.StatusUnauthorized},
+	} {
+		req, err := http.NewRequest(tc.method, server.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error during %s %s: %v", tc.method, tc.path, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.expected {
+			t.Errorf("%s %s: unexpected status code: %v != %v", tc.method, tc.path, resp.StatusCode, tc.expected)
+		}
+	}
+}
+
+// TestImmutableTagPolicies validates that the tag patterns of the
+// immutability policies match whole tags.
+func TestImmutableTagPolicies(t *testing.T) {
+	policies, err := immutableTagPolicies(configuration.ImmutableTags{
+		Policies: []configuration.ImmutableTagPolicy{{Repositories: []string{"release/*"}, Tags: `v[0-9]+|stable`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := dcontext.Background()
+	for tag, immutable := range map[string]bool{
+		"v1":          true,
+		"v12":         true,
+		"stable":      true,
+		"dev-v1-test": false,
+		"v1-rc":       false,
+		"unstable":    false,
+	} {
+		if got := storage.TagImmutable(ctx, policies, "release/app", tag); got != immutable {
+			t.Errorf("tag %s: immutable %v, expected %v", tag, got, immutable)
+		}
+	}
+
+	if _, err := immutableTagPolicies(configuration.ImmutableTags{
+		Policies: []configuration.ImmutableTagPolicy{{Repositories: []string{"release/*"}, Tags: `v[0-9`}},
+	}); err == nil {
+		t.Fatal("expected an error for an invalid tag pattern")
+	}
+}
+
 // Test the access record accumulator
 func TestAppendAccessRecords(t *testing.T) {
 	repo := "testRepo"
@@ -77,6 +77,12 @@ func (bh *blobHandler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(bh).Debug("DeleteBlob")
 
 	blobs := bh.Repository.Blobs(bh)
+	var size int64
+	if bh.App.quotas != nil {
+		if desc, err := blobs.Stat(bh, bh.Digest); err == nil {
+			size = desc.Size
+		}
+	}
 	err := blobs.Delete(bh, bh.Digest)
 	if err != nil {
 		switch err {
@@ -93,6 +99,8 @@ func (bh *blobHandler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	bh.recordUsage(-size)
+
 	w.Header().Set("Content-Length", "0")
 	w.WriteHeader(http.StatusAccepted)
 }
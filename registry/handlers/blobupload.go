@@ -73,6 +73,10 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 	if mountDigest != "" && fromRepo != "" {
 		opt, err := buh.createBlobMountOption(fromRepo, mountDigest)
 		if opt != nil && err == nil {
+			if err := buh.checkMountQuota(digest.Digest(mountDigest)); err != nil {
+				buh.Errors = append(buh.Errors, err)
+				return
+			}
 			options = append(options, opt)
 		}
 	}
@@ -81,6 +85,7 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 	upload, err := blobs.Create(buh, options...)
 	if err != nil {
 		if ebm, ok := err.(distribution.ErrBlobMounted); ok {
+			buh.recordUsage(ebm.Descriptor.Size)
 			if err := buh.writeBlobCreatedHeaders(w, ebm.Descriptor); err != nil {
 				buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			}
@@ -211,6 +216,20 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 		return
 	}
 
+	// content already linked to the repository is not accounted again
+	linked := false
+	if buh.App.quotas != nil {
+		if _, err := buh.Repository.Blobs(buh).Stat(buh, dgst); err == nil {
+			linked = true
+		} else if err := buh.checkQuota(buh.Upload.Size()); err != nil {
+			buh.Errors = append(buh.Errors, err)
+			if err := buh.Upload.Cancel(buh); err != nil {
+				dcontext.GetLogger(buh).Errorf("error canceling upload after error: %v", err)
+			}
+			return
+		}
+	}
+
 	desc, err := buh.Upload.Commit(buh, v1.Descriptor{
 		Digest: dgst,
 
@@ -247,6 +266,9 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 
 		return
 	}
+	if !linked {
+		buh.recordUsage(desc.Size)
+	}
 	if err := buh.writeBlobCreatedHeaders(w, desc); err != nil {
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
@@ -382,6 +404,24 @@ func (buh *blobUploadHandler) createBlobMountOption(fromRepo, mountDigest string
 	return storage.WithMountFrom(canonical), nil
 }
 
+// checkMountQuota returns an error if mounting the blob identified by dgst
+// would exceed one of the quotas of the repository. Blobs unknown to the
+// registry are not mounted, and are checked once uploaded instead.
+func (buh *blobUploadHandler) checkMountQuota(dgst digest.Digest) error {
+	if buh.App.quotas == nil {
+		return nil
+	}
+
+	desc, err := buh.App.registry.BlobStatter().Stat(buh, dgst)
+	if err != nil {
+		if err == distribution.ErrBlobUnknown {
+			return nil
+		}
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+	return buh.checkQuota(desc.Size)
+}
+
 // writeBlobCreatedHeaders writes the standard headers describing a newly
 // created blob. A 201 Created is written as well as the canonical URL and
 // blob digest.
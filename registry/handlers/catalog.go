@@ -1,20 +1,39 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/quota"
+	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
 	"github.com/gorilla/handlers"
 )
 
 const defaultReturnedEntries = 100
 
+// maxDetailedEntries is the maximum number of repositories returned at once
+// with their details, which are read from the storage of each repository.
+const maxDetailedEntries = 50
+
+// catalogDetailsTTL is the time during which the details of a repository are
+// cached, before being read again from its storage.
+const catalogDetailsTTL = time.Minute
+
+// catalogDetailsCacheSize is the number of repositories whose details are
+// cached.
+const catalogDetailsCacheSize = 10000
+
 func catalogDispatcher(ctx *Context, r *http.Request) http.Handler {
 	catalogHandler := &catalogHandler{
 		Context: ctx,
@@ -30,7 +49,19 @@ type catalogHandler struct {
 }
 
 type catalogAPIResponse struct {
-	Repositories []string `json:"repositories"`
+	Repositories []string            `json:"repositories"`
+	Details      []repositoryDetails `json:"details,omitempty"`
+}
+
+// repositoryDetails is the extended information on a repository returned by
+// the catalog when requested.
+type repositoryDetails struct {
+	Name string `json:"name"`
+	Tags int    `json:"tags"`
+	// LastPush is the last time a manifest was pushed or tagged, if any.
+	LastPush *time.Time `json:"lastPush,omitempty"`
+	// Size is the total size of the layers and manifests of the repository.
+	Size int64 `json:"size"`
 }
 
 func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
@@ -38,9 +69,19 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 
 	q := r.URL.Query()
 	lastEntry := q.Get("last")
+	prefix := q.Get("prefix")
+
+	details, _ := strconv.ParseBool(q.Get("details"))
+	if details && ch.App.isCache {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnsupported.WithMessage("catalog details are not supported by a pull-through cache"))
+		return
+	}
 
 	entries := defaultReturnedEntries
 	maximumConfiguredEntries := ch.App.Config.Catalog.MaxEntries
+	if details && maximumConfiguredEntries > maxDetailedEntries {
+		maximumConfiguredEntries = maxDetailedEntries
+	}
 
 	// parse n, if n is negative abort with an error
 	if n := q.Get("n"); n != "" {
@@ -67,21 +108,48 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	repos := make([]string, entries)
 	filled := 0
 
+	// the details are only returned for the repositories the request was
+	// granted access to, and are refused if the access controller cannot
+	// tell which ones
+	var authorized func(string) bool
+	if details && ch.App.accessController != nil {
+		var ok bool
+		authorized, ok = authorizedRepositories(ch)
+		if !ok {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeDenied.WithMessage("catalog details require an access controller authorizing each repository"))
+			return
+		}
+	}
+
 	// entries is guaranteed to be >= 0 and < maximumConfiguredEntries
 	if entries == 0 {
 		moreEntries = false
 	} else {
-		returnedRepositories, err := ch.App.registry.Repositories(ch.Context, repos, lastEntry)
+		var err error
+		filled, moreEntries, err = ch.repositories(repos, prefix, lastEntry, authorized)
 		if err != nil {
-			_, pathNotFound := err.(driver.PathNotFoundError)
-			if err != io.EOF && !pathNotFound {
+			if err == distribution.ErrUnsupported {
+				ch.Errors = append(ch.Errors, errcode.ErrorCodeUnsupported.WithMessage("catalog prefixes are not supported by this registry"))
+				return
+			}
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+	}
+
+	resp := catalogAPIResponse{
+		Repositories: repos[0:filled],
+	}
+	if details {
+		resp.Details = make([]repositoryDetails, 0, filled)
+		for _, repo := range resp.Repositories {
+			repoDetails, err := ch.repositoryDetails(repo)
+			if err != nil {
 				ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 				return
 			}
-			// err is either io.EOF or not PathNotFoundError
-			moreEntries = false
+			resp.Details = append(resp.Details, repoDetails)
 		}
-		filled = returnedRepositories
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -98,25 +166,155 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	enc := json.NewEncoder(w)
-	if err := enc.Encode(catalogAPIResponse{
-		Repositories: repos[0:filled],
-	}); err != nil {
+	if err := enc.Encode(resp); err != nil {
 		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
 }
 
+// repositories fills repos with the repositories whose name starts with
+// prefix and which are after last, skipping those not accepted by include
+// unless it is nil. It returns the number of repositories filled, and
+// whether more repositories may follow them.
+func (ch *catalogHandler) repositories(repos []string, prefix, last string, include func(string) bool) (int, bool, error) {
+	list := ch.App.registry.Repositories
+	if prefix != "" {
+		prefixEnumerator, ok := ch.App.registry.(distribution.RepositoryPrefixEnumerator)
+		if !ok {
+			return 0, false, distribution.ErrUnsupported
+		}
+		list = func(ctx context.Context, repos []string, last string) (int, error) {
+			return prefixEnumerator.RepositoriesWithPrefix(ctx, repos, prefix, last)
+		}
+	}
+
+	filled := 0
+	for filled < len(repos) {
+		// list into the rest of the buffer, moving the accepted
+		// repositories to its front
+		listed := repos[filled:]
+		n, err := list(ch, listed, last)
+		for _, repo := range listed[:n] {
+			last = repo
+			if include == nil || include(repo) {
+				repos[filled] = repo
+				filled++
+			}
+		}
+		if err != nil {
+			_, pathNotFound := err.(driver.PathNotFoundError)
+			if err != io.EOF && !pathNotFound {
+				return 0, false, err
+			}
+			// err is either io.EOF or PathNotFoundError
+			return filled, false, nil
+		}
+	}
+	return filled, true, nil
+}
+
+// repositoryDetails returns the extended information on the named
+// repository, from the cache if it was read recently.
+func (ch *catalogHandler) repositoryDetails(repo string) (repositoryDetails, error) {
+	if details, ok := ch.App.catalogDetails.Get(repo); ok {
+		return details, nil
+	}
+	details, err := ch.readRepositoryDetails(repo)
+	if err != nil {
+		return details, err
+	}
+	ch.App.catalogDetails.Add(repo, details)
+	return details, nil
+}
+
+// readRepositoryDetails reads the extended information on the named
+// repository from its storage.
+func (ch *catalogHandler) readRepositoryDetails(repo string) (repositoryDetails, error) {
+	details := repositoryDetails{Name: repo}
+
+	named, err := reference.WithName(repo)
+	if err != nil {
+		return details, err
+	}
+	repository, err := ch.App.registry.Repository(ch, named)
+	if err != nil {
+		return details, err
+	}
+	tags, err := repository.Tags(ch).All(ch)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			return details, err
+		}
+	}
+	details.Tags = len(tags)
+
+	lastPush, err := storage.LastPushed(ch, ch.App.driver, repo)
+	if err != nil {
+		return details, err
+	}
+	if !lastPush.IsZero() {
+		lastPush = lastPush.UTC()
+		details.LastPush = &lastPush
+	}
+
+	// the usages recorded for the quotas are used if configured, rather
+	// than summing the sizes of the content of the repository
+	if ch.App.quotas != nil {
+		details.Size, err = ch.App.quotas.RepositoryUsage(ch, repo)
+		if err != nil {
+			return details, err
+		}
+	} else {
+		details.Size, err = quota.RepositoryUsage(ch, ch.App.registry, repo)
+		if err != nil {
+			return details, err
+		}
+	}
+	return details, nil
+}
+
+// authorizedRepositories returns a function reporting whether the request was
+// granted pull access to a repository. ok is false if the access controller
+// reports neither the accesses it grants nor the resources it granted, whose
+// names may be patterns.
+func authorizedRepositories(ctx context.Context) (authorized func(string) bool, ok bool) {
+	if allowed := allowedAccess(ctx); allowed != nil {
+		return func(repo string) bool {
+			return allowed(auth.Access{
+				Resource: auth.Resource{Type: "repository", Name: repo},
+				Action:   "pull",
+			})
+		}, true
+	}
+
+	resources := authorizedResources(ctx)
+	if resources == nil {
+		return nil, false
+	}
+
+	var patterns []string
+	for _, resource := range resources {
+		if resource.Type == "repository" {
+			patterns = append(patterns, resource.Name)
+		}
+	}
+	return func(repo string) bool {
+		return matchesAny(patterns, repo)
+	}, true
+}
+
 // Use the original URL from the request to create a new URL for
-// the link header
+// the link header. The other query parameters of the original URL, such as
+// the catalog prefix, are kept.
 func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string, error) {
 	calledURL, err := url.Parse(origURL)
 	if err != nil {
 		return "", err
 	}
 
-	v := url.Values{}
-	v.Add("n", strconv.Itoa(maxEntries))
-	v.Add("last", lastEntry)
+	v := calledURL.Query()
+	v.Set("n", strconv.Itoa(maxEntries))
+	v.Set("last", lastEntry)
 
 	calledURL.RawQuery = v.Encode()
 
@@ -151,6 +151,25 @@ func (rc resourceContext) Value(key interface{}) interface{} {
 	return rc.Context.Value(key)
 }
 
+// withAllowed returns a context with the function reporting whether the
+// request is granted other accesses than the ones it was authorized for.
+func withAllowed(ctx context.Context, allowed func(auth.Access) bool) context.Context {
+	return context.WithValue(ctx, allowedKey{}, allowed)
+}
+
+type allowedKey struct{}
+
+// allowedAccess returns the function reporting whether the request is
+// granted other accesses than the ones it was authorized for, or nil if the
+// access controller cannot tell.
+func allowedAccess(ctx context.Context) func(auth.Access) bool {
+	if allowed, ok := ctx.Value(allowedKey{}).(func(auth.Access) bool); ok {
+		return allowed
+	}
+
+	return nil
+}
+
 // authorizedResources returns the list of resources which have
 // been authorized for this request.
 func authorizedResources(ctx context.Context) []auth.Resource {
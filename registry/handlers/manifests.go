@@ -288,6 +288,27 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := imh.checkTagMutable(imh.Tag, imh.Digest); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
+	// manifests already stored in the repository are not accounted again
+	exists := false
+	if imh.App.quotas != nil {
+		exists, err = manifests.Exists(imh, desc.Digest)
+		if err != nil {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		if !exists {
+			if err := imh.checkQuota(desc.Size); err != nil {
+				imh.Errors = append(imh.Errors, err)
+				return
+			}
+		}
+	}
+
 	_, err = manifests.Put(imh, manifest, options...)
 	if err != nil {
 		// TODO(stevvooe): These error handling switches really need to be
@@ -326,11 +347,19 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !exists {
+		imh.recordUsage(desc.Size)
+	}
+
 	// Tag this manifest
 	if imh.Tag != "" {
 		tags := imh.Repository.Tags(imh)
 		err = tags.Tag(imh, imh.Tag, desc)
 		if err != nil {
+			if _, ok := err.(distribution.ErrTagImmutable); ok {
+				imh.Errors = append(imh.Errors, errcode.ErrorCodeTagImmutable.WithDetail(err))
+				return
+			}
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			return
 		}
@@ -354,11 +383,36 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 
 	w.Header().Set("Location", location)
 	w.Header().Set("Docker-Content-Digest", imh.Digest.String())
+	if subject := storage.ManifestSubject(manifest); subject != nil {
+		// Signal to the client that the subject has been indexed for the
+		// referrers API.
+		w.Header().Set("OCI-Subject", subject.Digest.String())
+	}
 	w.WriteHeader(http.StatusCreated)
 
 	dcontext.GetLogger(imh).Debug("Succeeded in putting manifest!")
 }
 
+// checkTagMutable returns a TAG_IMMUTABLE error if putting the manifest would
+// move an immutable tag to another manifest, before the manifest is stored.
+func (imh *manifestHandler) checkTagMutable(tag string, dgst digest.Digest) error {
+	if tag == "" || !storage.TagImmutable(imh, imh.App.immutableTags, imh.Repository.Named().Name(), tag) {
+		return nil
+	}
+
+	current, err := imh.Repository.Tags(imh).Get(imh, tag)
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			return nil
+		}
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+	if current.Digest != dgst {
+		return errcode.ErrorCodeTagImmutable.WithDetail(distribution.ErrTagImmutable{Tag: tag})
+	}
+	return nil
+}
+
 // applyResourcePolicy checks whether the resource class matches what has
 // been authorized and allowed by the policy configuration.
 func (imh *manifestHandler) applyResourcePolicy(manifest distribution.Manifest) error {
@@ -438,11 +492,17 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 
 	if imh.Tag != "" {
 		dcontext.GetLogger(imh).Debug("DeleteImageTag")
+		if !imh.App.deleteEnabled {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnsupported)
+			return
+		}
 		tagService := imh.Repository.Tags(imh.Context)
 		if err := tagService.Untag(imh.Context, imh.Tag); err != nil {
 			switch err.(type) {
 			case distribution.ErrTagUnknown, driver.PathNotFoundError:
 				imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(err))
+			case distribution.ErrTagImmutable:
+				imh.Errors = append(imh.Errors, errcode.ErrorCodeTagImmutable.WithDetail(err))
 			default:
 				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			}
@@ -458,6 +518,28 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// manifests referenced by immutable tags cannot be deleted
+	if len(imh.App.immutableTags) > 0 {
+		referencedTags, err := imh.Repository.Tags(imh).Lookup(imh, v1.Descriptor{Digest: imh.Digest})
+		if err != nil {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		for _, tag := range referencedTags {
+			if storage.TagImmutable(imh, imh.App.immutableTags, imh.Repository.Named().Name(), tag) {
+				imh.Errors = append(imh.Errors, errcode.ErrorCodeTagImmutable.WithDetail(distribution.ErrTagImmutable{Tag: tag}))
+				return
+			}
+		}
+	}
+
+	var size int64
+	if imh.App.quotas != nil {
+		if desc, err := imh.registry.BlobStatter().Stat(imh, imh.Digest); err == nil {
+			size = desc.Size
+		}
+	}
+
 	err = manifests.Delete(imh, imh.Digest)
 	if err != nil {
 		switch err {
@@ -476,6 +558,7 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 			return
 		}
 	}
+	imh.recordUsage(-size)
 
 	tagService := imh.Repository.Tags(imh)
 	referencedTags, err := tagService.Lookup(imh, v1.Descriptor{Digest: imh.Digest})
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/reference"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// tagHistoryDispatcher constructs the tag history handler api endpoint.
+func tagHistoryDispatcher(ctx *Context, r *http.Request) http.Handler {
+	tagHistoryHandler := &tagHistoryHandler{
+		Context: ctx,
+		Tag:     dcontext.GetStringValue(ctx, "vars.tag"),
+	}
+
+	mhandler := handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(tagHistoryHandler.GetTagHistory),
+	}
+
+	if !ctx.readOnly {
+		mhandler[http.MethodPost] = http.HandlerFunc(tagHistoryHandler.RollbackTag)
+	}
+
+	return mhandler
+}
+
+// tagHistoryHandler handles requests for the manifests previously referenced
+// by a tag.
+type tagHistoryHandler struct {
+	*Context
+
+	Tag string
+}
+
+// tagRevision is a manifest in the history of a tag.
+type tagRevision struct {
+	Digest  digest.Digest `json:"digest"`
+	Tagged  time.Time     `json:"tagged"`
+	Current bool          `json:"current"`
+}
+
+// tagHistoryAPIResponse is the response of a tag history request.
+type tagHistoryAPIResponse struct {
+	Name    string        `json:"name"`
+	Tag     string        `json:"tag"`
+	History []tagRevision `json:"history"`
+}
+
+// GetTagHistory returns the manifests referenced by the tag, from the most
+// to the least recently tagged.
+func (th *tagHistoryHandler) GetTagHistory(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(th).Debug("GetTagHistory")
+
+	revisions, ok := th.history()
+	if !ok {
+		return
+	}
+
+	resp := tagHistoryAPIResponse{
+		Name:    th.Repository.Named().Name(),
+		Tag:     th.Tag,
+		History: make([]tagRevision, 0, len(revisions)),
+	}
+	for _, revision := range revisions {
+		resp.History = append(resp.History, tagRevision{
+			Digest:  revision.Digest,
+			Tagged:  revision.Tagged.UTC(),
+			Current: revision.Current,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// RollbackTag points the tag at a manifest it previously referenced, given
+// by the digest query parameter.
+func (th *tagHistoryHandler) RollbackTag(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(th).Debug("RollbackTag")
+
+	if th.App.isCache {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	dgst, err := digest.Parse(r.FormValue("digest"))
+	if err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(err))
+		return
+	}
+
+	revisions, ok := th.history()
+	if !ok {
+		return
+	}
+	var found bool
+	for _, revision := range revisions {
+		if revision.Digest == dgst {
+			found = true
+			break
+		}
+	}
+	if !found {
+		th.Errors = append(th.Errors, errcode.ErrorCodeManifestUnknown.WithMessage("manifest is not in the history of the tag").WithDetail(dgst))
+		return
+	}
+
+	manifests, err := th.Repository.Manifests(th)
+	if err != nil {
+		th.Errors = append(th.Errors, err)
+		return
+	}
+	exists, err := manifests.Exists(th, dgst)
+	if err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if !exists {
+		th.Errors = append(th.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(dgst))
+		return
+	}
+
+	if err := th.Repository.Tags(th).Tag(th, th.Tag, v1.Descriptor{Digest: dgst}); err != nil {
+		if _, ok := err.(distribution.ErrTagImmutable); ok {
+			th.Errors = append(th.Errors, errcode.ErrorCodeTagImmutable.WithDetail(err))
+			return
+		}
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	ref, err := reference.WithDigest(th.Repository.Named(), dgst)
+	if err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	location, err := th.urlBuilder.BuildManifestURL(ref)
+	if err != nil {
+		dcontext.GetLogger(th).Errorf("error building manifest url from digest: %v", err)
+	}
+
+	w.Header().Set("Location", location)
+	w.Header().Set("Content-Length", "0")
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// history returns the history of the tag, reporting whether it was read or
+// an error was recorded.
+func (th *tagHistoryHandler) history() ([]storage.TagRevision, bool) {
+	revisions, err := storage.TagHistory(th, th.App.driver, th.Repository.Named().Name(), th.Tag)
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			th.Errors = append(th.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(err))
+		} else {
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return nil, false
+	}
+	return revisions, true
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/quota"
+	"github.com/gorilla/handlers"
+)
+
+// usageDispatcher constructs the usage handler api endpoint.
+func usageDispatcher(ctx *Context, r *http.Request) http.Handler {
+	usageHandler := &usageHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(usageHandler.GetUsage),
+	}
+}
+
+// usageHandler handles requests for the storage used by a repository.
+type usageHandler struct {
+	*Context
+}
+
+// GetUsage returns the storage used by the repository and by the
+// repositories sharing its quotas.
+func (uh *usageHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(uh).Debug("GetUsage")
+
+	if uh.App.quotas == nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	usage, err := uh.App.quotas.Usage(uh, uh.Repository.Named().Name())
+	if err != nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(usage); err != nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// checkQuota returns an error if storing size more bytes in the repository
+// of the request would exceed one of its quotas.
+func (ctx *Context) checkQuota(size int64) error {
+	if ctx.App.quotas == nil {
+		return nil
+	}
+
+	if err := ctx.App.quotas.Check(ctx, ctx.Repository.Named().Name(), size); err != nil {
+		if err, ok := err.(quota.ErrQuotaExceeded); ok {
+			return errcode.ErrorCodeQuotaExceeded.WithDetail(err)
+		}
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+	return nil
+}
+
+// recordUsage accounts delta bytes stored in the repository of the request,
+// or removed from it if delta is negative.
+func (ctx *Context) recordUsage(delta int64) {
+	if ctx.App.quotas == nil {
+		return
+	}
+
+	if err := ctx.App.quotas.Record(ctx, ctx.Repository.Named().Name(), delta); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error recording storage usage: %v", err)
+	}
+}
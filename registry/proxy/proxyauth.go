@@ -81,8 +81,8 @@ func getAuthURLs(remoteURL string) ([]string, error) {
 	return authURLs, nil
 }
 
-func ping(manager challenge.Manager, endpoint, versionHeader string) error {
-	resp, err := http.Get(endpoint)
+func ping(client *http.Client, manager challenge.Manager, endpoint, versionHeader string) error {
+	resp, err := client.Get(endpoint)
 	if err != nil {
 		return err
 	}
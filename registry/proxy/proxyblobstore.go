@@ -20,10 +20,16 @@ import (
 type proxyBlobStore struct {
 	localStore     distribution.BlobStore
 	remoteStore    distribution.BlobService
-	scheduler      *scheduler.TTLExpirationScheduler
+	scheduler      scheduler.Scheduler
 	ttl            *time.Duration
 	repositoryName reference.Named
 	authChallenger authChallenger
+
+	// hybrid accepts blobs pushed locally
+	hybrid bool
+
+	// health tracks the availability of the remote
+	health *upstreamHealth
 }
 
 var _ distribution.BlobStore = &proxyBlobStore{}
@@ -76,6 +82,9 @@ func (pbs *proxyBlobStore) serveLocal(ctx context.Context, w http.ResponseWriter
 	}
 
 	proxyMetrics.BlobPush(uint64(localDesc.Size), true)
+	if pbs.health.unavailable(ctx) {
+		proxyMetrics.BlobStale()
+	}
 	return true, pbs.localStore.ServeBlob(ctx, w, r, dgst)
 }
 
@@ -186,16 +195,25 @@ func (pbs *proxyBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte,
 	return blob, nil
 }
 
-// Unsupported functions
+// Write functions, only supported in hybrid mode
 func (pbs *proxyBlobStore) Put(ctx context.Context, mediaType string, p []byte) (v1.Descriptor, error) {
+	if pbs.hybrid {
+		return pbs.localStore.Put(ctx, mediaType, p)
+	}
 	return v1.Descriptor{}, distribution.ErrUnsupported
 }
 
 func (pbs *proxyBlobStore) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
+	if pbs.hybrid {
+		return pbs.localStore.Create(ctx, options...)
+	}
 	return nil, distribution.ErrUnsupported
 }
 
 func (pbs *proxyBlobStore) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
+	if pbs.hybrid {
+		return pbs.localStore.Resume(ctx, id)
+	}
 	return nil, distribution.ErrUnsupported
 }
 
@@ -208,5 +226,8 @@ func (pbs *proxyBlobStore) Open(ctx context.Context, dgst digest.Digest) (io.Rea
 }
 
 func (pbs *proxyBlobStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	if pbs.hybrid {
+		return pbs.localStore.Delete(ctx, dgst)
+	}
 	return distribution.ErrUnsupported
 }
@@ -297,6 +297,49 @@ func TestProxyStoreStat(t *testing.T) {
 	}
 }
 
+func TestProxyStoreHybridPush(t *testing.T) {
+	te := makeTestEnv(t, "foo/bar")
+
+	blob := makeBlob(10)
+	if _, err := te.store.Put(te.ctx, "", blob); err != distribution.ErrUnsupported {
+		t.Fatalf("expected ErrUnsupported pushing to a cache, got %v", err)
+	}
+	if _, err := te.store.Create(te.ctx); err != distribution.ErrUnsupported {
+		t.Fatalf("expected ErrUnsupported pushing to a cache, got %v", err)
+	}
+
+	te.store.hybrid = true
+	localStats := te.LocalStats()
+	remoteStats := te.RemoteStats()
+
+	desc, err := te.store.Put(te.ctx, "", blob)
+	if err != nil {
+		t.Fatalf("error pushing blob: %v", err)
+	}
+	if (*localStats)["put"] != 1 || (*remoteStats)["put"] != 0 {
+		t.Fatalf("blob not pushed locally: local %v, remote %v", *localStats, *remoteStats)
+	}
+
+	// pushed blobs are served locally
+	if _, err := te.store.Stat(te.ctx, desc.Digest); err != nil {
+		t.Fatalf("error stating pushed blob: %v", err)
+	}
+	if (*remoteStats)["stat"] != 0 {
+		t.Fatalf("pushed blob stated remotely")
+	}
+
+	bw, err := te.store.Create(te.ctx)
+	if err != nil {
+		t.Fatalf("error creating upload: %v", err)
+	}
+	if _, err := te.store.Resume(te.ctx, bw.ID()); err != nil {
+		t.Fatalf("error resuming upload: %v", err)
+	}
+	if err := bw.Cancel(te.ctx); err != nil {
+		t.Fatalf("error canceling upload: %v", err)
+	}
+}
+
 func TestProxyStoreServeHighConcurrency(t *testing.T) {
 	te := makeTestEnv(t, "foo/bar")
 	blobSize := 200
@@ -16,10 +16,18 @@ type proxyManifestStore struct {
 	ctx             context.Context
 	localManifests  distribution.ManifestService
 	remoteManifests distribution.ManifestService
+	localBlobs      distribution.BlobStatter
+	remoteBlobs     distribution.BlobStatter
 	repositoryName  reference.Named
-	scheduler       *scheduler.TTLExpirationScheduler
+	scheduler       scheduler.Scheduler
 	ttl             *time.Duration
 	authChallenger  authChallenger
+
+	// hybrid accepts manifests pushed locally
+	hybrid bool
+
+	// health tracks the availability of the remote
+	health *upstreamHealth
 }
 
 var _ distribution.ManifestService = &proxyManifestStore{}
@@ -61,6 +69,9 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 	}
 
 	proxyMetrics.ManifestPush(uint64(len(payload)), !fromRemote)
+	if !fromRemote && pms.health.unavailable(ctx) {
+		proxyMetrics.ManifestStale()
+	}
 	if fromRemote {
 		proxyMetrics.ManifestPull(uint64(len(payload)))
 
@@ -92,10 +103,55 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 }
 
 func (pms proxyManifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
-	var d digest.Digest
-	return d, distribution.ErrUnsupported
+	if !pms.hybrid {
+		var d digest.Digest
+		return d, distribution.ErrUnsupported
+	}
+
+	// The local manifest service skips the verification of the references,
+	// as they may be pulled through later from the remote, so a pushed
+	// manifest is verified here against both.
+	var errs distribution.ErrManifestVerification
+	for _, desc := range manifest.References() {
+		if len(desc.URLs) > 0 {
+			continue
+		}
+		exists, err := pms.referenceExists(ctx, desc.Digest)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			errs = append(errs, distribution.ErrManifestBlobUnknown{Digest: desc.Digest})
+		}
+	}
+	if len(errs) != 0 {
+		return "", errs
+	}
+	return pms.localManifests.Put(ctx, manifest, options...)
+}
+
+// referenceExists returns whether the blob or manifest referenced by a pushed
+// manifest exists locally, or otherwise on the remote.
+func (pms proxyManifestStore) referenceExists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	if _, err := pms.localBlobs.Stat(ctx, dgst); err != distribution.ErrBlobUnknown {
+		return err == nil, err
+	}
+	if exists, err := pms.localManifests.Exists(ctx, dgst); err != nil || exists {
+		return exists, err
+	}
+
+	if err := pms.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return false, err
+	}
+	if _, err := pms.remoteBlobs.Stat(ctx, dgst); err != distribution.ErrBlobUnknown {
+		return err == nil, err
+	}
+	return pms.remoteManifests.Exists(ctx, dgst)
 }
 
 func (pms proxyManifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	if pms.hybrid {
+		return pms.localManifests.Delete(ctx, dgst)
+	}
 	return distribution.ErrUnsupported
 }
@@ -140,6 +140,8 @@ func newManifestStoreTestEnv(t *testing.T, name, tag string) *manifestStoreTestE
 			ctx:             ctx,
 			localManifests:  localManifests,
 			remoteManifests: truthManifests,
+			localBlobs:      localRepo.Blobs(ctx),
+			remoteBlobs:     truthRepo.Blobs(ctx),
 			scheduler:       s,
 			repositoryName:  nameRef,
 			authChallenger:  &mockChallenger{},
@@ -291,6 +293,56 @@ func TestProxyManifestsWithoutScheduler(t *testing.T) {
 	}
 }
 
+func TestProxyManifestsPutHybrid(t *testing.T) {
+	name := "foo/bar"
+	env := newManifestStoreTestEnv(t, name, "latest")
+	env.manifests.hybrid = true
+
+	ctx := context.Background()
+	remote, err := env.manifests.remoteManifests.Get(ctx, env.manifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := remote.(*schema2.DeserializedManifest).Config
+
+	// the config blob only exists on the remote
+	m := schema2.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: schema2.MediaTypeManifest,
+		Config:    config,
+	}
+	sm, err := schema2.FromStruct(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.manifests.Put(ctx, sm); err != nil {
+		t.Fatalf("unexpected error pushing manifest: %v", err)
+	}
+
+	// a layer existing neither locally nor on the remote is refused
+	dangling := digest.FromString("dangling")
+	m.Layers = []v1.Descriptor{{MediaType: schema2.MediaTypeLayer, Digest: dangling, Size: 8}}
+	sm, err = schema2.FromStruct(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = env.manifests.Put(ctx, sm)
+	verificationErrs, ok := err.(distribution.ErrManifestVerification)
+	if !ok || len(verificationErrs) != 1 {
+		t.Fatalf("expected a manifest verification error, got %v", err)
+	}
+	if blobErr, ok := verificationErrs[0].(distribution.ErrManifestBlobUnknown); !ok || blobErr.Digest != dangling {
+		t.Fatalf("expected ErrManifestBlobUnknown for %s, got %v", dangling, verificationErrs[0])
+	}
+	_, payload, err := sm.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := env.manifests.localManifests.Exists(ctx, digest.FromBytes(payload)); err != nil || exists {
+		t.Fatalf("manifest with a dangling layer was stored: %v", err)
+	}
+}
+
 func TestProxyManifestsMetrics(t *testing.T) {
 	proxyMetrics = &proxyMetricsCollector{}
 	name := "foo/bar"
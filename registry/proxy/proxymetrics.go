@@ -19,6 +19,8 @@ var (
 	pulledBytes = prometheus.ProxyNamespace.NewLabeledCounter("pulled_bytes", "The size of total bytes pulled from the upstream", "type")
 	// pushedBytes is the size of total bytes pushed to the client for blob/manifest
 	pushedBytes = prometheus.ProxyNamespace.NewLabeledCounter("pushed_bytes", "The size of total bytes pushed to the client", "type")
+	// stale is the number of total proxy request served from the cache while the upstream is unavailable for blob/manifest
+	stale = prometheus.ProxyNamespace.NewLabeledCounter("stale", "The number of total proxy request served from the cache while the upstream is unavailable", "type")
 )
 
 // Metrics is used to hold metric counters
@@ -29,6 +31,7 @@ type Metrics struct {
 	Misses      uint64
 	BytesPulled uint64
 	BytesPushed uint64
+	Stale       uint64
 }
 
 type proxyMetricsCollector struct {
@@ -72,6 +75,7 @@ func initPrometheusMetrics(value string) {
 	misses.WithValues(value).Inc(0)
 	pulledBytes.WithValues(value).Inc(0)
 	pushedBytes.WithValues(value).Inc(0)
+	stale.WithValues(value).Inc(0)
 }
 
 // BlobPull tracks metrics about blobs pulled into the cache
@@ -121,3 +125,19 @@ func (pmc *proxyMetricsCollector) ManifestPush(bytesPushed uint64, isHit bool) {
 		hits.WithValues("manifest").Inc(1)
 	}
 }
+
+// BlobStale tracks metrics about blobs served from the cache while the
+// upstream is unavailable
+func (pmc *proxyMetricsCollector) BlobStale() {
+	atomic.AddUint64(&pmc.blobMetrics.Stale, 1)
+
+	stale.WithValues("blob").Inc(1)
+}
+
+// ManifestStale tracks metrics about manifests served from the cache while
+// the upstream is unavailable
+func (pmc *proxyMetricsCollector) ManifestStale() {
+	atomic.AddUint64(&pmc.manifestMetrics.Stale, 1)
+
+	stale.WithValues("manifest").Inc(1)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,6 +21,7 @@ import (
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/redis/go-redis/v9"
 )
 
 var repositoryTTL = 24 * 7 * time.Hour
@@ -27,112 +29,264 @@ var repositoryTTL = 24 * 7 * time.Hour
 // proxyingRegistry fetches content from a remote registry and caches it locally
 type proxyingRegistry struct {
 	embedded       distribution.Namespace // provides local registry functionality
-	scheduler      *scheduler.TTLExpirationScheduler
+	scheduler      scheduler.Scheduler
 	ttl            *time.Duration
 	remoteURL      url.URL
 	authChallenger authChallenger
 	basicAuth      auth.CredentialStore
-}
-
-// NewRegistryPullThroughCache creates a registry acting as a pull through cache
-func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy) (distribution.Namespace, error) {
-	remoteURL, err := url.Parse(config.RemoteURL)
-	if err != nil {
-		return nil, err
-	}
-
-	v := storage.NewVacuum(ctx, driver)
 
-	var s *scheduler.TTLExpirationScheduler
-	var ttl *time.Duration
-	if config.TTL == nil {
-		// Default TTL is 7 days
-		ttl = &repositoryTTL
-	} else if *config.TTL > 0 {
-		ttl = config.TTL
-	} else {
-		// TTL is disabled, never expire
-		ttl = nil
-	}
+	// prefix is removed from the names of the local repositories to get the
+	// names of the remote repositories.
+	prefix string
 
-	if ttl != nil {
-		s = scheduler.New(ctx, driver, "/scheduler-state.json")
-		s.OnBlobExpire(func(ref reference.Reference) error {
-			var r reference.Canonical
-			var ok bool
-			if r, ok = ref.(reference.Canonical); !ok {
-				return fmt.Errorf("unexpected reference type : %T", ref)
-			}
+	// hybrid serves local content first, and accepts pushes.
+	hybrid bool
 
-			repo, err := registry.Repository(ctx, r)
-			if err != nil {
-				return err
-			}
+	// health tracks the availability of the remote registry, which is
+	// requested through transport.
+	health    *upstreamHealth
+	transport http.RoundTripper
+}
 
-			blobs := repo.Blobs(ctx)
+// Option configures a pull through cache.
+type Option func(*options)
 
-			// Clear the repository reference and descriptor caches
-			err = blobs.Delete(ctx, r.Digest())
-			if err != nil {
-				return err
-			}
+type options struct {
+	redis redis.UniversalClient
+}
 
-			err = v.RemoveBlob(r.Digest().String())
-			if err != nil {
-				return err
-			}
+// WithRedis provides the redis client used by the redis scheduler backend.
+func WithRedis(pool redis.UniversalClient) Option {
+	return func(o *options) {
+		o.redis = pool
+	}
+}
 
-			return nil
-		})
+// NewRegistryPullThroughCache creates a registry acting as a pull through cache.
+// If upstreams are configured, only the repositories matching the prefix of an
+// upstream are pulled through from it, and the other repositories are hosted
+// by registry.
+func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy, opts ...Option) (distribution.Namespace, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
 
-		s.OnManifestExpire(func(ref reference.Reference) error {
-			var r reference.Canonical
-			var ok bool
-			if r, ok = ref.(reference.Canonical); !ok {
-				return fmt.Errorf("unexpected reference type : %T", ref)
-			}
+	if len(config.Upstreams) > 0 {
+		if config.RemoteURL != "" {
+			return nil, fmt.Errorf("proxy remoteurl and upstreams cannot both be configured")
+		}
+		return newUpstreamsRegistry(ctx, registry, driver, config, o)
+	}
 
-			repo, err := registry.Repository(ctx, r)
-			if err != nil {
-				return err
-			}
+	pr, err := newProxyingRegistry(registry, "", configuration.ProxyUpstream{
+		RemoteURL: config.RemoteURL,
+		Username:  config.Username,
+		Password:  config.Password,
+		TTL:       config.TTL,
+		Hybrid:    config.Hybrid,
+	}, config.Health)
+	if err != nil {
+		return nil, err
+	}
 
-			manifests, err := repo.Manifests(ctx)
-			if err != nil {
-				return err
-			}
-			err = manifests.Delete(ctx, r.Digest())
-			if err != nil {
-				return err
-			}
-			return nil
+	if pr.ttl != nil {
+		s, err := newScheduler(ctx, driver, config.Scheduler, o)
+		if err != nil {
+			return nil, err
+		}
+		// unless pushes are accepted, every repository is a cache, so
+		// expired blobs are removed from the storage as well
+		err = startScheduler(ctx, s, registry, driver, !config.Hybrid, func(string) *upstreamHealth {
+			return pr.health
 		})
-
-		err = s.Start()
 		if err != nil {
 			return nil, err
 		}
+		pr.scheduler = s
+	}
+	return pr, nil
+}
+
+// newProxyingRegistry creates a registry pulling the repositories whose name
+// starts with prefix through from upstream. The scheduler expiring the cached
+// content is set by the caller.
+func newProxyingRegistry(registry distribution.Namespace, prefix string, upstream configuration.ProxyUpstream, healthConfig configuration.ProxyHealth) (*proxyingRegistry, error) {
+	remoteURL, err := url.Parse(upstream.RemoteURL)
+	if err != nil {
+		return nil, err
 	}
 
-	cs, b, err := configureAuth(config.Username, config.Password, config.RemoteURL)
+	cs, b, err := configureAuth(upstream.Username, upstream.Password, upstream.RemoteURL)
 	if err != nil {
 		return nil, err
 	}
 
+	h := newUpstreamHealth(*remoteURL, healthConfig)
+	tr := &healthTransport{base: http.DefaultTransport, health: h}
+
 	return &proxyingRegistry{
 		embedded:  registry,
-		scheduler: s,
-		ttl:       ttl,
+		ttl:       cacheTTL(upstream.TTL),
 		remoteURL: *remoteURL,
 		authChallenger: &remoteAuthChallenger{
 			remoteURL: *remoteURL,
 			cm:        challenge.NewSimpleManager(),
 			cs:        cs,
+			transport: tr,
 		},
 		basicAuth: b,
+		prefix:    prefix,
+		hybrid:    upstream.Hybrid,
+		health:    h,
+		transport: tr,
 	}, nil
 }
 
+// cacheTTL returns the expiry time of cached content, or nil if cached
+// content never expires.
+func cacheTTL(ttl *time.Duration) *time.Duration {
+	if ttl == nil {
+		// Default TTL is 7 days
+		return &repositoryTTL
+	} else if *ttl > 0 {
+		return ttl
+	}
+	// TTL is disabled, never expire
+	return nil
+}
+
+// newScheduler returns the scheduler expiring the cached content, keeping its
+// entries in the configured backend.
+func newScheduler(ctx context.Context, driver driver.StorageDriver, config configuration.ProxyScheduler, o options) (scheduler.Scheduler, error) {
+	switch config.Backend {
+	case "", "storage":
+		return scheduler.New(ctx, driver, "/scheduler-state.json"), nil
+	case "redis":
+		if o.redis == nil {
+			return nil, fmt.Errorf("redis configuration required to use redis proxy scheduler backend")
+		}
+		return scheduler.NewRedis(ctx, o.redis, config.KeyPrefix, config.Interval), nil
+	default:
+		return nil, fmt.Errorf("unknown proxy scheduler backend %q", config.Backend)
+	}
+}
+
+// startScheduler starts the scheduler s expiring the content cached in
+// registry. Expired blobs are only removed from the storage if removeBlobs is
+// set, otherwise they are unlinked from their repository and left to the
+// garbage collection, as hosted repositories may share them. healthOf returns
+// the health of the remote registry the named repository is pulled through
+// from, if any, so that stale content is kept while it is unavailable.
+func startScheduler(ctx context.Context, s scheduler.Scheduler, registry distribution.Namespace, driver driver.StorageDriver, removeBlobs bool, healthOf func(name string) *upstreamHealth) error {
+	v := storage.NewVacuum(ctx, driver)
+
+	postpone := func(r reference.Named) error {
+		if h := healthOf(r.Name()); h != nil {
+			if delay := h.staleDelay(ctx); delay > 0 {
+				return scheduler.ErrPostponed{Delay: delay}
+			}
+		}
+		return nil
+	}
+
+	s.OnBlobExpire(func(ref reference.Reference) error {
+		var r reference.Canonical
+		var ok bool
+		if r, ok = ref.(reference.Canonical); !ok {
+			return fmt.Errorf("unexpected reference type : %T", ref)
+		}
+		if err := postpone(r); err != nil {
+			return err
+		}
+
+		repo, err := registry.Repository(ctx, r)
+		if err != nil {
+			return err
+		}
+
+		blobs := repo.Blobs(ctx)
+
+		// Clear the repository reference and descriptor caches
+		err = blobs.Delete(ctx, r.Digest())
+		if err != nil {
+			return err
+		}
+
+		if !removeBlobs {
+			return nil
+		}
+
+		err = v.RemoveBlob(r.Digest().String())
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+
+	s.OnManifestExpire(func(ref reference.Reference) error {
+		var r reference.Canonical
+		var ok bool
+		if r, ok = ref.(reference.Canonical); !ok {
+			return fmt.Errorf("unexpected reference type : %T", ref)
+		}
+		if err := postpone(r); err != nil {
+			return err
+		}
+
+		repo, err := registry.Repository(ctx, r)
+		if err != nil {
+			return err
+		}
+
+		manifests, err := repo.Manifests(ctx)
+		if err != nil {
+			return err
+		}
+		err = manifests.Delete(ctx, r.Digest())
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+
+	s.OnTagExpire(func(ref reference.Reference) error {
+		r, ok := ref.(reference.Canonical)
+		if !ok {
+			return fmt.Errorf("unexpected reference type : %T", ref)
+		}
+		tagged, ok := ref.(reference.Tagged)
+		if !ok {
+			return fmt.Errorf("unexpected reference type : %T", ref)
+		}
+		if err := postpone(r); err != nil {
+			return err
+		}
+
+		repo, err := registry.Repository(ctx, r)
+		if err != nil {
+			return err
+		}
+
+		tags := repo.Tags(ctx)
+		desc, err := tags.Get(ctx, tagged.Tag())
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				return nil
+			}
+			return err
+		}
+		if desc.Digest != r.Digest() {
+			// the tag was pushed or pulled through again since
+			return nil
+		}
+		return tags.Untag(ctx, tagged.Tag())
+	})
+
+	return s.Start()
+}
+
 func (pr *proxyingRegistry) Scope() distribution.Scope {
 	return distribution.GlobalScope
 }
@@ -141,22 +295,47 @@ func (pr *proxyingRegistry) Repositories(ctx context.Context, repos []string, la
 	return pr.embedded.Repositories(ctx, repos, last)
 }
 
+func (pr *proxyingRegistry) RepositoriesWithPrefix(ctx context.Context, repos []string, prefix, last string) (n int, err error) {
+	prefixEnumerator, ok := pr.embedded.(distribution.RepositoryPrefixEnumerator)
+	if !ok {
+		return 0, distribution.ErrUnsupported
+	}
+	return prefixEnumerator.RepositoriesWithPrefix(ctx, repos, prefix, last)
+}
+
+func (pr *proxyingRegistry) EnumeratePrefix(ctx context.Context, prefix string, ingester func(string) error) error {
+	prefixEnumerator, ok := pr.embedded.(distribution.RepositoryPrefixEnumerator)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+	return prefixEnumerator.EnumeratePrefix(ctx, prefix, ingester)
+}
+
 func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
 	c := pr.authChallenger
 
+	remoteName := name
+	if pr.prefix != "" {
+		var err error
+		remoteName, err = reference.WithName(strings.TrimPrefix(name.Name(), pr.prefix+"/"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	tkopts := auth.TokenHandlerOptions{
-		Transport:   http.DefaultTransport,
+		Transport:   pr.transport,
 		Credentials: c.credentialStore(),
 		Scopes: []auth.Scope{
 			auth.RepositoryScope{
-				Repository: name.Name(),
+				Repository: remoteName.Name(),
 				Actions:    []string{"pull"},
 			},
 		},
 		Logger: dcontext.GetLogger(ctx),
 	}
 
-	tr := transport.NewTransport(http.DefaultTransport,
+	tr := transport.NewTransport(pr.transport,
 		auth.NewAuthorizer(c.challengeManager(),
 			auth.NewTokenHandlerWithOptions(tkopts),
 			auth.NewBasicHandler(pr.basicAuth)))
@@ -170,7 +349,7 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		return nil, err
 	}
 
-	remoteRepo, err := client.NewRepository(name, pr.remoteURL.String(), tr)
+	remoteRepo, err := client.NewRepository(remoteName, pr.remoteURL.String(), tr)
 	if err != nil {
 		return nil, err
 	}
@@ -188,21 +367,31 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 			ttl:            pr.ttl,
 			repositoryName: name,
 			authChallenger: pr.authChallenger,
+			hybrid:         pr.hybrid,
+			health:         pr.health,
 		},
 		manifests: &proxyManifestStore{
 			repositoryName:  name,
 			localManifests:  localManifests, // Options?
 			remoteManifests: remoteManifests,
+			localBlobs:      localRepo.Blobs(ctx),
+			remoteBlobs:     remoteRepo.Blobs(ctx),
 			ctx:             ctx,
 			scheduler:       pr.scheduler,
 			ttl:             pr.ttl,
 			authChallenger:  pr.authChallenger,
+			hybrid:          pr.hybrid,
+			health:          pr.health,
 		},
 		name: name,
 		tags: &proxyTagService{
 			localTags:      localRepo.Tags(ctx),
 			remoteTags:     remoteRepo.Tags(ctx),
 			authChallenger: pr.authChallenger,
+			hybrid:         pr.hybrid,
+			repositoryName: name,
+			scheduler:      pr.scheduler,
+			ttl:            pr.ttl,
 		},
 	}, nil
 }
@@ -221,9 +410,31 @@ type Closer interface {
 }
 
 func (pr *proxyingRegistry) Close() error {
+	if pr.scheduler == nil {
+		return nil
+	}
 	return pr.scheduler.Stop()
 }
 
+// UpstreamChecker reports the availability of the remote registries content
+// is pulled through from.
+type UpstreamChecker interface {
+	// CheckUpstreams returns an error if a remote registry is unavailable.
+	CheckUpstreams(ctx context.Context) error
+
+	// PollUpstreams probes the remote registries at interval until ctx is
+	// done, so that their recovery is noticed without pulling content.
+	PollUpstreams(ctx context.Context, interval time.Duration)
+}
+
+func (pr *proxyingRegistry) CheckUpstreams(ctx context.Context) error {
+	return pr.health.Check(ctx)
+}
+
+func (pr *proxyingRegistry) PollUpstreams(ctx context.Context, interval time.Duration) {
+	pr.health.poll(ctx, http.DefaultTransport, interval)
+}
+
 // authChallenger encapsulates a request to the upstream to establish credential challenges
 type authChallenger interface {
 	tryEstablishChallenges(context.Context) error
@@ -234,8 +445,9 @@ type authChallenger interface {
 type remoteAuthChallenger struct {
 	remoteURL url.URL
 	sync.Mutex
-	cm challenge.Manager
-	cs auth.CredentialStore
+	cm        challenge.Manager
+	cs        auth.CredentialStore
+	transport http.RoundTripper
 }
 
 func (r *remoteAuthChallenger) credentialStore() auth.CredentialStore {
@@ -263,7 +475,7 @@ func (r *remoteAuthChallenger) tryEstablishChallenges(ctx context.Context) error
 	}
 
 	// establish challenge type with upstream
-	if err := ping(r.cm, remoteURL.String(), challengeHeader); err != nil {
+	if err := ping(&http.Client{Transport: r.transport}, r.cm, remoteURL.String(), challengeHeader); err != nil {
 		return err
 	}
 
@@ -2,8 +2,13 @@ package proxy
 
 import (
 	"context"
+	"sort"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/reference"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -12,14 +17,29 @@ type proxyTagService struct {
 	localTags      distribution.TagService
 	remoteTags     distribution.TagService
 	authChallenger authChallenger
+
+	// hybrid serves local tags first, and accepts tags pushed locally.
+	// Tags pulled through from the remote are scheduled for expiry, so
+	// that they are refreshed, while pushed tags never expire.
+	hybrid         bool
+	repositoryName reference.Named
+	scheduler      scheduler.Scheduler
+	ttl            *time.Duration
 }
 
-var _ distribution.TagService = proxyTagService{}
+var (
+	_ distribution.TagService = proxyTagService{}
+	_ distribution.TagLister  = proxyTagService{}
+)
 
 // Get attempts to get the most recent digest for the tag by checking the remote
 // tag service first and then caching it locally.  If the remote is unavailable
 // the local association is returned
 func (pt proxyTagService) Get(ctx context.Context, tag string) (v1.Descriptor, error) {
+	if pt.hybrid {
+		return pt.getHybrid(ctx, tag)
+	}
+
 	err := pt.authChallenger.tryEstablishChallenges(ctx)
 	if err == nil {
 		desc, err := pt.remoteTags.Get(ctx, tag)
@@ -39,8 +59,75 @@ func (pt proxyTagService) Get(ctx context.Context, tag string) (v1.Descriptor, e
 	return desc, nil
 }
 
+// getHybrid returns the local tag if any, and otherwise pulls the tag through
+// from the remote and schedules its expiry.
+func (pt proxyTagService) getHybrid(ctx context.Context, tag string) (v1.Descriptor, error) {
+	desc, err := pt.localTags.Get(ctx, tag)
+	if err == nil {
+		return desc, nil
+	}
+	if _, ok := err.(distribution.ErrTagUnknown); !ok {
+		return v1.Descriptor{}, err
+	}
+
+	if err := pt.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return v1.Descriptor{}, err
+	}
+	desc, err = pt.remoteTags.Get(ctx, tag)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	if err := pt.localTags.Tag(ctx, tag, desc); err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	if pt.scheduler != nil && pt.ttl != nil {
+		tagged, err := reference.WithTag(pt.repositoryName, tag)
+		if err != nil {
+			return v1.Descriptor{}, err
+		}
+		tagRef, err := reference.WithDigest(tagged, desc.Digest)
+		if err != nil {
+			return v1.Descriptor{}, err
+		}
+		if err := pt.scheduler.AddTag(tagRef, *pt.ttl); err != nil {
+			dcontext.GetLogger(ctx).Errorf("Error adding tag: %s", err)
+			return v1.Descriptor{}, err
+		}
+	}
+	return desc, nil
+}
+
+// Tag tags the manifest locally when the registry is hybrid. A pushed tag
+// never expires, so the expiry of the tag scheduled when it was pulled
+// through, if any, is cancelled first.
 func (pt proxyTagService) Tag(ctx context.Context, tag string, desc v1.Descriptor) error {
-	return distribution.ErrUnsupported
+	if !pt.hybrid {
+		return distribution.ErrUnsupported
+	}
+
+	if pt.scheduler != nil {
+		prev, err := pt.localTags.Get(ctx, tag)
+		switch err.(type) {
+		case nil:
+			tagged, err := reference.WithTag(pt.repositoryName, tag)
+			if err != nil {
+				return err
+			}
+			tagRef, err := reference.WithDigest(tagged, prev.Digest)
+			if err != nil {
+				return err
+			}
+			if err := pt.scheduler.RemoveTag(tagRef); err != nil {
+				dcontext.GetLogger(ctx).Errorf("Error removing tag: %s", err)
+				return err
+			}
+		case distribution.ErrTagUnknown, distribution.ErrRepositoryUnknown:
+		default:
+			return err
+		}
+	}
+	return pt.localTags.Tag(ctx, tag, desc)
 }
 
 func (pt proxyTagService) Untag(ctx context.Context, tag string) error {
@@ -52,6 +139,10 @@ func (pt proxyTagService) Untag(ctx context.Context, tag string) error {
 }
 
 func (pt proxyTagService) All(ctx context.Context) ([]string, error) {
+	if pt.hybrid {
+		return pt.allHybrid(ctx)
+	}
+
 	err := pt.authChallenger.tryEstablishChallenges(ctx)
 	if err == nil {
 		tags, err := pt.remoteTags.All(ctx)
@@ -62,6 +153,72 @@ func (pt proxyTagService) All(ctx context.Context) ([]string, error) {
 	return pt.localTags.All(ctx)
 }
 
+// allHybrid returns the local tags along with the remote ones, if the remote
+// is available.
+func (pt proxyTagService) allHybrid(ctx context.Context) ([]string, error) {
+	local, localErr := pt.localTags.All(ctx)
+	if localErr != nil {
+		if _, ok := localErr.(distribution.ErrRepositoryUnknown); !ok {
+			return nil, localErr
+		}
+	}
+
+	var remote []string
+	if err := pt.authChallenger.tryEstablishChallenges(ctx); err == nil {
+		remote, err = pt.remoteTags.All(ctx)
+		if err != nil {
+			dcontext.GetLogger(ctx).Warnf("Error listing remote tags: %s", err)
+		}
+	}
+	if len(local) == 0 && len(remote) == 0 && localErr != nil {
+		return nil, localErr
+	}
+
+	seen := make(map[string]struct{}, len(local)+len(remote))
+	tags := make([]string, 0, len(local)+len(remote))
+	for _, tag := range append(local, remote...) {
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// List returns up to n tags following last, from the remote if it is
+// available. Local and remote tags are merged when the registry is hybrid.
+func (pt proxyTagService) List(ctx context.Context, last string, n int) ([]string, error) {
+	if pt.hybrid {
+		tags, err := pt.allHybrid(ctx)
+		if err != nil {
+			return nil, err
+		}
+		i := sort.SearchStrings(tags, last)
+		if i < len(tags) && tags[i] == last {
+			i++
+		}
+		tags = tags[i:]
+		if n > 0 && n < len(tags) {
+			tags = tags[:n]
+		}
+		return tags, nil
+	}
+
+	err := pt.authChallenger.tryEstablishChallenges(ctx)
+	if err == nil {
+		tags, err := distribution.ListTags(ctx, pt.remoteTags, last, n)
+		if err == nil {
+			return tags, err
+		}
+	}
+	return distribution.ListTags(ctx, pt.localTags, last, n)
+}
+
 func (pt proxyTagService) Lookup(ctx context.Context, digest v1.Descriptor) ([]string, error) {
+	if pt.hybrid {
+		return pt.localTags.Lookup(ctx, digest)
+	}
 	return []string{}, distribution.ErrUnsupported
 }
@@ -6,8 +6,13 @@ import (
 	"sort"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -176,3 +181,139 @@ func TestGet(t *testing.T) {
 		t.Fatalf("Expected 4 auth challenge calls, got %#v", proxyTags.authChallenger)
 	}
 }
+
+func TestGetHybrid(t *testing.T) {
+	ctx := context.Background()
+	remoteDesc := v1.Descriptor{Digest: digest.FromString("remote"), Size: 42}
+	pushedDesc := v1.Descriptor{Digest: digest.FromString("pushed"), Size: 43}
+
+	s := scheduler.New(ctx, inmemory.New(), "/scheduler-state.json")
+	expired := make(chan reference.Reference, 1)
+	s.OnTagExpire(func(ref reference.Reference) error {
+		expired <- ref
+		return nil
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("error starting scheduler: %v", err)
+	}
+	defer s.Stop()
+
+	ttl := 10 * time.Millisecond
+	proxyTags := testProxyTagService(nil, map[string]v1.Descriptor{"latest": remoteDesc, "dev": remoteDesc})
+	proxyTags.hybrid = true
+	proxyTags.repositoryName, _ = reference.WithName("foo/bar")
+	proxyTags.scheduler = s
+	proxyTags.ttl = &ttl
+
+	// pushed tags are served locally, and shadow the remote ones
+	if err := proxyTags.Tag(ctx, "dev", pushedDesc); err != nil {
+		t.Fatalf("error pushing tag: %v", err)
+	}
+	d, err := proxyTags.Get(ctx, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, pushedDesc) {
+		t.Fatalf("unexpected descriptor for pushed tag: %v", d)
+	}
+	if proxyTags.authChallenger.(*mockChallenger).count != 0 {
+		t.Fatalf("Expected no auth challenge call, got %#v", proxyTags.authChallenger)
+	}
+
+	// missing tags are pulled through and scheduled for expiry
+	d, err = proxyTags.Get(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, remoteDesc) {
+		t.Fatalf("unexpected descriptor for pulled through tag: %v", d)
+	}
+	if _, err := proxyTags.localTags.Get(ctx, "latest"); err != nil {
+		t.Fatal("remote tag not pulled into store")
+	}
+
+	select {
+	case ref := <-expired:
+		if tagged, ok := ref.(reference.Tagged); !ok || tagged.Tag() != "latest" {
+			t.Fatalf("unexpected tag expired: %s", ref)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pulled through tag was not scheduled for expiry")
+	}
+
+	all, err := proxyTags.All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(all, []string{"dev", "latest"}) {
+		t.Fatalf("Unexpected tags returned from All() : %v ", all)
+	}
+}
+
+func TestGetHybridPushAfterPull(t *testing.T) {
+	ctx := context.Background()
+	remoteDesc := v1.Descriptor{Digest: digest.FromString("remote"), Size: 42}
+
+	s := scheduler.New(ctx, inmemory.New(), "/scheduler-state.json")
+	expired := make(chan reference.Reference, 1)
+	s.OnTagExpire(func(ref reference.Reference) error {
+		expired <- ref
+		return nil
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("error starting scheduler: %v", err)
+	}
+	defer s.Stop()
+
+	ttl := 50 * time.Millisecond
+	proxyTags := testProxyTagService(nil, map[string]v1.Descriptor{"latest": remoteDesc})
+	proxyTags.hybrid = true
+	proxyTags.repositoryName, _ = reference.WithName("foo/bar")
+	proxyTags.scheduler = s
+	proxyTags.ttl = &ttl
+
+	if _, err := proxyTags.Get(ctx, "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	// pushing the pulled through tag, even with the same digest, makes it
+	// local, so that it no longer expires
+	if err := proxyTags.Tag(ctx, "latest", remoteDesc); err != nil {
+		t.Fatalf("error pushing tag: %v", err)
+	}
+
+	select {
+	case ref := <-expired:
+		t.Fatalf("pushed tag was expired: %s", ref)
+	case <-time.After(4 * ttl):
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	desc := v1.Descriptor{Digest: digest.FromString("desc"), Size: 42}
+	local := map[string]v1.Descriptor{"pushed": desc}
+	remote := map[string]v1.Descriptor{"a": desc, "b": desc, "c": desc}
+
+	proxyTags := testProxyTagService(local, remote)
+	tags, err := proxyTags.List(ctx, "a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"b"}) {
+		t.Fatalf("unexpected tags listed from the remote: %v", tags)
+	}
+	if proxyTags.authChallenger.(*mockChallenger).count != 1 {
+		t.Fatalf("Expected 1 auth challenge call, got %#v", proxyTags.authChallenger)
+	}
+
+	// hybrid registries merge local and remote tags
+	proxyTags.hybrid = true
+	tags, err = proxyTags.List(ctx, "b", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"c", "pushed"}) {
+		t.Fatalf("unexpected tags listed from a hybrid registry: %v", tags)
+	}
+}
@@ -0,0 +1,410 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/reference"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisEntriesKey is a sorted set of the scheduled entries, scored by
+	// their expiry time in milliseconds. It is prefixed with the key prefix
+	// of the scheduler.
+	redisEntriesKey = "scheduler::entries"
+	// redisLeaderKey holds the identifier of the instance expiring the
+	// entries, for the duration of its lease. It is prefixed with the key
+	// prefix of the scheduler.
+	redisLeaderKey = "scheduler::leader"
+
+	defaultRedisInterval = 5 * time.Second
+	redisBatchSize       = 100
+)
+
+// renewLease extends the lease of the leader if it is still held by the
+// instance.
+var renewLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLease gives the leadership up if it is still held by the instance.
+var releaseLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// removeEntry removes an expired entry, unless it was scheduled again since
+// it expired.
+var removeEntry = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if score and tonumber(score) == tonumber(ARGV[2]) then
+	return redis.call("ZREM", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// postponeEntry schedules an expired entry to expire again, unless it was
+// scheduled again since it expired.
+var postponeEntry = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if score and tonumber(score) == tonumber(ARGV[2]) then
+	return redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+end
+return 0
+`)
+
+// RedisScheduler is a scheduler keeping its entries in redis, so that they
+// are shared by the registry instances using the same redis. The instances
+// elect a leader holding a lease in redis, and only the leader calls the
+// expiry functions, checking for expired entries at a regular interval.
+type RedisScheduler struct {
+	sync.Mutex
+
+	ctx        context.Context
+	pool       redis.UniversalClient
+	entriesKey string
+	leaderKey  string
+	id         string
+	interval   time.Duration
+	lease      time.Duration
+
+	stopped  bool
+	leader   bool
+	doneChan chan struct{}
+	loopDone chan struct{}
+
+	callbacks
+}
+
+// NewRedis returns a scheduler keeping its entries in redis under keys
+// starting with keyPrefix, which checks for expired entries at interval, every
+// 5 seconds if interval is not positive. Schedulers sharing a redis only share
+// their entries and leadership if they use the same key prefix.
+func NewRedis(ctx context.Context, pool redis.UniversalClient, keyPrefix string, interval time.Duration) *RedisScheduler {
+	if interval <= 0 {
+		interval = defaultRedisInterval
+	}
+	return &RedisScheduler{
+		ctx:        ctx,
+		pool:       pool,
+		entriesKey: keyPrefix + redisEntriesKey,
+		leaderKey:  keyPrefix + redisLeaderKey,
+		id:         uuid.NewString(),
+		interval:   interval,
+		lease:      3 * interval,
+		stopped:    true,
+	}
+}
+
+// OnBlobExpire is called when a scheduled blob's TTL expires
+func (rs *RedisScheduler) OnBlobExpire(f expiryFunc) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	rs.onBlobExpire = f
+}
+
+// OnManifestExpire is called when a scheduled manifest's TTL expires
+func (rs *RedisScheduler) OnManifestExpire(f expiryFunc) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	rs.onManifestExpire = f
+}
+
+// OnTagExpire is called when a scheduled tag's TTL expires
+func (rs *RedisScheduler) OnTagExpire(f expiryFunc) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	rs.onTagExpire = f
+}
+
+// AddBlob schedules a blob cleanup after ttl expires
+func (rs *RedisScheduler) AddBlob(blobRef reference.Canonical, ttl time.Duration) error {
+	return rs.add(blobRef, ttl, entryTypeBlob)
+}
+
+// AddManifest schedules a manifest cleanup after ttl expires
+func (rs *RedisScheduler) AddManifest(manifestRef reference.Canonical, ttl time.Duration) error {
+	return rs.add(manifestRef, ttl, entryTypeManifest)
+}
+
+// AddTag schedules a tag cleanup after ttl expires. tagRef must be tagged, its
+// digest is the one the tag referenced when it was scheduled.
+func (rs *RedisScheduler) AddTag(tagRef reference.Canonical, ttl time.Duration) error {
+	if _, ok := tagRef.(reference.Tagged); !ok {
+		return fmt.Errorf("reference %s is not tagged", tagRef)
+	}
+	return rs.add(tagRef, ttl, entryTypeTag)
+}
+
+// RemoveTag cancels the scheduled cleanup of a tag, if any. tagRef must be
+// tagged with the digest the tag was scheduled with.
+func (rs *RedisScheduler) RemoveTag(tagRef reference.Canonical) error {
+	rs.Lock()
+	stopped := rs.stopped
+	rs.Unlock()
+	if stopped {
+		return fmt.Errorf("scheduler not started")
+	}
+
+	dcontext.GetLogger(rs.ctx).Infof("Removing scheduler entry for %s", tagRef.String())
+	return rs.pool.ZRem(rs.ctx, rs.entriesKey, redisMember(entryTypeTag, tagRef.String())).Err()
+}
+
+func (rs *RedisScheduler) add(r reference.Reference, ttl time.Duration, eType int) error {
+	rs.Lock()
+	stopped := rs.stopped
+	rs.Unlock()
+	if stopped {
+		return fmt.Errorf("scheduler not started")
+	}
+
+	dcontext.GetLogger(rs.ctx).Infof("Adding new scheduler entry for %s with ttl=%s", r.String(), ttl)
+	return rs.pool.ZAdd(rs.ctx, rs.entriesKey, redis.Z{
+		Score:  float64(time.Now().Add(ttl).UnixMilli()),
+		Member: redisMember(eType, r.String()),
+	}).Err()
+}
+
+// Start starts the scheduler
+func (rs *RedisScheduler) Start() error {
+	rs.Lock()
+	defer rs.Unlock()
+
+	if !rs.stopped {
+		return fmt.Errorf("scheduler already started")
+	}
+	if err := rs.pool.Ping(rs.ctx).Err(); err != nil {
+		return fmt.Errorf("error connecting to redis: %w", err)
+	}
+
+	dcontext.GetLogger(rs.ctx).Infof("Starting cached object TTL expiration scheduler backed by redis...")
+	rs.stopped = false
+	rs.doneChan = make(chan struct{})
+	rs.loopDone = make(chan struct{})
+	go rs.run(rs.doneChan, rs.loopDone)
+	return nil
+}
+
+// Stop stops the scheduler, giving the leadership up if held.
+func (rs *RedisScheduler) Stop() error {
+	rs.Lock()
+	if rs.stopped {
+		rs.Unlock()
+		return nil
+	}
+	rs.stopped = true
+	close(rs.doneChan)
+	loopDone := rs.loopDone
+	rs.Unlock()
+
+	<-loopDone
+	if err := releaseLease.Run(context.Background(), rs.pool, []string{rs.leaderKey}, rs.id).Err(); err != nil {
+		return fmt.Errorf("error releasing scheduler leadership: %w", err)
+	}
+	return nil
+}
+
+// run expires the entries at interval while the instance is the leader,
+// until done is closed.
+func (rs *RedisScheduler) run(done <-chan struct{}, loopDone chan<- struct{}) {
+	defer close(loopDone)
+
+	ticker := time.NewTicker(rs.interval)
+	defer ticker.Stop()
+	for {
+		if rs.elect() {
+			rs.expire(done)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// elect acquires or renews the lease of the leader, and reports whether the
+// instance is the leader.
+func (rs *RedisScheduler) elect() bool {
+	leader, err := rs.pool.SetNX(rs.ctx, rs.leaderKey, rs.id, rs.lease).Result()
+	if err == nil && !leader {
+		var renewed int64
+		renewed, err = renewLease.Run(rs.ctx, rs.pool, []string{rs.leaderKey}, rs.id, rs.lease.Milliseconds()).Int64()
+		leader = renewed == 1
+	}
+	if err != nil {
+		dcontext.GetLogger(rs.ctx).Errorf("Error electing scheduler leader: %s", err)
+		leader = false
+	}
+
+	if leader != rs.leader {
+		if leader {
+			dcontext.GetLogger(rs.ctx).Infof("Elected scheduler leader, expiring cached objects")
+		} else {
+			dcontext.GetLogger(rs.ctx).Infof("Lost scheduler leadership")
+		}
+		rs.leader = leader
+	}
+	return leader
+}
+
+// expire calls the expiry functions of the expired entries, in batches as
+// long as the instance remains the leader. The lease is renewed while the
+// expiry functions run, as they may take longer than the lease, and the
+// expiry stops before the next entry once the lease is lost.
+func (rs *RedisScheduler) expire(done <-chan struct{}) {
+	ctx, cancel := context.WithCancel(rs.ctx)
+	defer cancel()
+	go rs.keepLease(ctx, cancel)
+
+	for {
+		entries, err := rs.pool.ZRangeByScoreWithScores(ctx, rs.entriesKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+			Count: redisBatchSize,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				dcontext.GetLogger(rs.ctx).Errorf("Error reading expired scheduler entries: %s", err)
+			}
+			return
+		}
+
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				return
+			}
+			member, ok := entry.Member.(string)
+			if !ok {
+				continue
+			}
+			rs.expireEntry(member, int64(entry.Score))
+		}
+
+		if len(entries) < redisBatchSize {
+			return
+		}
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+	}
+}
+
+// keepLease renews the lease of the leader at interval until ctx is done,
+// calling lost once the lease may have expired without being renewed.
+func (rs *RedisScheduler) keepLease(ctx context.Context, lost context.CancelFunc) {
+	ticker := time.NewTicker(rs.interval)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		held, err := renewLease.Run(ctx, rs.pool, []string{rs.leaderKey}, rs.id, rs.lease.Milliseconds()).Int64()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && held == 1 {
+			renewed = time.Now()
+			continue
+		}
+		if err != nil {
+			dcontext.GetLogger(rs.ctx).Errorf("Error renewing scheduler leadership: %s", err)
+			// the lease is still held until it expires
+			if time.Since(renewed)+rs.interval < rs.lease {
+				continue
+			}
+		}
+		dcontext.GetLogger(rs.ctx).Infof("Lost scheduler leadership while expiring cached objects")
+		lost()
+		return
+	}
+}
+
+// expireEntry calls the expiry function of an entry which expired at expiry,
+// and removes it unless the expiry is postponed.
+func (rs *RedisScheduler) expireEntry(member string, expiry int64) {
+	score, err := rs.pool.ZScore(rs.ctx, rs.entriesKey, member).Result()
+	if errors.Is(err, redis.Nil) || (err == nil && int64(score) != expiry) {
+		// the entry was removed or scheduled again since it was read
+		return
+	}
+	if err != nil {
+		dcontext.GetLogger(rs.ctx).Errorf("Error reading scheduler entry %s: %s", member, err)
+		return
+	}
+
+	eType, key, err := parseRedisMember(member)
+	if err == nil {
+		var ref reference.Reference
+		ref, err = reference.Parse(key)
+		if err == nil {
+			rs.Lock()
+			f := rs.expiryFunc(eType)
+			rs.Unlock()
+
+			err = f(ref)
+		}
+	}
+	if err != nil {
+		var postponed ErrPostponed
+		if errors.As(err, &postponed) {
+			dcontext.GetLogger(rs.ctx).Infof("Postponing expiry of scheduler entry %s by %s", key, postponed.Delay)
+			next := time.Now().Add(postponed.Delay).UnixMilli()
+			if err := postponeEntry.Run(rs.ctx, rs.pool, []string{rs.entriesKey}, member, expiry, next).Err(); err != nil {
+				dcontext.GetLogger(rs.ctx).Errorf("Error postponing scheduler entry %s: %s", key, err)
+			}
+			return
+		}
+		dcontext.GetLogger(rs.ctx).Errorf("Scheduler error returned from OnExpire(%s): %s", member, err)
+	}
+
+	if err := removeEntry.Run(rs.ctx, rs.pool, []string{rs.entriesKey}, member, expiry).Err(); err != nil {
+		dcontext.GetLogger(rs.ctx).Errorf("Error removing scheduler entry %s: %s", member, err)
+	}
+}
+
+// redisMember returns the member of the sorted set of entries for the entry
+// of the given type and key.
+func redisMember(eType int, key string) string {
+	return strconv.Itoa(eType) + "|" + key
+}
+
+// parseRedisMember returns the type and the key of the entry of a member of
+// the sorted set of entries.
+func parseRedisMember(member string) (int, string, error) {
+	t, key, ok := strings.Cut(member, "|")
+	if !ok {
+		return 0, "", fmt.Errorf("invalid scheduler entry %q", member)
+	}
+	eType, err := strconv.Atoi(t)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid scheduler entry %q: %v", member, err)
+	}
+	return eType, key, nil
+}
@@ -0,0 +1,239 @@
+package scheduler
+
+import (
+	"context"
+	"flag"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/reference"
+	"github.com/redis/go-redis/v9"
+)
+
+var redisAddr string
+
+func init() {
+	flag.StringVar(&redisAddr, "test.registry.proxy.scheduler.redis.addr", "", "configure the address of a test instance of redis")
+}
+
+func TestRedisMember(t *testing.T) {
+	key := "testrepo:latest@sha256:aaaaeaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	eType, parsed, err := parseRedisMember(redisMember(entryTypeTag, key))
+	if err != nil {
+		t.Fatalf("error parsing member: %v", err)
+	}
+	if eType != entryTypeTag || parsed != key {
+		t.Fatalf("unexpected entry: %d %s", eType, parsed)
+	}
+
+	for _, member := range []string{"testrepo", "blob|testrepo"} {
+		if _, _, err := parseRedisMember(member); err == nil {
+			t.Fatalf("expected error parsing %q", member)
+		}
+	}
+}
+
+// testRedisPool returns a client of the test instance of redis, whose
+// database is flushed, skipping the test if none is configured.
+func testRedisPool(t *testing.T) *redis.Client {
+	t.Helper()
+	if redisAddr == "" {
+		// fallback to an environment variable
+		redisAddr = os.Getenv("TEST_REGISTRY_PROXY_SCHEDULER_REDIS_ADDR")
+	}
+
+	if redisAddr == "" {
+		// skip if still not set
+		t.Skip("please set -test.registry.proxy.scheduler.redis.addr to test the scheduler against redis")
+	}
+
+	pool := redis.NewClient(&redis.Options{
+		Addr:     redisAddr,
+		PoolSize: 4,
+	})
+	t.Cleanup(func() { pool.Close() })
+
+	// Clear the database
+	if err := pool.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+	return pool
+}
+
+// TestRedisScheduler exercises a live redis instance shared by two
+// schedulers, only one of which expires the entries.
+func TestRedisScheduler(t *testing.T) {
+	pool := testRedisPool(t)
+	ctx := context.Background()
+
+	ref1, ref2, ref3 := testRefs(t)
+	var mu sync.Mutex
+	expired := make(map[string]int)
+	onExpire := func(ref reference.Reference) error {
+		mu.Lock()
+		defer mu.Unlock()
+		expired[ref.String()]++
+		return nil
+	}
+
+	var schedulers []*RedisScheduler
+	for i := 0; i < 2; i++ {
+		s := NewRedis(dcontext.Background(), pool, "test::", 10*time.Millisecond)
+		s.OnBlobExpire(onExpire)
+		s.OnManifestExpire(onExpire)
+		if err := s.Start(); err != nil {
+			t.Fatalf("Error starting scheduler: %s", err)
+		}
+		schedulers = append(schedulers, s)
+	}
+
+	if err := schedulers[0].AddBlob(ref1.(reference.Canonical), 10*time.Millisecond); err != nil {
+		t.Fatalf("Error scheduling blob: %s", err)
+	}
+	if err := schedulers[1].AddManifest(ref2.(reference.Canonical), 20*time.Millisecond); err != nil {
+		t.Fatalf("Error scheduling manifest: %s", err)
+	}
+	if err := schedulers[1].AddBlob(ref3.(reference.Canonical), time.Hour); err != nil {
+		t.Fatalf("Error scheduling blob: %s", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	for _, s := range schedulers {
+		if err := s.Stop(); err != nil {
+			t.Fatalf("Error stopping scheduler: %s", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 2 || expired[ref1.String()] != 1 || expired[ref2.String()] != 1 {
+		t.Fatalf("unexpected expired entries: %v", expired)
+	}
+
+	remaining, err := pool.ZCard(ctx, "test::"+redisEntriesKey).Result()
+	if err != nil {
+		t.Fatalf("error counting entries: %v", err)
+	}
+	if remaining != 1 {
+		t.Fatalf("expected 1 remaining entry, got %d", remaining)
+	}
+	if leader, err := pool.Exists(ctx, "test::"+redisLeaderKey).Result(); err != nil || leader != 0 {
+		t.Fatalf("leadership was not released: %d %v", leader, err)
+	}
+}
+
+// TestRedisSchedulerKeyPrefix checks that schedulers sharing a redis with
+// different key prefixes each lead and expire their own entries.
+func TestRedisSchedulerKeyPrefix(t *testing.T) {
+	pool := testRedisPool(t)
+
+	ref1, ref2, _ := testRefs(t)
+	var mu sync.Mutex
+	expired := make(map[string][]string)
+
+	var schedulers []*RedisScheduler
+	for _, prefix := range []string{"cache1::", "cache2::"} {
+		prefix := prefix
+		s := NewRedis(dcontext.Background(), pool, prefix, 10*time.Millisecond)
+		s.OnBlobExpire(func(ref reference.Reference) error {
+			mu.Lock()
+			defer mu.Unlock()
+			expired[prefix] = append(expired[prefix], ref.String())
+			return nil
+		})
+		if err := s.Start(); err != nil {
+			t.Fatalf("Error starting scheduler: %s", err)
+		}
+		schedulers = append(schedulers, s)
+	}
+
+	if err := schedulers[0].AddBlob(ref1.(reference.Canonical), 0); err != nil {
+		t.Fatalf("Error scheduling blob: %s", err)
+	}
+	if err := schedulers[1].AddBlob(ref2.(reference.Canonical), 0); err != nil {
+		t.Fatalf("Error scheduling blob: %s", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	for _, s := range schedulers {
+		if err := s.Stop(); err != nil {
+			t.Fatalf("Error stopping scheduler: %s", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired["cache1::"]) != 1 || expired["cache1::"][0] != ref1.String() ||
+		len(expired["cache2::"]) != 1 || expired["cache2::"][0] != ref2.String() {
+		t.Fatalf("unexpected expired entries: %v", expired)
+	}
+}
+
+// TestRedisSchedulerSlowExpiry checks that the leader keeps its lease while
+// expiry functions run longer than the lease, so that the entries are not
+// expired concurrently by another scheduler.
+func TestRedisSchedulerSlowExpiry(t *testing.T) {
+	pool := testRedisPool(t)
+
+	ref1, ref2, ref3 := testRefs(t)
+	var (
+		mu             sync.Mutex
+		running, peak  int
+		expired        = make(map[string]int)
+		interval       = 10 * time.Millisecond
+		expiryDuration = 10 * interval
+	)
+	onExpire := func(ref reference.Reference) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		expired[ref.String()]++
+		mu.Unlock()
+
+		time.Sleep(expiryDuration)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}
+
+	var schedulers []*RedisScheduler
+	for i := 0; i < 2; i++ {
+		s := NewRedis(dcontext.Background(), pool, "test::", interval)
+		s.OnBlobExpire(onExpire)
+		if err := s.Start(); err != nil {
+			t.Fatalf("Error starting scheduler: %s", err)
+		}
+		schedulers = append(schedulers, s)
+	}
+
+	for _, ref := range []reference.Reference{ref1, ref2, ref3} {
+		if err := schedulers[0].AddBlob(ref.(reference.Canonical), 0); err != nil {
+			t.Fatalf("Error scheduling blob: %s", err)
+		}
+	}
+
+	time.Sleep(5 * expiryDuration)
+	for _, s := range schedulers {
+		if err := s.Stop(); err != nil {
+			t.Fatalf("Error stopping scheduler: %s", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if peak != 1 {
+		t.Fatalf("entries expired concurrently by %d schedulers", peak)
+	}
+	for _, ref := range []reference.Reference{ref1, ref2, ref3} {
+		if expired[ref.String()] != 1 {
+			t.Fatalf("unexpected expired entries: %v", expired)
+		}
+	}
+}
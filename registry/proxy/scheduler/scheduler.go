@@ -3,6 +3,7 @@ package scheduler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -18,9 +19,76 @@ type expiryFunc func(reference.Reference) error
 const (
 	entryTypeBlob = iota
 	entryTypeManifest
+	entryTypeTag
 	indexSaveFrequency = 5 * time.Second
 )
 
+// Scheduler calls the expiry functions of the blobs, manifests and tags it
+// schedules once their TTL expires.
+type Scheduler interface {
+	// OnBlobExpire is called when a scheduled blob's TTL expires
+	OnBlobExpire(f expiryFunc)
+	// OnManifestExpire is called when a scheduled manifest's TTL expires
+	OnManifestExpire(f expiryFunc)
+	// OnTagExpire is called when a scheduled tag's TTL expires
+	OnTagExpire(f expiryFunc)
+
+	// AddBlob schedules a blob cleanup after ttl expires
+	AddBlob(blobRef reference.Canonical, ttl time.Duration) error
+	// AddManifest schedules a manifest cleanup after ttl expires
+	AddManifest(manifestRef reference.Canonical, ttl time.Duration) error
+	// AddTag schedules a tag cleanup after ttl expires
+	AddTag(tagRef reference.Canonical, ttl time.Duration) error
+	// RemoveTag cancels the scheduled cleanup of a tag
+	RemoveTag(tagRef reference.Canonical) error
+
+	// Start starts the scheduler
+	Start() error
+	// Stop stops the scheduler
+	Stop() error
+}
+
+var (
+	_ Scheduler = &TTLExpirationScheduler{}
+	_ Scheduler = &RedisScheduler{}
+)
+
+// callbacks holds the expiry functions of a scheduler.
+type callbacks struct {
+	onBlobExpire     expiryFunc
+	onManifestExpire expiryFunc
+	onTagExpire      expiryFunc
+}
+
+// expiryFunc returns the expiry function of the given entry type.
+func (c *callbacks) expiryFunc(entryType int) expiryFunc {
+	var f expiryFunc
+	switch entryType {
+	case entryTypeBlob:
+		f = c.onBlobExpire
+	case entryTypeManifest:
+		f = c.onManifestExpire
+	case entryTypeTag:
+		f = c.onTagExpire
+	}
+	if f == nil {
+		f = func(reference.Reference) error {
+			return fmt.Errorf("scheduler entry type")
+		}
+	}
+	return f
+}
+
+// ErrPostponed is returned by an expiry function to expire the entry again
+// after Delay, rather than dropping it.
+type ErrPostponed struct {
+	Delay time.Duration
+}
+
+func (err ErrPostponed) Error() string {
+	return fmt.Sprintf("expiry postponed by %s", err.Delay)
+}
+
 // schedulerEntry represents an entry in the scheduler
 // fields are exported for serialization
 type schedulerEntry struct {
@@ -45,7 +113,8 @@ func New(ctx context.Context, driver driver.StorageDriver, path string) *TTLExpi
 }
 
 // TTLExpirationScheduler is a scheduler used to perform actions
-// when TTLs expire
+// when TTLs expire. Its entries are kept in memory and saved to the storage,
+// so it must not be shared by several registry instances.
 type TTLExpirationScheduler struct {
 	sync.Mutex
 
@@ -57,8 +126,7 @@ type TTLExpirationScheduler struct {
 
 	stopped bool
 
-	onBlobExpire     expiryFunc
-	onManifestExpire expiryFunc
+	callbacks
 
 	indexDirty bool
 	saveTimer  *time.Ticker
@@ -81,6 +149,14 @@ func (ttles *TTLExpirationScheduler) OnManifestExpire(f expiryFunc) {
 	ttles.onManifestExpire = f
 }
 
+// OnTagExpire is called when a scheduled tag's TTL expires
+func (ttles *TTLExpirationScheduler) OnTagExpire(f expiryFunc) {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	ttles.onTagExpire = f
+}
+
 // AddBlob schedules a blob cleanup after ttl expires
 func (ttles *TTLExpirationScheduler) AddBlob(blobRef reference.Canonical, ttl time.Duration) error {
 	ttles.Lock()
@@ -107,6 +183,47 @@ func (ttles *TTLExpirationScheduler) AddManifest(manifestRef reference.Canonical
 	return nil
 }
 
+// AddTag schedules a tag cleanup after ttl expires. tagRef must be tagged, its
+// digest is the one the tag referenced when it was scheduled.
+func (ttles *TTLExpirationScheduler) AddTag(tagRef reference.Canonical, ttl time.Duration) error {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	if ttles.stopped {
+		return fmt.Errorf("scheduler not started")
+	}
+
+	if _, ok := tagRef.(reference.Tagged); !ok {
+		return fmt.Errorf("reference %s is not tagged", tagRef)
+	}
+
+	ttles.add(tagRef, ttl, entryTypeTag)
+	return nil
+}
+
+// RemoveTag cancels the scheduled cleanup of a tag, if any. tagRef must be
+// tagged with the digest the tag was scheduled with.
+func (ttles *TTLExpirationScheduler) RemoveTag(tagRef reference.Canonical) error {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	if ttles.stopped {
+		return fmt.Errorf("scheduler not started")
+	}
+
+	entry, ok := ttles.entries[tagRef.String()]
+	if !ok || entry.EntryType != entryTypeTag {
+		return nil
+	}
+	dcontext.GetLogger(ttles.ctx).Infof("Removing scheduler entry for %s", entry.Key)
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	delete(ttles.entries, entry.Key)
+	ttles.indexDirty = true
+	return nil
+}
+
 // Start starts the scheduler
 func (ttles *TTLExpirationScheduler) Start() error {
 	ttles.Lock()
@@ -178,22 +295,26 @@ func (ttles *TTLExpirationScheduler) startTimer(entry *schedulerEntry, ttl time.
 		ttles.Lock()
 		defer ttles.Unlock()
 
-		var f expiryFunc
-
-		switch entry.EntryType {
-		case entryTypeBlob:
-			f = ttles.onBlobExpire
-		case entryTypeManifest:
-			f = ttles.onManifestExpire
-		default:
-			f = func(reference.Reference) error {
-				return fmt.Errorf("scheduler entry type")
-			}
+		if ttles.entries[entry.Key] != entry {
+			// the entry was removed or scheduled again while the timer fired
+			return
 		}
 
+		f := ttles.expiryFunc(entry.EntryType)
+
 		ref, err := reference.Parse(entry.Key)
 		if err == nil {
 			if err := f(ref); err != nil {
+				var postponed ErrPostponed
+				if errors.As(err, &postponed) {
+					if ttles.entries[entry.Key] == entry && !ttles.stopped {
+						dcontext.GetLogger(ttles.ctx).Infof("Postponing expiry of scheduler entry %s by %s", entry.Key, postponed.Delay)
+						entry.Expiry = time.Now().Add(postponed.Delay)
+						entry.timer = ttles.startTimer(entry, postponed.Delay)
+						ttles.indexDirty = true
+					}
+					return
+				}
 				dcontext.GetLogger(ttles.ctx).Errorf("Scheduler error returned from OnExpire(%s): %s", entry.Key, err)
 			}
 		} else {
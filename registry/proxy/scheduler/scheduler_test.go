@@ -215,3 +215,86 @@ func TestDoubleStart(t *testing.T) {
 		t.Fatal("Scheduler started twice without error")
 	}
 }
+
+func TestScheduleTag(t *testing.T) {
+	ref, err := reference.Parse("testrepo:latest@sha256:aaaaeaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	if err != nil {
+		t.Fatalf("could not parse reference: %v", err)
+	}
+	untagged, _, _ := testRefs(t)
+
+	expired := make(chan reference.Reference, 1)
+	s := New(dcontext.Background(), inmemory.New(), "/ttl")
+	s.OnTagExpire(func(ref reference.Reference) error {
+		expired <- ref
+		return nil
+	})
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("Error starting ttlExpirationScheduler: %s", err)
+	}
+
+	if err := s.AddTag(untagged.(reference.Canonical), time.Millisecond); err == nil {
+		t.Fatal("Scheduled a reference without tag")
+	}
+	if err := s.AddTag(ref.(reference.Canonical), time.Millisecond); err != nil {
+		t.Fatalf("Error scheduling tag: %s", err)
+	}
+
+	select {
+	case r := <-expired:
+		tagged, ok := r.(reference.Tagged)
+		if !ok || tagged.Tag() != "latest" || r.(reference.Canonical).Digest() != ref.(reference.Canonical).Digest() {
+			t.Fatalf("Unexpected expired reference: %s", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Tag did not expire")
+	}
+}
+
+func TestPostponeExpiry(t *testing.T) {
+	_, _, ref := testRefs(t)
+
+	calls := make(chan time.Time, 2)
+	postponed := false
+	s := New(dcontext.Background(), inmemory.New(), "/ttl")
+	s.OnManifestExpire(func(r reference.Reference) error {
+		calls <- time.Now()
+		if !postponed {
+			postponed = true
+			return ErrPostponed{Delay: 50 * time.Millisecond}
+		}
+		return nil
+	})
+	err := s.Start()
+	if err != nil {
+		t.Fatalf("Error starting ttlExpirationScheduler: %s", err)
+	}
+
+	if err := s.AddManifest(ref.(reference.Canonical), time.Millisecond); err != nil {
+		t.Fatalf("Error scheduling manifest: %s", err)
+	}
+
+	var first time.Time
+	for i := 0; i < 2; i++ {
+		select {
+		case call := <-calls:
+			if i == 0 {
+				first = call
+				continue
+			}
+			if call.Sub(first) < 50*time.Millisecond {
+				t.Fatalf("Expiry was not postponed: %s", call.Sub(first))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expiry %d did not happen", i)
+		}
+	}
+
+	s.Lock()
+	remaining := len(s.entries)
+	s.Unlock()
+	if remaining != 0 {
+		t.Fatalf("Expired entry was not removed")
+	}
+}
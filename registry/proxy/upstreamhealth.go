@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/health"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+)
+
+// defaultStaleDelay is the time by which the expiry of stale content is
+// postponed while its remote registry is unavailable, if no backoff is
+// configured.
+const defaultStaleDelay = time.Minute
+
+// ErrUpstreamUnavailable is returned instead of sending a request to a remote
+// registry considered unavailable.
+type ErrUpstreamUnavailable struct {
+	RemoteURL string
+	Err       error
+}
+
+func (err ErrUpstreamUnavailable) Error() string {
+	return fmt.Sprintf("remote registry %s is unavailable: %v", err.RemoteURL, err.Err)
+}
+
+func (err ErrUpstreamUnavailable) Unwrap() error {
+	return err.Err
+}
+
+// upstreamHealth tracks the availability of a remote registry from the
+// outcome of the requests sent to it, and acts as a circuit breaker: once the
+// remote registry is considered unavailable, requests fail without being sent
+// until the backoff elapses since the last failure. The next requests then
+// probe the remote registry again.
+type upstreamHealth struct {
+	remoteURL  url.URL
+	updater    health.Updater
+	backoff    time.Duration
+	serveStale bool
+
+	mu          sync.Mutex
+	lastFailure time.Time
+}
+
+var (
+	_ health.Checker = &upstreamHealth{}
+	_ health.Updater = &upstreamHealth{}
+)
+
+func newUpstreamHealth(remoteURL url.URL, config configuration.ProxyHealth) *upstreamHealth {
+	return &upstreamHealth{
+		remoteURL:  remoteURL,
+		updater:    health.NewThresholdStatusUpdater(config.Threshold),
+		backoff:    config.Backoff,
+		serveStale: config.ServeStale,
+	}
+}
+
+// Check returns an error if the remote registry is unavailable.
+func (h *upstreamHealth) Check(ctx context.Context) error {
+	if err := h.updater.Check(ctx); err != nil {
+		return ErrUpstreamUnavailable{RemoteURL: h.remoteURL.String(), Err: err}
+	}
+	return nil
+}
+
+// Update records the outcome of a request sent to the remote registry.
+func (h *upstreamHealth) Update(status error) {
+	if status != nil {
+		h.mu.Lock()
+		h.lastFailure = time.Now()
+		h.mu.Unlock()
+	}
+	h.updater.Update(status)
+}
+
+// allow returns an error if requests must not be sent to the remote
+// registry.
+func (h *upstreamHealth) allow(ctx context.Context) error {
+	err := h.Check(ctx)
+	if err == nil || h.backoff <= 0 {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.lastFailure) >= h.backoff {
+		return nil
+	}
+	return err
+}
+
+// unavailable reports whether the remote registry is considered unavailable.
+func (h *upstreamHealth) unavailable(ctx context.Context) bool {
+	return h != nil && h.Check(ctx) != nil
+}
+
+// staleDelay returns the time by which the expiry of the content pulled
+// through from the remote registry must be postponed, or 0 if it expires.
+func (h *upstreamHealth) staleDelay(ctx context.Context) time.Duration {
+	if !h.serveStale || !h.unavailable(ctx) {
+		return 0
+	}
+	if h.backoff > 0 {
+		return h.backoff
+	}
+	return defaultStaleDelay
+}
+
+// probe sends a request to the base endpoint of the remote registry through
+// base, bypassing the circuit breaker.
+func (h *upstreamHealth) probe(base http.RoundTripper) health.CheckFunc {
+	return func(ctx context.Context) error {
+		remoteURL := h.remoteURL
+		remoteURL.Path = "/v2/"
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, remoteURL.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := base.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return statusError(resp)
+	}
+}
+
+// poll probes the remote registry at interval until ctx is done.
+func (h *upstreamHealth) poll(ctx context.Context, base http.RoundTripper, interval time.Duration) {
+	dcontext.GetLogger(ctx).Infof("configuring upstream health check remoteurl=%s, interval=%d", h.remoteURL.String(), interval/time.Second)
+	health.Poll(ctx, h, h.probe(base), interval)
+}
+
+// statusError returns an error if the status of resp reports that the
+// remote registry failed to handle the request.
+func statusError(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status from remote registry: %s", resp.Status)
+	}
+	return nil
+}
+
+// healthTransport sends requests to a remote registry unless it is
+// unavailable, and records their outcome.
+type healthTransport struct {
+	base   http.RoundTripper
+	health *upstreamHealth
+}
+
+func (t *healthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.health.allow(req.Context()); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		if req.Context().Err() == nil {
+			// a cancelled request tells nothing about the remote
+			t.health.Update(err)
+		}
+		return nil, err
+	}
+	t.health.Update(statusError(resp))
+	return resp, nil
+}
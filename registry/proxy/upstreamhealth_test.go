@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestUpstreamHealthCircuitBreaker(t *testing.T) {
+	var requests, status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	remoteURL, _ := url.Parse(server.URL)
+	h := newUpstreamHealth(*remoteURL, configuration.ProxyHealth{Threshold: 2, Backoff: time.Hour})
+	client := &http.Client{Transport: &healthTransport{base: http.DefaultTransport, health: h}}
+
+	get := func() error {
+		resp, err := client.Get(server.URL + "/v2/")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := get(); err != nil {
+			t.Fatalf("request %d was not sent: %v", i, err)
+		}
+	}
+	if err := h.Check(context.Background()); err == nil {
+		t.Fatal("remote is not unavailable after reaching the threshold")
+	}
+
+	var unavailable ErrUpstreamUnavailable
+	if err := get(); !errors.As(err, &unavailable) {
+		t.Fatalf("expected ErrUpstreamUnavailable, got %v", err)
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("request was sent to an unavailable remote")
+	}
+
+	// once the backoff elapses, requests probe the remote again
+	h.mu.Lock()
+	h.lastFailure = time.Now().Add(-time.Hour)
+	h.mu.Unlock()
+	status.Store(http.StatusOK)
+	if err := get(); err != nil {
+		t.Fatalf("request was not sent after the backoff: %v", err)
+	}
+	if err := h.Check(context.Background()); err != nil {
+		t.Fatalf("remote is unavailable after a successful request: %v", err)
+	}
+}
+
+func TestUpstreamHealthProbe(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusBadGateway)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	remoteURL, _ := url.Parse(server.URL)
+	h := newUpstreamHealth(*remoteURL, configuration.ProxyHealth{})
+	probe := h.probe(http.DefaultTransport)
+
+	ctx := context.Background()
+	h.Update(probe(ctx))
+	if !h.unavailable(ctx) {
+		t.Fatal("remote failing to answer probes is available")
+	}
+
+	status.Store(http.StatusUnauthorized)
+	h.Update(probe(ctx))
+	if h.unavailable(ctx) {
+		t.Fatal("remote requiring authentication is unavailable")
+	}
+}
+
+func TestServeStaleKeepsExpiredTags(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	registry, err := storage.NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	h := newUpstreamHealth(url.URL{Scheme: "http", Host: "remote"}, configuration.ProxyHealth{
+		Backoff:    10 * time.Millisecond,
+		ServeStale: true,
+	})
+	h.Update(errors.New("connection refused"))
+
+	s := scheduler.New(ctx, driver, "/scheduler-state.json")
+	err = startScheduler(ctx, s, registry, driver, false, func(string) *upstreamHealth {
+		return h
+	})
+	if err != nil {
+		t.Fatalf("error starting scheduler: %v", err)
+	}
+	defer s.Stop()
+
+	named, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+	dgst := digest.FromString("cached")
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: dgst}); err != nil {
+		t.Fatalf("error tagging: %v", err)
+	}
+	tagged, _ := reference.WithTag(named, "latest")
+	tagRef, _ := reference.WithDigest(tagged, dgst)
+	if err := s.AddTag(tagRef, time.Millisecond); err != nil {
+		t.Fatalf("error scheduling tag: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := repo.Tags(ctx).Get(ctx, "latest"); err != nil {
+		t.Fatalf("expired tag was removed while the remote is unavailable: %v", err)
+	}
+
+	h.Update(nil)
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := repo.Tags(ctx).Get(ctx, "latest")
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expired tag was not removed once the remote is available: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+)
+
+// upstreamsRegistry pulls the repositories matching the prefix of an upstream
+// through from it, and hosts the other repositories.
+type upstreamsRegistry struct {
+	distribution.Namespace // hosts the repositories matching no upstream
+
+	// upstreams are sorted from the longest to the shortest prefix, so that
+	// the most specific upstream of a repository is matched first.
+	upstreams []*proxyingRegistry
+	scheduler scheduler.Scheduler
+}
+
+var (
+	_ distribution.RepositoryEnumerator = &upstreamsRegistry{}
+	_ distribution.RepositoryRemover    = &upstreamsRegistry{}
+	_ UpstreamChecker                   = &upstreamsRegistry{}
+	_ UpstreamChecker                   = &proxyingRegistry{}
+)
+
+func newUpstreamsRegistry(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy, o options) (*upstreamsRegistry, error) {
+	prefixes := make(map[string]struct{}, len(config.Upstreams))
+	expires := false
+	for _, upstream := range config.Upstreams {
+		prefix := strings.Trim(upstream.Prefix, "/")
+		if prefix == "" {
+			return nil, fmt.Errorf("proxy upstream %s: prefix is required", upstream.RemoteURL)
+		}
+		if _, err := reference.WithName(prefix); err != nil {
+			return nil, fmt.Errorf("proxy upstream %s: invalid prefix %q: %v", upstream.RemoteURL, upstream.Prefix, err)
+		}
+		if _, ok := prefixes[prefix]; ok {
+			return nil, fmt.Errorf("proxy upstream %s: duplicate prefix %q", upstream.RemoteURL, upstream.Prefix)
+		}
+		prefixes[prefix] = struct{}{}
+		if cacheTTL(upstream.TTL) != nil {
+			expires = true
+		}
+	}
+
+	ur := &upstreamsRegistry{
+		Namespace: registry,
+	}
+	for _, upstream := range config.Upstreams {
+		pr, err := newProxyingRegistry(registry, strings.Trim(upstream.Prefix, "/"), upstream, config.Health)
+		if err != nil {
+			return nil, err
+		}
+		ur.upstreams = append(ur.upstreams, pr)
+		dcontext.GetLogger(ctx).Infof("Repositories under %s/ are pulled through from %s", pr.prefix, upstream.RemoteURL)
+	}
+	sort.SliceStable(ur.upstreams, func(i, j int) bool {
+		return len(ur.upstreams[i].prefix) > len(ur.upstreams[j].prefix)
+	})
+
+	if expires {
+		s, err := newScheduler(ctx, driver, config.Scheduler, o)
+		if err != nil {
+			return nil, err
+		}
+		// hosted repositories may share blobs with the cached ones, so the
+		// blobs which expire are left to the garbage collection
+		err = startScheduler(ctx, s, registry, driver, false, func(name string) *upstreamHealth {
+			if pr := ur.upstream(name); pr != nil {
+				return pr.health
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		ur.scheduler = s
+		for _, pr := range ur.upstreams {
+			pr.scheduler = s
+		}
+	}
+
+	return ur, nil
+}
+
+// upstream returns the upstream the named repository is pulled through from,
+// or nil if the repository is hosted.
+func (ur *upstreamsRegistry) upstream(name string) *proxyingRegistry {
+	for _, pr := range ur.upstreams {
+		if strings.HasPrefix(name, pr.prefix+"/") {
+			return pr
+		}
+	}
+	return nil
+}
+
+func (ur *upstreamsRegistry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	if pr := ur.upstream(name.Name()); pr != nil {
+		return pr.Repository(ctx, name)
+	}
+	return ur.Namespace.Repository(ctx, name)
+}
+
+func (ur *upstreamsRegistry) Enumerate(ctx context.Context, ingester func(string) error) error {
+	repositoryEnumerator, ok := ur.Namespace.(distribution.RepositoryEnumerator)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+	return repositoryEnumerator.Enumerate(ctx, ingester)
+}
+
+func (ur *upstreamsRegistry) RepositoriesWithPrefix(ctx context.Context, repos []string, prefix, last string) (int, error) {
+	prefixEnumerator, ok := ur.Namespace.(distribution.RepositoryPrefixEnumerator)
+	if !ok {
+		return 0, distribution.ErrUnsupported
+	}
+	return prefixEnumerator.RepositoriesWithPrefix(ctx, repos, prefix, last)
+}
+
+func (ur *upstreamsRegistry) EnumeratePrefix(ctx context.Context, prefix string, ingester func(string) error) error {
+	prefixEnumerator, ok := ur.Namespace.(distribution.RepositoryPrefixEnumerator)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+	return prefixEnumerator.EnumeratePrefix(ctx, prefix, ingester)
+}
+
+func (ur *upstreamsRegistry) Remove(ctx context.Context, name reference.Named) error {
+	repositoryRemover, ok := ur.Namespace.(distribution.RepositoryRemover)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+	return repositoryRemover.Remove(ctx, name)
+}
+
+func (ur *upstreamsRegistry) CheckUpstreams(ctx context.Context) error {
+	var errs []error
+	for _, pr := range ur.upstreams {
+		if err := pr.CheckUpstreams(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (ur *upstreamsRegistry) PollUpstreams(ctx context.Context, interval time.Duration) {
+	for _, pr := range ur.upstreams {
+		go pr.PollUpstreams(ctx, interval)
+	}
+	<-ctx.Done()
+}
+
+func (ur *upstreamsRegistry) Close() error {
+	if ur.scheduler == nil {
+		return nil
+	}
+	return ur.scheduler.Stop()
+}
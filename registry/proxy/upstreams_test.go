@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// upstreamServer is a remote registry recording the paths of the requests it
+// receives, and knowing no repository.
+type upstreamServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	paths []string
+}
+
+func newUpstreamServer() *upstreamServer {
+	u := &upstreamServer{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.paths = append(u.paths, r.URL.Path)
+		u.mu.Unlock()
+
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	return u
+}
+
+func (u *upstreamServer) requested(path string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, p := range u.paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUpstreamsRegistryRouting(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	registry, err := storage.NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	mirror := newUpstreamServer()
+	defer mirror.Close()
+	library := newUpstreamServer()
+	defer library.Close()
+
+	noTTL := time.Duration(0)
+	namespace, err := NewRegistryPullThroughCache(ctx, registry, driver, configuration.Proxy{
+		Upstreams: []configuration.ProxyUpstream{
+			{Prefix: "mirror", RemoteURL: mirror.URL, TTL: &noTTL},
+			{Prefix: "mirror/library/", RemoteURL: library.URL, TTL: &noTTL},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	defer namespace.(Closer).Close()
+
+	for _, tc := range []struct {
+		name       string
+		upstream   *upstreamServer
+		remotePath string
+	}{
+		{name: "mirror/foo/bar", upstream: mirror, remotePath: "/v2/foo/bar/manifests/latest"},
+		{name: "mirror/library/foo", upstream: library, remotePath: "/v2/foo/manifests/latest"},
+	} {
+		named, _ := reference.WithName(tc.name)
+		repo, err := namespace.Repository(ctx, named)
+		if err != nil {
+			t.Fatalf("error creating repository %s: %v", tc.name, err)
+		}
+		if _, ok := repo.(*proxiedRepository); !ok {
+			t.Fatalf("repository %s is not pulled through: %T", tc.name, repo)
+		}
+		if repo.Named().Name() != tc.name {
+			t.Fatalf("unexpected repository name: %s != %s", repo.Named().Name(), tc.name)
+		}
+
+		if _, err := repo.Tags(ctx).Get(ctx, "latest"); err == nil {
+			t.Fatalf("expected unknown tag in %s", tc.name)
+		}
+		if !tc.upstream.requested(tc.remotePath) {
+			t.Fatalf("expected request to %s for %s, got %v", tc.remotePath, tc.name, tc.upstream.paths)
+		}
+	}
+
+	// repositories matching no upstream are hosted
+	named, _ := reference.WithName("mirrored/app")
+	repo, err := namespace.Repository(ctx, named)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+	if _, ok := repo.(*proxiedRepository); ok {
+		t.Fatalf("repository %s is pulled through", named)
+	}
+	dgst := digest.FromString("hosted")
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: dgst}); err != nil {
+		t.Fatalf("error tagging hosted repository: %v", err)
+	}
+
+	if _, ok := namespace.(distribution.RepositoryEnumerator); !ok {
+		t.Fatalf("registry with upstreams does not enumerate repositories")
+	}
+}
+
+func TestUpstreamsRegistryConfiguration(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	registry, err := storage.NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	upstream := newUpstreamServer()
+	defer upstream.Close()
+
+	for _, tc := range []struct {
+		name   string
+		config configuration.Proxy
+	}{
+		{
+			name: "missing prefix",
+			config: configuration.Proxy{Upstreams: []configuration.ProxyUpstream{
+				{RemoteURL: upstream.URL},
+			}},
+		},
+		{
+			name: "invalid prefix",
+			config: configuration.Proxy{Upstreams: []configuration.ProxyUpstream{
+				{Prefix: "Docker Hub", RemoteURL: upstream.URL},
+			}},
+		},
+		{
+			name: "duplicate prefix",
+			config: configuration.Proxy{Upstreams: []configuration.ProxyUpstream{
+				{Prefix: "hub", RemoteURL: upstream.URL},
+				{Prefix: "hub/", RemoteURL: upstream.URL},
+			}},
+		},
+		{
+			name: "remoteurl and upstreams",
+			config: configuration.Proxy{
+				RemoteURL: upstream.URL,
+				Upstreams: []configuration.ProxyUpstream{
+					{Prefix: "hub", RemoteURL: upstream.URL},
+				},
+			},
+		},
+	} {
+		if _, err := NewRegistryPullThroughCache(ctx, registry, driver, tc.config); err == nil {
+			t.Fatalf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestSchedulerBackend(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	registry, err := storage.NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	remote := newUpstreamServer()
+	defer remote.Close()
+
+	for _, backend := range []string{"redis", "unknown"} {
+		_, err := NewRegistryPullThroughCache(ctx, registry, driver, configuration.Proxy{
+			RemoteURL: remote.URL,
+			Scheduler: configuration.ProxyScheduler{Backend: backend},
+		})
+		if err == nil {
+			t.Fatalf("expected error creating registry with scheduler backend %s", backend)
+		}
+	}
+
+	namespace, err := NewRegistryPullThroughCache(ctx, registry, driver, configuration.Proxy{
+		RemoteURL: remote.URL,
+		Scheduler: configuration.ProxyScheduler{Backend: "storage"},
+	})
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	if err := namespace.(Closer).Close(); err != nil {
+		t.Fatalf("error closing registry: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type memoryEntry struct {
+	usage   int64
+	expires time.Time
+}
+
+type memoryLedger struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]memoryEntry
+}
+
+// NewMemoryLedger returns a Ledger keeping usages in memory. Usages are
+// computed again from the storage once ttl has elapsed, if ttl is positive.
+// It is only accurate when a single registry instance writes to the storage.
+func NewMemoryLedger(ttl time.Duration) Ledger {
+	return &memoryLedger{
+		ttl:     ttl,
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+func (l *memoryLedger) Get(ctx context.Context, key string) (int64, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok || l.expired(entry) {
+		return 0, false, nil
+	}
+	return entry.usage, true, nil
+}
+
+func (l *memoryLedger) Init(ctx context.Context, key string, usage int64) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry, ok := l.entries[key]; ok && !l.expired(entry) {
+		return entry.usage, nil
+	}
+
+	entry := memoryEntry{usage: usage}
+	if l.ttl > 0 {
+		entry.expires = time.Now().Add(l.ttl)
+	}
+	l.entries[key] = entry
+	return usage, nil
+}
+
+func (l *memoryLedger) Add(ctx context.Context, key string, delta int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok || l.expired(entry) {
+		return nil
+	}
+	entry.usage += delta
+	l.entries[key] = entry
+	return nil
+}
+
+func (l *memoryLedger) Delete(ctx context.Context, keys ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+	return nil
+}
+
+func (l *memoryLedger) Clear(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = make(map[string]memoryEntry)
+	return nil
+}
+
+func (l *memoryLedger) expired(entry memoryEntry) bool {
+	return !entry.expires.IsZero() && time.Now().After(entry.expires)
+}
+
+// incrExisting increments a key only if it exists, so that usages evicted
+// from redis are computed again rather than restarting from zero.
+var incrExisting = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("INCRBY", KEYS[1], ARGV[1])
+end
+return nil
+`)
+
+type redisLedger struct {
+	pool redis.UniversalClient
+	ttl  time.Duration
+}
+
+// NewRedisLedger returns a Ledger keeping usages in redis, shared by the
+// registry instances using the same redis. Usages are computed again from the
+// storage once ttl has elapsed, if ttl is positive.
+func NewRedisLedger(pool redis.UniversalClient, ttl time.Duration) Ledger {
+	return &redisLedger{
+		pool: pool,
+		ttl:  ttl,
+	}
+}
+
+func (l *redisLedger) Get(ctx context.Context, key string) (int64, bool, error) {
+	usage, err := l.pool.Get(ctx, l.key(key)).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return usage, true, nil
+}
+
+func (l *redisLedger) Init(ctx context.Context, key string, usage int64) (int64, error) {
+	if err := l.pool.SetNX(ctx, l.key(key), usage, l.ttl).Err(); err != nil {
+		return 0, err
+	}
+	recorded, err := l.pool.Get(ctx, l.key(key)).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			// expired in between
+			return usage, nil
+		}
+		return 0, err
+	}
+	return recorded, nil
+}
+
+func (l *redisLedger) Add(ctx context.Context, key string, delta int64) error {
+	err := incrExisting.Run(ctx, l.pool, []string{l.key(key)}, delta).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	return nil
+}
+
+func (l *redisLedger) Delete(ctx context.Context, keys ...string) error {
+	// keys are deleted one by one, as they may be stored on different nodes
+	// of a redis cluster
+	_, err := l.pool.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, l.key(key))
+		}
+		return nil
+	})
+	return err
+}
+
+func (l *redisLedger) Clear(ctx context.Context) error {
+	if cluster, ok := l.pool.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return l.clear(ctx, client)
+		})
+	}
+	return l.clear(ctx, l.pool)
+}
+
+// clear deletes the usages recorded in the redis node of client.
+func (l *redisLedger) clear(ctx context.Context, client redis.Cmdable) error {
+	iter := client.Scan(ctx, 0, l.key("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		if err := client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func (l *redisLedger) key(key string) string {
+	return "quota::" + key
+}
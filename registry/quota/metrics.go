@@ -0,0 +1,17 @@
+package quota
+
+import (
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+var (
+	// usageGauge is the storage used by the repositories sharing a quota
+	usageGauge = prometheus.QuotaNamespace.NewLabeledGauge("usage", "The storage used by the repositories sharing a quota", metrics.Bytes, "prefix")
+	// limitGauge is the configured limit of a quota
+	limitGauge = prometheus.QuotaNamespace.NewLabeledGauge("limit", "The configured storage limit of a quota", metrics.Bytes, "prefix")
+)
+
+func init() {
+	metrics.Register(prometheus.QuotaNamespace)
+}
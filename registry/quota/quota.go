@@ -0,0 +1,337 @@
+// Package quota accounts the storage used by repositories, and enforces the
+// byte quotas configured for prefixes of repository names.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// DefaultTTL is the default time after which recorded usages are computed
+// again from the storage, accounting for the content removed without going
+// through Record.
+const DefaultTTL = time.Hour
+
+// Limit restricts the bytes used by the repositories whose name starts with
+// the path Prefix: a limit for team-a applies to team-a and team-a/app, but
+// not to team-ab.
+type Limit struct {
+	Prefix string
+	Bytes  int64
+}
+
+// Ledger records the bytes used by repositories and by prefixes of
+// repository names. Recorded usages may be evicted at any time, in which case
+// they are computed again from the storage.
+type Ledger interface {
+	// Get returns the usage recorded for key. ok is false if no usage is
+	// recorded.
+	Get(ctx context.Context, key string) (usage int64, ok bool, err error)
+
+	// Init records usage for key unless a usage is already recorded, and
+	// returns the usage recorded for key.
+	Init(ctx context.Context, key string, usage int64) (int64, error)
+
+	// Add adds delta to the usage recorded for key. Nothing is recorded if
+	// no usage is recorded for key yet.
+	Add(ctx context.Context, key string, delta int64) error
+
+	// Delete deletes the usages recorded for keys.
+	Delete(ctx context.Context, keys ...string) error
+
+	// Clear deletes all the recorded usages.
+	Clear(ctx context.Context) error
+}
+
+// ErrQuotaExceeded is returned when storing content would exceed the quota
+// of a repository.
+type ErrQuotaExceeded struct {
+	Repository string
+	Prefix     string
+	Limit      int64
+	Usage      int64
+	Size       int64
+}
+
+func (err ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("storing %d bytes in %s would exceed the quota of %d bytes of %q, %d bytes are used",
+		err.Size, err.Repository, err.Limit, err.Prefix, err.Usage)
+}
+
+// Usage describes the storage used by a repository and the quotas applying
+// to it.
+type Usage struct {
+	Repository string       `json:"repository"`
+	Bytes      int64        `json:"bytes"`
+	Quotas     []LimitUsage `json:"quotas"`
+}
+
+// LimitUsage describes the storage used by the repositories sharing a quota.
+type LimitUsage struct {
+	Prefix string `json:"prefix"`
+	Limit  int64  `json:"limit"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Quotas accounts the storage used by the repositories of a registry and
+// enforces limits on it. Content is accounted once per repository it is
+// linked to, whether or not it is shared with other repositories.
+type Quotas struct {
+	registry distribution.Namespace
+	ledger   Ledger
+	limits   []Limit
+}
+
+// New returns Quotas enforcing limits on the repositories of registry, which
+// is used to compute the usages missing from ledger. registry must be a
+// storage registry able to enumerate its repositories and their content.
+func New(registry distribution.Namespace, ledger Ledger, limits []Limit) *Quotas {
+	for _, limit := range limits {
+		limitGauge.WithValues(limit.Prefix).Set(float64(limit.Bytes))
+	}
+
+	return &Quotas{
+		registry: registry,
+		ledger:   ledger,
+		limits:   limits,
+	}
+}
+
+// Check returns ErrQuotaExceeded if storing size more bytes in the named
+// repository would exceed one of the limits applying to it.
+func (q *Quotas) Check(ctx context.Context, repo string, size int64) error {
+	for _, limit := range q.limits {
+		if !matchPrefix(repo, limit.Prefix) {
+			continue
+		}
+
+		usage, err := q.prefixUsage(ctx, limit.Prefix)
+		if err != nil {
+			return err
+		}
+		if usage+size > limit.Bytes {
+			return ErrQuotaExceeded{
+				Repository: repo,
+				Prefix:     limit.Prefix,
+				Limit:      limit.Bytes,
+				Usage:      usage,
+				Size:       size,
+			}
+		}
+	}
+	return nil
+}
+
+// Record accounts delta bytes stored in the named repository, or removed
+// from it if delta is negative.
+func (q *Quotas) Record(ctx context.Context, repo string, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+
+	if err := q.ledger.Add(ctx, repositoryKey(repo), delta); err != nil {
+		return err
+	}
+	for _, limit := range q.limits {
+		if !matchPrefix(repo, limit.Prefix) {
+			continue
+		}
+		if err := q.ledger.Add(ctx, prefixKey(limit.Prefix), delta); err != nil {
+			return err
+		}
+		if usage, ok, err := q.ledger.Get(ctx, prefixKey(limit.Prefix)); err == nil && ok {
+			usageGauge.WithValues(limit.Prefix).Set(float64(usage))
+		}
+	}
+	return nil
+}
+
+// Invalidate discards the usages recorded for the named repository and the
+// quotas applying to it, so that they are computed again from the storage,
+// such as after content was removed from the repository without going
+// through Record.
+func (q *Quotas) Invalidate(ctx context.Context, repo string) error {
+	keys := []string{repositoryKey(repo)}
+	for _, limit := range q.limits {
+		if matchPrefix(repo, limit.Prefix) {
+			keys = append(keys, prefixKey(limit.Prefix))
+		}
+	}
+	return q.ledger.Delete(ctx, keys...)
+}
+
+// Reset discards all the recorded usages, so that they are computed again
+// from the storage, such as after a garbage collection.
+func (q *Quotas) Reset(ctx context.Context) error {
+	return q.ledger.Clear(ctx)
+}
+
+// Usage returns the storage used by the named repository and by the
+// repositories sharing its quotas.
+func (q *Quotas) Usage(ctx context.Context, repo string) (Usage, error) {
+	bytes, err := q.RepositoryUsage(ctx, repo)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage := Usage{
+		Repository: repo,
+		Bytes:      bytes,
+		Quotas:     []LimitUsage{},
+	}
+	for _, limit := range q.limits {
+		if !matchPrefix(repo, limit.Prefix) {
+			continue
+		}
+		bytes, err := q.prefixUsage(ctx, limit.Prefix)
+		if err != nil {
+			return Usage{}, err
+		}
+		usage.Quotas = append(usage.Quotas, LimitUsage{
+			Prefix: limit.Prefix,
+			Limit:  limit.Bytes,
+			Bytes:  bytes,
+		})
+	}
+	return usage, nil
+}
+
+// prefixUsage returns the bytes used by the repositories whose name starts
+// with prefix.
+func (q *Quotas) prefixUsage(ctx context.Context, prefix string) (int64, error) {
+	usage, ok, err := q.ledger.Get(ctx, prefixKey(prefix))
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		var total int64
+		ingester := func(repo string) error {
+			if !matchPrefix(repo, prefix) {
+				return nil
+			}
+			usage, err := q.RepositoryUsage(ctx, repo)
+			if err != nil {
+				return err
+			}
+			total += usage
+			return nil
+		}
+
+		var err error
+		switch enumerator := q.registry.(type) {
+		case distribution.RepositoryPrefixEnumerator:
+			err = enumerator.EnumeratePrefix(ctx, prefix, ingester)
+		case distribution.RepositoryEnumerator:
+			err = enumerator.Enumerate(ctx, ingester)
+		default:
+			return 0, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+		}
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return 0, err
+			}
+		}
+
+		usage, err = q.ledger.Init(ctx, prefixKey(prefix), total)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	usageGauge.WithValues(prefix).Set(float64(usage))
+	return usage, nil
+}
+
+// RepositoryUsage returns the bytes used by the named repository, as
+// recorded or computed again from the storage.
+func (q *Quotas) RepositoryUsage(ctx context.Context, repo string) (int64, error) {
+	usage, ok, err := q.ledger.Get(ctx, repositoryKey(repo))
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return usage, nil
+	}
+
+	dcontext.GetLogger(ctx).Debugf("computing storage usage of repository %s", repo)
+	usage, err = RepositoryUsage(ctx, q.registry, repo)
+	if err != nil {
+		return 0, err
+	}
+	return q.ledger.Init(ctx, repositoryKey(repo), usage)
+}
+
+// RepositoryUsage sums the sizes of the layers and manifests linked to the
+// named repository of registry, which must be a storage registry.
+func RepositoryUsage(ctx context.Context, registry distribution.Namespace, repo string) (int64, error) {
+	named, err := reference.WithName(repo)
+	if err != nil {
+		return 0, err
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		return 0, err
+	}
+
+	layers, ok := repository.Blobs(ctx).(distribution.ManifestEnumerator)
+	if !ok {
+		return 0, fmt.Errorf("unable to convert BlobService into ManifestEnumerator")
+	}
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return 0, err
+	}
+	manifests, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return 0, fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+
+	statter := registry.BlobStatter()
+	var usage int64
+	ingester := func(dgst digest.Digest) error {
+		desc, err := statter.Stat(ctx, dgst)
+		if err != nil {
+			if err == distribution.ErrBlobUnknown {
+				// removed by garbage collection
+				return nil
+			}
+			return err
+		}
+		usage += desc.Size
+		return nil
+	}
+
+	for _, enumerator := range []distribution.ManifestEnumerator{layers, manifests} {
+		if err := enumerator.Enumerate(ctx, ingester); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return 0, err
+			}
+		}
+	}
+	return usage, nil
+}
+
+// matchPrefix returns whether the named repository is prefix or one of its
+// subpaths.
+func matchPrefix(repo, prefix string) bool {
+	if prefix == "" || repo == prefix {
+		return true
+	}
+	return strings.HasPrefix(repo, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+func repositoryKey(repo string) string {
+	return "repository::" + repo
+}
+
+func prefixKey(prefix string) string {
+	return "prefix::" + prefix
+}
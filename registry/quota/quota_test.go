@@ -0,0 +1,256 @@
+package quota
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+func setupRegistry(t *testing.T) distribution.Namespace {
+	t.Helper()
+
+	registry, err := storage.NewRegistry(context.Background(), inmemory.New())
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	return registry
+}
+
+// pushImage uploads an image of n random layers to the named repository, and
+// returns the total size of its manifest and blobs.
+func pushImage(t *testing.T, registry distribution.Namespace, name string, n int) int64 {
+	t.Helper()
+	ctx := context.Background()
+
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatalf("error parsing repository name: %v", err)
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+
+	layers, err := testutil.CreateRandomLayers(n)
+	if err != nil {
+		t.Fatalf("error creating layers: %v", err)
+	}
+	if err := testutil.UploadBlobs(repository, layers); err != nil {
+		t.Fatalf("error uploading layers: %v", err)
+	}
+	digests := make([]digest.Digest, 0, n)
+	for dgst := range layers {
+		digests = append(digests, dgst)
+	}
+
+	manifest, err := testutil.MakeOCIManifest(repository, digests)
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		t.Fatalf("error creating manifest service: %v", err)
+	}
+	if _, err := manifestService.Put(ctx, manifest); err != nil {
+		t.Fatalf("error putting manifest: %v", err)
+	}
+
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		t.Fatalf("error getting manifest payload: %v", err)
+	}
+	size := int64(len(payload))
+	for _, ref := range manifest.References() {
+		desc, err := registry.BlobStatter().Stat(ctx, ref.Digest)
+		if err != nil {
+			t.Fatalf("error getting blob size: %v", err)
+		}
+		size += desc.Size
+	}
+	return size
+}
+
+func TestQuotasComputeUsageFromStorage(t *testing.T) {
+	ctx := context.Background()
+	registry := setupRegistry(t)
+
+	teamA := pushImage(t, registry, "team-a/app", 2)
+	teamAOther := pushImage(t, registry, "team-a/other", 1)
+	pushImage(t, registry, "team-b/app", 1)
+
+	quotas := New(registry, NewMemoryLedger(0), []Limit{
+		{Prefix: "team-a/", Bytes: teamA + teamAOther + 10},
+	})
+
+	usage, err := quotas.Usage(ctx, "team-a/app")
+	if err != nil {
+		t.Fatalf("error computing usage: %v", err)
+	}
+	if usage.Bytes != teamA {
+		t.Fatalf("unexpected repository usage: %d != %d", usage.Bytes, teamA)
+	}
+	if len(usage.Quotas) != 1 || usage.Quotas[0].Bytes != teamA+teamAOther {
+		t.Fatalf("unexpected quota usage: %+v", usage.Quotas)
+	}
+
+	usage, err = quotas.Usage(ctx, "team-b/app")
+	if err != nil {
+		t.Fatalf("error computing usage: %v", err)
+	}
+	if len(usage.Quotas) != 0 {
+		t.Fatalf("unexpected quotas for team-b/app: %+v", usage.Quotas)
+	}
+}
+
+func TestQuotasCheckAndRecord(t *testing.T) {
+	ctx := context.Background()
+	registry := setupRegistry(t)
+
+	used := pushImage(t, registry, "team-a/app", 1)
+	quotas := New(registry, NewMemoryLedger(0), []Limit{
+		{Prefix: "team-a/", Bytes: used + 100},
+	})
+
+	if err := quotas.Check(ctx, "team-a/app", 100); err != nil {
+		t.Fatalf("unexpected error checking quota: %v", err)
+	}
+	if err := quotas.Check(ctx, "team-b/app", 1000); err != nil {
+		t.Fatalf("unexpected error checking repository without quota: %v", err)
+	}
+
+	if err := quotas.Record(ctx, "team-a/new", 60); err != nil {
+		t.Fatalf("error recording usage: %v", err)
+	}
+	err := quotas.Check(ctx, "team-a/app", 50)
+	exceeded, ok := err.(ErrQuotaExceeded)
+	if !ok {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if exceeded.Prefix != "team-a/" || exceeded.Usage != used+60 {
+		t.Fatalf("unexpected error: %+v", exceeded)
+	}
+
+	if err := quotas.Record(ctx, "team-a/new", -60); err != nil {
+		t.Fatalf("error recording usage: %v", err)
+	}
+	if err := quotas.Check(ctx, "team-a/app", 50); err != nil {
+		t.Fatalf("unexpected error checking quota: %v", err)
+	}
+}
+
+func TestQuotasPrefixBoundary(t *testing.T) {
+	ctx := context.Background()
+	registry := setupRegistry(t)
+
+	used := pushImage(t, registry, "team-a/app", 1)
+	pushImage(t, registry, "team-ab/app", 1)
+
+	for _, prefix := range []string{"team-a", "team-a/"} {
+		quotas := New(registry, NewMemoryLedger(0), []Limit{
+			{Prefix: prefix, Bytes: used + 100},
+		})
+
+		usage, err := quotas.Usage(ctx, "team-a/app")
+		if err != nil {
+			t.Fatalf("error computing usage: %v", err)
+		}
+		if len(usage.Quotas) != 1 || usage.Quotas[0].Bytes != used {
+			t.Fatalf("unexpected quota usage for prefix %q: %+v", prefix, usage.Quotas)
+		}
+
+		usage, err = quotas.Usage(ctx, "team-ab/app")
+		if err != nil {
+			t.Fatalf("error computing usage: %v", err)
+		}
+		if len(usage.Quotas) != 0 {
+			t.Fatalf("unexpected quotas for team-ab/app with prefix %q: %+v", prefix, usage.Quotas)
+		}
+		if err := quotas.Check(ctx, "team-ab/app", used+1000); err != nil {
+			t.Fatalf("unexpected error checking team-ab/app with prefix %q: %v", prefix, err)
+		}
+	}
+
+	quotas := New(registry, NewMemoryLedger(0), []Limit{{Prefix: "team-a", Bytes: used}})
+	if err := quotas.Check(ctx, "team-a", 1); err == nil {
+		t.Fatal("expected the quota to apply to the repository named by the prefix")
+	}
+}
+
+func TestQuotasInvalidate(t *testing.T) {
+	ctx := context.Background()
+	registry := setupRegistry(t)
+
+	used := pushImage(t, registry, "team-a/app", 1)
+	quotas := New(registry, NewMemoryLedger(0), []Limit{
+		{Prefix: "team-a/", Bytes: used + 100},
+	})
+
+	if err := quotas.Check(ctx, "team-a/app", 100); err != nil {
+		t.Fatalf("unexpected error checking quota: %v", err)
+	}
+	// usage removed from the storage without going through Record
+	if err := quotas.Record(ctx, "team-a/app", 100); err != nil {
+		t.Fatalf("error recording usage: %v", err)
+	}
+	if err := quotas.Check(ctx, "team-a/app", 1); err == nil {
+		t.Fatal("expected the quota to be exceeded")
+	}
+
+	if err := quotas.Invalidate(ctx, "team-a/app"); err != nil {
+		t.Fatalf("error invalidating usage: %v", err)
+	}
+	if err := quotas.Check(ctx, "team-a/app", 100); err != nil {
+		t.Fatalf("unexpected error checking quota after invalidation: %v", err)
+	}
+
+	if err := quotas.Record(ctx, "team-a/app", 100); err != nil {
+		t.Fatalf("error recording usage: %v", err)
+	}
+	if err := quotas.Reset(ctx); err != nil {
+		t.Fatalf("error resetting usages: %v", err)
+	}
+	usage, err := quotas.Usage(ctx, "team-a/app")
+	if err != nil {
+		t.Fatalf("error computing usage: %v", err)
+	}
+	if usage.Bytes != used || usage.Quotas[0].Bytes != used {
+		t.Fatalf("unexpected usage after reset: %+v", usage)
+	}
+}
+
+func TestMemoryLedgerExpiry(t *testing.T) {
+	ctx := context.Background()
+	ledger := NewMemoryLedger(10 * time.Millisecond)
+
+	if err := ledger.Add(ctx, "key", 10); err != nil {
+		t.Fatalf("error adding usage: %v", err)
+	}
+	if _, ok, _ := ledger.Get(ctx, "key"); ok {
+		t.Fatalf("usage recorded without Init")
+	}
+
+	if usage, _ := ledger.Init(ctx, "key", 5); usage != 5 {
+		t.Fatalf("unexpected usage: %d", usage)
+	}
+	if usage, _ := ledger.Init(ctx, "key", 7); usage != 5 {
+		t.Fatalf("Init overwrote recorded usage: %d", usage)
+	}
+	if err := ledger.Add(ctx, "key", 10); err != nil {
+		t.Fatalf("error adding usage: %v", err)
+	}
+	if usage, ok, _ := ledger.Get(ctx, "key"); !ok || usage != 15 {
+		t.Fatalf("unexpected usage: %d", usage)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok, _ := ledger.Get(ctx, "key"); ok {
+		t.Fatalf("usage not expired")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -452,6 +453,12 @@ func alive(path string, handler http.Handler) http.Handler {
 }
 
 func resolveConfiguration(args []string) (*configuration.Configuration, error) {
+	return resolveConfigurationWith(args, configuration.Parse)
+}
+
+// resolveConfigurationWith reads the configuration file given in args or in
+// the environment, and parses it with parse.
+func resolveConfigurationWith(args []string, parse func(io.Reader) (*configuration.Configuration, error)) (*configuration.Configuration, error) {
 	var configurationPath string
 
 	if len(args) > 0 {
@@ -471,7 +478,7 @@ func resolveConfiguration(args []string) (*configuration.Configuration, error) {
 
 	defer fp.Close()
 
-	config, err := configuration.Parse(fp)
+	config, err := parse(fp)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing %s: %v", configurationPath, err)
 	}
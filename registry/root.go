@@ -1,12 +1,19 @@
 package registry
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
 
+	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/auth/tokenserver"
+	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	replicate "github.com/distribution/distribution/v3/registry/storage/driver/middleware/replicate"
 	"github.com/distribution/distribution/v3/version"
 	"github.com/spf13/cobra"
 )
@@ -16,8 +23,18 @@ var showVersion bool
 func init() {
 	RootCmd.AddCommand(ServeCmd)
 	RootCmd.AddCommand(GCCmd)
+	RootCmd.AddCommand(RetentionCmd)
+	RootCmd.AddCommand(ReconcileCmd)
+	RootCmd.AddCommand(TokenServerCmd)
 	GCCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do everything except remove the blobs")
 	GCCmd.Flags().BoolVarP(&removeUntagged, "delete-untagged", "m", false, "delete manifests that are not currently referenced via tag")
+	GCCmd.Flags().IntVarP(&keepTagHistory, "keep-tag-history", "k", 0, "with --delete-untagged, keep the given number of manifests previously referenced by each tag")
+	GCCmd.Flags().BoolVarP(&online, "online", "o", false, "keep content written while collecting, allowing the registry to serve writes")
+	GCCmd.Flags().DurationVarP(&gracePeriod, "grace-period", "g", storage.DefaultGCGracePeriod, "in online mode, also keep content modified within this duration before collecting")
+	RetentionCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "report the expired tags without deleting them")
+	ReconcileCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "report the objects to copy and delete without changing the secondary storage")
+	ReconcileCmd.Flags().BoolVarP(&reconcileDelete, "delete", "", false, "delete the objects of the secondary storage missing from the primary storage")
+	TokenServerCmd.Flags().BoolVarP(&printJWKS, "print-jwks", "", false, "print the JSON Web Key Set of the signing key and exit")
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
 }
 
@@ -37,8 +54,13 @@ var RootCmd = &cobra.Command{
 }
 
 var (
-	dryRun         bool
-	removeUntagged bool
+	dryRun          bool
+	removeUntagged  bool
+	keepTagHistory  int
+	online          bool
+	gracePeriod     time.Duration
+	printJWKS       bool
+	reconcileDelete bool
 )
 
 // GCCmd is the cobra command that corresponds to the garbage-collect subcommand
@@ -77,6 +99,9 @@ var GCCmd = &cobra.Command{
 		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
 			DryRun:         dryRun,
 			RemoveUntagged: removeUntagged,
+			KeepTagHistory: keepTagHistory,
+			Online:         online,
+			GracePeriod:    gracePeriod,
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
@@ -84,3 +109,191 @@ var GCCmd = &cobra.Command{
 		}
 	},
 }
+
+// RetentionCmd is the cobra command that corresponds to the retention subcommand
+var RetentionCmd = &cobra.Command{
+	Use:   "retention <config>",
+	Short: "`retention` deletes the tags selected by the retention policies",
+	Long:  "`retention` deletes the tags selected by the retention policies",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		policies, err := handlers.RetentionPolicies(config.Retention)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		expired, err := storage.EnforceRetention(ctx, driver, registry, storage.RetentionOpts{
+			Policies:        policies,
+			DryRun:          dryRun || config.Retention.DryRun,
+			DeleteManifests: config.Retention.DeleteManifests,
+		})
+		for _, tag := range expired {
+			fmt.Printf("%s:%s\t%s\t%s\n", tag.Repository, tag.Tag, tag.Digest, tag.Pushed.Format(time.RFC3339))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to enforce retention: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// ReconcileCmd is the cobra command that corresponds to the reconcile subcommand
+var ReconcileCmd = &cobra.Command{
+	Use:   "reconcile <config>",
+	Short: "`reconcile` copies the objects missing from the secondary storage of the replicate middleware",
+	Long:  "`reconcile` walks the storage and the secondary storage of the replicate storage middleware, copying the objects missing from the secondary storage or whose content differs, and optionally deleting the objects missing from the storage",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		var options map[string]interface{}
+		for _, mw := range config.Middleware["storage"] {
+			if mw.Name == replicate.Name && !mw.Disabled {
+				options = mw.Options
+			}
+		}
+		if options == nil {
+			fmt.Fprintf(os.Stderr, "configuration error: no %s storage middleware configured\n", replicate.Name)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		secondary, err := replicate.SecondaryDriver(ctx, options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct secondary driver: %v", err)
+			os.Exit(1)
+		}
+
+		result, err := replicate.Reconcile(ctx, driver, secondary, replicate.ReconcileOpts{
+			DryRun: dryRun,
+			Delete: reconcileDelete,
+		})
+		copied, deleted := "copied", "deleted"
+		if dryRun {
+			copied, deleted = "would copy", "would delete"
+		}
+		for _, path := range result.Copied {
+			fmt.Printf("%s\t%s\n", copied, path)
+		}
+		for _, path := range result.Deleted {
+			fmt.Printf("%s\t%s\n", deleted, path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to reconcile: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// defaultTokenServerAddr is the address the token server listens on if not
+// configured.
+const defaultTokenServerAddr = ":5001"
+
+// TokenServerCmd is the cobra command that corresponds to the token-server subcommand
+var TokenServerCmd = &cobra.Command{
+	Use:   "token-server <config>",
+	Short: "`token-server` issues the tokens of the token access controller",
+	Long:  "`token-server` issues the tokens of the token access controller, authenticating users against an htpasswd file and granting them the actions of an access control list",
+	Run: func(cmd *cobra.Command, args []string) {
+		// the token server does not use the storage
+		config, err := resolveConfigurationWith(args, configuration.ParseWithoutStorage)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		server, err := tokenserver.New(config.TokenServer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if printJWKS {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(server.JWKS()); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to encode jwks: %v", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		addr := config.TokenServer.Addr
+		if addr == "" {
+			addr = defaultTokenServerAddr
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/token", server)
+		httpServer := &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		tls := config.TokenServer.TLS
+		if tls.Certificate != "" {
+			dcontext.GetLogger(ctx).Infof("token server listening on %v, tls", addr)
+			err = httpServer.ListenAndServeTLS(tls.Certificate, tls.Key)
+		} else {
+			dcontext.GetLogger(ctx).Infof("token server listening on %v", addr)
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "token server failed: %v", err)
+			os.Exit(1)
+		}
+	},
+}
@@ -19,6 +19,10 @@ import (
 type blobStore struct {
 	driver  driver.StorageDriver
 	statter distribution.BlobStatter
+
+	// inflight records the blobs written or linked while an online garbage
+	// collection is running, it may be nil.
+	inflight *InFlight
 }
 
 var _ distribution.BlobProvider = &blobStore{}
@@ -62,6 +66,7 @@ func (bs *blobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekC
 // only be used for small objects, such as manifests. This implemented as a convenience for other Put implementations
 func (bs *blobStore) Put(ctx context.Context, mediaType string, p []byte) (v1.Descriptor, error) {
 	dgst := digest.FromBytes(p)
+	bs.inflight.Add(dgst)
 	desc, err := bs.statter.Stat(ctx, dgst)
 	if err == nil {
 		// content already present
@@ -133,6 +138,8 @@ func (bs *blobStore) path(dgst digest.Digest) (string, error) {
 // link links the path to the provided digest by writing the digest into the
 // target file. Caller must ensure that the blob actually exists.
 func (bs *blobStore) link(ctx context.Context, path string, dgst digest.Digest) error {
+	bs.inflight.Add(dgst)
+
 	// The contents of the "link" file are the exact string contents of the
 	// digest, which is specified in that package.
 	return bs.driver.PutContent(ctx, path, []byte(dgst))
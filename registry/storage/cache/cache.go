@@ -3,6 +3,7 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/distribution/distribution/v3"
@@ -17,6 +18,30 @@ type BlobDescriptorCacheProvider interface {
 	RepositoryScoped(repo string) (distribution.BlobDescriptorService, error)
 }
 
+// TagIndex keeps a lexically sorted index of the tags of repositories, so
+// that they can be listed without walking the storage backend. The index of a
+// repository is only used once it was set from the tags in the storage
+// backend.
+type TagIndex interface {
+	// List returns up to n of the indexed tags of the repository following
+	// last, or all of them if n is not positive. It reports whether the
+	// tags of the repository are indexed.
+	List(ctx context.Context, repo, last string, n int) ([]string, bool, error)
+
+	// Set indexes tags as the tags of the repository.
+	Set(ctx context.Context, repo string, tags []string) error
+
+	// Add adds a tag to the index of the repository.
+	Add(ctx context.Context, repo, tag string) error
+
+	// Remove removes a tag from the index of the repository.
+	Remove(ctx context.Context, repo, tag string) error
+
+	// Invalidate discards the index of the repository, which is set again
+	// the next time its tags are listed.
+	Invalidate(ctx context.Context, repo string) error
+}
+
 // ValidateDescriptor provides a helper function to ensure that caches have
 // common criteria for admitting descriptors.
 func ValidateDescriptor(desc v1.Descriptor) error {
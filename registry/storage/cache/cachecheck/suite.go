@@ -186,3 +186,53 @@ func checkBlobDescriptorCacheClear(ctx context.Context, t *testing.T, provider c
 		t.Fatalf("expected error statting deleted blob: %v", err)
 	}
 }
+
+// CheckTagIndex takes a tag index implementation through a common set of
+// operations.
+func CheckTagIndex(t *testing.T, index cache.TagIndex) {
+	ctx := context.Background()
+
+	if _, indexed, err := index.List(ctx, "foo/bar", "", 0); err != nil || indexed {
+		t.Fatalf("expected the repository not to be indexed: indexed=%t, err=%v", indexed, err)
+	}
+
+	if err := index.Add(ctx, "foo/bar", "pushed"); err != nil {
+		t.Fatalf("unexpected error adding tag: %v", err)
+	}
+	if err := index.Set(ctx, "foo/bar", []string{"b", "a", "c"}); err != nil {
+		t.Fatalf("unexpected error indexing tags: %v", err)
+	}
+	if err := index.Add(ctx, "foo/bar", "d"); err != nil {
+		t.Fatalf("unexpected error adding tag: %v", err)
+	}
+	if err := index.Remove(ctx, "foo/bar", "b"); err != nil {
+		t.Fatalf("unexpected error removing tag: %v", err)
+	}
+
+	for _, tc := range []struct {
+		last     string
+		n        int
+		expected []string
+	}{
+		{"", 0, []string{"a", "c", "d", "pushed"}},
+		{"", 2, []string{"a", "c"}},
+		{"c", 2, []string{"d", "pushed"}},
+		{"b", 0, []string{"c", "d", "pushed"}},
+		{"pushed", 0, []string{}},
+	} {
+		tags, indexed, err := index.List(ctx, "foo/bar", tc.last, tc.n)
+		if err != nil || !indexed {
+			t.Fatalf("expected the repository to be indexed: indexed=%t, err=%v", indexed, err)
+		}
+		if len(tags) != len(tc.expected) || (len(tags) > 0 && !reflect.DeepEqual(tags, tc.expected)) {
+			t.Fatalf("last=%q n=%d: unexpected tags %v, expected %v", tc.last, tc.n, tags, tc.expected)
+		}
+	}
+
+	if err := index.Invalidate(ctx, "foo/bar"); err != nil {
+		t.Fatalf("unexpected error invalidating index: %v", err)
+	}
+	if _, indexed, err := index.List(ctx, "foo/bar", "", 0); err != nil || indexed {
+		t.Fatalf("expected the repository not to be indexed: indexed=%t, err=%v", indexed, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3/registry/storage/cache/cachecheck"
 	"github.com/redis/go-redis/v9"
@@ -48,3 +49,60 @@ func TestRedisBlobDescriptorCacheProvider(t *testing.T) {
 
 	cachecheck.CheckBlobDescriptorCache(t, NewRedisBlobDescriptorCacheProvider(pool))
 }
+
+// TestRedisTagIndex exercises a live redis instance using the tag index
+// implementation.
+func TestRedisTagIndex(t *testing.T) {
+	if redisAddr == "" {
+		redisAddr = os.Getenv("TEST_REGISTRY_STORAGE_CACHE_REDIS_ADDR")
+	}
+
+	if redisAddr == "" {
+		t.Skip("please set -test.registry.storage.cache.redis.addr to test tag index against redis")
+	}
+
+	pool := redis.NewClient(&redis.Options{
+		Addr:       redisAddr,
+		MaxRetries: 3,
+		PoolSize:   2,
+	})
+
+	ctx := context.Background()
+	if err := pool.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+
+	cachecheck.CheckTagIndex(t, NewRedisTagIndex(pool, 0))
+
+	// the index and the tags written without it expire
+	index := NewRedisTagIndex(pool, time.Minute)
+	if err := index.Add(ctx, "foo/unindexed", "a"); err != nil {
+		t.Fatalf("unexpected error adding tag: %v", err)
+	}
+	if err := index.Set(ctx, "foo/indexed", []string{"a"}); err != nil {
+		t.Fatalf("unexpected error indexing tags: %v", err)
+	}
+	if err := pool.PExpire(ctx, "repository::{foo/indexed}::tags", time.Second).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Add(ctx, "foo/indexed", "b"); err != nil {
+		t.Fatalf("unexpected error adding tag: %v", err)
+	}
+	for repo, maxTTL := range map[string]time.Duration{"foo/unindexed": time.Minute, "foo/indexed": time.Second} {
+		ttl, err := pool.PTTL(ctx, "repository::{"+repo+"}::tags").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl <= 0 || ttl > maxTTL {
+			t.Fatalf("%s: unexpected lifetime %v, expected at most %v", repo, ttl, maxTTL)
+		}
+	}
+
+	// an empty index is still indexed
+	if err := index.Set(ctx, "foo/empty", nil); err != nil {
+		t.Fatalf("unexpected error indexing tags: %v", err)
+	}
+	if tags, indexed, err := index.List(ctx, "foo/empty", "", 0); err != nil || !indexed || len(tags) != 0 {
+		t.Fatalf("expected the repository to be indexed without tags: tags=%v, indexed=%t, err=%v", tags, indexed, err)
+	}
+}
@@ -0,0 +1,131 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// tagIndexBatchSize is the number of tags added at once when indexing the
+// tags of a repository.
+const tagIndexBatchSize = 1000
+
+// DefaultTagIndexTTL is the default lifetime of the index of a repository,
+// after which it is set again from the tags in the storage backend.
+const DefaultTagIndexTTL = 24 * time.Hour
+
+// indexedMember is the member of the sorted set marking the repository as
+// indexed. It sorts before every tag, and cannot be a tag.
+const indexedMember = ""
+
+// updateTagIndex adds or removes a tag and gives the sorted set a lifetime if
+// it has none, without extending the lifetime of an index.
+var updateTagIndex = redis.NewScript(`
+redis.call(ARGV[1], KEYS[1], unpack(ARGV, 3))
+if redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// redisTagIndex provides an implementation of cache.TagIndex based on redis.
+// The tags of a repository are the members of a sorted set, all with the same
+// score so that they are sorted lexically, along with an empty member marking
+// the repository as indexed. Keeping the mark in the sorted set ensures that
+// an evicted index is not mistaken for an empty one.
+//
+// Tags are added to and removed from the set even when the repository is not
+// indexed yet, so that the tags written while the repository is being
+// indexed are not missed. A tag removed while the repository is being indexed
+// may however be indexed again, until the index is invalidated or expires.
+// The index expires after its lifetime, which also discards the tags written
+// by registries or commands not using the index.
+type redisTagIndex struct {
+	pool redis.UniversalClient
+	ttl  time.Duration
+}
+
+var _ cache.TagIndex = &redisTagIndex{}
+
+// NewRedisTagIndex returns a new redis-based cache.TagIndex using the provided
+// redis connection pool. The index of a repository expires after ttl, or
+// DefaultTagIndexTTL if ttl is not positive.
+func NewRedisTagIndex(pool redis.UniversalClient, ttl time.Duration) cache.TagIndex {
+	if ttl <= 0 {
+		ttl = DefaultTagIndexTTL
+	}
+	return &redisTagIndex{
+		pool: pool,
+		ttl:  ttl,
+	}
+}
+
+// List returns up to n tags of the repository following last, using the
+// lexical range of the sorted set.
+func (rti *redisTagIndex) List(ctx context.Context, repo, last string, n int) ([]string, bool, error) {
+	var count int64
+	if n > 0 {
+		count = int64(n)
+	}
+
+	var (
+		indexed *redis.IntCmd
+		tags    *redis.StringSliceCmd
+	)
+	_, err := rti.pool.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		indexed = pipe.ZLexCount(ctx, rti.tagsKey(repo), "["+indexedMember, "["+indexedMember)
+		// the range excludes last, and the mark when last is empty
+		tags = pipe.ZRangeByLex(ctx, rti.tagsKey(repo), &redis.ZRangeBy{Min: "(" + last, Max: "+", Count: count})
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if indexed.Val() == 0 {
+		return nil, false, nil
+	}
+	return tags.Val(), true, nil
+}
+
+// Set adds tags to the sorted set of the repository, marks it as indexed and
+// sets its lifetime.
+func (rti *redisTagIndex) Set(ctx context.Context, repo string, tags []string) error {
+	_, err := rti.pool.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for start := 0; start < len(tags); start += tagIndexBatchSize {
+			end := start + tagIndexBatchSize
+			if end > len(tags) {
+				end = len(tags)
+			}
+			members := make([]redis.Z, 0, end-start)
+			for _, tag := range tags[start:end] {
+				members = append(members, redis.Z{Member: tag})
+			}
+			pipe.ZAdd(ctx, rti.tagsKey(repo), members...)
+		}
+		pipe.ZAdd(ctx, rti.tagsKey(repo), redis.Z{Member: indexedMember})
+		pipe.PExpire(ctx, rti.tagsKey(repo), rti.ttl)
+		return nil
+	})
+	return err
+}
+
+// Add adds the tag to the sorted set of the repository.
+func (rti *redisTagIndex) Add(ctx context.Context, repo, tag string) error {
+	return updateTagIndex.Run(ctx, rti.pool, []string{rti.tagsKey(repo)}, "ZADD", rti.ttl.Milliseconds(), 0, tag).Err()
+}
+
+// Remove removes the tag from the sorted set of the repository.
+func (rti *redisTagIndex) Remove(ctx context.Context, repo, tag string) error {
+	return updateTagIndex.Run(ctx, rti.pool, []string{rti.tagsKey(repo)}, "ZREM", rti.ttl.Milliseconds(), tag).Err()
+}
+
+// Invalidate deletes the sorted set of the repository along with its mark.
+func (rti *redisTagIndex) Invalidate(ctx context.Context, repo string) error {
+	return rti.pool.Del(ctx, rti.tagsKey(repo)).Err()
+}
+
+func (rti *redisTagIndex) tagsKey(repo string) string {
+	return "repository::{" + repo + "}::tags"
+}
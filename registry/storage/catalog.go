@@ -6,7 +6,9 @@ import (
 	"io"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
 )
@@ -15,6 +17,12 @@ import (
 // Because it's a quite expensive operation, it should only be used when building up
 // an initial set of repositories.
 func (reg *registry) Repositories(ctx context.Context, repos []string, last string) (int, error) {
+	return reg.RepositoriesWithPrefix(ctx, repos, "", last)
+}
+
+// RepositoriesWithPrefix returns a list, or partial list, of the repositories
+// whose name starts with prefix. Only the directory of the prefix is walked.
+func (reg *registry) RepositoriesWithPrefix(ctx context.Context, repos []string, prefix, last string) (int, error) {
 	filledBuffer := false
 	foundRepos := 0
 
@@ -22,37 +30,17 @@ func (reg *registry) Repositories(ctx context.Context, repos []string, last stri
 		return 0, errors.New("Attempted to list 0 repositories")
 	}
 
-	root, err := pathFor(repositoriesRootPathSpec{})
-	if err != nil {
-		return 0, err
-	}
-
-	startAfter := ""
-	if last != "" {
-		startAfter, err = pathFor(manifestsPathSpec{name: last})
-		if err != nil {
-			return 0, err
-		}
-	}
-
-	err = reg.blobStore.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
-		err := handleRepository(fileInfo, root, last, func(repoPath string) error {
-			repos[foundRepos] = repoPath
-			foundRepos += 1
-			return nil
-		})
-		if err != nil {
-			return err
-		}
-
+	err := reg.walkRepositories(ctx, prefix, last, func(repoPath string) error {
 		// if we've filled our slice, no need to walk any further
 		if foundRepos == len(repos) {
 			filledBuffer = true
 			return driver.ErrFilledBuffer
 		}
 
+		repos[foundRepos] = repoPath
+		foundRepos += 1
 		return nil
-	}, driver.WithStartAfterHint(startAfter))
+	})
 
 	if err != nil {
 		return foundRepos, err
@@ -69,18 +57,91 @@ func (reg *registry) Repositories(ctx context.Context, repos []string, last stri
 
 // Enumerate applies ingester to each repository
 func (reg *registry) Enumerate(ctx context.Context, ingester func(string) error) error {
+	return reg.EnumeratePrefix(ctx, "", ingester)
+}
+
+// EnumeratePrefix applies ingester to each repository whose name starts with
+// prefix.
+func (reg *registry) EnumeratePrefix(ctx context.Context, prefix string, ingester func(string) error) error {
+	return reg.walkRepositories(ctx, prefix, "", ingester)
+}
+
+// walkRepositories calls fn with the repositories whose name starts with
+// prefix and which are after last, in the order of the walk. The walk starts
+// from the directory of the prefix and stops once it is past the prefix, so
+// that other repositories are not walked. fn may return ErrFilledBuffer to
+// stop the walk.
+func (reg *registry) walkRepositories(ctx context.Context, prefix, last string, fn func(repoPath string) error) error {
 	root, err := pathFor(repositoriesRootPathSpec{})
 	if err != nil {
 		return err
 	}
 
-	err = reg.blobStore.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
-		return handleRepository(fileInfo, root, "", ingester)
-	})
+	from, rest := root, prefix
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir := prefix[:i]
+		if _, err := reference.WithName(dir); err != nil {
+			// no repository can have a name starting with the prefix
+			return nil
+		}
+		from, rest = path.Join(root, dir), prefix[i+1:]
+	}
+
+	startAfter := ""
+	if last != "" {
+		startAfter, err = pathFor(manifestsPathSpec{name: last})
+		if err != nil {
+			return err
+		}
+	}
 
+	err = reg.blobStore.driver.Walk(ctx, from, func(fileInfo driver.FileInfo) error {
+		if rest != "" {
+			child, _, _ := strings.Cut(fileInfo.Path()[len(from)+1:], "/")
+			if !strings.HasPrefix(child, rest) {
+				// the names starting with the prefix are contiguous in
+				// the order of the walk, whether the walk is in the order
+				// of the paths or of the object keys.
+				if child > rest {
+					return driver.ErrFilledBuffer
+				}
+				return driver.ErrSkipDir
+			}
+		}
+		return handleRepository(fileInfo, root, last, fn)
+	}, driver.WithStartAfterHint(startAfter))
+	if err != nil && from != root {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			// no repository has a name in the directory of the prefix
+			return nil
+		}
+	}
 	return err
 }
 
+// LastPushed returns the last time a manifest was pushed to or tagged in the
+// named repository, or the zero time if none was.
+func LastPushed(ctx context.Context, storageDriver driver.StorageDriver, name string) (time.Time, error) {
+	manifestsPath, err := pathFor(manifestsPathSpec{name: name})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var last time.Time
+	err = storageDriver.Walk(ctx, manifestsPath, func(fileInfo driver.FileInfo) error {
+		if !fileInfo.IsDir() && path.Base(fileInfo.Path()) == "link" && fileInfo.ModTime().After(last) {
+			last = fileInfo.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return time.Time{}, err
+		}
+	}
+	return last, nil
+}
+
 // Remove removes a repository from storage
 func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
 	root, err := pathFor(repositoriesRootPathSpec{})
@@ -88,7 +149,15 @@ func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
 		return err
 	}
 	repoDir := path.Join(root, name.Name())
-	return reg.driver.Delete(ctx, repoDir)
+	if err := reg.driver.Delete(ctx, repoDir); err != nil {
+		return err
+	}
+	if reg.tagIndex != nil {
+		if err := reg.tagIndex.Invalidate(ctx, name.Name()); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error invalidating the tag index of %s: %v", name.Name(), err)
+		}
+	}
+	return nil
 }
 
 // lessPath returns true if one path a is less than path b.
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
@@ -197,6 +200,96 @@ func TestCatalogEnumerate(t *testing.T) {
 	}
 }
 
+// listRecordingDriver records the directories listed by walks.
+type listRecordingDriver struct {
+	driver.StorageDriver
+	listed []string
+}
+
+func (d *listRecordingDriver) List(ctx context.Context, path string) ([]string, error) {
+	d.listed = append(d.listed, path)
+	return d.StorageDriver.List(ctx, path)
+}
+
+func (d *listRecordingDriver) Walk(ctx context.Context, path string, f driver.WalkFn, options ...func(*driver.WalkOptions)) error {
+	return driver.WalkFallback(ctx, d, path, f, options...)
+}
+
+func TestCatalogPrefix(t *testing.T) {
+	env := setupFS(t)
+	d := &listRecordingDriver{StorageDriver: env.driver}
+	reg, err := NewRegistry(env.ctx, d)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	prefixEnumerator := reg.(distribution.RepositoryPrefixEnumerator)
+
+	for _, tc := range []struct {
+		prefix   string
+		expected []string
+	}{
+		{prefix: "foo/", expected: []string{"foo/a", "foo/b", "foo/d/in"}},
+		{prefix: "foo", expected: []string{"foo/a", "foo/b", "foo/d/in", "foo-bar/a", "foo-bar/b"}},
+		{prefix: "foo-", expected: []string{"foo-bar/a", "foo-bar/b"}},
+		{prefix: "foo/d/", expected: []string{"foo/d/in"}},
+		{prefix: "foo/d/i", expected: []string{"foo/d/in"}},
+		{prefix: "te", expected: []string{"test"}},
+		{prefix: "baz/"},
+		{prefix: "Foo/"},
+		{prefix: "../"},
+	} {
+		d.listed = nil
+		var repos []string
+		err := prefixEnumerator.EnumeratePrefix(env.ctx, tc.prefix, func(repo string) error {
+			repos = append(repos, repo)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error enumerating repositories: %v", tc.prefix, err)
+		}
+		if !reflect.DeepEqual(repos, tc.expected) {
+			t.Fatalf("%s: unexpected repositories: %v", tc.prefix, repos)
+		}
+		for _, listed := range d.listed {
+			if strings.Contains(listed, "/repositories/bar") {
+				t.Fatalf("%s: unexpected directory listed: %s", tc.prefix, listed)
+			}
+		}
+	}
+
+	p := make([]string, 2)
+	n, err := prefixEnumerator.RepositoriesWithPrefix(env.ctx, p, "foo", "")
+	if err != nil || !reflect.DeepEqual(p[:n], []string{"foo/a", "foo/b"}) {
+		t.Fatalf("unexpected first page: %v, %v", p[:n], err)
+	}
+	n, err = prefixEnumerator.RepositoriesWithPrefix(env.ctx, p, "foo", p[n-1])
+	if err != nil || !reflect.DeepEqual(p[:n], []string{"foo/d/in", "foo-bar/a"}) {
+		t.Fatalf("unexpected second page: %v, %v", p[:n], err)
+	}
+	n, err = prefixEnumerator.RepositoriesWithPrefix(env.ctx, p, "foo", p[n-1])
+	if err != io.EOF || !reflect.DeepEqual(p[:n], []string{"foo-bar/b"}) {
+		t.Fatalf("unexpected last page: %v, %v", p[:n], err)
+	}
+}
+
+func TestLastPushed(t *testing.T) {
+	env := setupFS(t)
+
+	before := time.Now().Add(-time.Minute)
+	last, err := LastPushed(env.ctx, env.driver, "foo/a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if last.Before(before) {
+		t.Fatalf("unexpected last push time: %v", last)
+	}
+
+	last, err = LastPushed(env.ctx, env.driver, "foo/unknown")
+	if err != nil || !last.IsZero() {
+		t.Fatalf("unexpected last push time of an unknown repository: %v, %v", last, err)
+	}
+}
+
 func testEq(a, b []string, size int) bool {
 	for cnt := 0; cnt < size-1; cnt++ {
 		if a[cnt] != b[cnt] {
@@ -1,6 +1,7 @@
 package inmemory
 
 import (
+	"context"
 	"testing"
 
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
@@ -15,6 +16,44 @@ func TestInMemoryDriverSuite(t *testing.T) {
 	testsuites.Driver(t, newDriverConstructor)
 }
 
+// TestWriterSize ensures that the size of a writer counts the bytes written
+// to it before they are committed, as with the other drivers.
+func TestWriterSize(t *testing.T) {
+	ctx := context.Background()
+	d := New()
+
+	w, err := d.Writer(ctx, "/file", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if size := w.Size(); size != int64(len("content")) {
+		t.Fatalf("unexpected size before commit: %d", size)
+	}
+	if err := w.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if size := w.Size(); size != int64(len("content")) {
+		t.Fatalf("unexpected size after commit: %d", size)
+	}
+
+	w, err = d.Writer(ctx, "/file", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("appended")); err != nil {
+		t.Fatal(err)
+	}
+	if size := w.Size(); size != int64(len("contentappended")) {
+		t.Fatalf("unexpected size of appending writer before commit: %d", size)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func BenchmarkInMemoryDriverSuite(b *testing.B) {
 	testsuites.BenchDriver(b, newDriverConstructor)
 }
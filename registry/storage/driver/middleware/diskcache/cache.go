@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"container/list"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tmpDirectory is the directory of the cache root in which content is
+// written before it is added to the cache. Storage paths are absolute, so
+// that they never conflict with it.
+const tmpDirectory = "_tmp"
+
+// diskCache stores content in files under its root directory, mirroring the
+// storage paths, and evicts the least recently used files to stay within its
+// maximum size.
+type diskCache struct {
+	root    string
+	maxSize int64
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the cacheEntry of each path, from the most to the least
+	// recently used.
+	lru  *list.List
+	size int64
+}
+
+type cacheEntry struct {
+	path string
+	size int64
+}
+
+// newDiskCache returns a cache of at most maxSize bytes stored in root,
+// which indexes the files left in root by a previous cache, ordered by
+// their modification time.
+func newDiskCache(root string, maxSize int64) (*diskCache, error) {
+	c := &diskCache{
+		root:    root,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	tmp := filepath.Join(root, tmpDirectory)
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tmp, 0o700); err != nil {
+		return nil, err
+	}
+
+	type cachedFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cachedFile
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p == tmp {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, cachedFile{
+			path:    "/" + filepath.ToSlash(rel),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		c.entries[f.path] = c.lru.PushFront(&cacheEntry{path: f.path, size: f.size})
+		c.size += f.size
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.evict()
+	sizeGauge.Set(float64(c.size))
+
+	return c, nil
+}
+
+// open returns the cached file of path, or false if path is not cached.
+func (c *diskCache) open(path string) (*os.File, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[path]
+	if !ok {
+		return nil, false
+	}
+
+	localPath := c.localPath(path)
+	f, err := os.Open(localPath)
+	if err != nil {
+		// the file was removed from the cache directory
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	// keep the order of use of the files across restarts
+	now := time.Now()
+	_ = os.Chtimes(localPath, now, now)
+
+	return f, true
+}
+
+// tempFile creates a file in which content is written before being added to
+// the cache.
+func (c *diskCache) tempFile() (*os.File, error) {
+	return os.CreateTemp(filepath.Join(c.root, tmpDirectory), "blob-")
+}
+
+// add moves the temporary file tmpName of the given size to the cache as the
+// content of path, evicting the least recently used files if the cache
+// exceeds its maximum size.
+func (c *diskCache) add(path string, tmpName string, size int64) error {
+	if size > c.maxSize {
+		return os.Remove(tmpName)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	localPath := c.localPath(path)
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o700); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, localPath); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if e, ok := c.entries[path]; ok {
+		entry := e.Value.(*cacheEntry)
+		c.size += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(e)
+	} else {
+		c.entries[path] = c.lru.PushFront(&cacheEntry{path: path, size: size})
+		c.size += size
+	}
+	c.evict()
+	sizeGauge.Set(float64(c.size))
+
+	return nil
+}
+
+// invalidate removes the cached files of path and its subpaths.
+func (c *diskCache) invalidate(path string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for p, e := range c.entries {
+		if p == path || strings.HasPrefix(p, prefix) {
+			c.remove(e)
+		}
+	}
+	sizeGauge.Set(float64(c.size))
+}
+
+// evict removes the least recently used files until the cache is within its
+// maximum size. It must be called with the mutex held.
+func (c *diskCache) evict() {
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+		evictions.Inc(1)
+	}
+}
+
+// remove removes the cached file of an entry. It must be called with the
+// mutex held.
+func (c *diskCache) remove(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+	c.lru.Remove(e)
+	delete(c.entries, entry.path)
+	c.size -= entry.size
+
+	localPath := c.localPath(entry.path)
+	_ = os.Remove(localPath)
+	// remove the directory of the file if it is now empty
+	_ = os.Remove(filepath.Dir(localPath))
+}
+
+// localPath returns the path of the cached file of a storage path.
+func (c *diskCache) localPath(path string) string {
+	return filepath.Join(c.root, filepath.FromSlash(path))
+}
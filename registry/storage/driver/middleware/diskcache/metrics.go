@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+var (
+	// hits is the number of reads served from the cache
+	hits = prometheus.DiskCacheNamespace.NewCounter("hits", "The number of blob reads served from the local disk cache")
+	// misses is the number of reads of cacheable content served by the
+	// storage driver
+	misses = prometheus.DiskCacheNamespace.NewCounter("misses", "The number of blob reads missing the local disk cache")
+	// evictions is the number of blobs evicted to honour the size limit
+	evictions = prometheus.DiskCacheNamespace.NewCounter("evictions", "The number of blobs evicted from the local disk cache")
+	// sizeGauge is the size of the cached content
+	sizeGauge = prometheus.DiskCacheNamespace.NewGauge("size", "The size of the blobs in the local disk cache", metrics.Bytes)
+)
+
+func init() {
+	metrics.Register(prometheus.DiskCacheNamespace)
+}
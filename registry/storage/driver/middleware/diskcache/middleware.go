@@ -0,0 +1,300 @@
+// Package middleware - local disk read-through cache of the blob data read
+// from the storage driver
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// init registers the diskcache storage middleware.
+func init() {
+	if err := storagemiddleware.Register("diskcache", newDiskCacheStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register diskcache storage middleware: %v", err)
+	}
+}
+
+// blobDataPathRegexp matches the paths of the blob data, which are
+// immutable as they are addressed by their digest. The algorithm and the
+// encoded digest are captured.
+var blobDataPathRegexp = regexp.MustCompile(`^/docker/registry/v2/blobs/([a-z0-9]+(?:[.+_-][a-z0-9]+)*)/[a-f0-9]{2}/([a-f0-9]+)/data$`)
+
+// blobsPath is the root of the blob data paths.
+const blobsPath = "/docker/registry/v2/blobs"
+
+// diskCacheStorageMiddleware caches the blob data read from the storage
+// driver on local disk. The other paths, such as the tag links and the
+// uploads, are mutable and are always read from the storage driver.
+type diskCacheStorageMiddleware struct {
+	storagedriver.StorageDriver
+	cache *diskCache
+}
+
+var _ storagedriver.StorageDriver = &diskCacheStorageMiddleware{}
+
+// newDiskCacheStorageMiddleware constructs and returns a new local disk
+// cache storage middleware.
+//
+// Required options:
+//
+//   - rootdirectory: the local directory storing the cached blobs.
+//   - maxsize: the maximum size in bytes of the cached blobs, above which
+//     the least recently used are evicted.
+func newDiskCacheStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	root, ok := options["rootdirectory"]
+	if !ok {
+		return nil, fmt.Errorf("no rootdirectory provided")
+	}
+	rootDirectory, ok := root.(string)
+	if !ok || rootDirectory == "" {
+		return nil, fmt.Errorf("rootdirectory must be a non-empty string")
+	}
+
+	maxSize, err := getInt64Option("maxsize", options)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("maxsize must be a positive number of bytes")
+	}
+
+	cache, err := newDiskCache(rootDirectory, maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize the disk cache in %s: %v", rootDirectory, err)
+	}
+
+	return &diskCacheStorageMiddleware{
+		StorageDriver: sd,
+		cache:         cache,
+	}, nil
+}
+
+func getInt64Option(key string, options map[string]interface{}) (int64, error) {
+	switch v := options[key].(type) {
+	case string:
+		n, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer, %v invalid", key, v)
+		}
+		return n, nil
+	case int64:
+		return v, nil
+	case int, uint, int32, uint32, uint64:
+		return reflect.ValueOf(v).Convert(reflect.TypeOf(int64(0))).Int(), nil
+	case nil:
+		return 0, fmt.Errorf("no %s provided", key)
+	default:
+		return 0, fmt.Errorf("%s must be an integer, %v invalid", key, v)
+	}
+}
+
+// GetContent returns the blob data from the cache, or reads it from the
+// storage driver and adds it to the cache.
+func (d *diskCacheStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	dgst, ok := cacheableDigest(path)
+	if !ok {
+		return d.StorageDriver.GetContent(ctx, path)
+	}
+
+	if f, ok := d.cache.open(path); ok {
+		defer f.Close()
+		content, err := io.ReadAll(f)
+		if err == nil {
+			hits.Inc(1)
+			return content, nil
+		}
+		dcontext.GetLogger(ctx).Warnf("diskcache: failed to read %s from the cache: %v", path, err)
+	}
+
+	misses.Inc(1)
+	content, err := d.StorageDriver.GetContent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := d.newCachingReader(ctx, io.NopCloser(bytes.NewReader(content)), path, dgst)
+	_, _ = io.Copy(io.Discard, r)
+	r.Close()
+
+	return content, nil
+}
+
+// Reader returns the blob data from the cache. Otherwise the blob data is
+// read from the storage driver, and added to the cache if it is read from
+// the start to the end.
+func (d *diskCacheStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	dgst, ok := cacheableDigest(path)
+	if !ok {
+		return d.StorageDriver.Reader(ctx, path, offset)
+	}
+
+	if f, ok := d.cache.open(path); ok {
+		if _, err := f.Seek(offset, io.SeekStart); err == nil {
+			hits.Inc(1)
+			return f, nil
+		}
+		f.Close()
+	}
+
+	misses.Inc(1)
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if err != nil || offset != 0 {
+		return rc, err
+	}
+	return d.newCachingReader(ctx, rc, path, dgst), nil
+}
+
+// PutContent invalidates the cached content of path before storing the
+// content.
+func (d *diskCacheStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	d.invalidate(path)
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+// Writer invalidates the cached content of path before writing to it.
+func (d *diskCacheStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	d.invalidate(path)
+	return d.StorageDriver.Writer(ctx, path, append)
+}
+
+// Move invalidates the cached content of both paths before moving the
+// content.
+func (d *diskCacheStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	d.invalidate(sourcePath)
+	d.invalidate(destPath)
+	return d.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+// Delete invalidates the cached content of path and its subpaths before
+// deleting them.
+func (d *diskCacheStorageMiddleware) Delete(ctx context.Context, path string) error {
+	d.invalidate(path)
+	return d.StorageDriver.Delete(ctx, path)
+}
+
+// invalidate removes the cached content of path and its subpaths, if they
+// may contain blob data.
+func (d *diskCacheStorageMiddleware) invalidate(path string) {
+	if isSubpath(path, blobsPath) || isSubpath(blobsPath, path) {
+		d.cache.invalidate(path)
+	}
+}
+
+// isSubpath returns whether p is parent or a subpath of it.
+func isSubpath(p string, parent string) bool {
+	if p == parent || parent == "/" {
+		return true
+	}
+	return len(p) > len(parent) && p[:len(parent)] == parent && p[len(parent)] == '/'
+}
+
+// cacheableDigest returns the digest of the blob data stored at path, or
+// false if path does not store blob data.
+func cacheableDigest(path string) (digest.Digest, bool) {
+	match := blobDataPathRegexp.FindStringSubmatch(path)
+	if match == nil {
+		return "", false
+	}
+	dgst := digest.NewDigestFromEncoded(digest.Algorithm(match[1]), match[2])
+	if err := dgst.Validate(); err != nil {
+		return "", false
+	}
+	return dgst, true
+}
+
+// cachingReader copies the content read from the storage driver to a
+// temporary file, which is added to the cache once the content is read
+// to the end and matches its digest.
+type cachingReader struct {
+	io.ReadCloser
+	ctx      context.Context
+	cache    *diskCache
+	path     string
+	dgst     digest.Digest
+	digester digest.Digester
+	file     *os.File
+	size     int64
+}
+
+func (d *diskCacheStorageMiddleware) newCachingReader(ctx context.Context, rc io.ReadCloser, path string, dgst digest.Digest) io.ReadCloser {
+	file, err := d.cache.tempFile()
+	if err != nil {
+		dcontext.GetLogger(ctx).Warnf("diskcache: failed to create a file to cache %s: %v", path, err)
+		return rc
+	}
+	return &cachingReader{
+		ReadCloser: rc,
+		ctx:        ctx,
+		cache:      d.cache,
+		path:       path,
+		dgst:       dgst,
+		digester:   dgst.Algorithm().Digester(),
+		file:       file,
+	}
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.file == nil {
+		return n, err
+	}
+
+	if n > 0 {
+		if _, werr := io.MultiWriter(r.file, r.digester.Hash()).Write(p[:n]); werr != nil {
+			dcontext.GetLogger(r.ctx).Warnf("diskcache: failed to cache %s: %v", r.path, werr)
+			r.discard()
+			return n, err
+		}
+		r.size += int64(n)
+	}
+	if err == io.EOF {
+		r.commit()
+	}
+	return n, err
+}
+
+// Close closes the reader of the storage driver, discarding the content
+// read if it was not read to the end.
+func (r *cachingReader) Close() error {
+	if r.file != nil {
+		r.discard()
+	}
+	return r.ReadCloser.Close()
+}
+
+// commit adds the content read to the cache if it matches its digest.
+func (r *cachingReader) commit() {
+	name := r.file.Name()
+	err := r.file.Close()
+	r.file = nil
+	if err == nil && r.digester.Digest() != r.dgst {
+		err = fmt.Errorf("content does not match digest %s", r.dgst)
+	}
+	if err == nil {
+		err = r.cache.add(r.path, name, r.size)
+	} else {
+		os.Remove(name)
+	}
+	if err != nil {
+		dcontext.GetLogger(r.ctx).Warnf("diskcache: failed to cache %s: %v", r.path, err)
+	}
+}
+
+// discard removes the content read.
+func (r *cachingReader) discard() {
+	r.file.Close()
+	os.Remove(r.file.Name())
+	r.file = nil
+}
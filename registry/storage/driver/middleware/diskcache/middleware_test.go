@@ -0,0 +1,282 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+// countingDriver counts the reads of the content of the storage driver.
+type countingDriver struct {
+	storagedriver.StorageDriver
+	reads int
+}
+
+func (d *countingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	d.reads++
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *countingDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	d.reads++
+	return d.StorageDriver.Reader(ctx, path, offset)
+}
+
+func newTestMiddleware(t *testing.T, root string, maxSize int64) (*diskCacheStorageMiddleware, *countingDriver) {
+	t.Helper()
+	backend := &countingDriver{StorageDriver: inmemory.New()}
+	sd, err := newDiskCacheStorageMiddleware(context.Background(), backend, map[string]interface{}{
+		"rootdirectory": root,
+		"maxsize":       maxSize,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+	return sd.(*diskCacheStorageMiddleware), backend
+}
+
+func blobDataPath(dgst digest.Digest) string {
+	return fmt.Sprintf("/docker/registry/v2/blobs/%s/%s/%s/data", dgst.Algorithm(), dgst.Encoded()[:2], dgst.Encoded())
+}
+
+// putBlob stores content as blob data and returns its path.
+func putBlob(t *testing.T, d storagedriver.StorageDriver, content string) string {
+	t.Helper()
+	p := blobDataPath(digest.FromString(content))
+	if err := d.PutContent(context.Background(), p, []byte(content)); err != nil {
+		t.Fatalf("unexpected error storing %s: %v", p, err)
+	}
+	return p
+}
+
+func readAt(t *testing.T, d storagedriver.StorageDriver, path string, offset int64) string {
+	t.Helper()
+	rc, err := d.Reader(context.Background(), path, offset)
+	if err != nil {
+		t.Fatalf("unexpected error reading %s: %v", path, err)
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("unexpected error reading %s: %v", path, err)
+	}
+	return string(content)
+}
+
+func expectReads(t *testing.T, backend *countingDriver, expected int) {
+	t.Helper()
+	if backend.reads != expected {
+		t.Fatalf("unexpected reads from the storage driver: %d, expected %d", backend.reads, expected)
+	}
+}
+
+func TestNewDiskCacheStorageMiddlewareOptions(t *testing.T) {
+	for name, options := range map[string]map[string]interface{}{
+		"no rootdirectory":    {"maxsize": 1024},
+		"empty rootdirectory": {"rootdirectory": "", "maxsize": 1024},
+		"no maxsize":          {"rootdirectory": t.TempDir()},
+		"invalid maxsize":     {"rootdirectory": t.TempDir(), "maxsize": "large"},
+		"negative maxsize":    {"rootdirectory": t.TempDir(), "maxsize": -1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := newDiskCacheStorageMiddleware(context.Background(), inmemory.New(), options); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	if _, err := newDiskCacheStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{
+		"rootdirectory": t.TempDir(),
+		"maxsize":       "1073741824",
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReaderCaching(t *testing.T) {
+	d, backend := newTestMiddleware(t, t.TempDir(), 1024)
+	p := putBlob(t, d, "hello, world")
+
+	if content := readAt(t, d, p, 0); content != "hello, world" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+	expectReads(t, backend, 1)
+
+	if content := readAt(t, d, p, 0); content != "hello, world" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+	if content := readAt(t, d, p, 7); content != "world" {
+		t.Fatalf("unexpected content at offset: %q", content)
+	}
+	content, err := d.GetContent(context.Background(), p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content) != "hello, world" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+	expectReads(t, backend, 1)
+}
+
+func TestGetContentCaching(t *testing.T) {
+	d, backend := newTestMiddleware(t, t.TempDir(), 1024)
+	p := putBlob(t, d, "manifest")
+
+	for i := 0; i < 2; i++ {
+		content, err := d.GetContent(context.Background(), p)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(content) != "manifest" {
+			t.Fatalf("unexpected content: %q", content)
+		}
+	}
+	expectReads(t, backend, 1)
+
+	if content := readAt(t, d, p, 3); content != "ifest" {
+		t.Fatalf("unexpected content at offset: %q", content)
+	}
+	expectReads(t, backend, 1)
+}
+
+func TestUncacheableReads(t *testing.T) {
+	d, backend := newTestMiddleware(t, t.TempDir(), 1024)
+	ctx := context.Background()
+
+	// tag links are mutable
+	link := "/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link"
+	if err := d.PutContent(ctx, link, []byte(digest.FromString("a"))); err != nil {
+		t.Fatal(err)
+	}
+	readAt(t, d, link, 0)
+	readAt(t, d, link, 0)
+	expectReads(t, backend, 2)
+
+	// the content does not match the digest of its path
+	p := blobDataPath(digest.FromString("expected"))
+	if err := d.PutContent(ctx, p, []byte("corrupted")); err != nil {
+		t.Fatal(err)
+	}
+	readAt(t, d, p, 0)
+	readAt(t, d, p, 0)
+	expectReads(t, backend, 4)
+
+	// the content is read from an offset, or not to the end
+	p = putBlob(t, d, "partial content")
+	readAt(t, d, p, 3)
+	rc, err := d.Reader(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.Read(make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	readAt(t, d, p, 0)
+	expectReads(t, backend, 7)
+	readAt(t, d, p, 0)
+	expectReads(t, backend, 7)
+}
+
+func TestEviction(t *testing.T) {
+	d, backend := newTestMiddleware(t, t.TempDir(), 25)
+	a := putBlob(t, d, "aaaaaaaaaa")
+	b := putBlob(t, d, "bbbbbbbbbb")
+	c := putBlob(t, d, "cccccccccc")
+	large := putBlob(t, d, "this blob is larger than the cache")
+
+	readAt(t, d, a, 0)
+	readAt(t, d, b, 0)
+	// use a, so that b is the least recently used
+	readAt(t, d, a, 0)
+	readAt(t, d, c, 0)
+	expectReads(t, backend, 3)
+
+	readAt(t, d, a, 0)
+	readAt(t, d, c, 0)
+	expectReads(t, backend, 3)
+	readAt(t, d, b, 0)
+	expectReads(t, backend, 4)
+
+	readAt(t, d, large, 0)
+	readAt(t, d, large, 0)
+	expectReads(t, backend, 6)
+
+	if d.cache.size > 25 {
+		t.Fatalf("unexpected cache size: %d", d.cache.size)
+	}
+}
+
+func TestInvalidation(t *testing.T) {
+	d, backend := newTestMiddleware(t, t.TempDir(), 1024)
+	ctx := context.Background()
+	p := putBlob(t, d, "deleted")
+
+	readAt(t, d, p, 0)
+	if err := d.Delete(ctx, path.Dir(p)); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+	if _, err := d.Reader(ctx, p, 0); err == nil {
+		t.Fatal("expected an error reading deleted blob data")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("unexpected error reading deleted blob data: %v", err)
+	}
+	expectReads(t, backend, 2)
+	if len(d.cache.entries) != 0 || d.cache.size != 0 {
+		t.Fatalf("unexpected cache entries after deletion: %v", d.cache.entries)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	root := t.TempDir()
+	d, _ := newTestMiddleware(t, root, 1024)
+	p := putBlob(t, d, "persisted")
+	readAt(t, d, p, 0)
+
+	// leave a partially cached blob
+	if err := os.WriteFile(filepath.Join(root, tmpDirectory, "blob-partial"), []byte("pers"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, backend := newTestMiddleware(t, root, 1024)
+	if _, err := d.StorageDriver.(*countingDriver).StorageDriver.Stat(context.Background(), p); err == nil {
+		t.Fatal("expected the new storage driver to be empty")
+	}
+	if content := readAt(t, d, p, 0); content != "persisted" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+	expectReads(t, backend, 0)
+
+	entries, err := os.ReadDir(filepath.Join(root, tmpDirectory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("unexpected temporary files: %v", entries)
+	}
+}
+
+func TestCacheableDigest(t *testing.T) {
+	dgst := digest.FromBytes(bytes.Repeat([]byte("a"), 10))
+	for path, expected := range map[string]bool{
+		blobDataPath(dgst): true,
+		"/docker/registry/v2/blobs/sha256/00/" + dgst.Encoded() + "/data":                 true,
+		"/docker/registry/v2/blobs/sha256/" + dgst.Encoded()[:2] + "/" + dgst.Encoded():   false,
+		"/docker/registry/v2/blobs/sha256/" + dgst.Encoded()[:2] + "/abc/data":            false,
+		"/docker/registry/v2/repositories/foo/_layers/sha256/" + dgst.Encoded() + "/link": false,
+		"/docker/registry/v2/repositories/foo/_uploads/" + dgst.Encoded() + "/data":       false,
+	} {
+		if _, ok := cacheableDigest(path); ok != expected {
+			t.Errorf("unexpected cacheable %v for %s", ok, path)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package middleware - replicate mirrors the content written to the storage
+// driver to a secondary storage driver
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// Name is the name the replicate storage middleware is registered as.
+const Name = "replicate"
+
+const (
+	modeSync  = "sync"
+	modeAsync = "async"
+
+	defaultQueueSize    = 1000
+	defaultMaxRetries   = 5
+	defaultRetryDelay   = time.Second
+	defaultDrainTimeout = 30 * time.Second
+)
+
+// init registers the replicate storage middleware.
+func init() {
+	if err := storagemiddleware.Register(Name, newReplicateStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register replicate storage middleware: %v", err)
+	}
+}
+
+// replicateStorageMiddleware mirrors the content put, committed, moved and
+// deleted in the storage driver to a secondary storage driver.
+type replicateStorageMiddleware struct {
+	storagedriver.StorageDriver
+	secondary  storagedriver.StorageDriver
+	replicator *replicator
+}
+
+var _ storagedriver.StorageDriver = &replicateStorageMiddleware{}
+
+// newReplicateStorageMiddleware constructs and returns a new replicate
+// storage middleware.
+//
+// Required options:
+//
+//   - secondary: the secondary storage driver, configured as the storage
+//     section of the configuration with its type and its parameters.
+//
+// Optional options:
+//
+//   - mode: "sync", the default, to replicate each operation before it
+//     returns, failing it if the replication fails, or "async" to replicate
+//     the operations in the background in the order they were done.
+//   - queuesize: the number of operations waiting to be replicated in async
+//     mode, above which the operations wait for the replication. Defaults to
+//     1000.
+//   - maxretries: the number of times a failed replication is retried.
+//     Defaults to 5.
+//   - retrydelay: the delay before retrying a failed replication, doubled
+//     on each retry. Defaults to 1s.
+//   - draintimeout: the time spent replicating the queued operations on
+//     shutdown in async mode, after which they are dropped. Defaults to 30s.
+func newReplicateStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	secondary, err := SecondaryDriver(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := modeSync
+	if m, ok := options["mode"]; ok {
+		mode = fmt.Sprint(m)
+	}
+	if mode != modeSync && mode != modeAsync {
+		return nil, fmt.Errorf("mode must be %q or %q, %v invalid", modeSync, modeAsync, mode)
+	}
+
+	queueSize, err := getIntOption("queuesize", options, defaultQueueSize)
+	if err != nil {
+		return nil, err
+	}
+	if queueSize <= 0 {
+		return nil, fmt.Errorf("queuesize must be positive")
+	}
+	maxRetries, err := getIntOption("maxretries", options, defaultMaxRetries)
+	if err != nil {
+		return nil, err
+	}
+	if maxRetries < 0 {
+		return nil, fmt.Errorf("maxretries must not be negative")
+	}
+
+	retryDelay, err := getDurationOption("retrydelay", options, defaultRetryDelay)
+	if err != nil {
+		return nil, err
+	}
+	drainTimeout, err := getDurationOption("draintimeout", options, defaultDrainTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &replicator{
+		primary:      sd,
+		secondary:    secondary,
+		maxRetries:   maxRetries,
+		retryDelay:   retryDelay,
+		drainTimeout: drainTimeout,
+		logger:       dcontext.GetLogger(ctx),
+	}
+	if mode == modeAsync {
+		r.start(queueSize)
+	}
+
+	return &replicateStorageMiddleware{
+		StorageDriver: sd,
+		secondary:     secondary,
+		replicator:    r,
+	}, nil
+}
+
+// SecondaryDriver constructs the secondary storage driver configured in the
+// options of the replicate storage middleware.
+func SecondaryDriver(ctx context.Context, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	secondary, ok := options["secondary"]
+	if !ok {
+		return nil, fmt.Errorf("no secondary provided")
+	}
+	drivers, err := toStringMap(secondary)
+	if err != nil || len(drivers) != 1 {
+		return nil, fmt.Errorf("secondary must map a single storage driver type to its parameters")
+	}
+
+	var (
+		driverType string
+		parameters map[string]interface{}
+	)
+	for t, params := range drivers {
+		driverType = t
+		if params != nil {
+			parameters, err = toStringMap(params)
+			if err != nil {
+				return nil, fmt.Errorf("the parameters of the secondary %s driver must be a map", driverType)
+			}
+		}
+	}
+
+	driver, err := factory.Create(ctx, driverType, parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct the secondary %s driver: %v", driverType, err)
+	}
+	return driver, nil
+}
+
+// toStringMap converts a map decoded from the configuration to a map with
+// string keys.
+func toStringMap(v interface{}) (map[string]interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = value
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("%v is not a map", v)
+	}
+}
+
+func getIntOption(key string, options map[string]interface{}, defaultValue int) (int, error) {
+	switch v := options[key].(type) {
+	case string:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer, %v invalid", key, v)
+		}
+		return n, nil
+	case int:
+		return v, nil
+	case int64, uint, int32, uint32, uint64:
+		return int(reflect.ValueOf(v).Convert(reflect.TypeOf(0)).Int()), nil
+	case nil:
+		return defaultValue, nil
+	default:
+		return 0, fmt.Errorf("%s must be an integer, %v invalid", key, v)
+	}
+}
+
+func getDurationOption(key string, options map[string]interface{}, defaultValue time.Duration) (time.Duration, error) {
+	switch v := options[key].(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		return d, nil
+	case nil:
+		return defaultValue, nil
+	default:
+		return 0, fmt.Errorf("%s must be a duration, %v invalid", key, v)
+	}
+}
+
+// Close replicates the operations queued in async mode before returning, up
+// to the drain timeout. It is called when the registry shuts down.
+func (r *replicateStorageMiddleware) Close() error {
+	r.replicator.close()
+	return nil
+}
+
+// PutContent stores the content in the storage driver, then in the
+// secondary storage driver.
+func (r *replicateStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	if err := r.StorageDriver.PutContent(ctx, path, content); err != nil {
+		return err
+	}
+	return r.replicator.replicate(ctx, operation{kind: opPut, path: path, content: content})
+}
+
+// Writer returns a FileWriter of the storage driver, which copies the content
+// of path to the secondary storage driver once committed.
+func (r *replicateStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := r.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+	return &replicatingWriter{
+		FileWriter: fw,
+		replicator: r.replicator,
+		path:       path,
+	}, nil
+}
+
+// Move moves the object in the storage driver, then in the secondary
+// storage driver.
+func (r *replicateStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if err := r.StorageDriver.Move(ctx, sourcePath, destPath); err != nil {
+		return err
+	}
+	return r.replicator.replicate(ctx, operation{kind: opMove, path: destPath, sourcePath: sourcePath})
+}
+
+// Delete deletes the objects from the storage driver, then from the
+// secondary storage driver.
+func (r *replicateStorageMiddleware) Delete(ctx context.Context, path string) error {
+	if err := r.StorageDriver.Delete(ctx, path); err != nil {
+		return err
+	}
+	return r.replicator.replicate(ctx, operation{kind: opDelete, path: path})
+}
+
+// replicatingWriter replicates the content of its path once committed.
+type replicatingWriter struct {
+	storagedriver.FileWriter
+	replicator *replicator
+	path       string
+}
+
+func (w *replicatingWriter) Commit(ctx context.Context) error {
+	if err := w.FileWriter.Commit(ctx); err != nil {
+		return err
+	}
+	return w.replicator.replicate(ctx, operation{kind: opCopy, path: w.path})
+}
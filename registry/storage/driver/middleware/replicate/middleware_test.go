@@ -0,0 +1,352 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// failingDriver fails the first writes to the storage driver.
+type failingDriver struct {
+	storagedriver.StorageDriver
+
+	mutex    sync.Mutex
+	failures int
+	calls    int
+}
+
+var errInjected = errors.New("injected failure")
+
+func (d *failingDriver) fail() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.calls++
+	if d.failures > 0 {
+		d.failures--
+		return errInjected
+	}
+	return nil
+}
+
+func (d *failingDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if err := d.fail(); err != nil {
+		return err
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func newTestMiddleware(t *testing.T, options map[string]interface{}) *replicateStorageMiddleware {
+	t.Helper()
+	if _, ok := options["secondary"]; !ok {
+		options["secondary"] = map[interface{}]interface{}{
+			"filesystem": map[interface{}]interface{}{
+				"rootdirectory": t.TempDir(),
+			},
+		}
+	}
+	sd, err := newReplicateStorageMiddleware(context.Background(), inmemory.New(), options)
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+	return sd.(*replicateStorageMiddleware)
+}
+
+func expectContent(t *testing.T, d storagedriver.StorageDriver, path string, expected string) {
+	t.Helper()
+	content, err := d.GetContent(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error reading %s: %v", path, err)
+	}
+	if string(content) != expected {
+		t.Fatalf("unexpected content of %s: %q, expected %q", path, content, expected)
+	}
+}
+
+func expectNotFound(t *testing.T, d storagedriver.StorageDriver, path string) {
+	t.Helper()
+	_, err := d.Stat(context.Background(), path)
+	if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("expected %s to be missing, got %v", path, err)
+	}
+}
+
+func TestNewReplicateStorageMiddlewareOptions(t *testing.T) {
+	secondary := map[interface{}]interface{}{"inmemory": nil}
+	for name, options := range map[string]map[string]interface{}{
+		"no secondary":         {},
+		"secondary not a map":  {"secondary": "inmemory"},
+		"two secondaries":      {"secondary": map[interface{}]interface{}{"inmemory": nil, "filesystem": nil}},
+		"unknown secondary":    {"secondary": map[interface{}]interface{}{"unknown": nil}},
+		"invalid mode":         {"secondary": secondary, "mode": "eventually"},
+		"invalid queuesize":    {"secondary": secondary, "queuesize": 0},
+		"invalid maxretries":   {"secondary": secondary, "maxretries": "many"},
+		"invalid retrydelay":   {"secondary": secondary, "retrydelay": "soon"},
+		"invalid draintimeout": {"secondary": secondary, "draintimeout": 30},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := newReplicateStorageMiddleware(context.Background(), inmemory.New(), options); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestSyncReplication(t *testing.T) {
+	ctx := context.Background()
+	d := newTestMiddleware(t, map[string]interface{}{})
+
+	if err := d.PutContent(ctx, "/put", []byte("put")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectContent(t, d.secondary, "/put", "put")
+
+	// the content is replicated once committed
+	fw, err := d.Writer(ctx, "/written", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := fw.Write([]byte("writ")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectNotFound(t, d.secondary, "/written")
+
+	fw, err = d.Writer(ctx, "/written", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := fw.Write([]byte("ten")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Commit(ctx); err != nil {
+		t.Fatalf("unexpected error committing: %v", err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, d.secondary, "/written", "written")
+
+	if err := d.Move(ctx, "/written", "/moved/file"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectNotFound(t, d.secondary, "/written")
+	expectContent(t, d.secondary, "/moved/file", "written")
+
+	if err := d.Delete(ctx, "/moved"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectNotFound(t, d.secondary, "/moved/file")
+
+	// the secondary driver misses the source of the move
+	if err := d.StorageDriver.PutContent(ctx, "/unreplicated", []byte("unreplicated")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Move(ctx, "/unreplicated", "/replicated"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectContent(t, d.secondary, "/replicated", "unreplicated")
+}
+
+func TestSyncReplicationFailure(t *testing.T) {
+	ctx := context.Background()
+	d := newTestMiddleware(t, map[string]interface{}{
+		"maxretries": 2,
+		"retrydelay": "1ms",
+	})
+	secondary := &failingDriver{StorageDriver: d.secondary, failures: 2}
+	d.replicator.secondary = secondary
+
+	// the failures are retried
+	if err := d.PutContent(ctx, "/retried", []byte("retried")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectContent(t, secondary, "/retried", "retried")
+
+	secondary.failures = 3
+	if err := d.PutContent(ctx, "/failed", []byte("failed")); err == nil {
+		t.Fatal("expected an error when the replication fails")
+	}
+	expectContent(t, d.StorageDriver, "/failed", "failed")
+	expectNotFound(t, secondary, "/failed")
+	if secondary.calls != 6 {
+		t.Fatalf("unexpected number of attempts: %d", secondary.calls)
+	}
+}
+
+func TestAsyncReplication(t *testing.T) {
+	ctx := context.Background()
+	d := newTestMiddleware(t, map[string]interface{}{
+		"mode":       "async",
+		"queuesize":  "10",
+		"retrydelay": "1ms",
+	})
+	secondary := &failingDriver{StorageDriver: d.secondary, failures: 2}
+	d.replicator.secondary = secondary
+
+	if err := d.PutContent(ctx, "/first", []byte("first")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.PutContent(ctx, "/second", []byte("second")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.Move(ctx, "/first", "/moved"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.Delete(ctx, "/second"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.PutContent(ctx, "/last", []byte("last")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the operations are replicated in order
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := secondary.Stat(ctx, "/last"); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("the operations were not replicated: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	expectContent(t, secondary, "/moved", "first")
+	expectNotFound(t, secondary, "/first")
+	expectNotFound(t, secondary, "/second")
+}
+
+// blockingDriver blocks the writes to the storage driver until their
+// context is done.
+type blockingDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d *blockingDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestAsyncReplicationClose(t *testing.T) {
+	ctx := context.Background()
+	d := newTestMiddleware(t, map[string]interface{}{
+		"mode":      "async",
+		"queuesize": "10",
+	})
+
+	for _, path := range []string{"/first", "/second"} {
+		if err := d.PutContent(ctx, path, []byte(path)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the queued operations are replicated before closing
+	if err := d.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+	expectContent(t, d.secondary, "/first", "/first")
+	expectContent(t, d.secondary, "/second", "/second")
+	if d.replicator.dropped != 0 {
+		t.Fatalf("unexpected dropped operations: %d", d.replicator.dropped)
+	}
+
+	if err := d.PutContent(ctx, "/closed", []byte("closed")); err == nil {
+		t.Fatal("expected an error replicating once closed")
+	}
+}
+
+func TestAsyncReplicationCloseTimeout(t *testing.T) {
+	ctx := context.Background()
+	d := newTestMiddleware(t, map[string]interface{}{
+		"mode":         "async",
+		"queuesize":    "10",
+		"draintimeout": "10ms",
+	})
+	d.replicator.secondary = &blockingDriver{StorageDriver: d.secondary}
+
+	for _, path := range []string{"/first", "/second", "/third"} {
+		if err := d.PutContent(ctx, path, []byte(path)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the operations not replicated within the drain timeout are dropped
+	if err := d.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+	if d.replicator.dropped != 3 {
+		t.Fatalf("expected 3 dropped operations, got %d", d.replicator.dropped)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	primary := inmemory.New()
+	secondary := inmemory.New()
+
+	for path, content := range map[string]string{
+		"/blobs/a":        "a",
+		"/blobs/b":        "b",
+		"/tags/latest":    "sha256:1",
+		"/tags/unchanged": "sha256:2",
+	} {
+		if err := primary.PutContent(ctx, path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, content := range map[string]string{
+		"/blobs/a":        "a",
+		"/blobs/deleted":  "deleted",
+		"/tags/latest":    "sha256:0",
+		"/tags/unchanged": "sha256:2",
+	} {
+		if err := secondary.PutContent(ctx, path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := ReconcileResult{
+		Copied:  []string{"/blobs/b", "/tags/latest"},
+		Deleted: []string{"/blobs/deleted"},
+	}
+
+	result, err := Reconcile(ctx, primary, secondary, ReconcileOpts{DryRun: true, Delete: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(result.Copied)
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("unexpected result: %v, expected %v", result, expected)
+	}
+	expectNotFound(t, secondary, "/blobs/b")
+	expectContent(t, secondary, "/blobs/deleted", "deleted")
+
+	result, err = Reconcile(ctx, primary, secondary, ReconcileOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(result.Copied)
+	if !reflect.DeepEqual(result, ReconcileResult{Copied: expected.Copied}) {
+		t.Fatalf("unexpected result: %v", result)
+	}
+	expectContent(t, secondary, "/blobs/b", "b")
+	expectContent(t, secondary, "/tags/latest", "sha256:1")
+	expectContent(t, secondary, "/blobs/deleted", "deleted")
+
+	result, err = Reconcile(ctx, primary, secondary, ReconcileOpts{Delete: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, ReconcileResult{Deleted: expected.Deleted}) {
+		t.Fatalf("unexpected result: %v", result)
+	}
+	expectNotFound(t, secondary, "/blobs/deleted")
+}
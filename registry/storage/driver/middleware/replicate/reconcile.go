@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// maxCompareSize is the size up to which the content of the objects of both
+// drivers is compared, such as for the tag links whose content changes but
+// not their size. Larger objects are compared by size.
+const maxCompareSize = 64 << 10
+
+// ReconcileOpts contains options for Reconcile
+type ReconcileOpts struct {
+	// DryRun reports the objects to copy and delete without changing the
+	// secondary driver.
+	DryRun bool
+	// Delete deletes the objects of the secondary driver missing from the
+	// primary driver.
+	Delete bool
+}
+
+// ReconcileResult lists the objects reconciled by Reconcile
+type ReconcileResult struct {
+	// Copied are the paths of the objects copied to the secondary driver
+	Copied []string
+	// Deleted are the paths of the objects deleted from the secondary driver
+	Deleted []string
+}
+
+// Reconcile walks the primary driver and copies to the secondary driver the
+// objects it misses or whose content differs, such as the objects whose
+// replication failed. Then, if requested, it walks the secondary driver and
+// deletes the objects missing from the primary driver.
+func Reconcile(ctx context.Context, primary, secondary storagedriver.StorageDriver, opts ReconcileOpts) (ReconcileResult, error) {
+	var result ReconcileResult
+
+	err := primary.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() {
+			return nil
+		}
+		same, err := sameContent(ctx, primary, secondary, fi)
+		if err != nil || same {
+			return err
+		}
+		if !opts.DryRun {
+			if err := copyContent(ctx, primary, secondary, fi.Path()); err != nil {
+				if _, ok := err.(storagedriver.PathNotFoundError); ok {
+					// deleted since walked
+					return nil
+				}
+				return err
+			}
+		}
+		result.Copied = append(result.Copied, fi.Path())
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return result, err
+		}
+	}
+
+	if !opts.Delete {
+		return result, nil
+	}
+
+	err = secondary.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() {
+			return nil
+		}
+		if _, err := primary.Stat(ctx, fi.Path()); err == nil {
+			return nil
+		} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+		if !opts.DryRun {
+			err := secondary.Delete(ctx, fi.Path())
+			if _, ok := err.(storagedriver.PathNotFoundError); !ok && err != nil {
+				return err
+			}
+		}
+		result.Deleted = append(result.Deleted, fi.Path())
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// sameContent returns whether the secondary driver stores the same content
+// as the primary driver for the object described by fi.
+func sameContent(ctx context.Context, primary, secondary storagedriver.StorageDriver, fi storagedriver.FileInfo) (bool, error) {
+	sfi, err := secondary.Stat(ctx, fi.Path())
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	if sfi.IsDir() || sfi.Size() != fi.Size() {
+		return false, nil
+	}
+	if fi.Size() > maxCompareSize {
+		return true, nil
+	}
+
+	content, err := primary.GetContent(ctx, fi.Path())
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			// deleted since walked
+			return true, nil
+		}
+		return false, err
+	}
+	secondaryContent, err := secondary.GetContent(ctx, fi.Path())
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(content, secondaryContent), nil
+}
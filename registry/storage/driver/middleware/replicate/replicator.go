@@ -0,0 +1,221 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+type opKind int
+
+const (
+	// opPut stores the content at the path
+	opPut opKind = iota
+	// opCopy copies the content of the path from the primary driver
+	opCopy
+	// opMove moves the object at the source path to the path
+	opMove
+	// opDelete deletes the objects at the path and its subpaths
+	opDelete
+)
+
+func (k opKind) String() string {
+	switch k {
+	case opPut:
+		return "put"
+	case opCopy:
+		return "copy"
+	case opMove:
+		return "move"
+	case opDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// operation is an operation done on the primary driver to replicate on the
+// secondary driver.
+type operation struct {
+	kind       opKind
+	path       string
+	sourcePath string
+	content    []byte
+}
+
+// replicator replicates the operations done on the primary driver on the
+// secondary driver, either synchronously or in the background from a queue.
+type replicator struct {
+	primary    storagedriver.StorageDriver
+	secondary  storagedriver.StorageDriver
+	maxRetries int
+	retryDelay time.Duration
+	logger     dcontext.Logger
+
+	// queue holds the operations to replicate in the background, nil if the
+	// operations are replicated synchronously.
+	queue chan operation
+	// drainTimeout bounds the time spent replicating the queued operations
+	// once the replicator is closed.
+	drainTimeout time.Duration
+
+	mu      sync.RWMutex
+	closed  bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+	dropped int
+}
+
+// start replicates the operations in the background, in the order they are
+// queued.
+func (r *replicator) start(queueSize int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.queue = make(chan operation, queueSize)
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		for op := range r.queue {
+			if ctx.Err() != nil {
+				r.dropped++
+				continue
+			}
+			if err := r.apply(ctx, op); err != nil {
+				if ctx.Err() != nil {
+					r.dropped++
+					continue
+				}
+				r.logger.Errorf("replicate: failed to %s %s: %v", op.kind, op.path, err)
+			}
+		}
+	}()
+}
+
+// close stops the replication in the background once the queued operations
+// are replicated, or drainTimeout elapsed. The operations dropped are
+// replicated by the next reconcile.
+func (r *replicator) close() {
+	if r.queue == nil {
+		return
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.queue)
+	r.mu.Unlock()
+
+	select {
+	case <-r.done:
+	case <-time.After(r.drainTimeout):
+		r.cancel()
+		<-r.done
+	}
+	r.cancel()
+
+	if r.dropped > 0 {
+		r.logger.Warnf("replicate: %d operations were not replicated on shutdown, reconcile the secondary storage to replicate them", r.dropped)
+	}
+}
+
+// replicate replicates op, returning its error if replicated synchronously.
+func (r *replicator) replicate(ctx context.Context, op operation) error {
+	if r.queue != nil {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		if r.closed {
+			return fmt.Errorf("failed to replicate %s of %s: replicator closed", op.kind, op.path)
+		}
+		r.queue <- op
+		return nil
+	}
+	if err := r.apply(ctx, op); err != nil {
+		return fmt.Errorf("failed to replicate %s of %s: %v", op.kind, op.path, err)
+	}
+	return nil
+}
+
+// apply applies op to the secondary driver, retrying on failure.
+func (r *replicator) apply(ctx context.Context, op operation) error {
+	delay := r.retryDelay
+	for attempt := 0; ; attempt++ {
+		err := r.applyOnce(ctx, op)
+		if err == nil || attempt >= r.maxRetries {
+			return err
+		}
+		r.logger.Warnf("replicate: retrying to %s %s in %v: %v", op.kind, op.path, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (r *replicator) applyOnce(ctx context.Context, op operation) error {
+	switch op.kind {
+	case opPut:
+		return r.secondary.PutContent(ctx, op.path, op.content)
+	case opCopy:
+		return r.copy(ctx, op.path)
+	case opMove:
+		err := r.secondary.Move(ctx, op.sourcePath, op.path)
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			// the source was not replicated, copy the destination instead
+			return r.copy(ctx, op.path)
+		}
+		return err
+	case opDelete:
+		err := r.secondary.Delete(ctx, op.path)
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown operation %v", op.kind)
+	}
+}
+
+// copy copies the content of path from the primary driver to the secondary
+// driver. Content since removed from the primary driver is not copied.
+func (r *replicator) copy(ctx context.Context, path string) error {
+	err := copyContent(ctx, r.primary, r.secondary, path)
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// copyContent copies the content of path from src to dst.
+func copyContent(ctx context.Context, src, dst storagedriver.StorageDriver, path string) error {
+	rc, err := src.Reader(ctx, path, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := dst.Writer(ctx, path, false)
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+
+	if _, err := io.Copy(fw, rc); err != nil {
+		if cErr := fw.Cancel(ctx); cErr != nil {
+			return errors.Join(err, cErr)
+		}
+		return err
+	}
+	return fw.Commit(ctx)
+}
@@ -1200,7 +1200,7 @@ func (d *driver) doWalk(parentCtx context.Context, objectCount *int64, from stri
 
 		for _, walkInfo := range walkInfos {
 			// skip any results under the last skip directory
-			if prevSkipDir != "" && strings.HasPrefix(walkInfo.Path(), prevSkipDir) {
+			if prevSkipDir != "" && strings.HasPrefix(walkInfo.Path(), prevSkipDir+"/") {
 				continue
 			}
 
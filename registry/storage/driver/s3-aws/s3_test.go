@@ -833,6 +833,64 @@ func TestWalk(t *testing.T) {
 	}
 }
 
+// TestWalkSkipDirSibling ensures that skipping a directory does not skip the
+// siblings listed after it whose name starts with the name of the directory.
+func TestWalkSkipDirSibling(t *testing.T) {
+	skipCheck(t)
+
+	rootDir := t.TempDir()
+
+	drvr, err := s3DriverConstructor(rootDir, s3.StorageClassStandard)
+	if err != nil {
+		t.Fatalf("unexpected error creating driver with standard storage: %v", err)
+	}
+
+	// S3 lists "/folder1/file1" before "/folder10/file1" and "/folder1_suffix/file1"
+	fileset := []string{
+		"/folder1/file1",
+		"/folder1/subfolder/file1",
+		"/folder10/file1",
+		"/folder1_suffix/file1",
+	}
+
+	created := make([]string, 0, len(fileset))
+	for _, p := range fileset {
+		if err := drvr.PutContent(dcontext.Background(), p, []byte("content "+p)); err != nil {
+			t.Fatalf("unable to create file %s: %s", p, err)
+		}
+		created = append(created, p)
+	}
+	defer func() {
+		for _, p := range created {
+			if err := drvr.Delete(dcontext.Background(), p); err != nil {
+				t.Errorf("cleanup failed for path %s: %s", p, err)
+			}
+		}
+	}()
+
+	var walked []string
+	err = drvr.Walk(dcontext.Background(), "/", func(fileInfo storagedriver.FileInfo) error {
+		walked = append(walked, fileInfo.Path())
+		if fileInfo.Path() == "/folder1" {
+			return storagedriver.ErrSkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"/folder1",
+		// folder1 contents skipped
+		"/folder10",
+		"/folder10/file1",
+		"/folder1_suffix",
+		"/folder1_suffix/file1",
+	}
+	compareWalked(t, expected, walked)
+}
+
 func TestOverThousandBlobs(t *testing.T) {
 	skipCheck(t)
 
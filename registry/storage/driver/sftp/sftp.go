@@ -0,0 +1,686 @@
+// Package sftp provides a storagedriver.StorageDriver implementation to
+// store blobs on a remote filesystem accessed with the SSH File Transfer
+// Protocol.
+package sftp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	driverName           = "sftp"
+	defaultPort          = 22
+	defaultRootDirectory = "/var/lib/registry"
+	defaultMaxThreads    = uint64(100)
+	defaultTimeout       = 30 * time.Second
+
+	// minThreads is the minimum value for the maxthreads configuration
+	// parameter. If the driver's parameters are less than this we set
+	// the parameters to minThreads
+	minThreads = uint64(25)
+
+	// writeBufferSize is the size of the writes sent to the server, which
+	// are split in concurrent requests.
+	writeBufferSize = 1 << 20
+
+	fsyncExtension       = "fsync@openssh.com"
+	posixRenameExtension = "posix-rename@openssh.com"
+)
+
+// DriverParameters represents all configuration options available for the
+// sftp driver
+type DriverParameters struct {
+	Host                 string
+	Port                 int
+	Username             string
+	Password             string
+	PrivateKey           string
+	PrivateKeyPassphrase string
+	HostKey              string
+	KnownHosts           string
+	RootDirectory        string
+	MaxThreads           uint64
+	Timeout              time.Duration
+}
+
+func init() {
+	factory.Register(driverName, &sftpDriverFactory{})
+}
+
+// sftpDriverFactory implements the factory.StorageDriverFactory interface
+type sftpDriverFactory struct{}
+
+func (factory *sftpDriverFactory) Create(ctx context.Context, parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
+}
+
+type driver struct {
+	rootDirectory string
+	address       string
+	config        *ssh.ClientConfig
+
+	// mutex guards the connection, which is established on first use and
+	// again once it is lost.
+	mutex  sync.Mutex
+	client *sftp.Client
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation backed by a remote
+// filesystem accessed with SFTP. All provided paths will be subpaths of the
+// RootDirectory.
+type Driver struct {
+	baseEmbed
+}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - host
+// - username
+// - password or privatekey
+// - hostkey or knownhosts
+// Optional Parameters:
+// - port
+// - privatekeypassphrase
+// - rootdirectory
+// - maxthreads
+// - timeout
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	params, err := fromParametersImpl(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return New(*params)
+}
+
+func fromParametersImpl(parameters map[string]interface{}) (*DriverParameters, error) {
+	params := &DriverParameters{
+		Port:          defaultPort,
+		RootDirectory: defaultRootDirectory,
+		Timeout:       defaultTimeout,
+	}
+
+	stringParams := map[string]*string{
+		"host":                 &params.Host,
+		"username":             &params.Username,
+		"password":             &params.Password,
+		"privatekey":           &params.PrivateKey,
+		"privatekeypassphrase": &params.PrivateKeyPassphrase,
+		"hostkey":              &params.HostKey,
+		"knownhosts":           &params.KnownHosts,
+		"rootdirectory":        &params.RootDirectory,
+	}
+	for name, value := range stringParams {
+		if param, ok := parameters[name]; ok && param != nil {
+			*value = fmt.Sprint(param)
+		}
+	}
+
+	if params.Host == "" {
+		return nil, fmt.Errorf("no host parameter provided")
+	}
+	if params.Username == "" {
+		return nil, fmt.Errorf("no username parameter provided")
+	}
+	if params.Password == "" && params.PrivateKey == "" {
+		return nil, fmt.Errorf("no password or privatekey parameter provided")
+	}
+	if params.HostKey == "" && params.KnownHosts == "" {
+		return nil, fmt.Errorf("no hostkey or knownhosts parameter provided")
+	}
+	if !path.IsAbs(params.RootDirectory) {
+		return nil, fmt.Errorf("the rootdirectory parameter should be an absolute path")
+	}
+
+	if port, ok := parameters["port"]; ok && port != nil {
+		p, err := strconv.Atoi(fmt.Sprint(port))
+		if err != nil || p <= 0 || p > 65535 {
+			return nil, fmt.Errorf("the port parameter should be a valid port number")
+		}
+		params.Port = p
+	}
+
+	var err error
+	params.MaxThreads, err = base.GetLimitFromParameter(parameters["maxthreads"], minThreads, defaultMaxThreads)
+	if err != nil {
+		return nil, fmt.Errorf("maxthreads config error: %s", err.Error())
+	}
+
+	switch timeout := parameters["timeout"].(type) {
+	case string:
+		params.Timeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("the timeout parameter should be a duration: %v", err)
+		}
+	case time.Duration:
+		params.Timeout = timeout
+	case nil:
+		// do nothing
+	default:
+		return nil, fmt.Errorf("the timeout parameter should be a duration")
+	}
+	if params.Timeout < 0 {
+		return nil, fmt.Errorf("the timeout parameter should not be negative")
+	}
+
+	return params, nil
+}
+
+// New constructs a new Driver with the given parameters. The connection to
+// the server is established on first use.
+func New(params DriverParameters) (*Driver, error) {
+	config := &ssh.ClientConfig{
+		User:    params.Username,
+		Timeout: params.Timeout,
+	}
+
+	if params.PrivateKey != "" {
+		pemBytes, err := os.ReadFile(params.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the private key: %v", err)
+		}
+		var signer ssh.Signer
+		if params.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(params.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pemBytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the private key: %v", err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	if params.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(params.Password))
+	}
+
+	if params.KnownHosts != "" {
+		callback, err := knownhosts.New(params.KnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the known hosts: %v", err)
+		}
+		config.HostKeyCallback = callback
+	} else {
+		hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(params.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the host key: %v", err)
+		}
+		config.HostKeyCallback = ssh.FixedHostKey(hostKey)
+	}
+
+	d := &driver{
+		rootDirectory: params.RootDirectory,
+		address:       net.JoinHostPort(params.Host, strconv.Itoa(params.Port)),
+		config:        config,
+	}
+
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: base.NewRegulator(d, params.MaxThreads),
+			},
+		},
+	}, nil
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	rc, err := d.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	p, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, subPath string, contents []byte) error {
+	writer, err := d.Writer(ctx, subPath, false)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	_, err = io.Copy(writer, bytes.NewReader(contents))
+	if err != nil {
+		if cErr := writer.Cancel(ctx); cErr != nil {
+			return errors.Join(err, cErr)
+		}
+		return err
+	}
+	return writer.Commit(ctx)
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	c, err := d.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := c.Open(d.fullPath(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, storagedriver.PathNotFoundError{Path: path}
+		}
+		return nil, err
+	}
+
+	seekPos, err := file.Seek(offset, io.SeekStart)
+	if err != nil {
+		file.Close()
+		return nil, err
+	} else if seekPos < offset {
+		file.Close()
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+	}
+
+	return file, nil
+}
+
+func (d *driver) Writer(ctx context.Context, subPath string, append bool) (storagedriver.FileWriter, error) {
+	c, err := d.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	fullPath := d.fullPath(subPath)
+	if err := c.MkdirAll(path.Dir(fullPath)); err != nil {
+		return nil, err
+	}
+
+	fp, err := c.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+
+	var offset int64
+
+	if !append {
+		err := fp.Truncate(0)
+		if err != nil {
+			fp.Close()
+			return nil, err
+		}
+	} else {
+		n, err := fp.Seek(0, io.SeekEnd)
+		if err != nil {
+			fp.Close()
+			return nil, err
+		}
+		offset = n
+	}
+
+	return newFileWriter(c, fp, offset), nil
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the creation time.
+func (d *driver) Stat(ctx context.Context, subPath string) (storagedriver.FileInfo, error) {
+	c, err := d.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := c.Stat(d.fullPath(subPath))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, storagedriver.PathNotFoundError{Path: subPath}
+		}
+
+		return nil, err
+	}
+
+	return fileInfo{
+		path:     subPath,
+		FileInfo: fi,
+	}, nil
+}
+
+// List returns a list of the objects that are direct descendants of the given
+// path.
+func (d *driver) List(ctx context.Context, subPath string) ([]string, error) {
+	infos, err := d.readDir(subPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(infos))
+	for _, info := range infos {
+		keys = append(keys, info.Path())
+	}
+
+	return keys, nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the original
+// object. The object is renamed, replacing any object at destPath atomically
+// if the server supports the posix-rename@openssh.com extension.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	c, err := d.sftpClient()
+	if err != nil {
+		return err
+	}
+
+	source := d.fullPath(sourcePath)
+	dest := d.fullPath(destPath)
+
+	if _, err := c.Stat(source); errors.Is(err, os.ErrNotExist) {
+		return storagedriver.PathNotFoundError{Path: sourcePath}
+	}
+
+	if err := c.MkdirAll(path.Dir(dest)); err != nil {
+		return err
+	}
+
+	if _, ok := c.HasExtension(posixRenameExtension); ok {
+		return c.PosixRename(source, dest)
+	}
+	// the SFTP rename fails if the destination exists
+	if err := c.Remove(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return c.Rename(source, dest)
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths.
+func (d *driver) Delete(ctx context.Context, subPath string) error {
+	c, err := d.sftpClient()
+	if err != nil {
+		return err
+	}
+
+	fullPath := d.fullPath(subPath)
+
+	_, err = c.Stat(fullPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	} else if err != nil {
+		return storagedriver.PathNotFoundError{Path: subPath}
+	}
+
+	return c.RemoveAll(fullPath)
+}
+
+// RedirectURL returns a URL which may be used to retrieve the content stored at the given path.
+func (d *driver) RedirectURL(*http.Request, string) (string, error) {
+	return "", nil
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file and directory.
+// The file infos are returned by the listing of each directory, instead of
+// being retrieved with a Stat for each file.
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return storagedriver.WalkFallback(ctx, &walkDriver{driver: d}, path, f, options...)
+}
+
+// readDir returns the FileInfos of the direct descendants of the given path.
+func (d *driver) readDir(subPath string) ([]storagedriver.FileInfo, error) {
+	c, err := d.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	fullPath := d.fullPath(subPath)
+	infos, err := c.ReadDir(fullPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, storagedriver.PathNotFoundError{Path: subPath}
+		}
+		// a file has no descendants
+		if fi, statErr := c.Stat(fullPath); statErr == nil && !fi.IsDir() {
+			return nil, storagedriver.PathNotFoundError{Path: subPath}
+		}
+		return nil, err
+	}
+
+	fileInfos := make([]storagedriver.FileInfo, 0, len(infos))
+	for _, info := range infos {
+		fileInfos = append(fileInfos, fileInfo{
+			path:     path.Join(subPath, info.Name()),
+			FileInfo: info,
+		})
+	}
+
+	return fileInfos, nil
+}
+
+// sftpClient returns the client of the connection to the server,
+// establishing it if it is not.
+func (d *driver) sftpClient() (*sftp.Client, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	conn, err := ssh.Dial("tcp", d.address, d.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", d.address, err)
+	}
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start the sftp session: %v", err)
+	}
+	d.client = client
+
+	// forget the session once it or its connection is lost, so that the
+	// next call reconnects
+	go func() {
+		_ = client.Wait()
+		d.mutex.Lock()
+		if d.client == client {
+			d.client = nil
+		}
+		d.mutex.Unlock()
+		conn.Close()
+	}()
+
+	return client, nil
+}
+
+// fullPath returns the absolute path of a key within the Driver's storage.
+func (d *driver) fullPath(subPath string) string {
+	return path.Join(d.rootDirectory, subPath)
+}
+
+// walkDriver lists the directories walked by WalkFallback with their file
+// infos, which are then returned by Stat.
+type walkDriver struct {
+	*driver
+	infos map[string]storagedriver.FileInfo
+}
+
+func (wd *walkDriver) List(ctx context.Context, subPath string) ([]string, error) {
+	infos, err := wd.readDir(subPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if wd.infos == nil {
+		wd.infos = make(map[string]storagedriver.FileInfo, len(infos))
+	}
+	keys := make([]string, 0, len(infos))
+	for _, info := range infos {
+		wd.infos[info.Path()] = info
+		keys = append(keys, info.Path())
+	}
+
+	return keys, nil
+}
+
+func (wd *walkDriver) Stat(ctx context.Context, subPath string) (storagedriver.FileInfo, error) {
+	if info, ok := wd.infos[subPath]; ok {
+		// each path is walked once
+		delete(wd.infos, subPath)
+		return info, nil
+	}
+	return wd.driver.Stat(ctx, subPath)
+}
+
+type fileInfo struct {
+	os.FileInfo
+	path string
+}
+
+var _ storagedriver.FileInfo = fileInfo{}
+
+// Path provides the full path of the target of this file info.
+func (fi fileInfo) Path() string {
+	return fi.path
+}
+
+// Size returns current length in bytes of the file. The return value can
+// be used to write to the end of the file at path. The value is
+// meaningless if IsDir returns true.
+func (fi fileInfo) Size() int64 {
+	if fi.IsDir() {
+		return 0
+	}
+
+	return fi.FileInfo.Size()
+}
+
+// ModTime returns the modification time for the file. For backends that
+// don't have a modification time, the creation time should be returned.
+func (fi fileInfo) ModTime() time.Time {
+	return fi.FileInfo.ModTime()
+}
+
+// IsDir returns true if the path is a directory.
+func (fi fileInfo) IsDir() bool {
+	return fi.FileInfo.IsDir()
+}
+
+type fileWriter struct {
+	client    *sftp.Client
+	file      *sftp.File
+	size      int64
+	bw        *bufio.Writer
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func newFileWriter(client *sftp.Client, file *sftp.File, size int64) *fileWriter {
+	return &fileWriter{
+		client: client,
+		file:   file,
+		size:   size,
+		bw:     bufio.NewWriterSize(file, writeBufferSize),
+	}
+}
+
+func (fw *fileWriter) Write(p []byte) (int, error) {
+	if fw.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if fw.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if fw.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+	n, err := fw.bw.Write(p)
+	fw.size += int64(n)
+	return n, err
+}
+
+func (fw *fileWriter) Size() int64 {
+	return fw.size
+}
+
+func (fw *fileWriter) Close() error {
+	if fw.closed {
+		return fmt.Errorf("already closed")
+	}
+
+	if err := fw.bw.Flush(); err != nil {
+		return err
+	}
+
+	if err := fw.sync(); err != nil {
+		return err
+	}
+
+	if err := fw.file.Close(); err != nil {
+		return err
+	}
+	fw.closed = true
+	return nil
+}
+
+func (fw *fileWriter) Cancel(ctx context.Context) error {
+	if fw.closed {
+		return fmt.Errorf("already closed")
+	}
+
+	fw.cancelled = true
+	fw.file.Close()
+	return fw.client.Remove(fw.file.Name())
+}
+
+func (fw *fileWriter) Commit(ctx context.Context) error {
+	if fw.closed {
+		return fmt.Errorf("already closed")
+	} else if fw.committed {
+		return fmt.Errorf("already committed")
+	} else if fw.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+
+	if err := fw.bw.Flush(); err != nil {
+		return err
+	}
+
+	if err := fw.sync(); err != nil {
+		return err
+	}
+
+	fw.committed = true
+	return nil
+}
+
+// sync flushes the file to stable storage if the server supports the
+// fsync@openssh.com extension.
+func (fw *fileWriter) sync() error {
+	if _, ok := fw.client.HasExtension(fsyncExtension); !ok {
+		return nil
+	}
+	return fw.file.Sync()
+}
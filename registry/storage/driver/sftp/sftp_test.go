@@ -0,0 +1,375 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testUsername = "registry"
+	testPassword = "secret"
+)
+
+// testServer is an in-process SSH server serving the sftp subsystem on the
+// local filesystem.
+type testServer struct {
+	host    string
+	port    int
+	hostKey ssh.PublicKey
+	userKey ssh.PublicKey
+
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func newTestServer(tb testing.TB) *testServer {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	server := &testServer{hostKey: hostSigner.PublicKey()}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == testUsername && string(password) == testPassword {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", conn.User())
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == testUsername && server.userKey != nil &&
+				string(key.Marshal()) == string(server.userKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("public key rejected for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { listener.Close() })
+	addr := listener.Addr().(*net.TCPAddr)
+	server.host = addr.IP.String()
+	server.port = addr.Port
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mutex.Lock()
+			server.conns = append(server.conns, conn)
+			server.mutex.Unlock()
+			go serveConn(conn, config)
+		}
+	}()
+
+	return server
+}
+
+// closeConnections closes the connections accepted by the server.
+func (s *testServer) closeConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				// the payload is the length prefixed subsystem name
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				go func() {
+					defer channel.Close()
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						return
+					}
+					_ = server.Serve()
+				}()
+			}
+		}()
+	}
+}
+
+// parameters returns the parameters of a driver connecting to the server
+// with a password and storing its content in a temporary directory.
+func (s *testServer) parameters(tb testing.TB) map[string]interface{} {
+	return map[string]interface{}{
+		"host":          s.host,
+		"port":          s.port,
+		"username":      testUsername,
+		"password":      testPassword,
+		"hostkey":       string(ssh.MarshalAuthorizedKey(s.hostKey)),
+		"rootdirectory": tb.TempDir(),
+	}
+}
+
+func newDriverConstructor(tb testing.TB) testsuites.DriverConstructor {
+	server := newTestServer(tb)
+	parameters := server.parameters(tb)
+
+	return func() (storagedriver.StorageDriver, error) {
+		return FromParameters(parameters)
+	}
+}
+
+func TestSFTPDriverSuite(t *testing.T) {
+	testsuites.Driver(t, newDriverConstructor(t))
+}
+
+func BenchmarkSFTPDriverSuite(b *testing.B) {
+	testsuites.BenchDriver(b, newDriverConstructor(b))
+}
+
+func TestFromParameters(t *testing.T) {
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"host":     "sftp.example.com",
+			"username": testUsername,
+			"password": testPassword,
+			"hostkey":  "ssh-ed25519 AAAA",
+		}
+	}
+
+	params, err := fromParametersImpl(valid())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &DriverParameters{
+		Host:          "sftp.example.com",
+		Port:          defaultPort,
+		Username:      testUsername,
+		Password:      testPassword,
+		HostKey:       "ssh-ed25519 AAAA",
+		RootDirectory: defaultRootDirectory,
+		MaxThreads:    defaultMaxThreads,
+		Timeout:       defaultTimeout,
+	}
+	if !reflect.DeepEqual(params, expected) {
+		t.Fatalf("unexpected parameters: %#v, expected %#v", params, expected)
+	}
+
+	for name, update := range map[string]func(map[string]interface{}){
+		"no host":                 func(p map[string]interface{}) { delete(p, "host") },
+		"no username":             func(p map[string]interface{}) { delete(p, "username") },
+		"no password or key":      func(p map[string]interface{}) { delete(p, "password") },
+		"no host key":             func(p map[string]interface{}) { delete(p, "hostkey") },
+		"relative root directory": func(p map[string]interface{}) { p["rootdirectory"] = "registry" },
+		"invalid port":            func(p map[string]interface{}) { p["port"] = "ssh" },
+		"invalid timeout":         func(p map[string]interface{}) { p["timeout"] = "soon" },
+		"invalid maxthreads":      func(p map[string]interface{}) { p["maxthreads"] = "many" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			parameters := valid()
+			update(parameters)
+			if _, err := fromParametersImpl(parameters); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestPrivateKeyAndKnownHosts(t *testing.T) {
+	server := newTestServer(t)
+
+	_, userPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userSigner, err := ssh.NewSignerFromKey(userPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.userKey = userSigner.PublicKey()
+
+	block, err := ssh.MarshalPrivateKey(userPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(privateKey, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := fmt.Sprintf("[%s]:%d %s", server.host, server.port, ssh.MarshalAuthorizedKey(server.hostKey))
+	if err := os.WriteFile(knownHosts, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	parameters := server.parameters(t)
+	delete(parameters, "password")
+	delete(parameters, "hostkey")
+	parameters["privatekey"] = privateKey
+	parameters["knownhosts"] = knownHosts
+
+	d, err := FromParameters(parameters)
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+	ctx := context.Background()
+	if err := d.PutContent(ctx, "/file", []byte("content")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	content, err := d.GetContent(ctx, "/file")
+	if err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+	if string(content) != "content" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+}
+
+func TestHostKeyMismatch(t *testing.T) {
+	server := newTestServer(t)
+
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, err := ssh.NewPublicKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parameters := server.parameters(t)
+	parameters["hostkey"] = string(ssh.MarshalAuthorizedKey(otherPublicKey))
+	d, err := FromParameters(parameters)
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+	_, err = d.Stat(context.Background(), "/")
+	if err == nil {
+		t.Fatal("expected an error connecting to a server with another host key")
+	}
+	if errors.As(err, &storagedriver.PathNotFoundError{}) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	server := newTestServer(t)
+	d, err := FromParameters(server.parameters(t))
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, p := range []string{
+		"/a/1",
+		"/a/2/x",
+		"/a/2/y",
+		"/b/1",
+		"/b/3/z",
+		"/c",
+	} {
+		if err := d.PutContent(ctx, p, []byte(p)); err != nil {
+			t.Fatalf("unexpected error writing %s: %v", p, err)
+		}
+	}
+
+	walked := func(walk func(storagedriver.WalkFn, ...func(*storagedriver.WalkOptions)) error, hint string) []string {
+		var paths []string
+		err := walk(func(fi storagedriver.FileInfo) error {
+			if fi.Path() == "/b" {
+				return storagedriver.ErrSkipDir
+			}
+			if !fi.IsDir() && fi.Size() != int64(len(fi.Path())) {
+				t.Errorf("unexpected size of %s: %d", fi.Path(), fi.Size())
+			}
+			paths = append(paths, fi.Path())
+			return nil
+		}, storagedriver.WithStartAfterHint(hint))
+		if err != nil {
+			t.Fatalf("unexpected error walking: %v", err)
+		}
+		return paths
+	}
+
+	for _, hint := range []string{"", "/a/1", "/a/2/x", "/b", "/c"} {
+		actual := walked(func(f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+			return d.Walk(ctx, "/", f, options...)
+		}, hint)
+		expected := walked(func(f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+			return storagedriver.WalkFallback(ctx, d, "/", f, options...)
+		}, hint)
+		if !sort.StringsAreSorted(actual) || !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("unexpected walk after %q: %v, expected %v", hint, actual, expected)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	server := newTestServer(t)
+	d, err := FromParameters(server.parameters(t))
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := d.PutContent(ctx, "/file", []byte("content")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+
+	server.closeConnections()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := d.GetContent(ctx, "/file"); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("unexpected error after the connection was lost: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
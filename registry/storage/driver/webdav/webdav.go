@@ -1,23 +1,36 @@
 package webdav
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
-	"io/fs"
+	"net"
 	"net/http"
+	"os"
 	"path"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/base"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
-	"github.com/sirupsen/logrus"
 	"github.com/studio-b12/gowebdav"
 )
 
-const driverName = "webdav"
+const (
+	driverName        = "webdav"
+	defaultMaxThreads = uint64(100)
+	defaultTimeout    = 30 * time.Second
+
+	// minThreads is the minimum value for the maxthreads configuration
+	// parameter. If the driver's parameters are less than this we set
+	// the parameters to minThreads
+	minThreads = uint64(25)
+)
 
 func init() {
 	factory.Register(driverName, &webdavDriverFactory{})
@@ -31,10 +44,7 @@ func (factory *webdavDriverFactory) Create(ctx context.Context, parameters map[s
 }
 
 type driver struct {
-	c                *gowebdav.Client
-	mutex            sync.RWMutex
-	accessMutex      map[string]*sync.RWMutex
-	accessMutexMutex sync.RWMutex
+	c *gowebdav.Client
 }
 
 // baseEmbed allows us to hide the Base embed.
@@ -42,52 +52,230 @@ type baseEmbed struct {
 	base.Base
 }
 
-// Driver is a storagedriver.StorageDriver implementation backed by a local map.
-// Intended solely for example and testing purposes.
+// Driver is a storagedriver.StorageDriver implementation backed by a WebDAV
+// server.
 type Driver struct {
 	baseEmbed // embedded, hidden base driver.
 }
 
 // DriverParameters represents all configuration options available for the
-// filesystem driver
+// webdav driver
 type DriverParameters struct {
-	baseURL    string
-	username   string
-	password   string
-	httpClient http.Client
+	baseURL        string
+	username       string
+	password       string
+	maxThreads     uint64
+	timeout        time.Duration
+	headers        http.Header
+	rootCertBundle string
+	certificate    string
+	key            string
+	skipVerify     bool
 }
 
 // FromParameters constructs a new Driver with a given parameters map
-// Optional Parameters:
+// Required parameters:
 // - baseURL
+// Optional Parameters:
 // - username
 // - password
+// - maxthreads
+// - timeout
+// - headers
+// - rootcertbundle
+// - certificate
+// - key
+// - skipverify
 func FromParameters(parameters map[string]interface{}) (*Driver, error) {
-	baseURL := parameters["baseURL"]
-	username := parameters["username"]
-	password := parameters["password"]
-	params := DriverParameters{
-		baseURL:    fmt.Sprint(baseURL),
-		username:   fmt.Sprint(username),
-		password:   fmt.Sprint(password),
-		httpClient: *http.DefaultClient,
+	params, err := fromParametersImpl(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return New(*params)
+}
+
+func fromParametersImpl(parameters map[string]interface{}) (*DriverParameters, error) {
+	baseURL, ok := parameters["baseURL"]
+	if !ok || fmt.Sprint(baseURL) == "" {
+		return nil, fmt.Errorf("no baseURL parameter provided")
+	}
+
+	params := &DriverParameters{
+		baseURL: fmt.Sprint(baseURL),
+		timeout: defaultTimeout,
+	}
+	if username, ok := parameters["username"]; ok {
+		params.username = fmt.Sprint(username)
+	}
+	if password, ok := parameters["password"]; ok {
+		params.password = fmt.Sprint(password)
+	}
+
+	var err error
+	params.maxThreads, err = base.GetLimitFromParameter(parameters["maxthreads"], minThreads, defaultMaxThreads)
+	if err != nil {
+		return nil, fmt.Errorf("maxthreads config error: %s", err.Error())
+	}
+
+	switch timeout := parameters["timeout"].(type) {
+	case string:
+		params.timeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("the timeout parameter should be a duration: %v", err)
+		}
+	case time.Duration:
+		params.timeout = timeout
+	case nil:
+		// do nothing
+	default:
+		return nil, fmt.Errorf("the timeout parameter should be a duration")
+	}
+	if params.timeout < 0 {
+		return nil, fmt.Errorf("the timeout parameter should not be negative")
+	}
+
+	params.headers, err = parseHeaders(parameters["headers"])
+	if err != nil {
+		return nil, err
+	}
+
+	if rootCertBundle, ok := parameters["rootcertbundle"]; ok {
+		params.rootCertBundle = fmt.Sprint(rootCertBundle)
+	}
+	if certificate, ok := parameters["certificate"]; ok {
+		params.certificate = fmt.Sprint(certificate)
+	}
+	if key, ok := parameters["key"]; ok {
+		params.key = fmt.Sprint(key)
+	}
+	if (params.certificate == "") != (params.key == "") {
+		return nil, fmt.Errorf("the certificate and key parameters should be provided together")
+	}
+
+	switch skipVerify := parameters["skipverify"].(type) {
+	case string:
+		b, err := strconv.ParseBool(skipVerify)
+		if err != nil {
+			return nil, fmt.Errorf("the skipverify parameter should be a boolean")
+		}
+		params.skipVerify = b
+	case bool:
+		params.skipVerify = skipVerify
+	case nil:
+		// do nothing
+	default:
+		return nil, fmt.Errorf("the skipverify parameter should be a boolean")
 	}
-	return New(params), nil
+
+	return params, nil
+}
+
+// parseHeaders parses the headers parameter, a map of header names to a
+// value or a list of values.
+func parseHeaders(param interface{}) (http.Header, error) {
+	headers := http.Header{}
+	add := func(name interface{}, value interface{}) error {
+		switch value := value.(type) {
+		case []interface{}:
+			for _, v := range value {
+				headers.Add(fmt.Sprint(name), fmt.Sprint(v))
+			}
+		case []string:
+			for _, v := range value {
+				headers.Add(fmt.Sprint(name), v)
+			}
+		case nil:
+			return fmt.Errorf("the headers parameter has no value for %v", name)
+		default:
+			headers.Add(fmt.Sprint(name), fmt.Sprint(value))
+		}
+		return nil
+	}
+
+	switch param := param.(type) {
+	case map[string]interface{}:
+		for name, value := range param {
+			if err := add(name, value); err != nil {
+				return nil, err
+			}
+		}
+	case map[interface{}]interface{}:
+		for name, value := range param {
+			if err := add(name, value); err != nil {
+				return nil, err
+			}
+		}
+	case http.Header:
+		for name, values := range param {
+			if err := add(name, values); err != nil {
+				return nil, err
+			}
+		}
+	case nil:
+		// do nothing
+	default:
+		return nil, fmt.Errorf("the headers parameter should be a map of header names to values")
+	}
+	return headers, nil
 }
 
 // New constructs a new Driver.
-func New(params DriverParameters) *Driver {
-	c := gowebdav.NewClient(params.baseURL, params.username, params.password)
+func New(params DriverParameters) (*Driver, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: params.skipVerify,
+	}
+	if params.rootCertBundle != "" {
+		pem, err := os.ReadFile(params.rootCertBundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the root certificate bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in the root certificate bundle %s", params.rootCertBundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if params.certificate != "" {
+		cert, err := tls.LoadX509KeyPair(params.certificate, params.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// the timeout bounds connecting and waiting for the responses, not
+	// streaming the content which may be arbitrarily large
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   params.timeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = params.timeout
+	transport.ResponseHeaderTimeout = params.timeout
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxIdleConnsPerHost = int(params.maxThreads)
+
+	// the credentials are sent with every request: negotiating the
+	// authentication would buffer the streamed uploads in memory to retry them
+	c := gowebdav.NewAuthClient(params.baseURL, gowebdav.NewPreemptiveAuth(&basicAuth{
+		username: params.username,
+		password: params.password,
+	}))
+	c.SetTransport(transport)
+	for name, values := range params.headers {
+		for _, value := range values {
+			c.SetHeader(name, value)
+		}
+	}
+
 	return &Driver{
 		baseEmbed: baseEmbed{
 			Base: base.Base{
-				StorageDriver: &driver{
-					c:           c,
-					accessMutex: make(map[string]*sync.RWMutex),
-				},
+				StorageDriver: base.NewRegulator(&driver{c: c}, params.maxThreads),
 			},
 		},
-	}
+	}, nil
 }
 
 // Implement the storagedriver.StorageDriver interface.
@@ -95,42 +283,108 @@ func (d *driver) Name() string {
 	return driverName
 }
 
+// GetContent retrieves the content stored at "path" as a []byte.
+// This should primarily be used for small objects.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	content, err := d.c.Read(path)
+	if err != nil {
+		return nil, d.pathError(path, err)
+	}
+	return content, nil
+}
+
 // PutContent stores the []byte content at a location designated by "path".
 // This should primarily be used for small objects.
 func (d *driver) PutContent(ctx context.Context, path string, content []byte) error {
-	logrus.Debugf("PutContent: %s / Bytes: %d", path, len(content))
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-	err := d.c.Write(path, content, fs.ModeAppend)
-	return err
+	return d.c.Write(path, content, 0)
 }
 
 // Reader retrieves an io.ReadCloser for the content stored at "path"
 // with a given byte offset.
 // May be used to resume reading a stream by providing a nonzero offset.
 func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
-	logrus.Debugf("Reader: %s", path)
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-	return d.c.ReadStreamRange(path, offset, 0)
+	fi, err := d.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+
+	var rc io.ReadCloser
+	switch {
+	case offset >= fi.Size():
+		// servers reject ranges starting at or after the end of the content
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	case offset == 0:
+		rc, err = d.c.ReadStream(path)
+	default:
+		// the length of the range is given, so that it is also read
+		// correctly from servers ignoring ranges
+		rc, err = d.c.ReadStreamRange(path, offset, fi.Size()-offset)
+	}
+	if err != nil {
+		return nil, d.pathError(path, err)
+	}
+	return rc, nil
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" after the call to Commit.
+// A path may be appended to if it has not been committed, or if the
+// existing committed content is zero length.
+//
+// The content written is uploaded to a segment file, one per writer, in a
+// directory next to the path. The segments are concatenated at the path on
+// Commit. Appending to committed content moves it to the first segment.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	segments := segmentsPath(path)
+	if !append {
+		if err := d.c.RemoveAll(segments); err != nil {
+			return nil, err
+		}
+		return d.newWebdavWriter(path, 0, 0), nil
+	}
+
+	infos, err := d.readSegments(path)
+	switch {
+	case err == nil:
+		var size int64
+		for _, info := range infos {
+			size += info.Size()
+		}
+		return d.newWebdavWriter(path, len(infos), size), nil
+	case !gowebdav.IsErrNotFound(err):
+		return nil, err
+	}
+
+	// nothing was written since the last commit, continue the committed
+	// content if any
+	fi, err := d.Stat(ctx, path)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return d.newWebdavWriter(path, 0, 0), nil
+		}
+		return nil, err
+	}
+	if err := d.c.MkdirAll(segments, 0); err != nil {
+		return nil, err
+	}
+	if err := d.c.Rename(path, segmentPath(path, 0), true); err != nil {
+		return nil, d.pathError(path, err)
+	}
+	return d.newWebdavWriter(path, 1, fi.Size()), nil
 }
 
 // Stat retrieves the FileInfo for the given path, including the current
 // size in bytes and the creation time.
 func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
-	logrus.Debugf("Stat: %s", path)
-	// d.mutex.Lock()
-	// defer d.mutex.Unlock()
 	fileInfo, err := d.c.Stat(path)
 	if err != nil {
-		// Maybe it is a directory, we have to test it with a '/' at the end
-		tmp_path := strings.TrimSuffix(path, "/") + "/"
-		fileInfo, err = d.c.Stat(tmp_path)
-		if err != nil {
-			// its neither a file or a directory
-			logrus.Debugf("Path not found: %v / Fi: %v", err, fileInfo)
-			return nil, storagedriver.PathNotFoundError{Path: path}
-		}
+		return nil, d.pathError(path, err)
+	}
+	if fileInfo == nil {
+		return nil, storagedriver.PathNotFoundError{Path: path}
 	}
 	fi := storagedriver.FileInfoFields{
 		Path:    path,
@@ -146,26 +400,25 @@ func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo,
 // List returns a list of the objects that are direct descendants of the
 // given path.
 func (d *driver) List(ctx context.Context, path string) ([]string, error) {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
 	dir, err := d.Stat(ctx, path)
 	if err != nil {
-		return nil, storagedriver.PathNotFoundError{Path: path}
+		return nil, err
 	}
 	if !dir.IsDir() {
-		return nil, storagedriver.Error{Detail: fmt.Errorf("not a directory")}
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	fileInfos, err := d.c.ReadDir(path)
+	if err != nil {
+		return nil, d.pathError(path, err)
 	}
-	fileInfos, _ := d.c.ReadDir(path)
 	files := []string{}
 	for _, i := range fileInfos {
 		name := i.Name()
-		// if i.IsDir() {
-		// 	name = strings.TrimSuffix(name, "/") + "/"
-		// }
-		file := strings.TrimSuffix(path, "/") + "/" + name
-		files = append(files, file)
+		if strings.HasSuffix(name, segmentsSuffix) {
+			continue
+		}
+		files = append(files, strings.TrimSuffix(path, "/")+"/"+name)
 	}
-	logrus.Debugf("List: %v", files)
 	return files, nil
 }
 
@@ -174,33 +427,39 @@ func (d *driver) List(ctx context.Context, path string) ([]string, error) {
 // Note: This may be no more efficient than a copy followed by a delete for
 // many implementations.
 func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-	// err := d.c.Connect()
-	// if err != nil {
-	// 	return storagedriver.Error{Detail: err}
-	// }
+	if _, err := d.Stat(ctx, sourcePath); err != nil {
+		return err
+	}
 	dir, _ := path.Split(destPath)
-	err := d.c.MkdirAll(dir, fs.ModeAppend)
-	if err != nil {
-		return storagedriver.Error{Detail: err}
+	if err := d.c.MkdirAll(dir, 0); err != nil {
+		return err
 	}
-	err = d.c.Rename(sourcePath, destPath, true)
-	if err != nil {
-		return storagedriver.Error{Detail: err}
+	if err := d.c.Rename(sourcePath, destPath, true); err != nil {
+		return d.pathError(sourcePath, err)
 	}
 	return nil
 }
 
-// Delete recursively deletes all objects stored at "path" and its subpaths.
+// Delete recursively deletes all objects stored at "path" and its subpaths,
+// along with the segments written to "path" and not committed.
 func (d *driver) Delete(ctx context.Context, path string) error {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-	// err := d.c.Connect()
-	// if err != nil {
-	// 	return storagedriver.Error{Detail: err}
-	// }
-	return d.c.Remove(path)
+	found := false
+	for _, p := range []string{path, segmentsPath(path)} {
+		if _, err := d.Stat(ctx, p); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				continue
+			}
+			return err
+		}
+		found = true
+		if err := d.c.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return storagedriver.PathNotFoundError{Path: path}
+	}
+	return nil
 }
 
 // RedirectURL returns a URL which the client of the request r may use
@@ -219,3 +478,45 @@ func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
 func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
 	return storagedriver.WalkFallback(ctx, d, path, f, options...)
 }
+
+// pathError returns PathNotFoundError if err reports that path was not
+// found by the server, and err otherwise, such as for transport errors.
+func (d *driver) pathError(path string, err error) error {
+	if gowebdav.IsErrNotFound(err) {
+		return storagedriver.PathNotFoundError{Path: path}
+	}
+	return err
+}
+
+// basicAuth is a gowebdav.Authenticator sending the basic authentication
+// credentials, if any, with every request.
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a *basicAuth) Authorize(c *http.Client, rq *http.Request, path string) error {
+	if a.username != "" || a.password != "" {
+		rq.SetBasicAuth(a.username, a.password)
+	}
+	return nil
+}
+
+func (a *basicAuth) Verify(c *http.Client, rs *http.Response, path string) (bool, error) {
+	if rs.StatusCode == http.StatusUnauthorized {
+		return false, gowebdav.NewPathError("Authorize", path, rs.StatusCode)
+	}
+	return false, nil
+}
+
+func (a *basicAuth) Close() error {
+	return nil
+}
+
+func (a *basicAuth) Clone() gowebdav.Authenticator {
+	return a
+}
+
+func (a *basicAuth) String() string {
+	return "BasicAuth"
+}
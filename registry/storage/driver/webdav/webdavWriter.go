@@ -2,37 +2,85 @@ package webdav
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"os"
+	"sort"
 
 	"github.com/studio-b12/gowebdav"
 )
 
+// segmentsSuffix is appended to a path to name the directory of the segments
+// written to it and not committed yet. The suffix cannot be part of a valid
+// storage path, so that the directory never conflicts with stored content.
+const segmentsSuffix = "~segments"
+
+// commitName names the file the segments are concatenated to in the
+// directory of the segments, before it is moved to the committed path.
+const commitName = "commit"
+
+// errWriterCancelled aborts the upload of a cancelled writer.
+var errWriterCancelled = errors.New("writer cancelled")
+
+// segmentsPath returns the directory of the segments written to path.
+func segmentsPath(path string) string {
+	return path + segmentsSuffix
+}
+
+// segmentPath returns the path of the nth segment written to path. The
+// segments are named so that they sort in the order they were written.
+func segmentPath(path string, n int) string {
+	return fmt.Sprintf("%s/%010d", segmentsPath(path), n)
+}
+
+// readSegments returns the segments written to path, sorted in the order
+// they were written.
+func (d *driver) readSegments(path string) ([]os.FileInfo, error) {
+	infos, err := d.c.ReadDir(segmentsPath(path))
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]os.FileInfo, 0, len(infos))
+	for _, info := range infos {
+		// skip the concatenation of an interrupted commit
+		if info.Name() == commitName {
+			continue
+		}
+		segments = append(segments, info)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Name() < segments[j].Name()
+	})
+	return segments, nil
+}
+
+// webdavWriter uploads the content written to it to a new segment of the
+// path, streaming it through a pipe.
 type webdavWriter struct {
-	Reader         *io.PipeReader
-	size           int64
-	writerInternal *io.PipeWriter
-	closed         bool
-	committed      bool
-	cancelled      bool
-	client         *gowebdav.Client
-	path           string
+	driver  *driver
+	path    string
+	segment int
+	size    int64
+
+	pipe   *io.PipeWriter
+	upload chan error
+
+	closed    bool
+	committed bool
+	cancelled bool
 }
 
-func (d *driver) newWebdavWriter(c *gowebdav.Client, path string) *webdavWriter {
-	fileReader, fileInternalWriter := io.Pipe()
+func (d *driver) newWebdavWriter(path string, segment int, size int64) *webdavWriter {
 	return &webdavWriter{
-		Reader:         fileReader,
-		writerInternal: fileInternalWriter,
-		size:           0, // Size returns the number of bytes written to this FileWriter.
-		client:         c,
-		path:           path,
+		driver:  d,
+		path:    path,
+		segment: segment,
+		size:    size,
 	}
 }
 
 func (fw *webdavWriter) Write(p []byte) (int, error) {
-	//log.Printf("Write(): Path: %s Data: %s\n", fw.path, string(p))
 	if fw.closed {
 		return 0, fmt.Errorf("already closed")
 	} else if fw.committed {
@@ -40,18 +88,17 @@ func (fw *webdavWriter) Write(p []byte) (int, error) {
 	} else if fw.cancelled {
 		return 0, fmt.Errorf("already cancelled")
 	}
-	n, err := fw.writerInternal.Write(p)
-	if err != nil {
-		log.Printf("Write Err: %+v\n", err)
+
+	if fw.pipe == nil {
+		fw.startUpload()
 	}
+	n, err := fw.pipe.Write(p)
 	fw.size += int64(n)
-	//log.Printf("fwWrite: Write %d bytes (%d)", len(p), fw.size)
 	return n, err
 }
 
 // Size returns the number of bytes written to this FileWriter.
 func (fw *webdavWriter) Size() int64 {
-	log.Printf("fwSize(): %s:%d", fw.path, fw.size)
 	return fw.size
 }
 
@@ -60,10 +107,9 @@ func (fw *webdavWriter) Close() error {
 		return fmt.Errorf("already closed")
 	}
 
-	if err := fw.writerInternal.Close(); err != nil {
+	if err := fw.finishUpload(nil); err != nil {
 		return err
 	}
-
 	fw.closed = true
 	return nil
 }
@@ -74,12 +120,9 @@ func (fw *webdavWriter) Cancel(ctx context.Context) error {
 	}
 
 	fw.cancelled = true
-	fw.writerInternal.Close()
-	// err := fw.client.Connect()
-	// if err != nil {
-	// 	return err
-	// }
-	return fw.client.Remove(fw.path)
+	// the upload fails with the cancellation
+	_ = fw.finishUpload(errWriterCancelled)
+	return fw.driver.c.RemoveAll(segmentsPath(fw.path))
 }
 
 func (fw *webdavWriter) Commit(ctx context.Context) error {
@@ -90,7 +133,96 @@ func (fw *webdavWriter) Commit(ctx context.Context) error {
 	} else if fw.cancelled {
 		return fmt.Errorf("already cancelled")
 	}
-	log.Printf("fwCommit()")
+
+	if err := fw.finishUpload(nil); err != nil {
+		return err
+	}
+	if err := fw.concatenate(); err != nil {
+		return err
+	}
 	fw.committed = true
 	return nil
 }
+
+// startUpload starts uploading the segment of the writer from a pipe.
+func (fw *webdavWriter) startUpload() {
+	pr, pw := io.Pipe()
+	fw.pipe = pw
+	fw.upload = make(chan error, 1)
+
+	go func() {
+		err := fw.driver.c.WriteStream(segmentPath(fw.path, fw.segment), pr, 0)
+		// fail the writes if the upload stopped before the end of the pipe
+		if err != nil {
+			pr.CloseWithError(err)
+		} else {
+			pr.Close()
+		}
+		fw.upload <- err
+	}()
+}
+
+// finishUpload closes the pipe, with err if not nil, and waits for the
+// upload of the segment to finish.
+func (fw *webdavWriter) finishUpload(err error) error {
+	if fw.pipe == nil {
+		return nil
+	}
+	fw.pipe.CloseWithError(err)
+	fw.pipe = nil
+	return <-fw.upload
+}
+
+// concatenate stores the concatenation of the segments at the path of the
+// writer and deletes them. The segments are concatenated next to them and
+// moved to the path, so that readers never see partially committed content.
+func (fw *webdavWriter) concatenate() error {
+	c := fw.driver.c
+	segments := segmentsPath(fw.path)
+
+	infos, err := fw.driver.readSegments(fw.path)
+	if err != nil && !gowebdav.IsErrNotFound(err) {
+		return err
+	}
+
+	switch len(infos) {
+	case 0:
+		// nothing was written
+		if err := c.Write(fw.path, nil, 0); err != nil {
+			return err
+		}
+	case 1:
+		if err := c.Rename(segments+"/"+infos[0].Name(), fw.path, true); err != nil {
+			return err
+		}
+	default:
+		pr, pw := io.Pipe()
+		go func() {
+			for _, info := range infos {
+				rc, err := c.ReadStream(segments + "/" + info.Name())
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				_, err = io.Copy(pw, rc)
+				rc.Close()
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+			pw.Close()
+		}()
+		commit := segments + "/" + commitName
+		err := c.WriteStream(commit, pr, 0)
+		// stop reading the segments if the upload failed
+		pr.CloseWithError(err)
+		if err != nil {
+			return err
+		}
+		if err := c.Rename(commit, fw.path, true); err != nil {
+			return err
+		}
+	}
+	return c.RemoveAll(segments)
+}
@@ -0,0 +1,246 @@
+package webdav
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"golang.org/x/net/webdav"
+)
+
+// newWebdavServer starts an in-process WebDAV server storing its content in
+// a temporary directory.
+func newWebdavServer(tb testing.TB) *httptest.Server {
+	server := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.Dir(tb.TempDir()),
+		LockSystem: webdav.NewMemLS(),
+	})
+	tb.Cleanup(server.Close)
+	return server
+}
+
+func newDriverConstructor(tb testing.TB) testsuites.DriverConstructor {
+	server := newWebdavServer(tb)
+
+	return func() (storagedriver.StorageDriver, error) {
+		return FromParameters(map[string]interface{}{
+			"baseURL": server.URL,
+		})
+	}
+}
+
+func TestWebdavDriverSuite(t *testing.T) {
+	testsuites.Driver(t, newDriverConstructor(t))
+}
+
+func BenchmarkWebdavDriverSuite(b *testing.B) {
+	testsuites.BenchDriver(b, newDriverConstructor(b))
+}
+
+func TestFromParameters(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		parameters map[string]interface{}
+		expected   *DriverParameters
+	}{
+		{
+			name:       "no baseURL",
+			parameters: map[string]interface{}{},
+		},
+		{
+			name: "defaults",
+			parameters: map[string]interface{}{
+				"baseURL": "http://webdav",
+			},
+			expected: &DriverParameters{
+				baseURL:    "http://webdav",
+				maxThreads: defaultMaxThreads,
+				timeout:    defaultTimeout,
+				headers:    http.Header{},
+			},
+		},
+		{
+			name: "all parameters",
+			parameters: map[string]interface{}{
+				"baseURL":        "https://webdav",
+				"username":       "user",
+				"password":       "secret",
+				"maxthreads":     50,
+				"timeout":        "5s",
+				"rootcertbundle": "/ca.pem",
+				"certificate":    "/client.pem",
+				"key":            "/client.key",
+				"skipverify":     "true",
+				"headers": map[interface{}]interface{}{
+					"X-Single": "value",
+					"X-Multi":  []interface{}{"one", "two"},
+				},
+			},
+			expected: &DriverParameters{
+				baseURL:        "https://webdav",
+				username:       "user",
+				password:       "secret",
+				maxThreads:     50,
+				timeout:        5 * time.Second,
+				rootCertBundle: "/ca.pem",
+				certificate:    "/client.pem",
+				key:            "/client.key",
+				skipVerify:     true,
+				headers: http.Header{
+					"X-Single": {"value"},
+					"X-Multi":  {"one", "two"},
+				},
+			},
+		},
+		{
+			name: "invalid timeout",
+			parameters: map[string]interface{}{
+				"baseURL": "http://webdav",
+				"timeout": "soon",
+			},
+		},
+		{
+			name: "invalid skipverify",
+			parameters: map[string]interface{}{
+				"baseURL":    "http://webdav",
+				"skipverify": "maybe",
+			},
+		},
+		{
+			name: "certificate without key",
+			parameters: map[string]interface{}{
+				"baseURL":     "http://webdav",
+				"certificate": "/client.pem",
+			},
+		},
+		{
+			name: "invalid headers",
+			parameters: map[string]interface{}{
+				"baseURL": "http://webdav",
+				"headers": "X-Single: value",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params, err := fromParametersImpl(tc.parameters)
+			if tc.expected == nil {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(params, tc.expected) {
+				t.Fatalf("unexpected parameters: %#v, expected %#v", params, tc.expected)
+			}
+		})
+	}
+}
+
+// TestTLSAndHeaders checks that the driver verifies the server with the
+// root certificate bundle and sends the configured headers.
+func TestTLSAndHeaders(t *testing.T) {
+	handler := &webdav.Handler{
+		FileSystem: webdav.Dir(t.TempDir()),
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Registry") != "webdav" {
+			http.Error(w, "missing header", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	rootCertBundle := filepath.Join(t.TempDir(), "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(rootCertBundle, pemBytes, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	d, err := FromParameters(map[string]interface{}{
+		"baseURL":        server.URL,
+		"rootcertbundle": rootCertBundle,
+		"headers":        map[string]interface{}{"X-Registry": "webdav"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+	if err := d.PutContent(ctx, "/file", []byte("content")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	content, err := d.GetContent(ctx, "/file")
+	if err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+	if string(content) != "content" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+
+	// the server certificate is not trusted without the bundle
+	d, err = FromParameters(map[string]interface{}{
+		"baseURL": server.URL,
+		"headers": map[string]interface{}{"X-Registry": "webdav"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+	if _, err := d.GetContent(ctx, "/file"); err == nil {
+		t.Fatal("expected an error with an untrusted server certificate")
+	}
+}
+
+// TestTransportError checks that failures to reach the server are not
+// reported as missing paths.
+func TestTransportError(t *testing.T) {
+	server := newWebdavServer(t)
+	d, err := FromParameters(map[string]interface{}{
+		"baseURL": server.URL,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := d.GetContent(ctx, "/missing"); err == nil {
+		t.Fatal("expected an error reading a missing path")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("unexpected error reading a missing path: %v", err)
+	}
+
+	server.Close()
+	for name, op := range map[string]func() error{
+		"GetContent": func() error {
+			_, err := d.GetContent(ctx, "/missing")
+			return err
+		},
+		"Stat": func() error {
+			_, err := d.Stat(ctx, "/missing")
+			return err
+		},
+		"Reader": func() error {
+			_, err := d.Reader(ctx, "/missing", 0)
+			return err
+		},
+	} {
+		err := op()
+		if err == nil {
+			t.Fatalf("%s: expected an error with the server closed", name)
+		}
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			t.Fatalf("%s: unexpected PathNotFoundError with the server closed", name)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
@@ -16,17 +18,41 @@ func emit(format string, a ...interface{}) {
 	fmt.Printf(format+"\n", a...)
 }
 
+// DefaultGCGracePeriod is the default duration before the start of an online
+// garbage collection during which modified content is kept.
+const DefaultGCGracePeriod = time.Hour
+
 // GCOpts contains options for garbage collector
 type GCOpts struct {
 	DryRun         bool
 	RemoveUntagged bool
+
+	// KeepTagHistory keeps, when removing untagged manifests, the given
+	// number of manifests each tag most recently referenced before its
+	// current one, so that the tag can be rolled back to them.
+	KeepTagHistory int
+
+	// Online allows the garbage collection to run while the registry is
+	// serving writes. Content written, tagged or linked after the mark
+	// phase started, or within GracePeriod before it, is not removed.
+	Online bool
+
+	// GracePeriod extends the protection of online garbage collection to
+	// content modified shortly before the mark phase started.
+	GracePeriod time.Duration
+
+	// InFlight, if set, records the content referenced by a registry in the
+	// same process during an online garbage collection. Without it, only
+	// the modification time of the stored content is considered.
+	InFlight *InFlight
 }
 
 // ManifestDel contains manifest structure which will be deleted
 type ManifestDel struct {
-	Name   string
-	Digest digest.Digest
-	Tags   []string
+	Name    string
+	Digest  digest.Digest
+	Tags    []string
+	Subject digest.Digest
 }
 
 // MarkAndSweep performs a mark and sweep of registry data
@@ -36,6 +62,15 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
 	}
 
+	var inflight *InFlight
+	if opts.Online && opts.InFlight != nil {
+		inflight = opts.InFlight
+		inflight.begin()
+		defer inflight.end()
+	}
+	// content modified after cutoff is in use when collecting online
+	cutoff := time.Now().Add(-opts.GracePeriod)
+
 	// mark
 	markSet := make(map[digest.Digest]struct{})
 	deleteLayerSet := make(map[string][]digest.Digest)
@@ -63,6 +98,17 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
 		}
 
+		markBlob := markBlobFunc(repoName, markSet)
+
+		var keptHistory map[digest.Digest]struct{}
+		if opts.RemoveUntagged {
+			keptHistory, err = keptTagHistory(ctx, storageDriver, repository.Tags(ctx), repoName, opts.KeepTagHistory)
+			if err != nil {
+				return fmt.Errorf("failed to retrieve tag history of repo %s: %v", repoName, err)
+			}
+		}
+
+		untaggedStart := len(manifestArr)
 		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
 			if opts.RemoveUntagged {
 				// fetch all tags where this manifest is the latest one
@@ -70,7 +116,8 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 				if err != nil {
 					return fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
 				}
-				if len(tags) == 0 {
+				_, kept := keptHistory[dgst]
+				if len(tags) == 0 && !kept {
 					// fetch all tags from repository
 					// all of these tags could contain manifest in history
 					// which means that we need check (and delete) those references when deleting manifest
@@ -82,7 +129,13 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 						}
 						return fmt.Errorf("failed to retrieve tags %v", err)
 					}
-					manifestArr = append(manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags})
+					// remember the subject, referrers of a marked
+					// manifest are kept even though they are untagged
+					subject, err := subjectDigest(ctx, manifestService, dgst)
+					if err != nil {
+						return err
+					}
+					manifestArr = append(manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags, Subject: subject})
 					return nil
 				}
 			}
@@ -90,15 +143,11 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			emit("%s: marking manifest %s ", repoName, dgst)
 			markSet[dgst] = struct{}{}
 
-			return markManifestReferences(dgst, manifestService, ctx, func(d digest.Digest) bool {
-				_, marked := markSet[d]
-				if !marked {
-					markSet[d] = struct{}{}
-					emit("%s: marking blob %s", repoName, d)
-				}
-				return marked
-			})
+			return markManifestReferences(dgst, manifestService, ctx, markBlob)
 		})
+		if err == nil {
+			err = markReferrers(ctx, repoName, manifestArr[untaggedStart:], markSet, manifestService, markBlob)
+		}
 
 		if err != nil {
 			// In certain situations such as unfinished uploads, deleting all
@@ -132,16 +181,56 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		return fmt.Errorf("failed to mark: %v", err)
 	}
 
+	if opts.Online {
+		err = markRecent(ctx, storageDriver, registry, repositoryEnumerator, manifestArr, markSet, inflight, cutoff)
+		if err != nil {
+			return fmt.Errorf("failed to mark recently used content: %v", err)
+		}
+	}
+
 	manifestArr = unmarkReferencedManifest(manifestArr, markSet)
 
+	// inUse reports whether content which was not marked has been used since
+	// the mark phase started, and must be kept when collecting online.
+	inUse := func(p string, dgst digest.Digest) (bool, error) {
+		if !opts.Online {
+			return false, nil
+		}
+		if inflight.Contains(dgst) {
+			return true, nil
+		}
+		fi, err := storageDriver.Stat(ctx, p)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				return false, nil
+			}
+			return false, err
+		}
+		return fi.ModTime().After(cutoff), nil
+	}
+
 	// sweep
 	vacuum := NewVacuum(ctx, storageDriver)
 	if !opts.DryRun {
 		for _, obj := range manifestArr {
+			if opts.Online {
+				// the manifest got tagged or referenced after it was
+				// marked, it is kept by the next collection
+				if inflight.Contains(obj.Digest) {
+					emit("%s: manifest in use, skipping deletion: %s", obj.Name, obj.Digest)
+					continue
+				}
+			}
 			err = vacuum.RemoveManifest(obj.Name, obj.Digest, obj.Tags)
 			if err != nil {
 				return fmt.Errorf("failed to delete manifest %s: %v", obj.Digest, err)
 			}
+			if obj.Subject != "" {
+				err = vacuum.RemoveReferrer(obj.Name, obj.Subject, obj.Digest)
+				if err != nil {
+					return fmt.Errorf("failed to delete referrer link %s of manifest %s: %v", obj.Digest, obj.Subject, err)
+				}
+			}
 		}
 	}
 	blobService := registry.Blobs()
@@ -162,6 +251,18 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		if opts.DryRun {
 			continue
 		}
+		blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			return err
+		}
+		used, err := inUse(blobPath, dgst)
+		if err != nil {
+			return fmt.Errorf("failed to check blob %s: %v", dgst, err)
+		}
+		if used {
+			emit("blob in use, skipping deletion: %s", dgst)
+			continue
+		}
 		err = vacuum.RemoveBlob(string(dgst))
 		if err != nil {
 			return fmt.Errorf("failed to delete blob %s: %v", dgst, err)
@@ -170,10 +271,26 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 
 	for repo, dgsts := range deleteLayerSet {
 		for _, dgst := range dgsts {
+			if _, ok := markSet[dgst]; ok {
+				// marked after the repository was enumerated
+				continue
+			}
 			emit("%s: layer link eligible for deletion: %s", repo, dgst)
 			if opts.DryRun {
 				continue
 			}
+			linkPath, err := pathFor(layerLinkPathSpec{name: repo, digest: dgst})
+			if err != nil {
+				return err
+			}
+			used, err := inUse(linkPath, dgst)
+			if err != nil {
+				return fmt.Errorf("failed to check layer link %s of repo %s: %v", dgst, repo, err)
+			}
+			if used {
+				emit("%s: layer link in use, skipping deletion: %s", repo, dgst)
+				continue
+			}
 			err = vacuum.RemoveLayer(repo, dgst)
 			if err != nil {
 				return fmt.Errorf("failed to delete layer link %s of repo %s: %v", dgst, repo, err)
@@ -184,6 +301,117 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 	return err
 }
 
+// markBlobFunc returns an ingester for markManifestReferences which adds
+// digests to markSet, reporting whether they were already marked.
+func markBlobFunc(repoName string, markSet map[digest.Digest]struct{}) func(digest.Digest) bool {
+	return func(d digest.Digest) bool {
+		_, marked := markSet[d]
+		if !marked {
+			markSet[d] = struct{}{}
+			emit("%s: marking blob %s", repoName, d)
+		}
+		return marked
+	}
+}
+
+// markRecent marks the manifests which were pushed, tagged or referenced
+// after cutoff, along with their references and referrers. Such content was
+// written by clients while the mark phase was running and may not have been
+// seen by it.
+func markRecent(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, repositoryEnumerator distribution.RepositoryEnumerator, manifestArr []ManifestDel, markSet map[digest.Digest]struct{}, inflight *InFlight, cutoff time.Time) error {
+	untagged := make(map[string][]ManifestDel)
+	for _, obj := range manifestArr {
+		untagged[obj.Name] = append(untagged[obj.Name], obj)
+	}
+
+	return repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+		manifestService, err := repository.Manifests(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to construct manifest service: %v", err)
+		}
+
+		markBlob := markBlobFunc(repoName, markSet)
+		markManifest := func(dgst digest.Digest) error {
+			if _, ok := markSet[dgst]; ok {
+				return nil
+			}
+			exists, err := manifestService.Exists(ctx, dgst)
+			if err != nil || !exists {
+				return err
+			}
+			emit("%s: marking recently used manifest %s", repoName, dgst)
+			markSet[dgst] = struct{}{}
+			return markManifestReferences(dgst, manifestService, ctx, markBlob)
+		}
+
+		for _, obj := range untagged[repoName] {
+			if inflight.Contains(obj.Digest) {
+				if err := markManifest(obj.Digest); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Revision, tag and referrer links are rewritten when manifests are
+		// pushed or tagged, their targets are in use if they are recent.
+		manifestsPath, err := pathFor(manifestsPathSpec{name: repoName})
+		if err != nil {
+			return err
+		}
+		err = walkRecentLinks(ctx, storageDriver, manifestsPath, cutoff, markManifest)
+		if err != nil {
+			return err
+		}
+
+		// Layer links are rewritten when blobs are pushed or mounted, even
+		// if the blob data already exists.
+		layersPath, err := pathFor(layersPathSpec{name: repoName})
+		if err != nil {
+			return err
+		}
+		err = walkRecentLinks(ctx, storageDriver, layersPath, cutoff, func(dgst digest.Digest) error {
+			markBlob(dgst)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return markReferrers(ctx, repoName, untagged[repoName], markSet, manifestService, markBlob)
+	})
+}
+
+// walkRecentLinks calls ingester with the target of every link below root
+// which was modified after cutoff. A missing root is not an error.
+func walkRecentLinks(ctx context.Context, storageDriver driver.StorageDriver, root string, cutoff time.Time, ingester func(digest.Digest) error) error {
+	err := storageDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" || !fileInfo.ModTime().After(cutoff) {
+			return nil
+		}
+		content, err := storageDriver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		dgst, err := digest.Parse(string(content))
+		if err != nil {
+			return err
+		}
+		return ingester(dgst)
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
 // unmarkReferencedManifest filters out manifest present in markSet
 func unmarkReferencedManifest(manifestArr []ManifestDel, markSet map[digest.Digest]struct{}) []ManifestDel {
 	filtered := make([]ManifestDel, 0)
@@ -196,6 +424,47 @@ func unmarkReferencedManifest(manifestArr []ManifestDel, markSet map[digest.Dige
 	return filtered
 }
 
+// subjectDigest returns the digest of the subject declared by the manifest,
+// or an empty digest if the manifest has no subject.
+func subjectDigest(ctx context.Context, manifestService distribution.ManifestService, dgst digest.Digest) (digest.Digest, error) {
+	manifest, err := manifestService.Get(ctx, dgst)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+	}
+	if subject := ManifestSubject(manifest); subject != nil {
+		return subject.Digest, nil
+	}
+	return "", nil
+}
+
+// markReferrers marks the untagged manifests whose subject is marked, along
+// with their references. Referrers may themselves be the subject of other
+// referrers, so candidates are revisited until no more manifests get marked.
+func markReferrers(ctx context.Context, repoName string, untagged []ManifestDel, markSet map[digest.Digest]struct{}, manifestService distribution.ManifestService, ingester func(digest.Digest) bool) error {
+	for marked := true; marked; {
+		marked = false
+		for _, obj := range untagged {
+			if obj.Subject == "" {
+				continue
+			}
+			if _, ok := markSet[obj.Digest]; ok {
+				continue
+			}
+			if _, ok := markSet[obj.Subject]; !ok {
+				continue
+			}
+
+			emit("%s: marking referrer %s of manifest %s", repoName, obj.Digest, obj.Subject)
+			markSet[obj.Digest] = struct{}{}
+			if err := markManifestReferences(obj.Digest, manifestService, ctx, ingester); err != nil {
+				return err
+			}
+			marked = true
+		}
+	}
+	return nil
+}
+
 // markManifestReferences marks the manifest references
 func markManifestReferences(dgst digest.Digest, manifestService distribution.ManifestService, ctx context.Context, ingester func(digest.Digest) bool) error {
 	manifest, err := manifestService.Get(ctx, dgst)
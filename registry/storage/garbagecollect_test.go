@@ -4,6 +4,7 @@ import (
 	"io"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
@@ -923,3 +924,226 @@ func TestTaggedManifestlistWithDeletedReference(t *testing.T) {
 		t.Fatalf("Garbage collection affected storage: %d != %d", len(after), 0)
 	}
 }
+
+func TestGCKeepsReferrersOfTaggedManifest(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "foo/referrers")
+	manifestService := makeManifestService(t, repo)
+
+	image := uploadRandomOCIImage(t, repo)
+	err := repo.Tags(ctx).Tag(ctx, "test", v1.Descriptor{Digest: image.manifestDigest})
+	if err != nil {
+		t.Fatalf("Failed to tag manifest: %v", err)
+	}
+
+	signature, err := manifestService.Put(ctx, makeReferrer(t, repo, "application/vnd.example.signature", image.manifestDigest))
+	if err != nil {
+		t.Fatalf("Failed to put referrer: %v", err)
+	}
+	// referrers can be the subject of other referrers
+	attestation, err := manifestService.Put(ctx, makeReferrer(t, repo, "application/vnd.example.attestation", signature))
+	if err != nil {
+		t.Fatalf("Failed to put referrer: %v", err)
+	}
+
+	before := allBlobs(t, registry)
+
+	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	after := allBlobs(t, registry)
+	if len(before) != len(after) {
+		t.Fatalf("Garbage collection affected storage: %d != %d", len(before), len(after))
+	}
+	for _, dgst := range []digest.Digest{signature, attestation} {
+		if _, ok := after[dgst]; !ok {
+			t.Fatalf("Referrer %s is missing", dgst)
+		}
+	}
+
+	// Once the subject is untagged, the subject and its referrers are removed.
+	if err := repo.Tags(ctx).Untag(ctx, "test"); err != nil {
+		t.Fatalf("Failed to untag manifest: %v", err)
+	}
+
+	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	after = allBlobs(t, registry)
+	for _, dgst := range []digest.Digest{image.manifestDigest, signature, attestation} {
+		if _, ok := after[dgst]; ok {
+			t.Fatalf("Manifest %s should have been removed", dgst)
+		}
+	}
+
+	referrerPath, err := pathFor(manifestReferrerLinkPathSpec{name: "foo/referrers", subject: image.manifestDigest, revision: signature})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := inmemoryDriver.Stat(ctx, referrerPath); err == nil {
+		t.Fatalf("Referrer link %s should have been removed", referrerPath)
+	}
+}
+
+func TestOnlineGCKeepsRecentContent(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "foo/online")
+	manifestService := makeManifestService(t, repo)
+
+	// an untagged image pushed while collecting
+	image := uploadRandomOCIImage(t, repo)
+	before := allBlobs(t, registry)
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: true,
+		Online:         true,
+		GracePeriod:    time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	after := allBlobs(t, registry)
+	if len(before) != len(after) {
+		t.Fatalf("Garbage collection affected storage: %d != %d", len(before), len(after))
+	}
+	if _, ok := allManifests(t, manifestService)[image.manifestDigest]; !ok {
+		t.Fatalf("Recent manifest %s was removed", image.manifestDigest)
+	}
+
+	// Offline, the same content is collected.
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	if _, ok := allBlobs(t, registry)[image.manifestDigest]; ok {
+		t.Fatalf("Untagged manifest %s was not removed", image.manifestDigest)
+	}
+}
+
+func TestOnlineGCKeepsInFlightContent(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+	inflight := NewInFlight()
+
+	// the collector reads through its own registry
+	registry := createRegistry(t, inmemoryDriver)
+	serving := createRegistry(t, inmemoryDriver, GCInFlight(inflight))
+
+	repo := makeRepository(t, serving, "foo/online")
+	image := uploadRandomOCIImage(t, repo)
+	if inflight.Contains(image.manifestDigest) {
+		t.Fatalf("Content recorded while no collection is running")
+	}
+
+	// simulate a collection running while a client checks for a layer
+	// before pushing a manifest referencing it
+	inflight.begin()
+	defer inflight.end()
+
+	var layer digest.Digest
+	for dgst := range image.layers {
+		layer = dgst
+		break
+	}
+	if _, err := repo.Blobs(ctx).Stat(ctx, layer); err != nil {
+		t.Fatalf("Failed to stat layer: %v", err)
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: true,
+		Online:         true,
+		// ignore modification times, only in flight content is kept
+		GracePeriod: -time.Hour,
+		InFlight:    inflight,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	after := allBlobs(t, registry)
+	if _, ok := after[layer]; !ok {
+		t.Fatalf("In flight layer %s was removed", layer)
+	}
+	if _, ok := after[image.manifestDigest]; ok {
+		t.Fatalf("Untagged manifest %s was not removed", image.manifestDigest)
+	}
+	if _, err := repo.Blobs(ctx).Stat(ctx, layer); err != nil {
+		t.Fatalf("In flight layer link was removed: %v", err)
+	}
+}
+
+func TestGCKeepsTagHistory(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "history")
+	manifestService := makeManifestService(t, repo)
+
+	var dgsts []digest.Digest
+	for i := 0; i < 4; i++ {
+		dgst := uploadRandomSchema2Image(t, repo).manifestDigest
+		if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: dgst}); err != nil {
+			t.Fatalf("failed to tag manifest: %v", err)
+		}
+		dgsts = append(dgsts, dgst)
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		RemoveUntagged: true,
+		KeepTagHistory: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	manifests := allManifests(t, manifestService)
+	for i, dgst := range dgsts {
+		_, ok := manifests[dgst]
+		if kept := i > 0; ok != kept {
+			t.Fatalf("manifest %d: expected kept=%t", i, kept)
+		}
+	}
+
+	revisions, err := TagHistory(ctx, inmemoryDriver, "history", "latest")
+	if err != nil {
+		t.Fatalf("failed to read tag history: %v", err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("unexpected history length after garbage collection: %d", len(revisions))
+	}
+
+	// without the option, only the current manifest is kept
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		RemoveUntagged: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	manifests = allManifests(t, manifestService)
+	if _, ok := manifests[dgsts[3]]; len(manifests) != 1 || !ok {
+		t.Fatalf("unexpected manifests after garbage collection: %v", manifests)
+	}
+}
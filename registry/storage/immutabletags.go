@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"sync"
+)
+
+// ImmutableTagPolicy selects the tags which cannot be moved to another
+// manifest or deleted once pushed.
+type ImmutableTagPolicy struct {
+	// Repositories are path.Match patterns of repository names.
+	Repositories []string
+	// Tags restricts the policy to the matching tags, if set.
+	Tags *regexp.Regexp
+}
+
+// matches reports whether the policy applies to the tag of the repository.
+func (p ImmutableTagPolicy) matches(repository, tag string) bool {
+	if p.Tags != nil && !p.Tags.MatchString(tag) {
+		return false
+	}
+	for _, pattern := range p.Repositories {
+		if ok, _ := path.Match(pattern, repository); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ImmutableTags is a functional option for NewRegistry. The tags selected by
+// one of the policies cannot be moved to another manifest or deleted, the tag
+// service returning distribution.ErrTagImmutable, unless the context
+// overrides immutability.
+func ImmutableTags(policies ...ImmutableTagPolicy) RegistryOption {
+	return func(registry *registry) error {
+		registry.immutableTags = append(registry.immutableTags, policies...)
+		return nil
+	}
+}
+
+// tagLocks serializes the check and the update of the immutable tags by the
+// registry, so that only one of concurrent first pushes of a tag succeeds.
+// Registry instances sharing the storage are not serialized.
+type tagLocks struct {
+	mu    sync.Mutex
+	locks map[string]*tagLock
+}
+
+type tagLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the tag of the repository, and returns the function unlocking
+// it.
+func (l *tagLocks) lock(repository, tag string) func() {
+	key := repository + ":" + tag
+
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*tagLock)
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &tagLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+type immutabilityOverrideKey struct{}
+
+// WithTagImmutabilityOverride returns a context in which the tags protected
+// by immutability policies can be moved and deleted, for deliberate fixes.
+func WithTagImmutabilityOverride(ctx context.Context) context.Context {
+	return context.WithValue(ctx, immutabilityOverrideKey{}, true)
+}
+
+// TagImmutable reports whether one of the policies protects the tag of the
+// repository, and the context does not override immutability.
+func TagImmutable(ctx context.Context, policies []ImmutableTagPolicy, repository, tag string) bool {
+	if overridden, _ := ctx.Value(immutabilityOverrideKey{}).(bool); overridden {
+		return false
+	}
+	for _, policy := range policies {
+		if policy.matches(repository, tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// InFlight tracks the digests which are linked or looked up by a registry
+// while an online garbage collection is running. Content recorded here was
+// referenced after it may have been considered during the mark phase, so the
+// sweep must not remove it. An InFlight is shared between a registry (see
+// GCInFlight) and the garbage collections run in the same process.
+type InFlight struct {
+	mu          sync.Mutex
+	collections int
+	digests     map[digest.Digest]struct{}
+}
+
+// NewInFlight returns an empty InFlight.
+func NewInFlight() *InFlight {
+	return &InFlight{}
+}
+
+// Add records dgst as in use. Digests are only recorded while a garbage
+// collection is running.
+func (f *InFlight) Add(dgst digest.Digest) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.collections == 0 {
+		return
+	}
+	f.digests[dgst] = struct{}{}
+}
+
+// Contains reports whether dgst has been recorded since the oldest running
+// garbage collection started.
+func (f *InFlight) Contains(dgst digest.Digest) bool {
+	if f == nil {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := f.digests[dgst]
+	return ok
+}
+
+// begin starts recording digests for a garbage collection.
+func (f *InFlight) begin() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.collections == 0 {
+		f.digests = make(map[digest.Digest]struct{})
+	}
+	f.collections++
+}
+
+// end stops recording digests for a garbage collection, releasing the
+// recorded digests once no collection is running anymore.
+func (f *InFlight) end() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.collections--
+	if f.collections == 0 {
+		f.digests = nil
+	}
+}
@@ -47,7 +47,15 @@ type linkedBlobStore struct {
 var _ distribution.BlobStore = &linkedBlobStore{}
 
 func (lbs *linkedBlobStore) Stat(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error) {
-	return lbs.blobAccessController.Stat(ctx, dgst)
+	desc, err := lbs.blobAccessController.Stat(ctx, dgst)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	// The blob may get referenced by content pushed after it was considered
+	// by a running garbage collection.
+	lbs.blobStore.inflight.Add(desc.Digest)
+	return desc, nil
 }
 
 func (lbs *linkedBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
@@ -35,6 +35,10 @@ const (
 //	        │   ├── revisions
 //	        │   │   └── <manifest digest path>
 //	        │   │       └── link
+//	        │   ├── referrers
+//	        │   │   └── <subject manifest digest path>
+//	        │   │       └── <manifest digest path>
+//	        │   │           └── link
 //	        │   └── tags
 //	        │       └── <tag>
 //	        │           ├── current
@@ -73,7 +77,9 @@ const (
 // implied as to the ordering of changes to a manifest. The tag store provides
 // support for name, tag lookups of manifests, using "current/link" under a
 // named tag directory. An index is maintained to support deletions of all
-// revisions of a given manifest tag.
+// revisions of a given manifest tag. Manifests declaring a subject are
+// additionally linked under the referrers directory of their subject, which
+// backs the referrers API.
 //
 // We cover the path formats implemented by this path mapper below.
 //
@@ -88,6 +94,11 @@ const (
 //	manifestRevisionPathSpec:      <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/
 //	manifestRevisionLinkPathSpec:  <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/link
 //
+//	Referrers:
+//
+//	manifestReferrersPathSpec:     <root>/v2/repositories/<name>/_manifests/referrers/<subject algorithm>/<subject hex digest>/
+//	manifestReferrerLinkPathSpec:  <root>/v2/repositories/<name>/_manifests/referrers/<subject algorithm>/<subject hex digest>/<algorithm>/<hex digest>/link
+//
 //	Tags:
 //
 //	manifestTagsPathSpec:                  <root>/v2/repositories/<name>/_manifests/tags/
@@ -96,6 +107,7 @@ const (
 //	manifestTagIndexPathSpec:              <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/
 //	manifestTagIndexEntryPathSpec:         <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/
 //	manifestTagIndexEntryLinkPathSpec:     <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/link
+//	manifestTagIndexEntryTaggedAtPathSpec: <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/taggedat
 //
 //	Blobs:
 //
@@ -154,6 +166,28 @@ func pathFor(spec pathSpec) (string, error) {
 		}
 
 		return path.Join(root, "link"), nil
+	case manifestReferrersPathSpec:
+		components, err := digestPathComponents(v.subject, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "_manifests", "referrers"), components...)...), nil
+	case manifestReferrerLinkPathSpec:
+		root, err := pathFor(manifestReferrersPathSpec{
+			name:    v.name,
+			subject: v.subject,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		components, err := digestPathComponents(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, path.Join(components...), "link"), nil
 	case manifestTagsPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_manifests", "tags")...), nil
 	case manifestTagPathSpec:
@@ -186,6 +220,13 @@ func pathFor(spec pathSpec) (string, error) {
 		}
 
 		return path.Join(root, "link"), nil
+	case manifestTagIndexEntryTaggedAtPathSpec:
+		root, err := pathFor(manifestTagIndexEntryPathSpec(v))
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "taggedat"), nil
 	case manifestTagIndexEntryPathSpec:
 		root, err := pathFor(manifestTagIndexPathSpec{
 			name: v.name,
@@ -298,6 +339,26 @@ type manifestRevisionLinkPathSpec struct {
 
 func (manifestRevisionLinkPathSpec) pathSpec() {}
 
+// manifestReferrersPathSpec describes the directory path under which the
+// manifests referring to the given subject are linked.
+type manifestReferrersPathSpec struct {
+	name    string
+	subject digest.Digest
+}
+
+func (manifestReferrersPathSpec) pathSpec() {}
+
+// manifestReferrerLinkPathSpec describes the link to a manifest revision
+// which declares the given subject. The contents of this file should just be
+// the digest of the referring manifest.
+type manifestReferrerLinkPathSpec struct {
+	name     string
+	subject  digest.Digest
+	revision digest.Digest
+}
+
+func (manifestReferrerLinkPathSpec) pathSpec() {}
+
 // manifestTagsPathSpec describes the path elements required to point to the
 // manifest tags directory.
 type manifestTagsPathSpec struct {
@@ -353,6 +414,17 @@ type manifestTagIndexEntryLinkPathSpec struct {
 
 func (manifestTagIndexEntryLinkPathSpec) pathSpec() {}
 
+// manifestTagIndexEntryTaggedAtPathSpec describes the file recording the last
+// time the tag was pointed at a revision. Unlike the modification time of the
+// link, it does not depend on the time resolution of the storage driver.
+type manifestTagIndexEntryTaggedAtPathSpec struct {
+	name     string
+	tag      string
+	revision digest.Digest
+}
+
+func (manifestTagIndexEntryTaggedAtPathSpec) pathSpec() {}
+
 // layersPathSpec contains the path for the layers inside a repo
 type layersPathSpec struct {
 	name string
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var _ distribution.ReferrersProvider = &manifestStore{}
+
+// ManifestSubject returns the subject declared by the manifest, if any.
+func ManifestSubject(manifest distribution.Manifest) *v1.Descriptor {
+	switch m := manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		return m.Subject
+	case *ocischema.DeserializedImageIndex:
+		return m.Subject
+	}
+	return nil
+}
+
+// Referrers returns the descriptors of the manifests which declare the
+// manifest identified by dgst as their subject. The subject itself does not
+// need to exist in the repository.
+func (ms *manifestStore) Referrers(ctx context.Context, dgst digest.Digest, artifactType string) ([]v1.Descriptor, error) {
+	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Referrers")
+
+	rootPath, err := pathFor(manifestReferrersPathSpec{
+		name:    ms.repository.Named().Name(),
+		subject: dgst,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	referrers := []v1.Descriptor{}
+	err = ms.blobStore.driver.Walk(ctx, rootPath, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+			return nil
+		}
+
+		revision, err := ms.blobStore.readlink(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+
+		desc, err := ms.referrerDescriptor(ctx, revision)
+		if err != nil {
+			// The referrer may have been removed by garbage collection
+			// before its link was cleaned up.
+			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+				return nil
+			}
+			return err
+		}
+
+		if artifactType == "" || desc.ArtifactType == artifactType {
+			referrers = append(referrers, desc)
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+
+	return referrers, nil
+}
+
+// referrerDescriptor builds the descriptor advertised by the referrers API
+// for the manifest revision identified by dgst.
+func (ms *manifestStore) referrerDescriptor(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error) {
+	manifest, err := ms.Get(ctx, dgst)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	desc := v1.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(payload)),
+	}
+
+	switch m := manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		desc.ArtifactType = m.ArtifactType
+		if desc.ArtifactType == "" {
+			// Image manifests without an explicit artifact type are
+			// identified by their config media type.
+			desc.ArtifactType = m.Config.MediaType
+		}
+		desc.Annotations = m.Annotations
+	case *ocischema.DeserializedImageIndex:
+		desc.ArtifactType = m.ArtifactType
+		desc.Annotations = m.Annotations
+	}
+
+	return desc, nil
+}
+
+// linkReferrer records the manifest identified by dgst as a referrer of
+// subject.
+func (ms *manifestStore) linkReferrer(ctx context.Context, subject, dgst digest.Digest) error {
+	linkPath, err := pathFor(manifestReferrerLinkPathSpec{
+		name:     ms.repository.Named().Name(),
+		subject:  subject,
+		revision: dgst,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ms.blobStore.link(ctx, linkPath, dgst)
+}
+
+// unlinkReferrer removes the manifest identified by dgst from the referrers
+// of subject. A missing link is not considered an error.
+func (ms *manifestStore) unlinkReferrer(ctx context.Context, subject, dgst digest.Digest) error {
+	linkPath, err := pathFor(manifestReferrerLinkPathSpec{
+		name:     ms.repository.Named().Name(),
+		subject:  subject,
+		revision: dgst,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ms.blobStore.driver.Delete(ctx, path.Dir(linkPath)); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// makeReferrer builds an artifact manifest of the given artifact type which
+// declares subject as its subject.
+func makeReferrer(t *testing.T, repository distribution.Repository, artifactType string, subject digest.Digest) distribution.Manifest {
+	ctx := dcontext.Background()
+	blobs := repository.Blobs(ctx)
+
+	config, err := blobs.Put(ctx, v1.MediaTypeEmptyJSON, v1.DescriptorEmptyJSON.Data)
+	if err != nil {
+		t.Fatalf("failed to put config: %v", err)
+	}
+	config.MediaType = v1.MediaTypeEmptyJSON
+
+	layer, err := blobs.Put(ctx, "application/octet-stream", []byte(artifactType+subject.String()))
+	if err != nil {
+		t.Fatalf("failed to put layer: %v", err)
+	}
+
+	manifest, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned:    specs.Versioned{SchemaVersion: 2},
+		MediaType:    v1.MediaTypeImageManifest,
+		ArtifactType: artifactType,
+		Config:       config,
+		Layers:       []v1.Descriptor{layer},
+		Subject:      &v1.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: subject, Size: 1},
+		Annotations:  map[string]string{"org.example.kind": artifactType},
+	})
+	if err != nil {
+		t.Fatalf("failed to build referrer: %v", err)
+	}
+	return manifest
+}
+
+func TestReferrers(t *testing.T) {
+	ctx := dcontext.Background()
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, "referrers")
+	manifestService := makeManifestService(t, repo)
+
+	provider, ok := manifestService.(distribution.ReferrersProvider)
+	if !ok {
+		t.Fatal("manifest service does not implement ReferrersProvider")
+	}
+
+	subject := uploadRandomOCIImage(t, repo)
+
+	referrers, err := provider.Referrers(ctx, subject.manifestDigest, "")
+	if err != nil {
+		t.Fatalf("unexpected error listing referrers: %v", err)
+	}
+	if len(referrers) != 0 {
+		t.Fatalf("expected no referrers, got %v", referrers)
+	}
+
+	signature, err := manifestService.Put(ctx, makeReferrer(t, repo, "application/vnd.example.signature", subject.manifestDigest))
+	if err != nil {
+		t.Fatalf("failed to put referrer: %v", err)
+	}
+	sbom, err := manifestService.Put(ctx, makeReferrer(t, repo, "application/vnd.example.sbom", subject.manifestDigest))
+	if err != nil {
+		t.Fatalf("failed to put referrer: %v", err)
+	}
+
+	referrers, err = provider.Referrers(ctx, subject.manifestDigest, "")
+	if err != nil {
+		t.Fatalf("unexpected error listing referrers: %v", err)
+	}
+	if len(referrers) != 2 {
+		t.Fatalf("expected 2 referrers, got %v", referrers)
+	}
+	for _, desc := range referrers {
+		if desc.MediaType != v1.MediaTypeImageManifest {
+			t.Errorf("unexpected media type %q", desc.MediaType)
+		}
+		if desc.Size == 0 {
+			t.Errorf("referrer %s has no size", desc.Digest)
+		}
+		if desc.Annotations["org.example.kind"] != desc.ArtifactType {
+			t.Errorf("unexpected annotations %v for artifact type %q", desc.Annotations, desc.ArtifactType)
+		}
+	}
+
+	referrers, err = provider.Referrers(ctx, subject.manifestDigest, "application/vnd.example.sbom")
+	if err != nil {
+		t.Fatalf("unexpected error listing referrers: %v", err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != sbom {
+		t.Fatalf("expected only %s, got %v", sbom, referrers)
+	}
+
+	if err := manifestService.Delete(ctx, sbom); err != nil {
+		t.Fatalf("failed to delete referrer: %v", err)
+	}
+
+	referrers, err = provider.Referrers(ctx, subject.manifestDigest, "")
+	if err != nil {
+		t.Fatalf("unexpected error listing referrers: %v", err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != signature {
+		t.Fatalf("expected only %s, got %v", signature, referrers)
+	}
+}
+
+func TestReferrersOfUnknownSubject(t *testing.T) {
+	ctx := dcontext.Background()
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, "referrers")
+	manifestService := makeManifestService(t, repo)
+
+	// The subject does not need to exist for the referrer to be pushed.
+	subject := digest.FromString("missing subject")
+	referrer, err := manifestService.Put(ctx, makeReferrer(t, repo, "application/vnd.example.signature", subject))
+	if err != nil {
+		t.Fatalf("failed to put referrer: %v", err)
+	}
+
+	referrers, err := manifestService.(distribution.ReferrersProvider).Referrers(ctx, subject, "")
+	if err != nil {
+		t.Fatalf("unexpected error listing referrers: %v", err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != referrer {
+		t.Fatalf("expected only %s, got %v", referrer, referrers)
+	}
+	if referrers[0].ArtifactType != "application/vnd.example.signature" {
+		t.Fatalf("unexpected artifact type %q", referrers[0].ArtifactType)
+	}
+}
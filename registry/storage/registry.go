@@ -31,6 +31,14 @@ type registry struct {
 	// Validation
 	manifestURLs         manifestURLs
 	validateImageIndexes validateImageIndexes
+
+	// immutableTags are the policies protecting tags from being moved or
+	// deleted.
+	immutableTags []ImmutableTagPolicy
+	tagLocks      tagLocks
+
+	// tagIndex, if set, indexes the tags of the repositories.
+	tagIndex cache.TagIndex
 }
 
 // manifestURLs holds regular expressions for controlling manifest URL whitelisting
@@ -84,6 +92,16 @@ func DisableDigestResumption(registry *registry) error {
 	return nil
 }
 
+// GCInFlight returns a functional option for NewRegistry. It records the
+// blobs written, linked or looked up by the registry into inflight while an
+// online garbage collection using the same InFlight is running.
+func GCInFlight(inflight *InFlight) RegistryOption {
+	return func(registry *registry) error {
+		registry.blobStore.inflight = inflight
+		return nil
+	}
+}
+
 // ManifestURLsAllowRegexp is a functional option for NewRegistry.
 func ManifestURLsAllowRegexp(r *regexp.Regexp) RegistryOption {
 	return func(registry *registry) error {
@@ -151,6 +169,16 @@ func BlobDescriptorCacheProvider(blobDescriptorCacheProvider cache.BlobDescripto
 	}
 }
 
+// TagIndex returns a functional option for NewRegistry. It lists the tags of
+// repositories from the index, which is kept up to date as tags are added and
+// removed.
+func TagIndex(tagIndex cache.TagIndex) RegistryOption {
+	return func(registry *registry) error {
+		registry.tagIndex = tagIndex
+		return nil
+	}
+}
+
 // NewRegistry creates a new registry instance from the provided driver. The
 // resulting registry may be shared by multiple goroutines but is cheap to
 // allocate. If the Redirect option is specified, the backend blob server will
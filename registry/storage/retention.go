@@ -0,0 +1,339 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// RetentionPolicy selects the tags to delete in the repositories matching
+// one of its patterns. A tag is deleted when it is not protected and every
+// rule of the policy allows it, a policy without rules deletes nothing.
+type RetentionPolicy struct {
+	// Repositories are path.Match patterns of repository names.
+	Repositories []string
+	// Tags restricts the policy to the matching tags, if set.
+	Tags *regexp.Regexp
+	// KeepLast keeps the given number of most recently pushed tags.
+	KeepLast int
+	// MaxAge keeps the tags pushed more recently than the given duration.
+	MaxAge time.Duration
+	// Protect lists tags which are never deleted.
+	Protect []string
+}
+
+// RetentionListener is notified of the tags and manifests deleted while
+// enforcing retention policies.
+type RetentionListener interface {
+	TagDeleted(repo reference.Named, tag string) error
+	ManifestDeleted(repo reference.Named, dgst digest.Digest) error
+}
+
+// RetentionOpts contains options for EnforceRetention
+type RetentionOpts struct {
+	// Policies are matched in order, the first policy matching a repository
+	// applies to it.
+	Policies []RetentionPolicy
+	// DryRun only reports the tags which would be deleted.
+	DryRun bool
+	// DeleteManifests also deletes the manifests left without any tag
+	// once the selected tags are deleted, unless other manifests in the
+	// repository reference them.
+	DeleteManifests bool
+	// Listener, if set, is notified of deleted tags and manifests.
+	Listener RetentionListener
+}
+
+// ExpiredTag describes a tag selected for deletion by a retention policy.
+type ExpiredTag struct {
+	Repository string
+	Tag        string
+	Digest     digest.Digest
+	Pushed     time.Time
+}
+
+// matches reports whether the policy applies to the named repository.
+func (p RetentionPolicy) matches(name string) bool {
+	for _, pattern := range p.Repositories {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// expired selects the tags the policy deletes from tags, which must be
+// sorted from the most to the least recently pushed.
+func (p RetentionPolicy) expired(tags []ExpiredTag, now time.Time) []ExpiredTag {
+	if p.KeepLast <= 0 && p.MaxAge <= 0 {
+		return nil
+	}
+
+	protected := make(map[string]struct{}, len(p.Protect))
+	for _, tag := range p.Protect {
+		protected[tag] = struct{}{}
+	}
+
+	var expired []ExpiredTag
+	kept := 0
+	for _, tag := range tags {
+		if _, ok := protected[tag.Tag]; ok {
+			continue
+		}
+		if p.Tags != nil && !p.Tags.MatchString(tag.Tag) {
+			continue
+		}
+		if kept < p.KeepLast {
+			kept++
+			continue
+		}
+		if p.MaxAge > 0 && now.Sub(tag.Pushed) <= p.MaxAge {
+			continue
+		}
+		expired = append(expired, tag)
+	}
+	return expired
+}
+
+// EnforceRetention deletes the tags selected by the retention policies from
+// every repository of the registry, and returns them. In dry run mode, the
+// selected tags are only returned.
+func EnforceRetention(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts RetentionOpts) ([]ExpiredTag, error) {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	now := time.Now()
+	var report []ExpiredTag
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		var policy *RetentionPolicy
+		for i := range opts.Policies {
+			if opts.Policies[i].matches(repoName) {
+				policy = &opts.Policies[i]
+				break
+			}
+		}
+		if policy == nil {
+			return nil
+		}
+
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+
+		tags, err := pushedTags(ctx, storageDriver, repository)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve tags of repository %s: %v", repoName, err)
+		}
+
+		expired := policy.expired(tags, now)
+		report = append(report, expired...)
+		if opts.DryRun || len(expired) == 0 {
+			return nil
+		}
+		return deleteExpiredTags(ctx, storageDriver, repository, expired, opts)
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to enforce retention: %v", err)
+	}
+	return report, nil
+}
+
+// pushedTags returns the tags of the repository from the most to the least
+// recently pushed. The time a tag was pushed is the modification time of its
+// current link.
+func pushedTags(ctx context.Context, storageDriver driver.StorageDriver, repository distribution.Repository) ([]ExpiredTag, error) {
+	name := repository.Named().Name()
+	tagService := repository.Tags(ctx)
+
+	all, err := tagService.All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	tags := make([]ExpiredTag, 0, len(all))
+	for _, tag := range all {
+		currentPath, err := pathFor(manifestTagCurrentPathSpec{name: name, tag: tag})
+		if err != nil {
+			return nil, err
+		}
+		fi, err := storageDriver.Stat(ctx, currentPath)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				// deleted concurrently
+				continue
+			}
+			return nil, err
+		}
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return nil, err
+		}
+		tags = append(tags, ExpiredTag{
+			Repository: name,
+			Tag:        tag,
+			Digest:     desc.Digest,
+			Pushed:     fi.ModTime(),
+		})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].Pushed.After(tags[j].Pushed)
+	})
+	return tags, nil
+}
+
+// deleteExpiredTags untags the expired tags of the repository, and deletes
+// the manifests they leave untagged if requested.
+func deleteExpiredTags(ctx context.Context, storageDriver driver.StorageDriver, repository distribution.Repository, expired []ExpiredTag, opts RetentionOpts) error {
+	tagService := repository.Tags(ctx)
+	for _, tag := range expired {
+		// the tag may have been pushed again since it was selected
+		desc, err := tagService.Get(ctx, tag.Tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return fmt.Errorf("failed to retrieve tag %s: %v", tag.Tag, err)
+		}
+		if desc.Digest != tag.Digest {
+			dcontext.GetLogger(ctx).Infof("retention: keeping tag %s:%s pushed again", tag.Repository, tag.Tag)
+			continue
+		}
+
+		dcontext.GetLogger(ctx).Infof("retention: deleting tag %s:%s", tag.Repository, tag.Tag)
+		if err := tagService.Untag(ctx, tag.Tag); err != nil {
+			switch err.(type) {
+			case driver.PathNotFoundError:
+				continue
+			case distribution.ErrTagImmutable:
+				dcontext.GetLogger(ctx).Infof("retention: keeping immutable tag %s:%s", tag.Repository, tag.Tag)
+				continue
+			}
+			return fmt.Errorf("failed to delete tag %s: %v", tag.Tag, err)
+		}
+		if opts.Listener != nil {
+			if err := opts.Listener.TagDeleted(repository.Named(), tag.Tag); err != nil {
+				dcontext.GetLogger(ctx).Errorf("error dispatching tag deleted to listener: %v", err)
+			}
+		}
+	}
+
+	if !opts.DeleteManifests {
+		return nil
+	}
+
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+	referenced, err := referencedManifests(ctx, manifestService)
+	if err != nil {
+		return err
+	}
+
+	// the remaining tags are listed once, they may still reference the
+	// manifests or have them in their history
+	allTags, err := tagService.All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			return fmt.Errorf("failed to retrieve tags %v", err)
+		}
+	}
+	tagged := make(map[digest.Digest]struct{}, len(allTags))
+	for _, tag := range allTags {
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return fmt.Errorf("failed to retrieve tag %s: %v", tag, err)
+		}
+		tagged[desc.Digest] = struct{}{}
+	}
+
+	vacuum := NewVacuum(ctx, storageDriver)
+	deleted := make(map[digest.Digest]struct{})
+	for _, tag := range expired {
+		if _, ok := deleted[tag.Digest]; ok {
+			continue
+		}
+		if _, ok := referenced[tag.Digest]; ok {
+			continue
+		}
+		if _, ok := tagged[tag.Digest]; ok {
+			continue
+		}
+
+		subject, err := subjectDigest(ctx, manifestService, tag.Digest)
+		if err != nil {
+			return err
+		}
+
+		dcontext.GetLogger(ctx).Infof("retention: deleting manifest %s@%s", tag.Repository, tag.Digest)
+		if err := vacuum.RemoveManifest(tag.Repository, tag.Digest, allTags); err != nil {
+			return fmt.Errorf("failed to delete manifest %s: %v", tag.Digest, err)
+		}
+		if subject != "" {
+			if err := vacuum.RemoveReferrer(tag.Repository, subject, tag.Digest); err != nil {
+				return fmt.Errorf("failed to delete referrer link %s of manifest %s: %v", tag.Digest, subject, err)
+			}
+		}
+		deleted[tag.Digest] = struct{}{}
+
+		if opts.Listener != nil {
+			if err := opts.Listener.ManifestDeleted(repository.Named(), tag.Digest); err != nil {
+				dcontext.GetLogger(ctx).Errorf("error dispatching manifest deleted to listener: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// referencedManifests returns the digests referenced by the manifests of the
+// repository, such as the manifests of an image index.
+func referencedManifests(ctx context.Context, manifestService distribution.ManifestService) (map[digest.Digest]struct{}, error) {
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+
+	referenced := make(map[digest.Digest]struct{})
+	err := manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		manifest, err := manifestService.Get(ctx, dgst)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+		}
+		for _, desc := range manifest.References() {
+			referenced[desc.Digest] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+	return referenced, nil
+}
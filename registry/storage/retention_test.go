@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type retentionListener struct {
+	tags      []string
+	manifests []digest.Digest
+}
+
+func (l *retentionListener) TagDeleted(repo reference.Named, tag string) error {
+	l.tags = append(l.tags, tag)
+	return nil
+}
+
+func (l *retentionListener) ManifestDeleted(repo reference.Named, dgst digest.Digest) error {
+	l.manifests = append(l.manifests, dgst)
+	return nil
+}
+
+func TestRetentionPolicyExpired(t *testing.T) {
+	now := time.Now()
+	tags := []ExpiredTag{
+		{Tag: "latest", Pushed: now.Add(-1 * time.Hour)},
+		{Tag: "v3", Pushed: now.Add(-2 * time.Hour)},
+		{Tag: "dev", Pushed: now.Add(-3 * time.Hour)},
+		{Tag: "v2", Pushed: now.Add(-48 * time.Hour)},
+		{Tag: "v1", Pushed: now.Add(-72 * time.Hour)},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		policy   RetentionPolicy
+		expected []string
+	}{
+		{
+			name:     "no rules",
+			policy:   RetentionPolicy{},
+			expected: nil,
+		},
+		{
+			name:     "keep last",
+			policy:   RetentionPolicy{KeepLast: 2},
+			expected: []string{"dev", "v2", "v1"},
+		},
+		{
+			name:     "keep last matching",
+			policy:   RetentionPolicy{KeepLast: 1, Tags: regexp.MustCompile(`^v\d+`)},
+			expected: []string{"v2", "v1"},
+		},
+		{
+			name:     "max age",
+			policy:   RetentionPolicy{MaxAge: 24 * time.Hour},
+			expected: []string{"v2", "v1"},
+		},
+		{
+			name:     "keep last and max age",
+			policy:   RetentionPolicy{KeepLast: 4, MaxAge: time.Hour},
+			expected: []string{"v1"},
+		},
+		{
+			name:     "protected",
+			policy:   RetentionPolicy{KeepLast: 1, Protect: []string{"latest", "v1"}},
+			expected: []string{"dev", "v2"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			expired := tc.policy.expired(tags, now)
+			if len(expired) != len(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, expired)
+			}
+			for i, tag := range expired {
+				if tag.Tag != tc.expected[i] {
+					t.Fatalf("expected %v, got %v", tc.expected, expired)
+				}
+			}
+		})
+	}
+}
+
+func TestEnforceRetention(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "foo/retention")
+	other := makeRepository(t, registry, "bar/retention")
+	manifestService := makeManifestService(t, repo)
+
+	images := make(map[string]image)
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		image := uploadRandomOCIImage(t, repo)
+		if err := repo.Tags(ctx).Tag(ctx, tag, v1.Descriptor{Digest: image.manifestDigest}); err != nil {
+			t.Fatalf("failed to tag manifest: %v", err)
+		}
+		images[tag] = image
+		// tags are ordered by the modification time of their links
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: images["v3"].manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	otherImage := uploadRandomOCIImage(t, other)
+	if err := other.Tags(ctx).Tag(ctx, "v1", v1.Descriptor{Digest: otherImage.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+
+	opts := RetentionOpts{
+		Policies: []RetentionPolicy{{
+			Repositories: []string{"foo/*"},
+			Tags:         regexp.MustCompile(`^v\d+`),
+			KeepLast:     1,
+			Protect:      []string{"latest"},
+		}},
+		DryRun: true,
+	}
+
+	expired, err := EnforceRetention(ctx, inmemoryDriver, registry, opts)
+	if err != nil {
+		t.Fatalf("failed to enforce retention: %v", err)
+	}
+	if len(expired) != 2 || expired[0].Tag != "v2" || expired[1].Tag != "v1" {
+		t.Fatalf("unexpected expired tags: %v", expired)
+	}
+	if expired[1].Digest != images["v1"].manifestDigest || expired[1].Repository != "foo/retention" {
+		t.Fatalf("unexpected expired tag: %v", expired[1])
+	}
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+	if len(tags) != 4 {
+		t.Fatalf("dry run deleted tags: %v", tags)
+	}
+
+	listener := &retentionListener{}
+	opts.DryRun = false
+	opts.DeleteManifests = true
+	opts.Listener = listener
+	if _, err := EnforceRetention(ctx, inmemoryDriver, registry, opts); err != nil {
+		t.Fatalf("failed to enforce retention: %v", err)
+	}
+
+	tags, err = repo.Tags(ctx).All(ctx)
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+	if len(tags) != 2 || tags[0] != "latest" || tags[1] != "v3" {
+		t.Fatalf("unexpected remaining tags: %v", tags)
+	}
+	if len(listener.tags) != 2 || len(listener.manifests) != 2 {
+		t.Fatalf("unexpected events: %v %v", listener.tags, listener.manifests)
+	}
+
+	manifests := allManifests(t, manifestService)
+	if _, ok := manifests[images["v1"].manifestDigest]; ok {
+		t.Fatalf("manifest of expired tag v1 was not deleted")
+	}
+	if _, ok := manifests[images["v3"].manifestDigest]; !ok {
+		t.Fatalf("manifest of tag v3 was deleted")
+	}
+
+	if _, err := other.Tags(ctx).Get(ctx, "v1"); err != nil {
+		t.Fatalf("tag of repository without policy was deleted: %v", err)
+	}
+}
+
+// TestDeleteExpiredTagsRepointed ensures that a tag pushed again after it was
+// selected for deletion is kept, along with its manifest.
+func TestDeleteExpiredTagsRepointed(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "foo/retention")
+	manifestService := makeManifestService(t, repo)
+
+	old := uploadRandomOCIImage(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "v1", v1.Descriptor{Digest: old.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	expired := []ExpiredTag{{Repository: "foo/retention", Tag: "v1", Digest: old.manifestDigest}}
+
+	// v1 is pushed again during the sweep
+	pushed := uploadRandomOCIImage(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "v1", v1.Descriptor{Digest: pushed.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+
+	listener := &retentionListener{}
+	opts := RetentionOpts{DeleteManifests: true, Listener: listener}
+	if err := deleteExpiredTags(ctx, inmemoryDriver, repo, expired, opts); err != nil {
+		t.Fatalf("failed to delete expired tags: %v", err)
+	}
+
+	desc, err := repo.Tags(ctx).Get(ctx, "v1")
+	if err != nil {
+		t.Fatalf("tag pushed again was deleted: %v", err)
+	}
+	if desc.Digest != pushed.manifestDigest {
+		t.Fatalf("unexpected digest of tag pushed again: %s", desc.Digest)
+	}
+	if len(listener.tags) != 0 {
+		t.Fatalf("unexpected deleted tags: %v", listener.tags)
+	}
+
+	manifests := allManifests(t, manifestService)
+	if _, ok := manifests[pushed.manifestDigest]; !ok {
+		t.Fatal("manifest of tag pushed again was deleted")
+	}
+	if _, ok := manifests[old.manifestDigest]; ok {
+		t.Fatal("untagged manifest was not deleted")
+	}
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// TagRevision is a manifest referenced by a tag, as recorded in the index of
+// the tag.
+type TagRevision struct {
+	Digest digest.Digest
+	// Tagged is the last time the tag was pointed at the manifest.
+	Tagged time.Time
+	// Current is set for the manifest the tag currently references.
+	Current bool
+}
+
+// TagHistory returns the manifests the tag of the named repository has
+// referenced, from the most to the least recently tagged.
+func TagHistory(ctx context.Context, storageDriver driver.StorageDriver, name, tag string) ([]TagRevision, error) {
+	currentPath, err := pathFor(manifestTagCurrentPathSpec{name: name, tag: tag})
+	if err != nil {
+		return nil, err
+	}
+	content, err := storageDriver.GetContent(ctx, currentPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, distribution.ErrTagUnknown{Tag: tag}
+		}
+		return nil, err
+	}
+	current, err := digest.Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	indexPath, err := pathFor(manifestTagIndexPathSpec{name: name, tag: tag})
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []TagRevision
+	err = storageDriver.Walk(ctx, indexPath, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+			return nil
+		}
+		content, err := storageDriver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		dgst, err := digest.Parse(string(content))
+		if err != nil {
+			return err
+		}
+		tagged, err := taggedAt(ctx, storageDriver, name, tag, dgst)
+		if err != nil {
+			return err
+		}
+		if tagged.IsZero() {
+			tagged = fileInfo.ModTime()
+		}
+		revisions = append(revisions, TagRevision{
+			Digest:  dgst,
+			Tagged:  tagged,
+			Current: dgst == current,
+		})
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		// the current revision comes first, even if another one was tagged
+		// at the same time
+		if revisions[i].Current != revisions[j].Current {
+			return revisions[i].Current
+		}
+		if !revisions[i].Tagged.Equal(revisions[j].Tagged) {
+			return revisions[i].Tagged.After(revisions[j].Tagged)
+		}
+		return revisions[i].Digest < revisions[j].Digest
+	})
+	return revisions, nil
+}
+
+// taggedAt returns the time recorded when the tag was last pointed at the
+// revision, or the zero time for the revisions tagged before the registry
+// recorded it, whose history falls back to the modification time of their
+// link.
+func taggedAt(ctx context.Context, storageDriver driver.StorageDriver, name, tag string, dgst digest.Digest) (time.Time, error) {
+	taggedAtPath, err := pathFor(manifestTagIndexEntryTaggedAtPathSpec{name: name, tag: tag, revision: dgst})
+	if err != nil {
+		return time.Time{}, err
+	}
+	content, err := storageDriver.GetContent(ctx, taggedAtPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	// an unreadable time also falls back to the modification time
+	tagged, _ := time.Parse(time.RFC3339Nano, string(content))
+	return tagged, nil
+}
+
+// keptTagHistory returns the manifests most recently referenced by the tags
+// of the named repository before their current one, up to keep per tag.
+func keptTagHistory(ctx context.Context, storageDriver driver.StorageDriver, tags distribution.TagService, name string, keep int) (map[digest.Digest]struct{}, error) {
+	kept := make(map[digest.Digest]struct{})
+	if keep <= 0 {
+		return kept, nil
+	}
+
+	allTags, err := tags.All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return kept, nil
+		}
+		return nil, err
+	}
+	for _, tag := range allTags {
+		revisions, err := TagHistory(ctx, storageDriver, name, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return nil, err
+		}
+		n := 0
+		for _, revision := range revisions {
+			if revision.Current {
+				continue
+			}
+			if n == keep {
+				break
+			}
+			kept[revision.Digest] = struct{}{}
+			n++
+		}
+	}
+	return kept, nil
+}
@@ -4,17 +4,23 @@ import (
 	"context"
 	"path"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 )
 
-var _ distribution.TagService = &tagStore{}
+var (
+	_ distribution.TagService = &tagStore{}
+	_ distribution.TagLister  = &tagStore{}
+)
 
 // tagStore provides methods to manage manifest tags in a backend storage driver.
 // This implementation uses the same on-disk layout as the (now deleted) tag
@@ -59,6 +65,142 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 	return tags, nil
 }
 
+// List returns up to n tags following last, all of them if n is not
+// positive. The tags are listed from the tag index if it is configured, and
+// otherwise by walking the tags of the repository, starting after last.
+func (ts *tagStore) List(ctx context.Context, last string, n int) ([]string, error) {
+	tagIndex := ts.repository.tagIndex
+	if tagIndex == nil {
+		return ts.walk(ctx, last, n)
+	}
+
+	name := ts.repository.Named().Name()
+	tags, indexed, err := tagIndex.List(ctx, name, last, n)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error listing the tags of %s from the index: %v", name, err)
+		return ts.walk(ctx, last, n)
+	}
+	if indexed {
+		return tags, nil
+	}
+
+	allTags, err := ts.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := tagIndex.Set(ctx, name, allTags); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error indexing the tags of %s: %v", name, err)
+	}
+
+	i := sort.SearchStrings(allTags, last)
+	if i < len(allTags) && allTags[i] == last {
+		i++
+	}
+	allTags = allTags[i:]
+	if n > 0 && n < len(allTags) {
+		allTags = allTags[:n]
+	}
+	return allTags, nil
+}
+
+// walk lists up to n tags following last by walking the tags of the
+// repository.
+func (ts *tagStore) walk(ctx context.Context, last string, n int) ([]string, error) {
+	root, err := pathFor(manifestTagsPathSpec{
+		name: ts.repository.Named().Name(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	startAfter := ""
+	if last != "" {
+		startAfter = path.Join(root, last)
+	}
+
+	var tags []string
+	err = ts.blobStore.driver.Walk(ctx, root, func(fileInfo storagedriver.FileInfo) error {
+		tag := strings.TrimPrefix(fileInfo.Path(), root+"/")
+		// skip the content of tags, and the tags walked before last
+		if strings.Contains(tag, "/") || tag <= last {
+			if fileInfo.IsDir() {
+				return storagedriver.ErrSkipDir
+			}
+			return nil
+		}
+
+		tags = append(tags, tag)
+		if n > 0 && len(tags) == n {
+			return storagedriver.ErrFilledBuffer
+		}
+		return storagedriver.ErrSkipDir
+	}, storagedriver.WithStartAfterHint(startAfter))
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, distribution.ErrRepositoryUnknown{Name: ts.repository.Named().Name()}
+		}
+		return nil, err
+	}
+
+	if len(tags) == 0 {
+		// the walk may not report a missing repository when starting after
+		// last
+		if _, err := ts.blobStore.driver.Stat(ctx, root); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				return nil, distribution.ErrRepositoryUnknown{Name: ts.repository.Named().Name()}
+			}
+			return nil, err
+		}
+	}
+
+	if n > 0 && len(tags) == n {
+		tags, err = ts.addWalkedAfter(ctx, tags, last)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(tags)
+	if n > 0 && len(tags) > n {
+		tags = tags[:n]
+	}
+	return tags, nil
+}
+
+// addWalkedAfter adds to tags the tags which precede some of them in lexical
+// order but may be walked after them. Drivers walking the objects of the
+// repository, rather than its directories, walk a tag after the tags it is a
+// prefix of when they continue with a dash or a dot, such as "v1" after
+// "v1-rc".
+func (ts *tagStore) addWalkedAfter(ctx context.Context, tags []string, last string) ([]string, error) {
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		seen[tag] = struct{}{}
+	}
+
+	for _, tag := range tags {
+		for i := 1; i < len(tag); i++ {
+			if tag[i] != '-' && tag[i] != '.' {
+				continue
+			}
+			prefix := tag[:i]
+			if _, ok := seen[prefix]; ok || prefix <= last {
+				continue
+			}
+			seen[prefix] = struct{}{}
+
+			if _, err := ts.Get(ctx, prefix); err != nil {
+				if _, ok := err.(distribution.ErrTagUnknown); ok {
+					continue
+				}
+				return nil, err
+			}
+			tags = append(tags, prefix)
+		}
+	}
+	return tags, nil
+}
+
 // Tag tags the digest with the given tag, updating the store to point at
 // the current tag. The digest must point to a manifest.
 func (ts *tagStore) Tag(ctx context.Context, tag string, desc v1.Descriptor) error {
@@ -70,6 +212,13 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc v1.Descriptor) err
 		return err
 	}
 
+	if unlock := ts.lockImmutable(ctx, tag); unlock != nil {
+		defer unlock()
+	}
+	if err := ts.checkMutable(ctx, tag, desc); err != nil {
+		return err
+	}
+
 	lbs := ts.linkedBlobStore(ctx, tag)
 
 	// Link into the index
@@ -77,8 +226,28 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc v1.Descriptor) err
 		return err
 	}
 
+	// Record when the tag was pointed at the revision, ordering its history
+	taggedAtPath, err := pathFor(manifestTagIndexEntryTaggedAtPathSpec{
+		name:     ts.repository.Named().Name(),
+		tag:      tag,
+		revision: desc.Digest,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ts.blobStore.driver.PutContent(ctx, taggedAtPath, []byte(time.Now().UTC().Format(time.RFC3339Nano))); err != nil {
+		return err
+	}
+
 	// Overwrite the current link
-	return ts.blobStore.link(ctx, currentPath, desc.Digest)
+	if err := ts.blobStore.link(ctx, currentPath, desc.Digest); err != nil {
+		return err
+	}
+
+	if ts.repository.tagIndex != nil {
+		ts.updateIndex(ctx, ts.repository.tagIndex.Add(ctx, ts.repository.Named().Name(), tag))
+	}
+	return nil
 }
 
 // resolve the current revision for name and tag.
@@ -114,7 +283,66 @@ func (ts *tagStore) Untag(ctx context.Context, tag string) error {
 		return err
 	}
 
-	return ts.blobStore.driver.Delete(ctx, tagPath)
+	if unlock := ts.lockImmutable(ctx, tag); unlock != nil {
+		defer unlock()
+	}
+	if err := ts.checkMutable(ctx, tag, v1.Descriptor{}); err != nil {
+		return err
+	}
+
+	if err := ts.blobStore.driver.Delete(ctx, tagPath); err != nil {
+		return err
+	}
+
+	if ts.repository.tagIndex != nil {
+		ts.updateIndex(ctx, ts.repository.tagIndex.Remove(ctx, ts.repository.Named().Name(), tag))
+	}
+	return nil
+}
+
+// updateIndex handles the error of an update of the tag index, discarding
+// the index of the repository which is no longer up to date.
+func (ts *tagStore) updateIndex(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	name := ts.repository.Named().Name()
+	dcontext.GetLogger(ctx).Errorf("error updating the tag index of %s: %v", name, err)
+	if err := ts.repository.tagIndex.Invalidate(ctx, name); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error invalidating the tag index of %s: %v", name, err)
+	}
+}
+
+// lockImmutable locks the tag if it is protected by an immutability policy,
+// until the returned function is called, so that it is checked and updated
+// atomically. It returns nil if the tag is mutable.
+func (ts *tagStore) lockImmutable(ctx context.Context, tag string) func() {
+	name := ts.repository.Named().Name()
+	if !TagImmutable(ctx, ts.repository.immutableTags, name, tag) {
+		return nil
+	}
+	return ts.repository.tagLocks.lock(name, tag)
+}
+
+// checkMutable returns distribution.ErrTagImmutable if the tag exists, is
+// protected by an immutability policy, and would no longer reference the
+// descriptor, which is empty when untagging.
+func (ts *tagStore) checkMutable(ctx context.Context, tag string, desc v1.Descriptor) error {
+	if !TagImmutable(ctx, ts.repository.immutableTags, ts.repository.Named().Name(), tag) {
+		return nil
+	}
+
+	current, err := ts.Get(ctx, tag)
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			return nil
+		}
+		return err
+	}
+	if current.Digest != desc.Digest {
+		return distribution.ErrTagImmutable{Tag: tag}
+	}
+	return nil
 }
 
 // linkedBlobStore returns the linkedBlobStore for the named tag, allowing one
@@ -3,10 +3,17 @@ package storage
 import (
 	"context"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/reference"
 	digest "github.com/opencontainers/go-digest"
@@ -22,10 +29,10 @@ type tagsTestEnv struct {
 	ctx context.Context
 }
 
-func testTagStore(t *testing.T) *tagsTestEnv {
+func testTagStore(t *testing.T, options ...RegistryOption) *tagsTestEnv {
 	ctx := context.Background()
 	d := inmemory.New()
-	reg, err := NewRegistry(ctx, d)
+	reg, err := NewRegistry(ctx, d, options...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -120,6 +127,113 @@ func TestTagStoreUnTag(t *testing.T) {
 	}
 }
 
+func TestTagStoreImmutable(t *testing.T) {
+	env := testTagStore(t, ImmutableTags(ImmutableTagPolicy{
+		Repositories: []string{"a/*"},
+		Tags:         regexp.MustCompile(`^v[0-9]`),
+	}))
+	tags := env.ts
+	ctx := env.ctx
+	desc := v1.Descriptor{Digest: "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}
+	other := v1.Descriptor{Digest: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}
+
+	for _, tag := range []string{"v1", "latest"} {
+		if err := tags.Tag(ctx, tag, desc); err != nil {
+			t.Fatalf("unexpected error tagging %s: %v", tag, err)
+		}
+	}
+
+	// tagging the same manifest again is allowed
+	if err := tags.Tag(ctx, "v1", desc); err != nil {
+		t.Fatalf("unexpected error tagging v1 again: %v", err)
+	}
+
+	expected := distribution.ErrTagImmutable{Tag: "v1"}
+	if err := tags.Tag(ctx, "v1", other); err != expected {
+		t.Fatalf("expected %v moving v1, got %v", expected, err)
+	}
+	if err := tags.Untag(ctx, "v1"); err != expected {
+		t.Fatalf("expected %v deleting v1, got %v", expected, err)
+	}
+
+	// tags not matching the policy are mutable
+	if err := tags.Tag(ctx, "latest", other); err != nil {
+		t.Fatalf("unexpected error moving latest: %v", err)
+	}
+
+	override := WithTagImmutabilityOverride(ctx)
+	if err := tags.Tag(override, "v1", other); err != nil {
+		t.Fatalf("unexpected error moving v1 with override: %v", err)
+	}
+	if err := tags.Untag(override, "v1"); err != nil {
+		t.Fatalf("unexpected error deleting v1 with override: %v", err)
+	}
+}
+
+// slowWriteDriver delays writes, widening the window between the check of a
+// tag and its update.
+type slowWriteDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d slowWriteDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	time.Sleep(10 * time.Millisecond)
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+// TestTagStoreImmutableConcurrent ensures that only one of concurrent first
+// pushes of an immutable tag succeeds.
+func TestTagStoreImmutableConcurrent(t *testing.T) {
+	ctx := context.Background()
+	reg, err := NewRegistry(ctx, slowWriteDriver{inmemory.New()}, ImmutableTags(ImmutableTagPolicy{
+		Repositories: []string{"a/*"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoRef, _ := reference.WithName("a/b")
+	repo, err := reg.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := repo.Tags(ctx)
+
+	const pushes = 16
+	descs := make([]v1.Descriptor, pushes)
+	errs := make([]error, pushes)
+	var wg sync.WaitGroup
+	for i := range descs {
+		descs[i] = v1.Descriptor{Digest: digest.FromString(strconv.Itoa(i))}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = tags.Tag(ctx, "v1", descs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var tagged []v1.Descriptor
+	for i, err := range errs {
+		switch err.(type) {
+		case nil:
+			tagged = append(tagged, descs[i])
+		case distribution.ErrTagImmutable:
+		default:
+			t.Fatalf("unexpected error tagging v1: %v", err)
+		}
+	}
+	if len(tagged) != 1 {
+		t.Fatalf("expected a single push of v1 to succeed, got %d", len(tagged))
+	}
+	desc, err := tags.Get(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != tagged[0].Digest {
+		t.Fatalf("v1 was overwritten: expected %s, got %s", tagged[0].Digest, desc.Digest)
+	}
+}
+
 func TestTagStoreAll(t *testing.T) {
 	env := testTagStore(t)
 	tagStore := env.ts
@@ -312,3 +426,299 @@ func digestMap(dgsts []digest.Digest) map[digest.Digest]struct{} {
 	}
 	return set
 }
+
+func TestTagHistory(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "a/b")
+
+	if _, err := TagHistory(ctx, d, "a/b", "latest"); err == nil {
+		t.Fatal("expected error reading the history of an unknown tag")
+	} else if _, ok := err.(distribution.ErrTagUnknown); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var dgsts []digest.Digest
+	for i := 0; i < 3; i++ {
+		dgst := uploadRandomOCIImage(t, repo).manifestDigest
+		if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+		dgsts = append(dgsts, dgst)
+	}
+	// roll back to the first manifest
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: dgsts[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := TagHistory(ctx, d, "a/b", "latest")
+	if err != nil {
+		t.Fatalf("error reading tag history: %v", err)
+	}
+	expected := []digest.Digest{dgsts[0], dgsts[2], dgsts[1]}
+	if len(revisions) != len(expected) {
+		t.Fatalf("unexpected history length: %d != %d", len(revisions), len(expected))
+	}
+	for i, revision := range revisions {
+		if revision.Digest != expected[i] {
+			t.Fatalf("unexpected digest at %d: %s != %s", i, revision.Digest, expected[i])
+		}
+		if revision.Current != (i == 0) {
+			t.Fatalf("unexpected current flag at %d", i)
+		}
+		if revision.Tagged.IsZero() {
+			t.Fatalf("missing tagged time at %d", i)
+		}
+	}
+}
+
+// coarseModTimeDriver reports the same modification time for every file,
+// like the drivers whose modification times have a coarse resolution when
+// the files are written in quick succession.
+type coarseModTimeDriver struct {
+	storagedriver.StorageDriver
+}
+
+type coarseModTimeFileInfo struct {
+	storagedriver.FileInfo
+}
+
+func (fi coarseModTimeFileInfo) ModTime() time.Time {
+	return time.Unix(0, 0)
+}
+
+func (d coarseModTimeDriver) Walk(ctx context.Context, from string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return d.StorageDriver.Walk(ctx, from, func(fileInfo storagedriver.FileInfo) error {
+		return f(coarseModTimeFileInfo{fileInfo})
+	}, options...)
+}
+
+// TestTagHistoryModTimeTies validates that the history of a tag is ordered by
+// the recorded tagging times rather than the modification times of the links.
+func TestTagHistoryModTimeTies(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "a/b")
+
+	var dgsts []digest.Digest
+	for i := 0; i < 4; i++ {
+		dgsts = append(dgsts, uploadRandomOCIImage(t, repo).manifestDigest)
+	}
+	// tag in the order of the digests, so that ordering the ties
+	// by digest would not pass
+	sort.Slice(dgsts, func(i, j int) bool { return dgsts[i] < dgsts[j] })
+	for _, dgst := range dgsts {
+		if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := TagHistory(ctx, coarseModTimeDriver{d}, "a/b", "latest")
+	if err != nil {
+		t.Fatalf("error reading tag history: %v", err)
+	}
+	expected := []digest.Digest{dgsts[3], dgsts[2], dgsts[1], dgsts[0]}
+	var history []digest.Digest
+	for _, revision := range revisions {
+		history = append(history, revision.Digest)
+	}
+	if !reflect.DeepEqual(history, expected) {
+		t.Fatalf("unexpected history: %v != %v", history, expected)
+	}
+
+	// the revisions tagged before the tagging times were recorded fall back
+	// to the modification times, then to the digests
+	taggedAtPath, err := pathFor(manifestTagIndexEntryTaggedAtPathSpec{name: "a/b", tag: "latest", revision: dgsts[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, taggedAtPath); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err = TagHistory(ctx, coarseModTimeDriver{d}, "a/b", "latest")
+	if err != nil {
+		t.Fatalf("error reading tag history: %v", err)
+	}
+	if len(revisions) != len(expected) || revisions[0].Digest != dgsts[3] || revisions[len(revisions)-1].Digest != dgsts[1] {
+		t.Fatalf("unexpected history without a tagging time: %v", revisions)
+	}
+}
+
+// objectWalkDriver walks like the drivers listing objects, in the lexical
+// order of their keys rather than directory by directory.
+type objectWalkDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d objectWalkDriver) Walk(ctx context.Context, from string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	walkOptions := &storagedriver.WalkOptions{}
+	for _, o := range options {
+		o(walkOptions)
+	}
+
+	var fileInfos []storagedriver.FileInfo
+	err := storagedriver.WalkFallback(ctx, d.StorageDriver, from, func(fileInfo storagedriver.FileInfo) error {
+		fileInfos = append(fileInfos, fileInfo)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	key := func(fileInfo storagedriver.FileInfo) string {
+		if fileInfo.IsDir() {
+			return fileInfo.Path() + "/"
+		}
+		return fileInfo.Path()
+	}
+	sort.Slice(fileInfos, func(i, j int) bool {
+		return key(fileInfos[i]) < key(fileInfos[j])
+	})
+
+	var skipDir string
+	for _, fileInfo := range fileInfos {
+		if key(fileInfo) <= walkOptions.StartAfterHint || (skipDir != "" && strings.HasPrefix(fileInfo.Path(), skipDir+"/")) {
+			continue
+		}
+		switch err := f(fileInfo); err {
+		case nil:
+		case storagedriver.ErrSkipDir:
+			skipDir = fileInfo.Path()
+		case storagedriver.ErrFilledBuffer:
+			return nil
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// fakeTagIndex is a cache.TagIndex keeping tags in memory.
+type fakeTagIndex struct {
+	tags    map[string]map[string]struct{}
+	indexed map[string]bool
+}
+
+func (fti *fakeTagIndex) List(ctx context.Context, repo, last string, n int) ([]string, bool, error) {
+	var tags []string
+	for tag := range fti.tags[repo] {
+		if tag > last {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	if n > 0 && n < len(tags) {
+		tags = tags[:n]
+	}
+	return tags, fti.indexed[repo], nil
+}
+
+func (fti *fakeTagIndex) Set(ctx context.Context, repo string, tags []string) error {
+	for _, tag := range tags {
+		if err := fti.Add(ctx, repo, tag); err != nil {
+			return err
+		}
+	}
+	fti.indexed[repo] = true
+	return nil
+}
+
+func (fti *fakeTagIndex) Add(ctx context.Context, repo, tag string) error {
+	if fti.tags[repo] == nil {
+		fti.tags[repo] = make(map[string]struct{})
+	}
+	fti.tags[repo][tag] = struct{}{}
+	return nil
+}
+
+func (fti *fakeTagIndex) Remove(ctx context.Context, repo, tag string) error {
+	delete(fti.tags[repo], tag)
+	return nil
+}
+
+func (fti *fakeTagIndex) Invalidate(ctx context.Context, repo string) error {
+	delete(fti.tags, repo)
+	delete(fti.indexed, repo)
+	return nil
+}
+
+func TestTagStoreList(t *testing.T) {
+	ctx := context.Background()
+	tags := []string{"latest", "v1", "v1-rc", "v1.1", "v1.1-rc", "v10"}
+
+	for name, newRegistry := range map[string]func(d storagedriver.StorageDriver) (distribution.Namespace, error){
+		"walk": func(d storagedriver.StorageDriver) (distribution.Namespace, error) {
+			return NewRegistry(ctx, d)
+		},
+		"object walk": func(d storagedriver.StorageDriver) (distribution.Namespace, error) {
+			return NewRegistry(ctx, objectWalkDriver{d})
+		},
+		"index": func(d storagedriver.StorageDriver) (distribution.Namespace, error) {
+			return NewRegistry(ctx, d, TagIndex(&fakeTagIndex{
+				tags:    make(map[string]map[string]struct{}),
+				indexed: make(map[string]bool),
+			}))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			reg, err := newRegistry(inmemory.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			repoRef, _ := reference.WithName("a/b")
+			repo, err := reg.Repository(ctx, repoRef)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tagStore := repo.Tags(ctx)
+			lister := tagStore.(distribution.TagLister)
+
+			if _, err := lister.List(ctx, "v1", 2); err == nil {
+				t.Fatal("expected error listing the tags of an unknown repository")
+			} else if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			desc := v1.Descriptor{Digest: "sha256:eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"}
+			for _, tag := range tags {
+				if err := tagStore.Tag(ctx, tag, desc); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, n := range []int{1, 2, 4, 0} {
+				var listed []string
+				last := ""
+				for {
+					page, err := lister.List(ctx, last, n)
+					if err != nil {
+						t.Fatalf("n=%d: error listing tags after %q: %v", n, last, err)
+					}
+					listed = append(listed, page...)
+					if n <= 0 || len(page) < n {
+						break
+					}
+					last = page[len(page)-1]
+				}
+				if !reflect.DeepEqual(listed, tags) {
+					t.Fatalf("n=%d: unexpected tags listed: %v", n, listed)
+				}
+			}
+
+			if err := tagStore.Untag(ctx, "v1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := tagStore.Tag(ctx, "v2", desc); err != nil {
+				t.Fatal(err)
+			}
+			listed, err := lister.List(ctx, "v1.1-rc", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(listed, []string{"v10", "v2"}) {
+				t.Fatalf("unexpected tags listed after updating tags: %v", listed)
+			}
+		})
+	}
+}
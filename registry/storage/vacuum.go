@@ -84,6 +84,26 @@ func (v Vacuum) RemoveManifest(name string, dgst digest.Digest, tags []string) e
 	return v.driver.Delete(v.ctx, manifestPath)
 }
 
+// RemoveReferrer removes the link recording the manifest identified by dgst
+// as a referrer of subject. A missing link is not considered an error.
+func (v Vacuum) RemoveReferrer(name string, subject, dgst digest.Digest) error {
+	referrerLinkPath, err := pathFor(manifestReferrerLinkPathSpec{name: name, subject: subject, revision: dgst})
+	if err != nil {
+		return err
+	}
+	referrerPath := path.Dir(referrerLinkPath)
+	dcontext.GetLogger(v.ctx).Infof("deleting referrer link: %s", referrerPath)
+	err = v.driver.Delete(v.ctx, referrerPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
 // RemoveRepository removes a repository directory from the
 // filesystem
 func (v Vacuum) RemoveRepository(repoName string) error {
@@ -2,6 +2,7 @@ package distribution
 
 import (
 	"context"
+	"sort"
 
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -29,6 +30,40 @@ type TagService interface {
 	Lookup(ctx context.Context, digest v1.Descriptor) ([]string, error)
 }
 
+// TagLister is implemented by the tag services which can list their tags one
+// page at a time, rather than all of them at once.
+type TagLister interface {
+	// List returns up to n of the tags managed by this tag service, in
+	// lexical order and following last if not empty. All the following tags
+	// are returned if n is not positive.
+	List(ctx context.Context, last string, n int) ([]string, error)
+}
+
+// ListTags returns up to n of the tags managed by the tag service, in lexical
+// order and following last if not empty, or all the following tags if n is
+// not positive. It uses the List method of the tag services implementing
+// TagLister, and lists all the tags of the others.
+func ListTags(ctx context.Context, ts TagService, last string, n int) ([]string, error) {
+	if lister, ok := ts.(TagLister); ok {
+		return lister.List(ctx, last, n)
+	}
+
+	tags, err := ts.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tags)
+	i := sort.SearchStrings(tags, last)
+	if i < len(tags) && tags[i] == last {
+		i++
+	}
+	tags = tags[i:]
+	if n > 0 && n < len(tags) {
+		tags = tags[:n]
+	}
+	return tags, nil
+}
+
 // TagManifestsProvider provides method to retrieve the digests of manifests that a tag historically
 // pointed to
 type TagManifestsProvider interface {